@@ -0,0 +1,635 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// namespaceSeparator joins an upstream name and a tool name in aggregator mode
+const namespaceSeparator = "__"
+
+// Upstream is one named backend in aggregator mode, with its own session
+type Upstream struct {
+	Name   string
+	proxy  *Proxy
+	health *HealthChecker // Nil without --health-check
+}
+
+// Aggregator presents several upstream MCP servers as one, prefixing listed
+// names with the upstream name and routing calls back to the owner
+type Aggregator struct {
+	upstreams   []*Upstream
+	parallelism int // Maximum concurrent upstream requests during fan-out
+	debug       bool
+
+	// routed are the client requests in flight on their owning upstream, by
+	// the client's request ID, so cancellations can be translated
+	routedMu sync.Mutex
+	routed   map[string]*routedCall
+}
+
+// routedCall is a client request forwarded to one upstream
+type routedCall struct {
+	owner *Upstream
+
+	mu sync.Mutex
+	id json.RawMessage // Internal ID of the upstream request, once sent
+}
+
+// UpstreamConfig describes one named upstream in aggregator mode
+type UpstreamConfig struct {
+	Name    string            `json:"name"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"` // Extra HTTP headers, e.g. API keys
+	Auth    *AuthConfig       `json:"auth,omitempty"`
+}
+
+// parseUpstreamSpecs converts "name=url" flag values into upstream configs
+func parseUpstreamSpecs(specs []string) ([]UpstreamConfig, error) {
+	var configs []UpstreamConfig
+	for _, spec := range specs {
+		name, url, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || url == "" {
+			return nil, fmt.Errorf("invalid upstream %q (expected name=url)", spec)
+		}
+		configs = append(configs, UpstreamConfig{Name: name, URL: url})
+	}
+	return configs, nil
+}
+
+// upstreamsFromArgs converts positional arguments into upstream configs: a
+// "name=url" argument keeps its name, a bare URL is named after its host
+// (plus the port when several URLs share a host)
+func upstreamsFromArgs(args []string) ([]UpstreamConfig, error) {
+	configs := make([]UpstreamConfig, 0, len(args))
+	ports := make([]string, 0, len(args))
+	counts := make(map[string]int)
+	for _, arg := range args {
+		if name, target, ok := strings.Cut(arg, "="); ok && !strings.ContainsAny(name, ":/") {
+			configs = append(configs, UpstreamConfig{Name: name, URL: target})
+			ports = append(ports, "")
+			continue
+		}
+
+		if isUnixURL(arg) {
+			configs = append(configs, UpstreamConfig{Name: unixSocketName(arg), URL: arg})
+			ports = append(ports, "")
+			continue
+		}
+
+		u, err := url.Parse(arg)
+		if err != nil || u.Hostname() == "" {
+			return nil, fmt.Errorf("invalid upstream URL %q", arg)
+		}
+		name := hostUpstreamName(u.Hostname())
+		counts[name]++
+		port := u.Port()
+		if port == "" {
+			port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+		}
+		configs = append(configs, UpstreamConfig{Name: name, URL: arg})
+		ports = append(ports, port)
+	}
+
+	seen := make(map[string]int)
+	for i := range configs {
+		c := &configs[i]
+		if ports[i] == "" {
+			continue
+		}
+		if counts[c.Name] > 1 {
+			c.Name += "-" + ports[i]
+		}
+		// Same host and port, different paths
+		if seen[c.Name]++; seen[c.Name] > 1 {
+			c.Name = fmt.Sprintf("%s-%d", c.Name, seen[c.Name])
+		}
+	}
+	return configs, nil
+}
+
+// hostUpstreamName derives an upstream name from a hostname: its most
+// specific label other than generic ones like "api" or "mcp", with anything
+// but letters, digits and dashes replaced so the name can't contain the
+// namespace separator
+func hostUpstreamName(host string) string {
+	name := host
+	if net.ParseIP(host) == nil {
+		labels := strings.Split(host, ".")
+		name = labels[0]
+		for _, label := range labels[:max(len(labels)-1, 1)] {
+			if label != "www" && label != "api" && label != "mcp" {
+				name = label
+				break
+			}
+		}
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+// loadUpstreamsConfig reads a JSON file of upstreams: {"upstreams": [...]}.
+// "${VAR}" references in URLs, headers and credentials are expanded from the
+// environment so secrets needn't be stored in the file.
+func loadUpstreamsConfig(path string) ([]UpstreamConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upstreams config: %w", err)
+	}
+
+	var file struct {
+		Upstreams []UpstreamConfig `json:"upstreams"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid upstreams config %s: %w", path, err)
+	}
+
+	for i := range file.Upstreams {
+		u := &file.Upstreams[i]
+		u.URL = os.ExpandEnv(u.URL)
+		for name, value := range u.Headers {
+			u.Headers[name] = os.ExpandEnv(value)
+		}
+		if u.Auth != nil {
+			u.Auth.expandEnv()
+		}
+	}
+
+	return file.Upstreams, nil
+}
+
+// NewAggregator creates an aggregator over the configured upstreams
+func NewAggregator(configs []UpstreamConfig, client *http.Client, parallelism int, debug bool) (*Aggregator, error) {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	a := &Aggregator{parallelism: parallelism, debug: debug, routed: make(map[string]*routedCall)}
+	seen := make(map[string]bool)
+
+	for _, config := range configs {
+		name, url := config.Name, config.URL
+		if name == "" || url == "" {
+			return nil, fmt.Errorf("upstream name and url are required")
+		}
+		if strings.Contains(name, namespaceSeparator) {
+			return nil, fmt.Errorf("upstream name %q must not contain %q", name, namespaceSeparator)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate upstream name %q", name)
+		}
+		if isUnixURL(url) {
+			resolved, err := resolveUnixURL(url)
+			if err != nil {
+				return nil, fmt.Errorf("upstream %s: %w", name, err)
+			}
+			url = resolved
+		} else if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return nil, fmt.Errorf("upstream %s: URL must start with http://, https:// or http+unix://", name)
+		}
+		if config.Auth != nil {
+			if err := config.Auth.validate(); err != nil {
+				return nil, fmt.Errorf("upstream %s: %w", name, err)
+			}
+		}
+		seen[name] = true
+
+		a.upstreams = append(a.upstreams, &Upstream{
+			Name: name,
+			proxy: &Proxy{
+				url:    url,
+				client: newAuthClient(client, config.Auth, config.Headers, url),
+				debug:  debug,
+			},
+		})
+	}
+
+	return a, nil
+}
+
+// fanOut runs fn against every upstream concurrently, bounded by the
+// aggregator's parallelism. Each upstream's error is isolated from the others.
+func (a *Aggregator) fanOut(fn func(u *Upstream) error) map[string]error {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errs   = make(map[string]error)
+		tokens = make(chan struct{}, a.parallelism)
+	)
+
+	for _, u := range a.upstreams {
+		wg.Add(1)
+		go func(u *Upstream) {
+			defer wg.Done()
+			defer recoverPanic("fan-out to " + u.Name)
+
+			tokens <- struct{}{}
+			defer func() { <-tokens }()
+
+			if err := fn(u); err != nil {
+				mu.Lock()
+				errs[u.Name] = err
+				mu.Unlock()
+			}
+		}(u)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// handle answers one client message on behalf of all upstreams
+func (a *Aggregator) handle(p *Proxy, msg *JSONRPCMessage) {
+	// A cancellation goes to the upstream serving the request; other client
+	// notifications go to every upstream
+	if msg.Method == "notifications/cancelled" {
+		a.cancelRouted(msg)
+		return
+	}
+	if msg.ID == nil {
+		a.broadcastNotification(msg)
+		return
+	}
+	// Upstream requests aren't forwarded in this mode, so a response has
+	// no one waiting for it and is never answered with an error
+	if msg.Method == "" {
+		if p.debug {
+			log.Printf("[AGGREGATOR] Dropped %s", describeMessage(msg))
+		}
+		return
+	}
+
+	var (
+		result json.RawMessage
+		err    error
+	)
+
+	switch msg.Method {
+	case "initialize":
+		result, err = a.initialize(msg.Params)
+	case "ping":
+		result = json.RawMessage("{}")
+	case "tools/list", "prompts/list", "resources/list", "resources/templates/list":
+		result, err = a.listMerged(msg.Method)
+	case "tools/call", "prompts/get", "resources/read":
+		result, err = a.route(p, msg)
+	default:
+		p.sendErrorResponse(msg.ID, -32601, fmt.Sprintf("Method not supported in aggregator mode: %s", msg.Method))
+		return
+	}
+
+	// Cancelled requests get no response, unless the proxy is stopping
+	if errors.Is(err, context.Canceled) && p.baseContext().Err() == nil {
+		p.finishRequest(msg.ID)
+		if p.debug {
+			log.Printf("[CANCEL] Request %s cancelled by the client", msg.ID)
+		}
+		return
+	}
+
+	var rpcErr *JSONRPCError
+	if errors.As(err, &rpcErr) {
+		p.sendErrorResponse(msg.ID, rpcErr.Code, rpcErr.Message)
+		return
+	}
+	if err != nil {
+		log.Printf("[AGGREGATOR] %s failed: %v", msg.Method, err)
+		p.sendErrorResponse(msg.ID, -32603, fmt.Sprintf("Internal error: %v", err))
+		return
+	}
+
+	resp := JSONRPCMessage{JSONRPC: "2.0", ID: msg.ID, Result: result}
+	data, marshalErr := json.Marshal(resp)
+	if marshalErr != nil {
+		log.Printf("[ERROR] Failed to marshal aggregated response: %v", marshalErr)
+		return
+	}
+	p.writeMessage(&resp, data)
+	if p.debug {
+		log.Printf("[STDOUT] Sent aggregated %s: %s", msg.Method, data)
+	}
+}
+
+// broadcastNotification delivers a client notification to all upstreams in parallel
+func (a *Aggregator) broadcastNotification(msg *JSONRPCMessage) {
+	errs := a.fanOut(func(u *Upstream) error {
+		if u.proxy.session() == "" && msg.Method != "notifications/initialized" {
+			return nil
+		}
+		return u.proxy.notify(msg.Method, msg.Params)
+	})
+
+	for name, err := range errs {
+		log.Printf("[AGGREGATOR] Failed to deliver %s to %s: %v", msg.Method, name, err)
+	}
+	if a.debug {
+		log.Printf("[AGGREGATOR] Delivered %s to %d/%d upstream(s)", msg.Method, len(a.upstreams)-len(errs), len(a.upstreams))
+	}
+}
+
+// cancelRouted translates a client's cancellation to the internal ID of the
+// routed request and sends it to the owning upstream only. The request's
+// own context has already been cancelled by the proxy.
+func (a *Aggregator) cancelRouted(msg *JSONRPCMessage) {
+	var params map[string]json.RawMessage
+	if json.Unmarshal(msg.Params, &params) != nil {
+		return
+	}
+	a.routedMu.Lock()
+	call := a.routed[string(params["requestId"])]
+	a.routedMu.Unlock()
+	if call == nil {
+		if a.debug {
+			log.Printf("[AGGREGATOR] Dropped cancellation of request %s, which is not in flight", params["requestId"])
+		}
+		return
+	}
+
+	call.mu.Lock()
+	params["requestId"] = call.id
+	call.mu.Unlock()
+	if params["requestId"] == nil {
+		return
+	}
+	if err := call.owner.proxy.notify(msg.Method, params); err != nil {
+		log.Printf("[AGGREGATOR] Failed to deliver %s to %s: %v", msg.Method, call.owner.Name, err)
+	} else if a.debug {
+		log.Printf("[AGGREGATOR] Delivered %s for %s to %s", msg.Method, params["requestId"], call.owner.Name)
+	}
+}
+
+// initialize opens a session with every upstream and merges their capabilities
+func (a *Aggregator) initialize(params json.RawMessage) (json.RawMessage, error) {
+	var (
+		mu              sync.Mutex
+		protocolVersion string
+		capabilities    = make(map[string]json.RawMessage)
+	)
+
+	errs := a.fanOut(func(u *Upstream) error {
+		u.proxy.sessionMu.Lock()
+		u.proxy.sessionID = ""
+		u.proxy.initParams = params
+		u.proxy.sessionMu.Unlock()
+		u.proxy.rememberClient(params)
+
+		resp, err := u.proxy.callContext(u.proxy.baseContext(), "initialize", params)
+		if err != nil {
+			return err
+		}
+
+		var result struct {
+			ProtocolVersion string                     `json:"protocolVersion"`
+			Capabilities    map[string]json.RawMessage `json:"capabilities"`
+		}
+		if err := json.Unmarshal(resp.Result, &result); err != nil {
+			return fmt.Errorf("invalid initialize result: %w", err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		// Advertise the oldest version any upstream negotiated
+		if protocolVersion == "" || result.ProtocolVersion < protocolVersion {
+			protocolVersion = result.ProtocolVersion
+		}
+		for name, capability := range result.Capabilities {
+			if _, ok := capabilities[name]; !ok {
+				capabilities[name] = capability
+			}
+		}
+		return nil
+	})
+
+	for name, err := range errs {
+		log.Printf("[AGGREGATOR] Upstream %s failed to initialize: %v", name, err)
+	}
+	if len(errs) == len(a.upstreams) {
+		return nil, fmt.Errorf("no upstream could be initialized")
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"protocolVersion": protocolVersion,
+		"capabilities":    capabilities,
+		"serverInfo": map[string]string{
+			"name":    "mcp-stdio-proxy-aggregator",
+			"version": version,
+		},
+	})
+}
+
+// listKinds maps each aggregated list method to its result array and the
+// member of each item that is namespaced with the upstream name
+var listKinds = map[string]struct{ items, key string }{
+	"tools/list":               {"tools", "name"},
+	"prompts/list":             {"prompts", "name"},
+	"resources/list":           {"resources", "uri"},
+	"resources/templates/list": {"resourceTemplates", "uriTemplate"},
+}
+
+// routedMethods maps each routed method to the params member naming its target
+var routedMethods = map[string]string{
+	"tools/call":     "name",
+	"prompts/get":    "name",
+	"resources/read": "uri",
+}
+
+// listMerged merges every upstream's list result, prefixing each item's
+// name (or URI) with the upstream name
+func (a *Aggregator) listMerged(method string) (json.RawMessage, error) {
+	kind := listKinds[method]
+
+	var (
+		mu    sync.Mutex
+		items = make(map[string][]map[string]json.RawMessage)
+	)
+
+	errs := a.fanOut(func(u *Upstream) error {
+		if u.proxy.session() == "" {
+			return nil
+		}
+		resp, err := u.proxy.call(method, nil)
+		if err != nil {
+			return err
+		}
+
+		var result map[string][]map[string]json.RawMessage
+		if err := json.Unmarshal(resp.Result, &result); err != nil {
+			return fmt.Errorf("invalid %s result: %w", method, err)
+		}
+
+		for _, item := range result[kind.items] {
+			var name string
+			if err := json.Unmarshal(item[kind.key], &name); err != nil {
+				continue
+			}
+			item[kind.key], _ = json.Marshal(u.Name + namespaceSeparator + name)
+		}
+
+		mu.Lock()
+		items[u.Name] = result[kind.items]
+		mu.Unlock()
+		return nil
+	})
+
+	for name, err := range errs {
+		log.Printf("[AGGREGATOR] Upstream %s failed to answer %s: %v", name, method, err)
+	}
+
+	// Keep upstream order stable across calls
+	merged := []map[string]json.RawMessage{}
+	for _, u := range a.upstreams {
+		merged = append(merged, items[u.Name]...)
+	}
+
+	return json.Marshal(map[string]interface{}{kind.items: merged})
+}
+
+// route forwards a namespaced request to the owning upstream with the
+// prefix removed. Progress it reports is passed on to the client, and a
+// cancellation by the client abandons it.
+func (a *Aggregator) route(p *Proxy, msg *JSONRPCMessage) (json.RawMessage, error) {
+	method := msg.Method
+	key := routedMethods[method]
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Params, &fields); err != nil {
+		return nil, &JSONRPCError{Code: -32602, Message: fmt.Sprintf("Invalid params: %v", err)}
+	}
+
+	var name string
+	if err := json.Unmarshal(fields[key], &name); err != nil || name == "" {
+		return nil, &JSONRPCError{Code: -32602, Message: fmt.Sprintf("Invalid params: missing %s", key)}
+	}
+
+	owner, target, err := a.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+
+	fields[key], _ = json.Marshal(target)
+
+	// Remember the internal ID for cancellations while the call is in flight
+	call := &routedCall{owner: owner}
+	a.routedMu.Lock()
+	a.routed[string(msg.ID)] = call
+	a.routedMu.Unlock()
+	defer func() {
+		a.routedMu.Lock()
+		delete(a.routed, string(msg.ID))
+		a.routedMu.Unlock()
+	}()
+	observer := &callObserver{
+		sent: func(id json.RawMessage) {
+			call.mu.Lock()
+			call.id = id
+			call.mu.Unlock()
+		},
+		message: func(update *JSONRPCMessage) {
+			a.forwardProgress(p, owner, progressToken(fields), update)
+		},
+	}
+
+	ctx := p.requestContext(p.trackedRequest(msg.ID))
+	resp, err := owner.proxy.callObserved(ctx, method, fields, observer)
+	if resp != nil && resp.Error != nil {
+		// Pass the upstream's own error through unchanged
+		return nil, resp.Error
+	}
+	if err != nil {
+		return nil, fmt.Errorf("upstream %s: %w", owner.Name, err)
+	}
+
+	// Resource contents name their URIs; keep them in the client's namespace
+	if method == "resources/read" {
+		var result map[string]json.RawMessage
+		var contents []map[string]json.RawMessage
+		if json.Unmarshal(resp.Result, &result) == nil && json.Unmarshal(result["contents"], &contents) == nil {
+			for _, content := range contents {
+				var uri string
+				if json.Unmarshal(content["uri"], &uri) == nil {
+					content["uri"], _ = json.Marshal(owner.Name + namespaceSeparator + uri)
+				}
+			}
+			result["contents"], _ = json.Marshal(contents)
+			return json.Marshal(result)
+		}
+	}
+
+	return resp.Result, nil
+}
+
+// forwardProgress passes a progress notification for a routed request on to
+// the client. Routed params keep the client's own progress token, so only
+// notifications carrying it belong to the request.
+func (a *Aggregator) forwardProgress(p *Proxy, owner *Upstream, token json.RawMessage, update *JSONRPCMessage) {
+	if update.Method != "notifications/progress" || token == nil {
+		if a.debug {
+			log.Printf("[AGGREGATOR] Discarded message from %s: %s", owner.Name, describeMessage(update))
+		}
+		return
+	}
+	var params map[string]json.RawMessage
+	if json.Unmarshal(update.Params, &params) != nil || string(params["progressToken"]) != string(token) {
+		return
+	}
+
+	note := JSONRPCMessage{JSONRPC: "2.0", Method: update.Method, Params: update.Params}
+	data, err := json.Marshal(note)
+	if err != nil {
+		return
+	}
+	p.writeMessage(&note, data)
+}
+
+// progressToken returns the progress token a request's params carry, if any
+func progressToken(params map[string]json.RawMessage) json.RawMessage {
+	var meta struct {
+		ProgressToken json.RawMessage `json:"progressToken"`
+	}
+	json.Unmarshal(params["_meta"], &meta)
+	return meta.ProgressToken
+}
+
+// resolve splits a namespaced name into its owning upstream and the upstream's own name
+func (a *Aggregator) resolve(name string) (*Upstream, string, error) {
+	prefix, target, ok := strings.Cut(name, namespaceSeparator)
+	if !ok {
+		return nil, "", &JSONRPCError{
+			Code:    -32602,
+			Message: fmt.Sprintf("%q has no upstream prefix (expected UPSTREAM%sNAME; upstreams: %s)", name, namespaceSeparator, a.names()),
+		}
+	}
+
+	for _, u := range a.upstreams {
+		if u.Name == prefix {
+			return u, target, nil
+		}
+	}
+
+	return nil, "", &JSONRPCError{
+		Code:    -32602,
+		Message: fmt.Sprintf("%q does not match any upstream (upstreams: %s)", name, a.names()),
+	}
+}
+
+// names lists the upstream names for error messages
+func (a *Aggregator) names() string {
+	names := make([]string, len(a.upstreams))
+	for i, u := range a.upstreams {
+		names[i] = u.Name
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// testSession drives a proxy over in-memory stdio
+type testSession struct {
+	t     *testing.T
+	in    *io.PipeWriter
+	lines chan string
+}
+
+// startAggregator runs an aggregating proxy over the named upstreams
+func startAggregator(t *testing.T, upstreams map[string]*testUpstream) *testSession {
+	var configs []UpstreamConfig
+	var url string
+	for name, u := range upstreams {
+		configs = append(configs, UpstreamConfig{Name: name, URL: u.URL})
+		url = u.URL
+	}
+	p, err := New(Config{URL: url, DisableGetStream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.aggregator, err = NewAggregator(configs, p.client, 4, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range p.aggregator.upstreams {
+		u.proxy.lifetime = p.lifetime
+	}
+	return startSession(t, p)
+}
+
+// startSession runs p over in-memory stdio until the test ends
+func startSession(t *testing.T, p *Proxy) *testSession {
+	inReader, in := io.Pipe()
+	outReader, out := io.Pipe()
+	s := &testSession{t: t, in: in, lines: make(chan string, 100)}
+	go func() {
+		scanner := bufio.NewScanner(outReader)
+		for scanner.Scan() {
+			s.lines <- scanner.Text()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Run(ctx, inReader, out)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		in.Close()
+		<-done
+	})
+	return s
+}
+
+func (s *testSession) send(format string, args ...interface{}) {
+	if _, err := fmt.Fprintf(s.in, format+"\n", args...); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func (s *testSession) receive() JSONRPCMessage {
+	select {
+	case line := <-s.lines:
+		var msg JSONRPCMessage
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			s.t.Fatalf("invalid message %q: %v", line, err)
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		s.t.Fatal("no message from the proxy")
+	}
+	return JSONRPCMessage{}
+}
+
+func (s *testSession) initialize() {
+	s.send(`{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":%q,"capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`, latestProtocolVersion)
+	if msg := s.receive(); string(msg.ID) != "0" || msg.Result == nil {
+		s.t.Fatalf("unexpected initialize response: %+v", msg)
+	}
+	s.send(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+}
+
+func TestAggregatorCancelGoesToOwner(t *testing.T) {
+	a, b := newTestUpstream(t, 0), newTestUpstream(t, 0)
+	s := startAggregator(t, map[string]*testUpstream{"a": a, "b": b})
+	s.initialize()
+
+	s.send(`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"a__slow","arguments":{"wait":true}}}`)
+	call := a.expectMessage(t, "tools/call")
+
+	// The cancellation names the request by the ID the upstream saw
+	s.send(`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":7,"reason":"test"}}`)
+	cancelled := a.expectMessage(t, "notifications/cancelled")
+	var params struct {
+		RequestID json.RawMessage `json:"requestId"`
+		Reason    string          `json:"reason"`
+	}
+	json.Unmarshal(cancelled.Params, &params)
+	if string(params.RequestID) != string(call.ID) || params.Reason != "test" {
+		t.Errorf("upstream got cancellation %s for request %s", cancelled.Params, call.ID)
+	}
+
+	// The cancelled request gets no response, and the other upstream
+	// never hears of it
+	s.send(`{"jsonrpc":"2.0","id":8,"method":"ping"}`)
+	if msg := s.receive(); string(msg.ID) != "8" {
+		t.Errorf("expected the ping response, got %+v", msg)
+	}
+	for len(b.received) > 0 {
+		if msg := <-b.received; msg.Method == "notifications/cancelled" {
+			t.Errorf("upstream b received %s", msg.Params)
+		}
+	}
+}
+
+func TestAggregatorForwardsProgress(t *testing.T) {
+	a, b := newTestUpstream(t, 0), newTestUpstream(t, 0)
+	s := startAggregator(t, map[string]*testUpstream{"a": a, "b": b})
+	s.initialize()
+
+	s.send(`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"b__echo","arguments":{},"_meta":{"progressToken":"work-1"}}}`)
+	progress := s.receive()
+	var params struct {
+		ProgressToken json.RawMessage `json:"progressToken"`
+	}
+	json.Unmarshal(progress.Params, &params)
+	if progress.Method != "notifications/progress" || string(params.ProgressToken) != `"work-1"` {
+		t.Fatalf("expected progress for the client's token, got %+v", progress)
+	}
+	if msg := s.receive(); string(msg.ID) != "3" || msg.Result == nil {
+		t.Errorf("expected the tool result, got %+v", msg)
+	}
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Alerter notifies operators when the HealthChecker gives up
+type Alerter struct {
+	command string // Shell command to run
+	webhook string // URL receiving a JSON POST (Slack-compatible "text" field)
+	url     string // Upstream URL, included as context
+	debug   bool
+}
+
+// alertPayload is the JSON body posted to the webhook
+type alertPayload struct {
+	Text     string    `json:"text"`
+	State    string    `json:"state"`
+	Upstream string    `json:"upstream"`
+	Error    string    `json:"error"`
+	Host     string    `json:"host"`
+	PID      int       `json:"pid"`
+	Time     time.Time `json:"time"`
+}
+
+// Alert runs the configured command and webhook with context about the
+// failure. They run in the background, so a hanging command can't hold up
+// the other failure handlers, such as --health-exit-on-failure.
+func (a *Alerter) Alert(state HealthState, failure error) {
+	host, _ := os.Hostname()
+	payload := alertPayload{
+		Text:     fmt.Sprintf("mcp-stdio-proxy on %s: upstream %s is %s: %v", host, a.url, state, failure),
+		State:    state.String(),
+		Upstream: a.url,
+		Error:    failure.Error(),
+		Host:     host,
+		PID:      os.Getpid(),
+		Time:     time.Now().UTC(),
+	}
+
+	go func() {
+		defer recoverPanic("health alert")
+		if a.command != "" {
+			if err := a.runCommand(payload); err != nil {
+				log.Printf("[ALERT] Alert command failed: %v", err)
+			} else if a.debug {
+				log.Printf("[ALERT] Ran alert command")
+			}
+		}
+
+		if a.webhook != "" {
+			if err := a.postWebhook(payload); err != nil {
+				log.Printf("[ALERT] Alert webhook failed: %v", err)
+			} else if a.debug {
+				log.Printf("[ALERT] Posted alert to webhook")
+			}
+		}
+	}()
+}
+
+// runCommand executes the alert command with context in environment
+// variables, giving up on it after recoveryCommandTimeout
+func (a *Alerter) runCommand(payload alertPayload) error {
+	ctx, cancel := context.WithTimeout(context.Background(), recoveryCommandTimeout)
+	defer cancel()
+
+	return runHookCommand(ctx, a.command, []string{
+		"MCP_PROXY_HEALTH_STATE=" + payload.State,
+		"MCP_PROXY_UPSTREAM=" + payload.Upstream,
+		"MCP_PROXY_ERROR=" + payload.Error,
+		"MCP_PROXY_MESSAGE=" + payload.Text,
+		fmt.Sprintf("MCP_PROXY_PID=%d", payload.PID),
+	})
+}
+
+// postWebhook sends the alert as JSON
+func (a *Alerter) postWebhook(payload alertPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(a.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
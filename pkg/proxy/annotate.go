@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// proxyMeta is added to annotated results as _meta.proxy
+type proxyMeta struct {
+	UpstreamLatencyMs int64  `json:"upstreamLatencyMs"`
+	Retries           int    `json:"retries"`
+	Upstream          string `json:"upstream"`
+	SessionID         string `json:"sessionId,omitempty"`
+	CorrelationID     string `json:"correlationId,omitempty"`
+}
+
+// annotateResponse adds _meta.proxy to a response's result
+func (p *Proxy) annotateResponse(msg *JSONRPCMessage, data []byte, stats *requestStats) []byte {
+	return withResultMeta(msg, data, "proxy", proxyMeta{
+		UpstreamLatencyMs: time.Since(stats.start).Milliseconds(),
+		Retries:           stats.retries,
+		Upstream:          p.target(),
+		SessionID:         p.session(),
+		CorrelationID:     stats.correlationID,
+	})
+}
+
+// withResultMeta sets _meta[key] in a response's result, updating msg and
+// returning the re-encoded message. Responses whose result isn't an object
+// are returned as is.
+func withResultMeta(msg *JSONRPCMessage, data []byte, key string, value interface{}) []byte {
+	if msg.Result == nil {
+		return data
+	}
+
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		return data
+	}
+
+	var meta map[string]json.RawMessage
+	if raw, ok := result["_meta"]; ok {
+		if err := json.Unmarshal(raw, &meta); err != nil {
+			return data
+		}
+	}
+	if meta == nil {
+		meta = make(map[string]json.RawMessage)
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return data
+	}
+	meta[key] = encoded
+	result["_meta"], _ = json.Marshal(meta)
+
+	updated := *msg
+	updated.Result, _ = json.Marshal(result)
+	out, err := json.Marshal(updated)
+	if err != nil {
+		return data
+	}
+	*msg = updated
+
+	return out
+}
+
+// captureMetaHeaders remembers the configured response headers for the request with the given ID
+func (p *Proxy) captureMetaHeaders(resp *http.Response, id json.RawMessage) {
+	if len(p.metaHeaders) == 0 || id == nil {
+		return
+	}
+	stats := p.trackedRequest(id)
+	if stats == nil {
+		return
+	}
+
+	headers := make(map[string]string)
+	for _, name := range p.metaHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			headers[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+	if len(headers) > 0 {
+		stats.headers = headers
+	}
+}
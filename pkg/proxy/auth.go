@@ -0,0 +1,408 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upstream authentication methods
+const (
+	AuthBearer = "bearer" // Static bearer token
+	AuthOAuth  = "oauth"  // OAuth 2.0 client credentials grant
+
+	AuthAuthorizationCode = "authorization_code" // OAuth 2.1 authorization code grant with PKCE
+)
+
+// AuthConfig describes how to authenticate to one upstream
+type AuthConfig struct {
+	Type string `json:"type"` // "bearer", "oauth" or "authorization_code"
+
+	// Bearer
+	Token string `json:"token,omitempty"`
+
+	// OAuth client credentials
+	TokenURL     string   `json:"tokenUrl,omitempty"`
+	ClientID     string   `json:"clientId,omitempty"`
+	ClientSecret string   `json:"clientSecret,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+
+	// OAuth discovery and dynamic client registration, used when tokenUrl or
+	// clientId is left out
+	Issuer          string `json:"issuer,omitempty"`          // Authorization server; discovered from the upstream if empty
+	RegistrationURL string `json:"registrationUrl,omitempty"` // Overrides the discovered registration endpoint
+	CredentialsFile string `json:"credentialsFile,omitempty"` // Where registered clients are kept; defaults to the user config dir
+
+	// Authorization code
+	TokensFile string `json:"tokensFile,omitempty"` // Where access and refresh tokens are cached; defaults to the user config dir
+}
+
+// validate checks that the fields required by the auth type are present
+func (c *AuthConfig) validate() error {
+	switch c.Type {
+	case AuthBearer:
+		if c.Token == "" {
+			return fmt.Errorf("bearer auth requires a token")
+		}
+	case AuthOAuth, AuthAuthorizationCode:
+		if c.ClientID == "" && c.ClientSecret != "" {
+			return fmt.Errorf("oauth auth has a clientSecret but no clientId")
+		}
+	default:
+		return fmt.Errorf("unknown auth type %q", c.Type)
+	}
+	return nil
+}
+
+// expandEnv replaces "${VAR}" references in the credentials and endpoints
+func (c *AuthConfig) expandEnv() {
+	c.Token = os.ExpandEnv(c.Token)
+	c.TokenURL = os.ExpandEnv(c.TokenURL)
+	c.ClientID = os.ExpandEnv(c.ClientID)
+	c.ClientSecret = os.ExpandEnv(c.ClientSecret)
+	c.Issuer = os.ExpandEnv(c.Issuer)
+	c.RegistrationURL = os.ExpandEnv(c.RegistrationURL)
+	c.CredentialsFile = os.ExpandEnv(c.CredentialsFile)
+	c.TokensFile = os.ExpandEnv(c.TokensFile)
+}
+
+// loadAuthConfig reads the upstream's auth settings from a JSON file in the
+// same format as an upstreams-config "auth" entry
+func loadAuthConfig(path string) (*AuthConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth config: %w", err)
+	}
+
+	var auth AuthConfig
+	if err := json.Unmarshal(data, &auth); err != nil {
+		return nil, fmt.Errorf("invalid auth config %s: %w", path, err)
+	}
+	auth.expandEnv()
+	if err := auth.validate(); err != nil {
+		return nil, fmt.Errorf("auth config %s: %w", path, err)
+	}
+	return &auth, nil
+}
+
+// authTransport adds static headers and an Authorization header to every request
+type authTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+	token   func() (string, error) // Returns the bearer token; nil or "" for none
+
+	// challenge handles a 401 to a request sent with token, reporting whether
+	// the request should be retried with a new token; nil for none
+	challenge func(resp *http.Response, token string) bool
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+
+	token, err := t.authorize(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && t.challenge != nil && (req.Body == nil || req.GetBody != nil) && t.challenge(resp, token) {
+		// Retry once with the token the challenge obtained
+		retry := req.Clone(req.Context())
+		if req.GetBody != nil {
+			if retry.Body, err = req.GetBody(); err != nil {
+				return resp, nil
+			}
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if _, err := t.authorize(retry); err != nil {
+			return nil, err
+		}
+		if resp, err = t.base.RoundTrip(retry); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// authorize sets the Authorization header, returning the token it used
+func (t *authTransport) authorize(req *http.Request) (string, error) {
+	if t.token == nil {
+		return "", nil
+	}
+	token, err := t.token()
+	if err != nil {
+		return "", fmt.Errorf("failed to obtain access token: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return token, nil
+}
+
+// clockSkewThreshold is how far an upstream's clock may drift before token
+// validation is likely to fail
+const clockSkewThreshold = time.Minute
+
+// clockSkew returns how far the server's Date header is ahead of local time
+func clockSkew(resp *http.Response) (time.Duration, bool) {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, false
+	}
+	// Date has one-second resolution
+	return date.Sub(time.Now()).Round(time.Second), true
+}
+
+// warnClockSkew explains an auth failure caused by a skewed clock, since
+// expired or not-yet-valid tokens are its most common symptom
+func warnClockSkew(resp *http.Response) {
+	skew, ok := clockSkew(resp)
+	if !ok || (skew < clockSkewThreshold && skew > -clockSkewThreshold) {
+		return
+	}
+	log.Printf("[AUTH] HTTP %d from %s with a clock skew of %v (server minus local time); check NTP on both hosts", resp.StatusCode, resp.Request.URL.Host, skew)
+}
+
+// newAuthClient returns a copy of client that authenticates to resource (the
+// upstream URL) with auth and headers
+func newAuthClient(client *http.Client, auth *AuthConfig, headers map[string]string, resource string) *http.Client {
+	if auth == nil && len(headers) == 0 {
+		return client
+	}
+
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	transport := &authTransport{base: base, headers: headers}
+
+	if auth != nil {
+		switch auth.Type {
+		case AuthBearer:
+			token := auth.Token
+			transport.token = func() (string, error) { return token, nil }
+		case AuthOAuth:
+			source := &clientCredentialsSource{config: *auth, resource: resource, client: &http.Client{Transport: base, Timeout: 30 * time.Second}}
+			transport.token = source.Token
+		case AuthAuthorizationCode:
+			source := &authorizationCodeSource{config: *auth, resource: resource, client: &http.Client{Transport: base, Timeout: 30 * time.Second}}
+			transport.token = source.Token
+			transport.challenge = source.challenge
+		}
+	}
+
+	authed := *client
+	authed.Transport = transport
+	return &authed
+}
+
+// clientCredentialsSource fetches and caches OAuth access tokens
+type clientCredentialsSource struct {
+	config   AuthConfig
+	resource string
+	client   *http.Client
+
+	mu           sync.Mutex
+	token        string
+	expires      time.Time
+	discovered   bool   // Endpoints or credentials came from discovery, so send the RFC 8707 resource
+	registration string // Registration endpoint the client ID came from, if registered
+}
+
+// Token returns a cached access token, fetching a new one shortly before it expires
+func (s *clientCredentialsSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Before(s.expires) {
+		return s.token, nil
+	}
+
+	if err := s.resolve(); err != nil {
+		return "", err
+	}
+
+	token, err := s.fetchToken()
+	var tokenErr *tokenError
+	if errors.As(err, &tokenErr) && tokenErr.Code == "invalid_client" && s.registration != "" {
+		// The server forgot our registration; register again once
+		log.Printf("[AUTH] Registered client %s was rejected; registering again", s.config.ClientID)
+		if store, err := openClientStore(s.config.CredentialsFile); err == nil {
+			store.put(s.registration, nil)
+		}
+		s.config.ClientID, s.config.ClientSecret = "", ""
+		if err := s.resolve(); err != nil {
+			return "", err
+		}
+		token, err = s.fetchToken()
+	}
+	return token, err
+}
+
+// resolve discovers the token endpoint and registers a client when the
+// configuration leaves them out
+func (s *clientCredentialsSource) resolve() error {
+	if s.config.TokenURL != "" && s.config.ClientID != "" {
+		return nil
+	}
+
+	metadata, err := discoverAuthServer(s.client, s.resource, s.config.Issuer)
+	if err != nil {
+		return err
+	}
+	s.discovered = true
+	if s.config.TokenURL == "" {
+		if metadata.TokenEndpoint == "" {
+			return fmt.Errorf("authorization server %s has no token endpoint", metadata.Issuer)
+		}
+		s.config.TokenURL = metadata.TokenEndpoint
+	}
+
+	if s.config.ClientID == "" {
+		endpoint := s.config.RegistrationURL
+		if endpoint == "" {
+			endpoint = metadata.RegistrationEndpoint
+		}
+		if endpoint == "" {
+			return fmt.Errorf("authorization server %s does not support dynamic client registration; set clientId", metadata.Issuer)
+		}
+		store, err := openClientStore(s.config.CredentialsFile)
+		if err != nil {
+			return err
+		}
+		request := map[string]interface{}{
+			"grant_types":                []string{"client_credentials"},
+			"token_endpoint_auth_method": "client_secret_post",
+		}
+		if len(s.config.Scopes) > 0 {
+			request["scope"] = strings.Join(s.config.Scopes, " ")
+		}
+		registered, err := registeredClientFor(s.client, store, endpoint, endpoint, request)
+		if err != nil {
+			return err
+		}
+		s.config.ClientID, s.config.ClientSecret = registered.ClientID, registered.ClientSecret
+		s.registration = endpoint
+	}
+
+	return nil
+}
+
+// tokenError is an RFC 6749 error response from the token endpoint
+type tokenError struct {
+	Status      int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// Error implements error
+func (e *tokenError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("token endpoint returned HTTP %d: %s: %s", e.Status, e.Code, e.Description)
+	}
+	return fmt.Sprintf("token endpoint returned HTTP %d: %s", e.Status, e.Code)
+}
+
+// fetchToken requests a new access token; callers must hold s.mu
+func (s *clientCredentialsSource) fetchToken() (string, error) {
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"client_id":  {s.config.ClientID},
+	}
+	if s.config.ClientSecret != "" {
+		form.Set("client_secret", s.config.ClientSecret)
+	}
+	if len(s.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.config.Scopes, " "))
+	}
+	if s.discovered && s.resource != "" {
+		form.Set("resource", s.resource)
+	}
+
+	result, skew, err := requestToken(s.client, s.config.TokenURL, form)
+	if err != nil {
+		return "", err
+	}
+	s.token = result.AccessToken
+	s.expires = tokenExpiry(result.ExpiresIn, skew)
+
+	return s.token, nil
+}
+
+// tokenResponse is a successful RFC 6749 token endpoint response
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// requestToken posts a token request, returning the response and how far the
+// token endpoint's clock is ahead of local time
+func requestToken(client *http.Client, tokenURL string, form url.Values) (*tokenResponse, time.Duration, error) {
+	resp, err := client.PostForm(tokenURL, form)
+	if err != nil {
+		return nil, 0, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	skew, _ := clockSkew(resp)
+	if skew >= clockSkewThreshold || skew <= -clockSkewThreshold {
+		log.Printf("[AUTH] Token endpoint clock differs from local time by %v (server minus local time); check NTP on both hosts", skew)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, skew, fmt.Errorf("failed to read token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		tokenErr := &tokenError{Status: resp.StatusCode}
+		if json.Unmarshal(body, tokenErr) != nil || tokenErr.Code == "" {
+			return nil, skew, fmt.Errorf("token endpoint returned HTTP %d: %s", resp.StatusCode, string(body))
+		}
+		return nil, skew, tokenErr
+	}
+
+	var result tokenResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, skew, fmt.Errorf("invalid token response: %w", err)
+	}
+	if result.AccessToken == "" {
+		return nil, skew, fmt.Errorf("token response has no access_token")
+	}
+	return &result, skew, nil
+}
+
+// tokenExpiry returns when to refresh a token issued for expiresIn seconds:
+// a little early so in-flight requests don't race expiry, and earlier still
+// if the clocks disagree
+func tokenExpiry(expiresIn int, skew time.Duration) time.Time {
+	lifetime := time.Hour
+	if expiresIn > 0 {
+		lifetime = time.Duration(expiresIn) * time.Second
+	}
+	leeway := lifetime / 10
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > leeway {
+		leeway = min(skew, lifetime/2)
+	}
+	return time.Now().Add(lifetime - leeway)
+}
@@ -0,0 +1,410 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loginOptions controls the interactive authorization code flow
+var loginOptions = struct {
+	openBrowser  bool          // Open the authorization URL in a browser, not just print it
+	callbackPort int           // Loopback port receiving the redirect; 0 picks a free one
+	timeout      time.Duration // How long to wait for the user to authorize
+	retryAfter   time.Duration // How long a failed authorization suppresses new attempts
+}{openBrowser: true, callbackPort: 33418, timeout: 5 * time.Minute, retryAfter: time.Minute}
+
+// savedToken is an authorization code grant cached on disk, with what is
+// needed to refresh it
+type savedToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry"`
+	TokenURL     string    `json:"token_url"`
+	ClientID     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+}
+
+// expired reports whether the access token is due for a refresh
+func (t *savedToken) expired() bool {
+	return !t.Expiry.IsZero() && time.Now().After(t.Expiry)
+}
+
+// authorizationCodeSource authenticates with tokens the user grants in a
+// browser, starting the flow when the upstream challenges a request
+type authorizationCodeSource struct {
+	config   AuthConfig
+	resource string
+	client   *http.Client
+
+	mu     sync.Mutex
+	token  *savedToken
+	loaded bool      // The token cache has been read
+	failed time.Time // When authorization last failed
+}
+
+// tokenStore returns the on-disk token cache
+func (s *authorizationCodeSource) tokenStore() (*credentialStore, error) {
+	return openCredentialStore(s.config.TokensFile, "oauth-tokens.json")
+}
+
+// Token returns the current access token, refreshing it when it expired, or
+// "" before the user has authorized the proxy
+func (s *authorizationCodeSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.loaded {
+		s.loaded = true
+		if store, err := s.tokenStore(); err == nil {
+			var token savedToken
+			if found, err := store.get(s.resource, &token); err != nil {
+				log.Printf("[AUTH] %v", err)
+			} else if found {
+				s.token = &token
+			}
+		}
+	}
+
+	if s.token == nil {
+		return "", nil
+	}
+	if s.token.expired() {
+		if err := s.refresh(); err != nil {
+			// Sending no token lets the upstream challenge us into a new authorization
+			log.Printf("[AUTH] Token refresh for %s failed: %v", s.resource, err)
+			s.save(nil)
+			return "", nil
+		}
+	}
+	return s.token.AccessToken, nil
+}
+
+// challenge responds to a 401: it authorizes anew unless another request
+// already replaced the rejected token, and reports whether to retry
+func (s *authorizationCodeSource) challenge(resp *http.Response, sent string) bool {
+	params, ok := parseBearerChallenge(resp.Header.Values("WWW-Authenticate"))
+	if !ok {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && s.token.AccessToken != sent {
+		return true
+	}
+	// A revoked access token may still come with a valid refresh token
+	if s.token != nil && s.token.RefreshToken != "" && s.refresh() == nil {
+		return true
+	}
+	if time.Since(s.failed) < loginOptions.retryAfter {
+		return false
+	}
+
+	if err := s.authorize(params); err != nil {
+		log.Printf("[AUTH] Authorization for %s failed: %v", s.resource, err)
+		s.failed = time.Now()
+		return false
+	}
+	return true
+}
+
+// authorize runs the authorization code flow with PKCE: it discovers the
+// authorization server, registers a client if needed, sends the user to the
+// authorization endpoint and exchanges the code it redirects back with.
+// Callers must hold s.mu.
+func (s *authorizationCodeSource) authorize(params map[string]string) error {
+	issuer := s.config.Issuer
+	if issuer == "" && params["resource_metadata"] != "" {
+		var metadata struct {
+			AuthorizationServers []string `json:"authorization_servers"`
+		}
+		if err := fetchJSON(s.client, params["resource_metadata"], &metadata); err == nil && len(metadata.AuthorizationServers) > 0 {
+			issuer = metadata.AuthorizationServers[0]
+		}
+	}
+	metadata, err := discoverAuthServer(s.client, s.resource, issuer)
+	if err != nil {
+		return err
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" {
+		return fmt.Errorf("authorization server %s lacks an authorization or token endpoint", metadata.Issuer)
+	}
+	if !slices.Contains(metadata.CodeChallengeMethods, "S256") {
+		return fmt.Errorf("authorization server %s does not advertise PKCE (S256) support", metadata.Issuer)
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", loginOptions.callbackPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen for the authorization callback: %w", err)
+	}
+	defer listener.Close()
+	redirectURI := fmt.Sprintf("http://127.0.0.1:%d/callback", listener.Addr().(*net.TCPAddr).Port)
+
+	clientID, clientSecret := s.config.ClientID, s.config.ClientSecret
+	if clientID == "" {
+		endpoint := s.config.RegistrationURL
+		if endpoint == "" {
+			endpoint = metadata.RegistrationEndpoint
+		}
+		if endpoint == "" {
+			return fmt.Errorf("authorization server %s does not support dynamic client registration; set clientId", metadata.Issuer)
+		}
+		store, err := openClientStore(s.config.CredentialsFile)
+		if err != nil {
+			return err
+		}
+		// A registration is only good for the redirect URI it was made with
+		registered, err := registeredClientFor(s.client, store, endpoint+" "+redirectURI, endpoint, map[string]interface{}{
+			"redirect_uris":              []string{redirectURI},
+			"grant_types":                []string{"authorization_code", "refresh_token"},
+			"response_types":             []string{"code"},
+			"token_endpoint_auth_method": "none",
+		})
+		if err != nil {
+			return err
+		}
+		clientID, clientSecret = registered.ClientID, registered.ClientSecret
+	}
+
+	verifier := randomToken(32)
+	state := randomToken(16)
+	challenge := sha256.Sum256([]byte(verifier))
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {redirectURI},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+		"state":                 {state},
+		"resource":              {s.resource},
+	}
+	scope := strings.Join(s.config.Scopes, " ")
+	if scope == "" {
+		scope = params["scope"]
+	}
+	if scope != "" {
+		query.Set("scope", scope)
+	}
+	authURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+	for name, values := range authURL.Query() {
+		if query.Get(name) == "" {
+			query[name] = values
+		}
+	}
+	authURL.RawQuery = query.Encode()
+
+	code, err := waitForAuthorization(listener, authURL.String(), state, s.resource)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {clientID},
+		"code_verifier": {verifier},
+		"resource":      {s.resource},
+	}
+	if clientSecret != "" {
+		form.Set("client_secret", clientSecret)
+	}
+	result, skew, err := requestToken(s.client, metadata.TokenEndpoint, form)
+	if err != nil {
+		return err
+	}
+
+	s.save(&savedToken{
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
+		Expiry:       tokenExpiry(result.ExpiresIn, skew),
+		TokenURL:     metadata.TokenEndpoint,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+	})
+	log.Printf("[AUTH] Authorized access to %s", s.resource)
+	return nil
+}
+
+// refresh replaces the access token using the refresh token; callers must hold s.mu
+func (s *authorizationCodeSource) refresh() error {
+	if s.token.RefreshToken == "" {
+		return fmt.Errorf("no refresh token")
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {s.token.RefreshToken},
+		"client_id":     {s.token.ClientID},
+		"resource":      {s.resource},
+	}
+	if s.token.ClientSecret != "" {
+		form.Set("client_secret", s.token.ClientSecret)
+	}
+	result, skew, err := requestToken(s.client, s.token.TokenURL, form)
+	if err != nil {
+		return err
+	}
+
+	refreshed := *s.token
+	refreshed.AccessToken = result.AccessToken
+	refreshed.Expiry = tokenExpiry(result.ExpiresIn, skew)
+	// Servers that don't rotate refresh tokens leave it out
+	if result.RefreshToken != "" {
+		refreshed.RefreshToken = result.RefreshToken
+	}
+	s.save(&refreshed)
+	return nil
+}
+
+// save replaces the current token and the cached copy on disk; callers must hold s.mu
+func (s *authorizationCodeSource) save(token *savedToken) {
+	s.token = token
+	store, err := s.tokenStore()
+	if err == nil {
+		var v interface{}
+		if token != nil {
+			v = token
+		}
+		err = store.put(s.resource, v)
+	}
+	if err != nil {
+		// The token still works for this run
+		log.Printf("[AUTH] Failed to cache OAuth token: %v", err)
+	}
+}
+
+// waitForAuthorization sends the user to authURL and waits for the
+// authorization server to redirect back to listener with a code
+func waitForAuthorization(listener net.Listener, authURL, state, resource string) (string, error) {
+	results := make(chan url.Values, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/callback" || query.Get("state") != state {
+			http.NotFound(w, r)
+			return
+		}
+		if query.Get("error") != "" {
+			fmt.Fprintf(w, "Authorization failed: %s. You can close this window.\n", query.Get("error"))
+		} else {
+			fmt.Fprintln(w, "Authorization complete. You can close this window.")
+		}
+		select {
+		case results <- query:
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	// stdout carries the MCP session, so the user is told on stderr
+	fmt.Fprintf(os.Stderr, "\n%s requires authorization. Open this URL in a browser to continue:\n\n  %s\n\n", resource, authURL)
+	if loginOptions.openBrowser {
+		if err := openBrowser(authURL); err != nil {
+			log.Printf("[AUTH] Failed to open a browser: %v", err)
+		}
+	}
+
+	select {
+	case query := <-results:
+		if code := query.Get("error"); code != "" {
+			if description := query.Get("error_description"); description != "" {
+				return "", fmt.Errorf("authorization denied: %s: %s", code, description)
+			}
+			return "", fmt.Errorf("authorization denied: %s", code)
+		}
+		if query.Get("code") == "" {
+			return "", fmt.Errorf("authorization callback has no code")
+		}
+		return query.Get("code"), nil
+	case <-time.After(loginOptions.timeout):
+		return "", fmt.Errorf("timed out after %v waiting for authorization", loginOptions.timeout)
+	}
+}
+
+// openBrowser opens a URL with the platform's default browser
+func openBrowser(target string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", target)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", target)
+	default:
+		cmd = exec.Command("xdg-open", target)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// randomToken returns n random bytes, base64url-encoded
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// parseBearerChallenge returns the auth-params of the first Bearer challenge
+// among WWW-Authenticate headers
+func parseBearerChallenge(headers []string) (map[string]string, bool) {
+	for _, header := range headers {
+		scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			continue
+		}
+
+		params := make(map[string]string)
+		for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimLeft(rest, ", ") {
+			key, value, ok := strings.Cut(rest, "=")
+			if !ok {
+				break
+			}
+			key = strings.ToLower(strings.TrimSpace(key))
+			value = strings.TrimLeft(value, " ")
+
+			if strings.HasPrefix(value, `"`) {
+				// quoted-string, with backslash escapes
+				var b strings.Builder
+				i := 1
+				for ; i < len(value) && value[i] != '"'; i++ {
+					if value[i] == '\\' && i+1 < len(value) {
+						i++
+					}
+					b.WriteByte(value[i])
+				}
+				params[key] = b.String()
+				rest = value[min(i+1, len(value)):]
+			} else {
+				end := strings.IndexByte(value, ',')
+				if end < 0 {
+					end = len(value)
+				}
+				params[key] = strings.TrimSpace(value[:end])
+				rest = value[end:]
+			}
+		}
+		return params, true
+	}
+	return nil, false
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// backendPollInterval is the delay between readiness probes during --wait-for-backend
+const backendPollInterval = 250 * time.Millisecond
+
+// maxQueuedMessages bounds how many client messages --queue-while-down holds
+const maxQueuedMessages = 1000
+
+// awaitBackend returns a channel closed once every upstream accepts
+// connections, or answers the --wait-for-backend-url health endpoint with
+// 200, or once backendWait has passed. It is closed right away when there
+// is nothing to wait for.
+func (p *Proxy) awaitBackend(ctx context.Context) <-chan struct{} {
+	ready := make(chan struct{})
+	if p.backendWait <= 0 || p.replay != nil {
+		close(ready)
+		return ready
+	}
+
+	go func() {
+		defer close(ready)
+		defer recoverPanic("backend wait")
+
+		ctx, cancel := context.WithTimeout(ctx, p.backendWait)
+		defer cancel()
+
+		start := time.Now()
+		for _, target := range p.upstreamProxies() {
+			if err := p.pollBackend(ctx, target); err != nil {
+				log.Printf("[INIT] Warning: %s not ready after %v, starting anyway: %v", target.target(), p.backendWait, err)
+				return
+			}
+		}
+		if p.debug {
+			log.Printf("[INIT] Upstream ready after %v", time.Since(start).Round(time.Millisecond))
+		}
+	}()
+	return ready
+}
+
+// pollBackend probes one upstream until it is ready or ctx is done, and
+// returns the last probe error in the latter case
+func (p *Proxy) pollBackend(ctx context.Context, target *Proxy) error {
+	checker, err := NewHealthChecker(target.target(), p.debug)
+	if err != nil {
+		return err
+	}
+	checker.client = &http.Client{Transport: target.client.Transport, Timeout: time.Second}
+	checker.clientHeaders = target.setClientHeaders
+	checker.timeout = time.Second
+	probe := checker.probeTCP
+	if p.backendWaitURL != "" {
+		checker.healthURL = p.backendWaitURL
+		probe = checker.probeHTTP
+	}
+
+	if p.debug {
+		log.Printf("[INIT] Waiting up to %v for %s", p.backendWait, target.target())
+	}
+	for {
+		err := probe(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backendPollInterval):
+		}
+	}
+}
+
+// queueMessage appends a client message read before the upstream was ready
+// (--queue-while-down); once the queue is full, requests are answered with
+// an error instead
+func (p *Proxy) queueMessage(queued []string, line string) []string {
+	if len(queued) < maxQueuedMessages {
+		return append(queued, line)
+	}
+
+	var msg JSONRPCMessage
+	if err := json.Unmarshal([]byte(line), &msg); err == nil && msg.ID != nil && msg.Method != "" {
+		p.sendErrorResponse(msg.ID, -32603, fmt.Sprintf("Internal error: upstream not ready and %d messages already queued", maxQueuedMessages))
+	} else {
+		log.Printf("[ERROR] Upstream not ready and the queue is full, dropped a client message")
+	}
+	return queued
+}
@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Breakpoints pauses client messages matching method or tool name patterns
+// until they are forwarded, edited, or dropped via the control socket
+type Breakpoints struct {
+	mu       sync.Mutex
+	patterns []string
+	paused   map[int]*pausedMessage
+	nextID   int
+}
+
+// pausedMessage is a client message held at a breakpoint
+type pausedMessage struct {
+	id       int
+	method   string
+	line     string
+	decision chan pausedDecision
+}
+
+// pausedDecision tells the main loop what to do with a paused message
+type pausedDecision struct {
+	forward bool
+	line    string
+}
+
+// NewBreakpoints creates an empty breakpoint set
+func NewBreakpoints() *Breakpoints {
+	return &Breakpoints{paused: make(map[int]*pausedMessage)}
+}
+
+// matches reports whether the message hits a breakpoint
+func (b *Breakpoints) matches(msg *JSONRPCMessage) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.patterns) == 0 || msg.Method == "" {
+		return false
+	}
+
+	name := paramsName(msg.Params)
+	for _, pattern := range b.patterns {
+		if ok, _ := path.Match(pattern, msg.Method); ok {
+			return true
+		}
+		if name != "" {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// intercept blocks on a matching message until a decision is made or ctx,
+// the request's or the proxy's lifetime, is done. It returns the (possibly
+// edited) line and whether it should be forwarded.
+func (b *Breakpoints) intercept(ctx context.Context, line string, msg *JSONRPCMessage) (string, bool) {
+	if !b.matches(msg) {
+		return line, true
+	}
+
+	b.mu.Lock()
+	b.nextID++
+	pm := &pausedMessage{
+		id:       b.nextID,
+		method:   msg.Method,
+		line:     line,
+		decision: make(chan pausedDecision, 1),
+	}
+	b.paused[pm.id] = pm
+	b.mu.Unlock()
+
+	log.Printf("[BREAK] Paused #%d: %s (use \"show %d\", \"edit %d <json>\", \"forward %d\" or \"drop %d\")",
+		pm.id, pm.method, pm.id, pm.id, pm.id, pm.id)
+
+	var decision pausedDecision
+	select {
+	case decision = <-pm.decision:
+	case <-ctx.Done():
+		b.mu.Lock()
+		delete(b.paused, pm.id)
+		b.mu.Unlock()
+		log.Printf("[BREAK] Abandoned #%d: %v", pm.id, context.Cause(ctx))
+		return "", false
+	}
+
+	if decision.forward {
+		log.Printf("[BREAK] Forwarding #%d", pm.id)
+	} else {
+		log.Printf("[BREAK] Dropped #%d", pm.id)
+	}
+
+	return decision.line, decision.forward
+}
+
+// resolve removes a paused message and delivers the decision to the main loop
+func (b *Breakpoints) resolve(args string, forward bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	pm, err := b.lookup(args)
+	if err != nil {
+		return err
+	}
+	delete(b.paused, pm.id)
+	pm.decision <- pausedDecision{forward: forward, line: pm.line}
+
+	return nil
+}
+
+// lookup finds a paused message by number; callers must hold b.mu
+func (b *Breakpoints) lookup(args string) (*pausedMessage, error) {
+	id, err := strconv.Atoi(strings.TrimSpace(args))
+	if err != nil {
+		return nil, fmt.Errorf("expected a paused message number")
+	}
+	pm, ok := b.paused[id]
+	if !ok {
+		return nil, fmt.Errorf("no paused message #%d", id)
+	}
+	return pm, nil
+}
+
+func init() {
+	registerControlCommand("break", "break <pattern>", "Pause client messages whose method or tool name matches a glob", func(p *Proxy, args string) (string, error) {
+		if args == "" {
+			return "", fmt.Errorf("pattern required")
+		}
+		if _, err := path.Match(args, ""); err != nil {
+			return "", fmt.Errorf("bad pattern: %w", err)
+		}
+		b := p.breakpoints
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for _, existing := range b.patterns {
+			if existing == args {
+				return "", nil
+			}
+		}
+		b.patterns = append(b.patterns, args)
+		return "", nil
+	})
+
+	registerControlCommand("unbreak", "unbreak <pattern>|all", "Remove a breakpoint", func(p *Proxy, args string) (string, error) {
+		b := p.breakpoints
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if args == "all" {
+			b.patterns = nil
+			return "", nil
+		}
+		for i, existing := range b.patterns {
+			if existing == args {
+				b.patterns = append(b.patterns[:i], b.patterns[i+1:]...)
+				return "", nil
+			}
+		}
+		return "", fmt.Errorf("no breakpoint %q", args)
+	})
+
+	registerControlCommand("breaks", "breaks", "List breakpoints", func(p *Proxy, args string) (string, error) {
+		b := p.breakpoints
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return strings.Join(b.patterns, "\n"), nil
+	})
+
+	registerControlCommand("paused", "paused", "List paused messages", func(p *Proxy, args string) (string, error) {
+		b := p.breakpoints
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		ids := make([]int, 0, len(b.paused))
+		for id := range b.paused {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+
+		var lines []string
+		for _, id := range ids {
+			lines = append(lines, fmt.Sprintf("#%d %s", id, b.paused[id].method))
+		}
+		return strings.Join(lines, "\n"), nil
+	})
+
+	registerControlCommand("show", "show <n>", "Print a paused message", func(p *Proxy, args string) (string, error) {
+		b := p.breakpoints
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		pm, err := b.lookup(args)
+		if err != nil {
+			return "", err
+		}
+		return pm.line, nil
+	})
+
+	registerControlCommand("edit", "edit <n> <json>", "Replace a paused message before forwarding", func(p *Proxy, args string) (string, error) {
+		num, replacement, _ := strings.Cut(args, " ")
+		replacement = strings.TrimSpace(replacement)
+
+		var msg JSONRPCMessage
+		if err := json.Unmarshal([]byte(replacement), &msg); err != nil {
+			return "", fmt.Errorf("invalid JSON-RPC message: %w", err)
+		}
+
+		b := p.breakpoints
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		pm, err := b.lookup(num)
+		if err != nil {
+			return "", err
+		}
+		pm.line = replacement
+		pm.method = msg.Method
+		return "", nil
+	})
+
+	registerControlCommand("forward", "forward <n>", "Release a paused message to the upstream", func(p *Proxy, args string) (string, error) {
+		return "", p.breakpoints.resolve(args, true)
+	})
+
+	registerControlCommand("drop", "drop <n>", "Discard a paused message", func(p *Proxy, args string) (string, error) {
+		return "", p.breakpoints.resolve(args, false)
+	})
+}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBreakpointReleasedWhenDone(t *testing.T) {
+	b := NewBreakpoints()
+	b.patterns = []string{"tools/call"}
+	msg := &JSONRPCMessage{JSONRPC: "2.0", ID: []byte("1"), Method: "tools/call"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	released := make(chan bool)
+	go func() {
+		_, forward := b.intercept(ctx, `{"jsonrpc":"2.0","id":1,"method":"tools/call"}`, msg)
+		released <- forward
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b.mu.Lock()
+		paused := len(b.paused)
+		b.mu.Unlock()
+		if paused == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the message was not paused")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case forward := <-released:
+		if forward {
+			t.Error("a cancelled message was forwarded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the paused message was not released when its context ended")
+	}
+	if len(b.paused) != 0 {
+		t.Errorf("%d message(s) still listed as paused", len(b.paused))
+	}
+}
@@ -0,0 +1,699 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Broker shares one upstream session between several proxy instances.
+//
+// The first proxy started with --broker listens on a Unix socket and owns the
+// upstream session; later proxies attach as thin shims relaying their stdio
+// over the socket. Request IDs and progress tokens are remapped so clients
+// can't collide, and later clients' initialize handshakes are answered from
+// the cached result.
+type Broker struct {
+	proxy *Proxy
+	path  string
+
+	// handshakeMu serializes initialize handshakes, so only the first
+	// client's reaches the upstream and the others get the cached result
+	handshakeMu sync.Mutex
+
+	// Requests are forwarded concurrently, at most maxConcurrent at a time
+	slots    chan struct{}
+	handlers sync.WaitGroup
+
+	// requests and progress translate the clients' request IDs and progress
+	// tokens; they are also used by POST streams delivering in the background
+	requests *idTable
+	progress *idTable
+
+	// initMu guards the cached handshake
+	initMu     sync.Mutex
+	initResult json.RawMessage
+
+	clients sync.WaitGroup
+	// settleTimeout bounds how long a client whose input ended may wait
+	// for its responses; it can't be told apart from one that went away
+	settleTimeout time.Duration
+	// connected are the clients server messages are delivered to
+	connectedMu sync.Mutex
+	connected   map[int]*brokerClient
+
+	// idleTimeout stops a headless broker once no shim has been attached
+	// for this long (--daemon); zero serves until signalled
+	idleTimeout time.Duration
+	attachMu    sync.Mutex
+	attached    int
+	idleTimer   *time.Timer
+	idle        chan struct{}
+}
+
+// brokerRoute remembers which client owns a remapped request ID or progress token
+type brokerRoute struct {
+	client     *brokerClient
+	originalID json.RawMessage
+	method     string
+	// progressToken is the remapped progress token of a request that has one
+	progressToken json.RawMessage
+}
+
+// brokerClient is one attached stdio client (the broker's own or a shim)
+type brokerClient struct {
+	id int
+
+	mu  sync.Mutex // Serializes writes from concurrent requests
+	out io.Writer
+
+	// pending counts the client's requests awaiting a response, so its
+	// connection stays open until they are answered
+	pending sync.WaitGroup
+	// gone is closed once a write fails: the client stopped reading
+	gone     chan struct{}
+	goneOnce sync.Once
+}
+
+// newBrokerClient creates a client writing its messages to out
+func newBrokerClient(id int, out io.Writer) *brokerClient {
+	return &brokerClient{id: id, out: out, gone: make(chan struct{})}
+}
+
+// write sends one message line to the client
+func (c *brokerClient) write(line []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := fmt.Fprintf(c.out, "%s\n", line)
+	if err != nil {
+		c.goneOnce.Do(func() { close(c.gone) })
+	}
+	return err
+}
+
+// runBrokerOrShim connects to an existing broker as a shim, or becomes the broker.
+// in is the client's input stream; output goes to proxy.stdout. A nil in runs
+// a headless broker (--serve) that only serves shims until it is signalled,
+// or until none has been attached for idleTimeout if that is set.
+func runBrokerOrShim(proxy *Proxy, path string, in io.Reader, idleTimeout time.Duration) error {
+	for attempt := 0; attempt < 3; attempt++ {
+		conn, err := net.Dial("unix", path)
+		if err == nil && in == nil {
+			conn.Close()
+			return fmt.Errorf("a broker is already serving %s", path)
+		}
+		if err == nil {
+			if proxy.debug {
+				log.Printf("[BROKER] Attached to broker at %s", path)
+			}
+			return runShim(conn, in, proxy.stdout)
+		}
+
+		// Stale socket from a dead broker: remove it and take over
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			os.Remove(path)
+		}
+
+		listener, err := net.Listen("unix", path)
+		if err == nil {
+			return newBroker(proxy, path, idleTimeout).Run(listener, in)
+		}
+
+		// Another instance won the race to listen; try to attach again
+		if proxy.debug {
+			log.Printf("[BROKER] Failed to listen on %s: %v", path, err)
+		}
+	}
+
+	return fmt.Errorf("could not attach to or start broker at %s", path)
+}
+
+// newBroker creates a broker sharing proxy's session over the socket at path
+func newBroker(proxy *Proxy, path string, idleTimeout time.Duration) *Broker {
+	return &Broker{
+		proxy:         proxy,
+		path:          path,
+		requests:      newIDTable(),
+		progress:      newIDTable(),
+		slots:         make(chan struct{}, max(proxy.maxConcurrent, 1)),
+		settleTimeout: shutdownTimeout,
+		connected:     make(map[int]*brokerClient),
+		idleTimeout:   idleTimeout,
+		idle:          make(chan struct{}),
+	}
+}
+
+// runShim relays stdio to a broker connection until both sides are done
+func runShim(conn net.Conn, in io.Reader, out io.Writer) error {
+	defer conn.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(out, conn)
+		done <- err
+	}()
+
+	if _, err := io.Copy(conn, in); err != nil {
+		return fmt.Errorf("failed to relay stdin to broker: %w", err)
+	}
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		unixConn.CloseWrite()
+	}
+
+	return <-done
+}
+
+// Run serves the broker's own stdio client (reading in) and attached shims.
+// It returns once in is closed and all shims have disconnected.
+func (b *Broker) Run(listener net.Listener, in io.Reader) error {
+	if err := os.Chmod(b.path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict broker socket permissions: %w", err)
+	}
+	defer os.Remove(b.path)
+
+	if b.proxy.debug {
+		log.Printf("[BROKER] Listening on %s", b.path)
+	}
+
+	// Everything the shared proxy writes is routed to the client it belongs
+	// to; the broker's own client gets the proxy's original output
+	own := newBrokerClient(0, b.proxy.stdout)
+	b.proxy.stdout = &brokerWriter{broker: b}
+
+	// Until the first shim attaches, the broker counts as idle
+	if in == nil && b.idleTimeout > 0 {
+		b.attachMu.Lock()
+		b.idleTimer = time.AfterFunc(b.idleTimeout, b.expireIdle)
+		b.attachMu.Unlock()
+	}
+
+	go func() {
+		nextClient := 1
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			client := newBrokerClient(nextClient, conn)
+			nextClient++
+
+			b.clients.Add(1)
+			go func() {
+				defer b.clients.Done()
+				defer conn.Close()
+				defer recoverPanic(fmt.Sprintf("broker client %d", client.id))
+				b.attach()
+				defer b.detach()
+				if b.proxy.debug {
+					log.Printf("[BROKER] Client %d attached", client.id)
+				}
+				b.serveClient(client, conn)
+				// A shim closes its side once its input ends, then waits
+				// for the answers to what it sent
+				b.settle(client)
+				if b.proxy.debug {
+					log.Printf("[BROKER] Client %d detached", client.id)
+				}
+			}()
+		}
+	}()
+
+	// A headless broker serves shims until it is told to stop
+	if in == nil {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(signals)
+		var stopped error
+		select {
+		case sig := <-signals:
+			if b.proxy.debug {
+				log.Printf("[BROKER] Received %v, shutting down", sig)
+			}
+		case err := <-b.proxy.healthFailed:
+			log.Printf("[SHUTDOWN] Health recovery failed, shutting down: %v", err)
+			stopped = &healthFailedError{err: err}
+		case <-b.idle:
+			log.Printf("[BROKER] No clients attached for %v, shutting down", b.idleTimeout)
+		}
+		b.proxy.stop(stopped)
+		listener.Close()
+		b.handlers.Wait()
+		return stopped
+	}
+
+	// The broker's own client reads the proxy's input, and the shared
+	// session stays alive while shims are attached
+	b.clients.Add(1)
+	go func() {
+		defer b.clients.Done()
+		b.serveClient(own, in)
+		b.settle(own)
+	}()
+	served := make(chan struct{})
+	go func() {
+		b.clients.Wait()
+		close(served)
+	}()
+
+	// Reading the own client's input can't be interrupted, so a signal or a
+	// failed upstream returns without waiting for it; the caller exits
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	var stopped error
+	select {
+	case <-served:
+	case sig := <-signals:
+		log.Printf("[SHUTDOWN] Received %v, shutting down", sig)
+		stopped = &signalError{signal: sig}
+	case err := <-b.proxy.healthFailed:
+		log.Printf("[SHUTDOWN] Health recovery failed, shutting down: %v", err)
+		stopped = &healthFailedError{err: err}
+	}
+	if stopped != nil {
+		b.proxy.stop(stopped)
+	}
+	listener.Close()
+	b.handlers.Wait()
+
+	return stopped
+}
+
+// attach counts a connected shim, cancelling a pending idle shutdown
+func (b *Broker) attach() {
+	b.attachMu.Lock()
+	defer b.attachMu.Unlock()
+	b.attached++
+	if b.idleTimer != nil {
+		b.idleTimer.Stop()
+	}
+}
+
+// detach starts the idle countdown when the last shim disconnects
+func (b *Broker) detach() {
+	b.attachMu.Lock()
+	defer b.attachMu.Unlock()
+	b.attached--
+	if b.attached == 0 && b.idleTimer != nil {
+		b.idleTimer.Reset(b.idleTimeout)
+	}
+}
+
+// expireIdle stops the broker unless a shim attached meanwhile
+func (b *Broker) expireIdle() {
+	b.attachMu.Lock()
+	defer b.attachMu.Unlock()
+	if b.attached == 0 {
+		close(b.idle)
+		b.idleTimer = nil
+	}
+}
+
+// serveClient reads messages from one client and forwards them through the shared proxy
+func (b *Broker) serveClient(client *brokerClient, in io.Reader) {
+	b.connectedMu.Lock()
+	b.connected[client.id] = client
+	b.connectedMu.Unlock()
+	defer func() {
+		b.connectedMu.Lock()
+		delete(b.connected, client.id)
+		b.connectedMu.Unlock()
+	}()
+
+	// Messages over the limit are skipped and answered with an error
+	limit := b.proxy.messageLimit()
+	framing, _ := newMessageFraming(FramingNDJSON)
+	framing.maxSize = limit
+	framing.oversized = func(id json.RawMessage) {
+		log.Printf("[ERROR] Skipped a message over the maximum message size of %d bytes from client %d", limit, client.id)
+		if id != nil {
+			b.writeTo(client, JSONRPCMessage{
+				JSONRPC: "2.0",
+				ID:      id,
+				Error: &JSONRPCError{
+					Code:    -32600,
+					Message: fmt.Sprintf("Request exceeds the maximum message size of %d bytes (--max-message-size)", limit),
+				},
+			})
+		}
+	}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(newMessageBuffer(limit), limit+frameOverhead)
+	scanner.Split(framing.split)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		if b.proxy.debug {
+			log.Printf("[BROKER] Client %d sent: %s", client.id, line)
+		}
+
+		var msg JSONRPCMessage
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			log.Printf("[ERROR] Invalid JSON-RPC message from client %d: %v", client.id, err)
+			continue
+		}
+
+		b.handleClientMessage(client, line, &msg)
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Printf("[ERROR] Broker client %d read error: %v", client.id, err)
+	}
+}
+
+// settle waits for a client whose input has ended to get the responses to
+// its outstanding requests. Requests the client can no longer receive, or
+// still open after settleTimeout, are cancelled upstream instead.
+func (b *Broker) settle(client *brokerClient) {
+	answered := make(chan struct{})
+	go func() {
+		client.pending.Wait()
+		close(answered)
+	}()
+
+	select {
+	case <-answered:
+		return
+	case <-client.gone:
+		if b.proxy.debug {
+			log.Printf("[BROKER] Client %d went away, cancelling its requests", client.id)
+		}
+	case <-time.After(b.settleTimeout):
+		log.Printf("[BROKER] Gave up waiting for client %d's requests after %v", client.id, b.settleTimeout)
+	}
+	b.abandon(client)
+}
+
+// abandon cancels a departed client's outstanding requests upstream and
+// forgets them, so late responses are dropped
+func (b *Broker) abandon(client *brokerClient) {
+	for _, proxyID := range b.requests.owned(client) {
+		params, _ := json.Marshal(map[string]interface{}{"requestId": proxyID, "reason": "client disconnected"})
+		data, err := json.Marshal(JSONRPCMessage{JSONRPC: "2.0", Method: "notifications/cancelled", Params: params})
+		if err == nil {
+			// Not dispatched: the cancellation must not queue behind the
+			// request it cancels for a slot
+			b.proxy.handleLine(string(data))
+		}
+		b.releaseRequest(proxyID)
+	}
+}
+
+// handleClientMessage remaps and forwards a single client message
+func (b *Broker) handleClientMessage(client *brokerClient, line string, msg *JSONRPCMessage) {
+	if msg.Method == "initialize" {
+		b.handshakeMu.Lock()
+		defer b.handshakeMu.Unlock()
+	}
+
+	// Later clients join the existing session instead of re-initializing it
+	b.initMu.Lock()
+	initResult := b.initResult
+	b.initMu.Unlock()
+	if initResult != nil {
+		switch msg.Method {
+		case "initialize":
+			b.writeTo(client, JSONRPCMessage{JSONRPC: "2.0", ID: msg.ID, Result: initResult})
+			return
+		case "notifications/initialized":
+			return
+		}
+	}
+
+	var err error
+	var cancelled json.RawMessage
+	switch {
+	case msg.ID != nil && msg.Method != "":
+		line, err = b.remapRequest(client, line, msg)
+	case msg.Method == "notifications/cancelled":
+		// The cancellation names the request by the client's own ID
+		var params struct {
+			RequestID json.RawMessage `json:"requestId"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		proxyID, ok := b.requests.translate(client, params.RequestID)
+		if !ok {
+			if b.proxy.debug {
+				log.Printf("[BROKER] Dropped client %d's cancellation of unknown request %s", client.id, params.RequestID)
+			}
+			return
+		}
+		line, err = replaceParam(line, proxyID, "requestId")
+		cancelled = proxyID
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to remap IDs for client %d: %v", client.id, err)
+		return
+	}
+
+	b.dispatch(line)
+
+	// A cancelled request gets no response, so it is settled here
+	if cancelled != nil {
+		b.releaseRequest(cancelled)
+	}
+}
+
+// dispatch forwards a remapped message through the shared proxy. As in Run,
+// requests from all clients are handled concurrently up to maxConcurrent,
+// while initialize, notifications and responses complete in order.
+func (b *Broker) dispatch(line string) {
+	if b.proxy.answersServerRequest(line) || (b.proxy.maxConcurrent > 1 && !isConcurrentRequest(line)) {
+		b.proxy.handleLine(line)
+		return
+	}
+
+	b.slots <- struct{}{}
+	b.handlers.Add(1)
+	go func() {
+		defer b.handlers.Done()
+		defer func() { <-b.slots }()
+		b.proxy.handleLine(line)
+	}()
+}
+
+// remapRequest gives a client's request, and its progress token if it has
+// one, proxy-assigned identifiers unique across clients
+func (b *Broker) remapRequest(client *brokerClient, line string, msg *JSONRPCMessage) (string, error) {
+	route := brokerRoute{client: client, originalID: msg.ID, method: msg.Method}
+
+	var params struct {
+		Meta struct {
+			ProgressToken json.RawMessage `json:"progressToken"`
+		} `json:"_meta"`
+	}
+	if json.Unmarshal(msg.Params, &params) == nil && params.Meta.ProgressToken != nil {
+		route.progressToken = b.progress.assign(brokerRoute{client: client, originalID: params.Meta.ProgressToken})
+		rewritten, err := replaceParam(line, route.progressToken, "_meta", "progressToken")
+		if err != nil {
+			b.progress.release(route.progressToken)
+			return "", err
+		}
+		line = rewritten
+	}
+
+	client.pending.Add(1)
+	proxyID := b.requests.assign(route)
+	rewritten, err := replaceMessageID(line, proxyID)
+	if err != nil {
+		b.releaseRequest(proxyID)
+		return "", err
+	}
+	return rewritten, nil
+}
+
+// releaseRequest forgets a request's remapped ID and progress token
+func (b *Broker) releaseRequest(proxyID json.RawMessage) (brokerRoute, bool) {
+	route, ok := b.requests.release(proxyID)
+	if !ok {
+		return route, false
+	}
+	if route.progressToken != nil {
+		b.progress.release(route.progressToken)
+	}
+	route.client.pending.Done()
+	return route, true
+}
+
+// writeTo marshals a message and writes it to a client
+func (b *Broker) writeTo(client *brokerClient, msg JSONRPCMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("[ERROR] Failed to marshal broker response: %v", err)
+		return
+	}
+	client.write(data)
+}
+
+// broadcast delivers a message to every connected client
+func (b *Broker) broadcast(line []byte) {
+	b.connectedMu.Lock()
+	clients := make([]*brokerClient, 0, len(b.connected))
+	for _, client := range b.connected {
+		clients = append(clients, client)
+	}
+	b.connectedMu.Unlock()
+
+	for _, client := range clients {
+		client.write(line)
+	}
+}
+
+// primary returns the client attached the longest, which answers server
+// requests: the broker's own client if it has one, else the oldest shim
+func (b *Broker) primary() *brokerClient {
+	b.connectedMu.Lock()
+	defer b.connectedMu.Unlock()
+	var oldest *brokerClient
+	for _, client := range b.connected {
+		if oldest == nil || client.id < oldest.id {
+			oldest = client
+		}
+	}
+	return oldest
+}
+
+// brokerWriter is the shared proxy's output. It restores original request
+// IDs and progress tokens and delivers each message to the client it
+// belongs to; server requests go to the primary client and notifications
+// to every client.
+type brokerWriter struct {
+	broker *Broker
+}
+
+// Write receives one newline-terminated JSON-RPC message per call.
+// Responses and progress notifications go to the client that owns them.
+func (w *brokerWriter) Write(data []byte) (int, error) {
+	var msg JSONRPCMessage
+	line := bytes.TrimSpace(data)
+	if len(line) == 0 {
+		return len(data), nil
+	}
+	if err := json.Unmarshal(line, &msg); err != nil {
+		log.Printf("[ERROR] Broker dropped an invalid message: %v", err)
+		return len(data), nil
+	}
+
+	var target *brokerClient
+	switch {
+	case msg.ID != nil && msg.Method == "":
+		route, ok := w.broker.releaseRequest(msg.ID)
+		if !ok {
+			if w.broker.proxy.debug {
+				log.Printf("[BROKER] Dropped response %s to no known request", msg.ID)
+			}
+			return len(data), nil
+		}
+		if route.method == "initialize" && msg.Result != nil {
+			w.broker.initMu.Lock()
+			w.broker.initResult = msg.Result
+			w.broker.initMu.Unlock()
+		}
+		if restored, err := replaceMessageID(string(line), route.originalID); err == nil {
+			line = []byte(restored)
+		}
+		target = route.client
+	case msg.Method == "notifications/progress":
+		var params struct {
+			ProgressToken json.RawMessage `json:"progressToken"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		route, ok := w.broker.progress.lookup(params.ProgressToken)
+		if !ok {
+			// The request has finished; no client knows the token
+			if w.broker.proxy.debug {
+				log.Printf("[BROKER] Dropped progress for unknown token %s", params.ProgressToken)
+			}
+			return len(data), nil
+		}
+		if restored, err := replaceParam(string(line), route.originalID, "progressToken"); err == nil {
+			line = []byte(restored)
+		}
+		target = route.client
+	case msg.Method != "" && msg.ID != nil:
+		// A server request must be answered once, so one client gets it
+		if target = w.broker.primary(); target == nil {
+			log.Printf("[BROKER] Dropped server request %s %s: no client attached", msg.Method, msg.ID)
+			return len(data), nil
+		}
+	}
+
+	// A client that has gone away no longer takes part in the session
+	if target != nil {
+		target.write(line)
+	} else {
+		w.broker.broadcast(line)
+	}
+	return len(data), nil
+}
+
+// replaceMessageID returns the message with its "id" member replaced
+func replaceMessageID(line string, id json.RawMessage) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return "", err
+	}
+	fields["id"] = id
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// replaceParam returns the message with the params member at path replaced
+func replaceParam(line string, value json.RawMessage, path ...string) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return "", err
+	}
+	params, err := replaceMember(fields["params"], value, path)
+	if err != nil {
+		return "", err
+	}
+	fields["params"] = params
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// replaceMember sets the member at path within a JSON object
+func replaceMember(object json.RawMessage, value json.RawMessage, path []string) (json.RawMessage, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	fields := make(map[string]json.RawMessage)
+	if object != nil {
+		if err := json.Unmarshal(object, &fields); err != nil {
+			return nil, err
+		}
+		if fields == nil {
+			fields = make(map[string]json.RawMessage)
+		}
+	}
+	member, err := replaceMember(fields[path[0]], value, path[1:])
+	if err != nil {
+		return nil, err
+	}
+	fields[path[0]] = member
+	return json.Marshal(fields)
+}
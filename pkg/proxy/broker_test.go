@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startTestBroker serves a headless broker for upstream on a temporary socket
+func startTestBroker(t *testing.T, upstream *testUpstream) string {
+	p, err := New(Config{URL: upstream.URL, MaxConcurrent: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "broker.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The broker exits once its clients have been gone for the idle timeout,
+	// and cancels a departed client's requests after a second
+	b := newBroker(p, path, 200*time.Millisecond)
+	b.settleTimeout = time.Second
+	done := make(chan error, 1)
+	go func() { done <- b.Run(listener, nil) }()
+	t.Cleanup(func() {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Error("broker did not stop after its clients left")
+		}
+	})
+	return path
+}
+
+// testClient is a shim connection to a broker
+type testClient struct {
+	t       *testing.T
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+func dialBroker(t *testing.T, path string) *testClient {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, scanner: bufio.NewScanner(conn)}
+}
+
+// connectClients attaches n initialized clients, in order, once the
+// broker's GET stream is open
+func connectClients(t *testing.T, path string, upstream *testUpstream, n int) []*testClient {
+	var clients []*testClient
+	for i := 0; i < n; i++ {
+		c := dialBroker(t, path)
+		c.initialize()
+		clients = append(clients, c)
+	}
+	select {
+	case <-upstream.streamOpen:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the broker did not open the GET stream")
+	}
+	return clients
+}
+
+func (c *testClient) send(format string, args ...interface{}) {
+	if _, err := fmt.Fprintf(c.conn, format+"\n", args...); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) receive() JSONRPCMessage {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if !c.scanner.Scan() {
+		c.t.Fatalf("no message from the broker: %v", c.scanner.Err())
+	}
+	var msg JSONRPCMessage
+	if err := json.Unmarshal(c.scanner.Bytes(), &msg); err != nil {
+		c.t.Fatalf("invalid message %q: %v", c.scanner.Text(), err)
+	}
+	return msg
+}
+
+// initialize performs the client's handshake
+func (c *testClient) initialize() {
+	c.send(`{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":%q,"capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`, latestProtocolVersion)
+	if msg := c.receive(); string(msg.ID) != "0" || msg.Result == nil {
+		c.t.Fatalf("unexpected initialize response: %+v", msg)
+	}
+	c.send(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+}
+
+func TestBrokerClientsShareSession(t *testing.T) {
+	const calls = 5
+	const delay = 50 * time.Millisecond
+	upstream := newTestUpstream(t, delay)
+	path := startTestBroker(t, upstream)
+
+	clients := connectClients(t, path, upstream, 2)
+
+	// Both clients use the same request IDs; each must get its own answers,
+	// and the calls must overlap rather than run one after the other
+	start := time.Now()
+	for n, c := range clients {
+		for id := 1; id <= calls; id++ {
+			c.send(`{"jsonrpc":"2.0","id":%d,"method":"tools/call","params":{"name":"echo","arguments":{"client":%d,"id":%d}}}`, id, n, id)
+		}
+	}
+	var wg sync.WaitGroup
+	for n, c := range clients {
+		wg.Add(1)
+		go func(n int, c *testClient) {
+			defer wg.Done()
+			for i := 0; i < calls; i++ {
+				msg := c.receive()
+				var result struct {
+					Arguments struct {
+						Client int `json:"client"`
+						ID     int `json:"id"`
+					} `json:"arguments"`
+				}
+				if err := json.Unmarshal(msg.Result, &result); err != nil {
+					t.Errorf("client %d: unexpected response %+v", n, msg)
+					return
+				}
+				if result.Arguments.Client != n || string(msg.ID) != fmt.Sprint(result.Arguments.ID) {
+					t.Errorf("client %d got response %s for client %d's request %d", n, msg.ID, result.Arguments.Client, result.Arguments.ID)
+				}
+			}
+		}(n, c)
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed >= time.Duration(calls*len(clients))*delay/2 {
+		t.Errorf("%d calls took %v; they were not forwarded concurrently", calls*len(clients), elapsed)
+	}
+
+	// Server notifications reach every client
+	upstream.notify <- `{"jsonrpc":"2.0","method":"notifications/tools/list_changed"}`
+	for n, c := range clients {
+		if msg := c.receive(); msg.Method != "notifications/tools/list_changed" {
+			t.Errorf("client %d: expected the list_changed notification, got %+v", n, msg)
+		}
+	}
+}
+
+func TestBrokerServerRequestGoesToOneClient(t *testing.T) {
+	upstream := newTestUpstream(t, 0)
+	path := startTestBroker(t, upstream)
+	clients := connectClients(t, path, upstream, 2)
+
+	// The first client answers; the second never sees the request
+	upstream.notify <- `{"jsonrpc":"2.0","id":"server-1","method":"roots/list"}`
+	upstream.notify <- `{"jsonrpc":"2.0","method":"notifications/tools/list_changed"}`
+
+	if msg := clients[0].receive(); msg.Method != "roots/list" || string(msg.ID) != `"server-1"` {
+		t.Fatalf("client 0: expected the server request, got %+v", msg)
+	}
+	clients[0].send(`{"jsonrpc":"2.0","id":"server-1","result":{"roots":[]}}`)
+	select {
+	case msg := <-upstream.responses:
+		if string(msg.ID) != `"server-1"` {
+			t.Errorf("upstream got the answer to %s", msg.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the client's answer did not reach the upstream")
+	}
+
+	for n, c := range clients {
+		if msg := c.receive(); msg.Method != "notifications/tools/list_changed" {
+			t.Errorf("client %d: expected the list_changed notification, got %+v", n, msg)
+		}
+	}
+}
+
+func TestBrokerProgressGoesToItsClient(t *testing.T) {
+	upstream := newTestUpstream(t, 0)
+	path := startTestBroker(t, upstream)
+	clients := connectClients(t, path, upstream, 2)
+
+	// Both clients pick the same request ID and progress token
+	for n, c := range clients {
+		c.send(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo","arguments":{"client":%d},"_meta":{"progressToken":"p"}}}`, n)
+	}
+	for n, c := range clients {
+		progress := c.receive()
+		var params struct {
+			ProgressToken json.RawMessage `json:"progressToken"`
+		}
+		json.Unmarshal(progress.Params, &params)
+		if progress.Method != "notifications/progress" || string(params.ProgressToken) != `"p"` {
+			t.Fatalf("client %d: expected progress for its own token, got %+v", n, progress)
+		}
+
+		response := c.receive()
+		var result struct {
+			Arguments struct {
+				Client int `json:"client"`
+			} `json:"arguments"`
+		}
+		json.Unmarshal(response.Result, &result)
+		if string(response.ID) != "1" || result.Arguments.Client != n {
+			t.Errorf("client %d: expected its own response, got %+v", n, response)
+		}
+	}
+}
+
+func TestBrokerAnswersClientAfterItsInputEnds(t *testing.T) {
+	upstream := newTestUpstream(t, 100*time.Millisecond)
+	path := startTestBroker(t, upstream)
+	c := connectClients(t, path, upstream, 1)[0]
+
+	// A shim closes its side as soon as its stdin ends, then waits for answers
+	c.send(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo","arguments":{}}}`)
+	c.conn.(*net.UnixConn).CloseWrite()
+
+	if msg := c.receive(); string(msg.ID) != "1" || msg.Result == nil {
+		t.Fatalf("expected the response to the request, got %+v", msg)
+	}
+}
+
+func TestBrokerCancelsDepartedClientRequests(t *testing.T) {
+	upstream := newTestUpstream(t, 0)
+	path := startTestBroker(t, upstream)
+	c := connectClients(t, path, upstream, 1)[0]
+
+	c.send(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo","arguments":{"wait":true}}}`)
+	upstream.expectMessage(t, "tools/call")
+	c.conn.Close()
+
+	cancelled := upstream.expectMessage(t, "notifications/cancelled")
+	var params struct {
+		RequestID json.RawMessage `json:"requestId"`
+	}
+	json.Unmarshal(cancelled.Params, &params)
+	if params.RequestID == nil {
+		t.Fatalf("expected the request to be cancelled, got %+v", cancelled)
+	}
+}
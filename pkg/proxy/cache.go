@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"path"
+	"sync"
+	"time"
+)
+
+// ResultCache caches tools/call results for designated read-only tools
+type ResultCache struct {
+	mu         sync.Mutex
+	tools      []string // Glob patterns of cacheable tool names
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]*cacheEntry
+	order      []string // Insertion order, oldest first, for eviction
+	debug      bool
+}
+
+// cacheEntry is a cached result with its expiry time
+type cacheEntry struct {
+	result  json.RawMessage
+	expires time.Time
+}
+
+// NewResultCache creates a cache for the given tool name patterns
+func NewResultCache(tools []string, ttl time.Duration, maxEntries int, debug bool) *ResultCache {
+	return &ResultCache{
+		tools:      tools,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*cacheEntry),
+		debug:      debug,
+	}
+}
+
+// key returns the cache key for a tools/call message, or "" if it isn't cacheable
+func (c *ResultCache) key(msg *JSONRPCMessage) string {
+	if msg.Method != "tools/call" || msg.ID == nil {
+		return ""
+	}
+
+	var params struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil || params.Name == "" {
+		return ""
+	}
+
+	cacheable := false
+	for _, pattern := range c.tools {
+		if ok, _ := path.Match(pattern, params.Name); ok {
+			cacheable = true
+			break
+		}
+	}
+	if !cacheable {
+		return ""
+	}
+
+	// Re-marshal arguments so key order and whitespace don't affect the key
+	var args interface{}
+	if len(params.Arguments) > 0 {
+		if err := json.Unmarshal(params.Arguments, &args); err != nil {
+			return ""
+		}
+	}
+	canonical, err := json.Marshal(args)
+	if err != nil {
+		return ""
+	}
+
+	return params.Name + "\x00" + string(canonical)
+}
+
+// get returns a cached result if present and not expired
+func (c *ResultCache) get(key string) (json.RawMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		c.remove(key)
+		return nil, false
+	}
+	return entry.result, true
+}
+
+// put stores a result, evicting the oldest entries when over the size limit
+func (c *ResultCache) put(key string, result json.RawMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; exists {
+		c.remove(key)
+	}
+	c.entries[key] = &cacheEntry{result: result, expires: time.Now().Add(c.ttl)}
+	c.order = append(c.order, key)
+
+	for c.maxEntries > 0 && len(c.entries) > c.maxEntries {
+		c.remove(c.order[0])
+	}
+}
+
+// remove deletes an entry; callers must hold c.mu
+func (c *ResultCache) remove(key string) {
+	delete(c.entries, key)
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// serveCached answers a tools/call from the cache, returning false on a miss.
+// On a miss the eventual upstream result is stored for next time.
+func (p *Proxy) serveCached(msg *JSONRPCMessage) bool {
+	key := p.resultCache.key(msg)
+	if key == "" {
+		return false
+	}
+
+	if result, ok := p.resultCache.get(key); ok {
+		resp := JSONRPCMessage{JSONRPC: "2.0", ID: msg.ID, Result: result}
+		data, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("[ERROR] Failed to marshal cached response: %v", err)
+			return false
+		}
+		p.writeMessage(&resp, data)
+		if p.debug {
+			log.Printf("[CACHE] Hit for %s: %s", paramsName(msg.Params), data)
+		}
+		return true
+	}
+
+	if p.debug {
+		log.Printf("[CACHE] Miss for %s", paramsName(msg.Params))
+	}
+
+	p.onResponse(msg.ID, func(resp *JSONRPCMessage) {
+		if resp.Error != nil || resp.Result == nil {
+			return
+		}
+		// Tool-level failures are reported in the result, don't cache them either
+		var result struct {
+			IsError bool `json:"isError"`
+		}
+		if json.Unmarshal(resp.Result, &result) == nil && result.IsError {
+			return
+		}
+		p.resultCache.put(key, resp.Result)
+	})
+
+	return false
+}
@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// methodCapabilities maps request methods (or method prefixes ending in "/")
+// to the server capability path they depend on
+var methodCapabilities = []struct {
+	method     string
+	capability string
+}{
+	{"resources/subscribe", "resources.subscribe"},
+	{"resources/unsubscribe", "resources.subscribe"},
+	{"logging/setLevel", "logging"},
+	{"completion/complete", "completions"},
+	{"tools/", "tools"},
+	{"prompts/", "prompts"},
+	{"resources/", "resources"},
+}
+
+// capabilityProbe compares what the client asked for during initialize with
+// what the server granted, and remembers the mismatches it found
+type capabilityProbe struct {
+	mu         sync.Mutex
+	requested  string // Protocol version requested by the client
+	negotiated string // Protocol version granted by the server
+	client     map[string]json.RawMessage
+	server     map[string]json.RawMessage
+	warnings   []string
+	warned     map[string]bool // Capabilities already reported as missing on use
+}
+
+// probeCapabilities records the negotiated capabilities from an initialize
+// response and reports mismatches with the client's request
+func (p *Proxy) probeCapabilities(msg *JSONRPCMessage) {
+	if msg.Result == nil {
+		return
+	}
+
+	var request struct {
+		ProtocolVersion string                     `json:"protocolVersion"`
+		Capabilities    map[string]json.RawMessage `json:"capabilities"`
+	}
+	p.sessionMu.Lock()
+	json.Unmarshal(p.initParams, &request)
+	p.sessionMu.Unlock()
+
+	var result struct {
+		ProtocolVersion string                     `json:"protocolVersion"`
+		Capabilities    map[string]json.RawMessage `json:"capabilities"`
+	}
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		return
+	}
+
+	var warnings []string
+	if request.ProtocolVersion != "" && result.ProtocolVersion != request.ProtocolVersion {
+		warnings = append(warnings, fmt.Sprintf("client requested protocol version %s, server negotiated %s", request.ProtocolVersion, result.ProtocolVersion))
+	}
+	for _, feature := range missingExperimental(request.Capabilities, result.Capabilities) {
+		warnings = append(warnings, fmt.Sprintf("client uses experimental capability %q, which the server does not declare", feature))
+	}
+
+	c := &p.capabilities
+	c.mu.Lock()
+	c.requested = request.ProtocolVersion
+	c.negotiated = result.ProtocolVersion
+	c.client = request.Capabilities
+	c.server = result.Capabilities
+	c.warnings = warnings
+	c.warned = make(map[string]bool)
+	c.mu.Unlock()
+
+	for _, warning := range warnings {
+		p.capabilityWarning(warning)
+	}
+}
+
+// checkCapability warns once per capability when the client calls a method
+// the server did not declare support for during initialize
+func (p *Proxy) checkCapability(msg *JSONRPCMessage) {
+	if msg.ID == nil || msg.Method == "" {
+		return
+	}
+
+	capability := methodCapability(msg.Method)
+	if capability == "" {
+		return
+	}
+
+	c := &p.capabilities
+	c.mu.Lock()
+	if c.server == nil || c.warned[capability] || hasCapability(c.server, capability) {
+		c.mu.Unlock()
+		return
+	}
+	c.warned[capability] = true
+	warning := fmt.Sprintf("client called %s, but the server did not declare the %q capability", msg.Method, capability)
+	c.warnings = append(c.warnings, warning)
+	c.mu.Unlock()
+
+	p.capabilityWarning(warning)
+}
+
+// methodCapability returns the server capability a request method depends on
+func methodCapability(method string) string {
+	for _, entry := range methodCapabilities {
+		if method == entry.method || (strings.HasSuffix(entry.method, "/") && strings.HasPrefix(method, entry.method)) {
+			return entry.capability
+		}
+	}
+	return ""
+}
+
+// capabilityWarning logs a mismatch and, if enabled, tells the client
+func (p *Proxy) capabilityWarning(warning string) {
+	log.Printf("[CAPABILITIES] Warning: %s", warning)
+	if p.capabilityNotify {
+		p.sendLogMessage("warning", warning)
+	}
+}
+
+// hasCapability reports whether a dotted capability path is declared; nested
+// flags such as resources.subscribe must be true
+func hasCapability(capabilities map[string]json.RawMessage, path string) bool {
+	name, flag, nested := strings.Cut(path, ".")
+	raw, ok := capabilities[name]
+	if !ok || string(raw) == "null" {
+		return false
+	}
+	if !nested {
+		return true
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return false
+	}
+	return string(fields[flag]) == "true"
+}
+
+// missingExperimental lists the client's experimental capabilities the server lacks
+func missingExperimental(client, server map[string]json.RawMessage) []string {
+	var clientFeatures, serverFeatures map[string]json.RawMessage
+	json.Unmarshal(client["experimental"], &clientFeatures)
+	json.Unmarshal(server["experimental"], &serverFeatures)
+
+	var missing []string
+	for feature := range clientFeatures {
+		if _, ok := serverFeatures[feature]; !ok {
+			missing = append(missing, feature)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// report renders the negotiated capabilities and mismatches for the doctor command
+func (c *capabilityProbe) report() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.server == nil {
+		return "No initialize handshake seen yet"
+	}
+
+	keys := func(m map[string]json.RawMessage) string {
+		names := sortedKeys(m)
+		if len(names) == 0 {
+			return "(none)"
+		}
+		return strings.Join(names, ", ")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Protocol version: requested %s, negotiated %s\n", c.requested, c.negotiated)
+	fmt.Fprintf(&b, "Client capabilities: %s\n", keys(c.client))
+	fmt.Fprintf(&b, "Server capabilities: %s\n", keys(c.server))
+	if len(c.warnings) == 0 {
+		fmt.Fprintf(&b, "No capability mismatches")
+	} else {
+		fmt.Fprintf(&b, "Mismatches:")
+		for _, warning := range c.warnings {
+			fmt.Fprintf(&b, "\n  - %s", warning)
+		}
+	}
+	return b.String()
+}
+
+func init() {
+	registerControlCommand("doctor", "doctor", "Show negotiated capabilities and client/server mismatches", func(p *Proxy, args string) (string, error) {
+		return p.capabilities.report(), nil
+	})
+}
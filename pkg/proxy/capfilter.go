@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// CapabilityFilter rewrites the capabilities negotiated by initialize so
+// clients don't attempt features blocked at the proxy (--disable-capability,
+// --capability). Disabled capabilities are removed from both the client's
+// initialize params and the server's result, and requests depending on a
+// disabled server capability are refused; overrides replace what the server
+// advertises.
+type CapabilityFilter struct {
+	disabled  []string          // Dotted capability paths, e.g. prompts or resources.subscribe
+	overrides []capabilityValue // Applied in order after disabling
+	debug     bool
+}
+
+// capabilityValue is one --capability override
+type capabilityValue struct {
+	path  string
+	value json.RawMessage
+}
+
+// NewCapabilityFilter parses comma-separated lists of capabilities to disable
+// and NAME=JSON overrides
+func NewCapabilityFilter(disabled, overrides []string, debug bool) (*CapabilityFilter, error) {
+	f := &CapabilityFilter{debug: debug}
+	for _, list := range disabled {
+		for _, path := range strings.Split(list, ",") {
+			path = strings.TrimSpace(path)
+			if path == "" {
+				continue
+			}
+			if strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+				return nil, fmt.Errorf("invalid capability %q", path)
+			}
+			f.disabled = append(f.disabled, path)
+		}
+	}
+	for _, spec := range overrides {
+		path, value, ok := strings.Cut(spec, "=")
+		path = strings.TrimSpace(path)
+		if !ok || path == "" || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			return nil, fmt.Errorf("invalid capability override %q (expected NAME=JSON)", spec)
+		}
+		if !json.Valid([]byte(value)) {
+			return nil, fmt.Errorf("capability override %q: value is not valid JSON", spec)
+		}
+		f.overrides = append(f.overrides, capabilityValue{path: path, value: json.RawMessage(value)})
+	}
+	return f, nil
+}
+
+// filterRequest strips disabled capabilities from the client's initialize
+// params, returning the line to forward
+func (f *CapabilityFilter) filterRequest(line string, msg *JSONRPCMessage) string {
+	var params struct {
+		Capabilities map[string]json.RawMessage `json:"capabilities"`
+	}
+	if json.Unmarshal(msg.Params, &params) != nil || params.Capabilities == nil {
+		return line
+	}
+
+	var removed []string
+	for _, path := range f.disabled {
+		if removeCapability(params.Capabilities, path) {
+			removed = append(removed, path)
+		}
+	}
+	if len(removed) == 0 {
+		return line
+	}
+
+	capabilities, _ := json.Marshal(params.Capabilities)
+	edited, err := replaceParam(line, capabilities, "capabilities")
+	if err != nil {
+		return line
+	}
+	var updated JSONRPCMessage
+	if err := json.Unmarshal([]byte(edited), &updated); err != nil {
+		return line
+	}
+	*msg = updated
+	if f.debug {
+		log.Printf("[CAPABILITIES] Removed client capabilities %s from initialize", strings.Join(removed, ", "))
+	}
+	return edited
+}
+
+// filterResult applies the disabled capabilities and overrides to the
+// server's initialize result
+func (f *CapabilityFilter) filterResult(msg *JSONRPCMessage, data []byte) []byte {
+	return rewriteResult(msg, data, func(result map[string]json.RawMessage) bool {
+		capabilities := make(map[string]json.RawMessage)
+		if raw, ok := result["capabilities"]; ok && string(raw) != "null" && json.Unmarshal(raw, &capabilities) != nil {
+			return false
+		}
+
+		changed := false
+		for _, path := range f.disabled {
+			changed = removeCapability(capabilities, path) || changed
+		}
+		for _, override := range f.overrides {
+			raw, _ := json.Marshal(capabilities)
+			updated, err := replaceMember(raw, override.value, strings.Split(override.path, "."))
+			if err != nil {
+				log.Printf("[CAPABILITIES] Cannot override %s: %v", override.path, err)
+				continue
+			}
+			capabilities = make(map[string]json.RawMessage)
+			json.Unmarshal(updated, &capabilities)
+			changed = true
+		}
+		if !changed {
+			return false
+		}
+		result["capabilities"], _ = json.Marshal(capabilities)
+		return true
+	})
+}
+
+// blocks returns the disabled capability a request depends on, if any
+func (f *CapabilityFilter) blocks(msg *JSONRPCMessage) string {
+	if msg.ID == nil || msg.Method == "" {
+		return ""
+	}
+	capability := methodCapability(msg.Method)
+	if capability == "" {
+		return ""
+	}
+	for _, path := range f.disabled {
+		if capability == path || strings.HasPrefix(capability, path+".") {
+			return path
+		}
+	}
+	return ""
+}
+
+// refuseDisabled answers a request that depends on a disabled capability
+// and reports whether it did
+func (p *Proxy) refuseDisabled(msg *JSONRPCMessage) bool {
+	capability := p.capabilityFilter.blocks(msg)
+	if capability == "" {
+		return false
+	}
+	if p.debug {
+		log.Printf("[CAPABILITIES] Refused %s: the %q capability is disabled", msg.Method, capability)
+	}
+	p.sendErrorResponse(msg.ID, -32601, fmt.Sprintf("Method not found: %s (the %q capability is disabled by the proxy)", msg.Method, capability))
+	return true
+}
+
+// removeCapability deletes the capability at a dotted path and reports
+// whether it was there
+func removeCapability(capabilities map[string]json.RawMessage, path string) bool {
+	name, rest, nested := strings.Cut(path, ".")
+	raw, ok := capabilities[name]
+	if !ok {
+		return false
+	}
+	if !nested {
+		delete(capabilities, name)
+		return true
+	}
+
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(raw, &fields) != nil || !removeCapability(fields, rest) {
+		return false
+	}
+	capabilities[name], _ = json.Marshal(fields)
+	return true
+}
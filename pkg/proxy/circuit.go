@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	circuitClosed   = "closed"    // Requests flow normally
+	circuitOpen     = "open"      // Requests fail fast
+	circuitHalfOpen = "half-open" // One probe request is let through
+)
+
+// CircuitBreaker stops forwarding to an upstream after consecutive failures,
+// failing requests fast instead of stalling the client for the full retry
+// budget. After a cooldown one request probes the upstream: success closes
+// the circuit, failure opens it for another cooldown.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int // Consecutive failed requests
+	lastErr  error
+	openedAt time.Time
+	probing  bool
+}
+
+// circuitOpenError is returned for requests refused by an open circuit
+type circuitOpenError struct {
+	failures int
+	lastErr  error
+	retryIn  time.Duration
+}
+
+// Error implements error
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("upstream unavailable: circuit breaker open after %d consecutive failures (last: %v); next attempt in %v",
+		e.failures, e.lastErr, e.retryIn.Round(time.Second))
+}
+
+// NewCircuitBreaker creates a closed breaker opening after threshold failures
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, state: circuitClosed}
+}
+
+// allow reports whether a request may be forwarded, returning a
+// circuitOpenError if not. Once the cooldown has passed, the first caller
+// becomes the half-open probe.
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if wait := b.cooldown - time.Since(b.openedAt); wait > 0 {
+			return &circuitOpenError{failures: b.failures, lastErr: b.lastErr, retryIn: wait}
+		}
+		b.state = circuitHalfOpen
+		b.probing = true
+		log.Printf("[CIRCUIT] Half-open: probing the upstream")
+		return nil
+	case circuitHalfOpen:
+		if b.probing {
+			return &circuitOpenError{failures: b.failures, lastErr: b.lastErr}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// success records a request the upstream answered, closing the circuit
+func (b *CircuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != circuitClosed {
+		log.Printf("[CIRCUIT] Closed: upstream recovered")
+	}
+	b.state = circuitClosed
+	b.failures = 0
+	b.lastErr = nil
+	b.probing = false
+}
+
+// failure records a request that failed after all retries; it opens the
+// circuit at the threshold or when the half-open probe fails
+func (b *CircuitBreaker) failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.lastErr = err
+	b.probing = false
+
+	if b.state == circuitHalfOpen || (b.state == circuitClosed && b.failures >= b.threshold) {
+		b.state = circuitOpen
+		b.openedAt = time.Now()
+		log.Printf("[CIRCUIT] Open after %d consecutive failures, failing fast for %v: %v", b.failures, b.cooldown, err)
+	}
+}
+
+// reset closes the circuit, e.g. after switching to another upstream
+func (b *CircuitBreaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = circuitClosed
+	b.failures = 0
+	b.lastErr = nil
+	b.probing = false
+}
+
+// status describes the breaker for diagnostics
+func (b *CircuitBreaker) status() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures == 0 {
+		return b.state
+	}
+	return fmt.Sprintf("%s (%d consecutive failures, last: %v)", b.state, b.failures, b.lastErr)
+}
+
+// abandon releases the half-open probe slot of a request that ended without
+// an outcome, e.g. because the client cancelled it
+func (b *CircuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// countsAsFailure reports whether an error says the upstream is unhealthy;
+// requests the upstream rejected on their merits don't trip the breaker
+func countsAsFailure(err error) bool {
+	var status *httpStatusError
+	if errors.As(err, &status) {
+		return status.code >= 500 || status.code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+func init() {
+	registerControlCommand("circuit", "circuit", "Show the circuit breaker state", func(p *Proxy, args string) (string, error) {
+		if p.breaker == nil {
+			return "", fmt.Errorf("circuit breaker is disabled")
+		}
+		return p.breaker.status(), nil
+	})
+}
@@ -0,0 +1,1087 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// Main runs the mcp-stdio-proxy command line from os.Args and exits with
+// its status once everything it set up has been cleaned up
+func Main() {
+	os.Exit(run())
+}
+
+// run is Main's body; it returns the exit status rather than exiting, so
+// that its deferred cleanup (transcripts, metrics push, sockets) always runs
+func run() int {
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "service" {
+		return runServiceCommand(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "transcript" {
+		return runTranscriptCommand(os.Args[2:])
+	}
+
+	// Define flags
+	var envFileFlag stringList
+	flag.Var(&envFileFlag, "env-file", "Load environment variables from a KEY=VALUE file before anything else (repeatable)")
+	debugFlag := flag.Bool("debug", false, "Enable debug logging")
+	verboseFlag := flag.Bool("v", false, "Enable verbose logging (alias for --debug)")
+	flag.BoolVar(verboseFlag, "verbose", false, "Enable verbose logging (alias for --debug)")
+	logFormatFlag := flag.String("log-format", "text", "Log format: text or json")
+	logLevelFlag := flag.String("log-level", "info", "Log level: error, warn, info, debug or trace (--debug implies trace)")
+	timeoutFlag := flag.Int("timeout", 120, "HTTP request timeout in seconds, including reading a streamed response (0 for none)")
+	connectTimeoutFlag := flag.Duration("connect-timeout", 0, "Timeout for establishing an upstream connection (0 uses Go's default of 30s)")
+	tlsHandshakeTimeoutFlag := flag.Duration("tls-handshake-timeout", 0, "Timeout for the upstream TLS handshake (0 uses Go's default of 10s)")
+	responseHeaderTimeoutFlag := flag.Duration("response-header-timeout", 0, "Timeout for the upstream to start answering a request, not counting a streamed body (0 for none)")
+	idleConnTimeoutFlag := flag.Duration("idle-conn-timeout", 0, "How long an idle upstream keep-alive connection stays open (0 uses Go's default of 90s)")
+	maxIdleConnsPerHostFlag := flag.Int("max-idle-conns-per-host", 0, "Idle upstream keep-alive connections kept per host (0 uses Go's default of 2)")
+	spawnFlag := flag.String("spawn", "", "Run this HTTP MCP server command on a free local port ({port} or $PORT) and proxy to it")
+	spawnPathFlag := flag.String("spawn-path", "/mcp", "MCP endpoint path of the --spawn server")
+	spawnReadyTimeoutFlag := flag.Duration("spawn-ready-timeout", 30*time.Second, "How long to wait for the --spawn server to accept connections")
+	stdioCmdFlag := flag.String("stdio-cmd", "", "Run this stdio MCP server command as a child process and proxy to it")
+	sshFlag := flag.String("ssh", "", "Reach the upstream through an SSH connection to this destination (user@host), e.g. for servers listening on the remote loopback")
+	caCertFlag := flag.String("ca-cert", "", "Trust the PEM CA certificate(s) in this file for upstream TLS, in addition to the system roots")
+	insecureFlag := flag.Bool("insecure-skip-verify", false, "Disable upstream TLS certificate verification (insecure; prefer --ca-cert)")
+	mcpHubFlag := flag.Bool("mcp-hub", false, "Auto-discover local mcp-hub port")
+	mcpHubAutostartFlag := flag.Bool("mcp-hub-autostart", false, "With --mcp-hub, start mcp-hub if no running instance is found")
+	mcpHubAutostartConfigFlag := flag.String("mcp-hub-autostart-config", defaultHubConfig(), "Config file for an auto-started mcp-hub")
+	mcpHubAutostartPortFlag := flag.Int("mcp-hub-autostart-port", 37373, "Port for an auto-started mcp-hub")
+	mcpHubConfigFlag := flag.String("mcp-hub-config", "", "With --mcp-hub, use the instance running with this config file")
+	mcpHubPIDFlag := flag.Int("mcp-hub-pid", 0, "With --mcp-hub, use the instance with this process ID")
+	mcpHubPortFlag := flag.Int("mcp-hub-port", 0, "With --mcp-hub, use the instance listening on this port")
+	mcpHubPromptFlag := flag.Bool("mcp-hub-prompt", false, "With --mcp-hub, ask on the terminal which instance to use when several are running")
+	replayFlag := flag.String("replay", "", "Answer requests from a --record transcript without contacting any upstream")
+	fixturesFlag := flag.String("fixtures", "", "Directory of canned responses to serve when the upstream is unreachable")
+	var cacheToolsFlag stringList
+	flag.Var(&cacheToolsFlag, "cache-tool", "Cache tools/call results for a read-only tool name glob (repeatable)")
+	cacheTTLFlag := flag.Duration("cache-ttl", 5*time.Minute, "How long cached tool results stay valid")
+	cacheSizeFlag := flag.Int("cache-size", 100, "Maximum number of cached tool results")
+	cacheListsFlag := flag.Duration("cache-lists", 0, "Cache tools/list, prompts/list and resources/list results for this long, or until the server sends list_changed (0 disables)")
+	prefetchFlag := flag.Bool("prefetch-resources", false, "Prefetch resources linked from tool results in the background")
+	prefetchTTLFlag := flag.Duration("prefetch-ttl", time.Minute, "How long prefetched resources stay valid")
+	prefetchSizeFlag := flag.Int("prefetch-size", 100, "Maximum number of prefetched resources")
+	coalesceFlag := flag.Duration("coalesce-window", 0, "Merge identical server notifications arriving within this window (e.g. 200ms)")
+	coalesceRequestsFlag := flag.Bool("coalesce-requests", false, "Send identical concurrent list and resources/read requests upstream once and answer them all with the result")
+	recordFlag := flag.String("record", "", "Record all stdin/stdout traffic to a JSONL transcript")
+	recordGzipFlag := flag.Bool("record-gzip", false, "Gzip-compress the transcript")
+	recordMaxSizeFlag := flag.Int64("record-max-size", 0, "Rotate the transcript after this many bytes on disk (0 = never)")
+	recordMaxAgeFlag := flag.Duration("record-max-age", 0, "Rotate the transcript after this long (0 = never)")
+	recordKeepFlag := flag.Int("record-keep", 0, "Number of rotated transcripts to keep (0 = all)")
+	transportFlag := flag.String("transport", TransportAuto, "Upstream transport: streamable (Streamable HTTP), sse (legacy HTTP+SSE of protocol 2024-11-05) or auto (Streamable HTTP, falling back to HTTP+SSE)")
+	framingFlag := flag.String("framing", FramingAuto, "Stdio message framing: ndjson, content-length (LSP-style headers) or auto (detected from the first input)")
+	inFlag := flag.String("in", "", "Read client messages from this path (e.g. a FIFO) or file descriptor (fd:N) instead of stdin")
+	outFlag := flag.String("out", "", "Write client messages to this path (e.g. a FIFO) or file descriptor (fd:N) instead of stdout")
+	teeFlag := flag.String("tee", "", "Stream a live NDJSON copy of all traffic to a FIFO, file or inherited descriptor (fd:N)")
+	pushgatewayFlag := flag.String("pushgateway", "", "Push final metrics to this Prometheus Pushgateway URL on exit")
+	pushJobFlag := flag.String("push-job", "mcp-stdio-proxy", "Job name used when pushing metrics")
+	otlpEndpointFlag := flag.String("otlp-endpoint", "", "Export a trace span per forwarded request to this OTLP/HTTP endpoint (default: $OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	otlpServiceFlag := flag.String("otlp-service-name", "", "Service name for exported spans (default: $OTEL_SERVICE_NAME or mcp-stdio-proxy)")
+	metricsAddrFlag := flag.String("metrics-addr", "", "Serve Prometheus metrics at /metrics on this address (e.g. 127.0.0.1:9127)")
+	healthCheckFlag := flag.Bool("health-check", false, "Periodically check upstream health and try to recover it when unhealthy")
+	healthProbeFlag := flag.String("health-probe", ProbeAuto, "Health probe: auto, http-endpoint (mcp-hub /api/health), mcp-ping or tcp-connect")
+	healthIntervalFlag := flag.Duration("health-interval", 30*time.Second, "Time between health probes")
+	healthTimeoutFlag := flag.Duration("health-timeout", 5*time.Second, "How long a health probe may take before it counts as failed")
+	healthRecoveryWaitFlag := flag.Duration("health-recovery-wait", 10*time.Second, "How long to wait after a recovery attempt before probing again")
+	healthFailureThresholdFlag := flag.Int("health-failure-threshold", 1, "Consecutive failed probes before the upstream counts as unhealthy")
+	healthSuccessThresholdFlag := flag.Int("health-success-threshold", 1, "Consecutive successful probes before an unhealthy upstream counts as healthy again")
+	healthJitterFlag := flag.Float64("health-jitter", 0.1, "Randomly shift each probe interval by up to this fraction of it (0 disables)")
+	healthURLFlag := flag.String("health-url", "", "Probe this health endpoint URL instead of the upstream's --health-path")
+	healthPathFlag := flag.String("health-path", DefaultHealthPath, "Health endpoint path on the upstream server for the http-endpoint probe")
+	healthRestartPathFlag := flag.String("health-restart-path", DefaultRestartPath, "Path POSTed on the upstream server to restart it (empty disables the REST restart)")
+	healthRecoveryCmdFlag := flag.String("health-recovery-cmd", "", "Shell command to recover the upstream (e.g. \"systemctl --user restart mcp-hub\"), run instead of /api/restart")
+	healthRecoveryAfterFlag := flag.Bool("health-recovery-cmd-after-restart", false, "Run --health-recovery-cmd after the /api/restart call instead of replacing it")
+	healthAlertCmdFlag := flag.String("health-alert-cmd", "", "Shell command to run when health recovery fails")
+	healthAlertWebhookFlag := flag.String("health-alert-webhook", "", "URL to POST a JSON alert to (Slack-compatible) when health recovery fails")
+	healthExitFlag := flag.Bool("health-exit-on-failure", false, fmt.Sprintf("Exit with status %d when health recovery fails, so a supervisor restarts the proxy", exitHealthFailed))
+	onUnhealthyFlag := flag.String("on-unhealthy", "", "Shell command to run when the upstream turns unhealthy")
+	onRecoveredFlag := flag.String("on-recovered", "", "Shell command to run when an unhealthy upstream is healthy again")
+	onFailedFlag := flag.String("on-failed", "", "Shell command to run when health recovery is given up")
+	healthNotifyFlag := flag.Bool("health-notify", true, "Tell the client about upstream health changes with notifications/message (--health-notify=false to disable)")
+	lazyFlag := flag.Bool("lazy", false, "Defer all upstream connections (and mcp-hub discovery) until the first client message")
+	compressionFlag := flag.Bool("compression", true, "Ask the upstream for gzip or deflate compressed responses and decompress them (--compression=false to disable)")
+	prewarmFlag := flag.Bool("prewarm", false, "Open a pooled upstream connection (TCP, TLS, HTTP/2) at startup, before the client sends anything")
+	http2Flag := flag.String("http2", HTTP2Auto, "HTTP/2 to the upstream: auto (over TLS when offered), off (HTTP/1.1 only) or h2c (HTTP/2 only, cleartext for http:// upstreams)")
+	preconnectFlag := flag.Bool("preconnect", false, "Warm up the upstream (initialize + tools/list) at startup, before the client sends anything")
+	eagerInitFlag := flag.Bool("eager-init", false, "Initialize the upstream session at startup and answer the client's initialize from its result")
+	eagerInitClientFlag := flag.String("eager-init-client", "mcp-stdio-proxy", "clientInfo of the --eager-init handshake, as name/version (the version defaults to the proxy's)")
+	brokerFlag := flag.String("broker", "", "Unix socket path for sharing one upstream session between proxy instances")
+	listenFlag := flag.String("listen", "", "Serve MCP clients connecting to this TCP address (host:port) or Unix socket path instead of stdio, each with its own upstream session")
+	acceptFlag := flag.String("accept", defaultAccept, "Accept header sent to the upstream, for gateways that reject the combined default")
+	contentTypeFlag := flag.String("content-type-check", ContentTypeLenient, "Response content-type checking: lenient (non-SSE parsed as JSON), strict (only application/json and text/event-stream) or sniff (detect SSE from the body)")
+	var headerTemplateFlag stringList
+	flag.Var(&headerTemplateFlag, "header-template", "Add an upstream header rendered from each message, e.g. \"X-MCP-Method: {{.Method}}\" (repeatable)")
+	var metaHeaderFlag stringList
+	flag.Var(&metaHeaderFlag, "meta-header", "Copy this upstream response header into each result's _meta.upstreamHeaders (repeatable)")
+	correlationHeaderFlag := flag.String("correlation-header", "", "Send each request's correlation ID to the upstream in this header (e.g. X-Request-ID)")
+	resolveLinksFlag := flag.Bool("resolve-links", false, "Fetch resource_link blocks in tool results and embed the resources for clients that don't follow links")
+	resolveLinksMaxFlag := flag.Int64("resolve-links-max-bytes", 256*1024, "Maximum total size of resources embedded into one tool result by --resolve-links")
+	capabilityWarningsFlag := flag.Bool("capability-warnings", false, "Send client/server capability mismatches to the client as notifications/message warnings")
+	validateArgsFlag := flag.Bool("validate-args", false, "Check tools/call arguments against the tool's inputSchema from tools/list and reject invalid calls locally")
+	var disableCapabilityFlag stringList
+	flag.Var(&disableCapabilityFlag, "disable-capability", "Hide capabilities from initialize, e.g. sampling,prompts or resources.subscribe, and refuse requests that need them (repeatable)")
+	var capabilityFlag stringList
+	flag.Var(&capabilityFlag, "capability", "Override a server capability in the initialize result, e.g. 'tools={\"listChanged\":false}' (repeatable)")
+	statusToolFlag := flag.Bool("status-tool", false, "Add a proxy.status tool reporting the upstream URL, session, health, retries and uptime")
+	statsResourceFlag := flag.Bool("stats-resource", false, "Serve per-tool call counts, error rates and latencies as the proxy://stats resource")
+	circuitThresholdFlag := flag.Int("circuit-threshold", 5, "Fail fast after this many consecutive failed requests (0 disables the circuit breaker)")
+	circuitCooldownFlag := flag.Duration("circuit-cooldown", 30*time.Second, "How long the circuit breaker fails fast before probing the upstream again")
+	maxConcurrentFlag := flag.Int("max-concurrent", 16, "Maximum client requests forwarded concurrently (1 processes messages one at a time)")
+	sseIdleTimeoutFlag := flag.Duration("sse-idle-timeout", 0, "Abort a POST response stream that sends nothing for this long and fail its pending request (0 waits for the request timeout)")
+	maxMessageSizeFlag := flag.Int("max-message-size", DefaultMaxMessageSize, "Maximum size in bytes of one JSON-RPC message in either direction; larger messages are answered with an error")
+	waitForBackendFlag := flag.Duration("wait-for-backend", 0, "Wait up to this long for the upstream to accept connections before reading stdin (e.g. 30s)")
+	waitForBackendURLFlag := flag.String("wait-for-backend-url", "", "Poll this health endpoint for HTTP 200 during --wait-for-backend instead of connecting to the upstream's port")
+	queueWhileDownFlag := flag.Bool("queue-while-down", false, "Read client messages during --wait-for-backend and forward them in order once the upstream is ready")
+	outageQueueFlag := flag.Int("outage-queue", 0, "Hold up to this many client messages while the upstream is unreachable and forward them in order once it is back (0 fails them right away)")
+	outageMaxWaitFlag := flag.Duration("outage-max-wait", 30*time.Second, "How long --outage-queue holds a message before failing it")
+	var rateFlag stringList
+	flag.Var(&rateFlag, "rate", "Limit forwarded requests to N per period, e.g. 10/s, or one method with method=N/period, e.g. tools/call=2/s (repeatable)")
+	ratePolicyFlag := flag.String("rate-policy", RatePolicyQueue, "What happens to requests over a --rate limit: queue (hold until allowed) or error (answer with a rate limit error)")
+	shadowFlag := flag.String("shadow", "", "Mirror client requests to this second upstream URL and log how its responses differ (the client only sees the primary's)")
+	sessionFileFlag := flag.String("session-file", "", "Save the upstream session to this file and resume it after a restart instead of initializing a new one")
+	getStreamFlag := flag.Bool("get-stream", true, "Keep a standalone GET stream open for server-initiated notifications and requests (--get-stream=false to disable)")
+	annotateFlag := flag.Bool("annotate", false, "Add transport details (latency, retries, upstream, session) to results as _meta.proxy")
+	var urlFlag stringList
+	flag.Var(&urlFlag, "url", "Upstream URL (repeatable; later ones are fallbacks used in order when the current one is unreachable)")
+	var upstreamFlag stringList
+	flag.Var(&upstreamFlag, "upstream", "Aggregate a named upstream, name=url (repeatable; replaces <streamable-http-url>)")
+	authConfigFlag := flag.String("auth-config", "", "JSON file with the upstream's bearer or OAuth client-credentials settings (see README)")
+	oauthFlag := flag.Bool("oauth", true, "Authorize with OAuth 2.1 (authorization code + PKCE) when an upstream without configured auth answers 401")
+	oauthBrowserFlag := flag.Bool("oauth-browser", true, "Open the OAuth authorization URL in a browser (it is always printed to stderr)")
+	oauthCallbackPortFlag := flag.Int("oauth-callback-port", loginOptions.callbackPort, "Loopback port for the OAuth redirect (0 picks a free port)")
+	var headerFlag stringList
+	flag.Var(&headerFlag, "header", "Add an HTTP header to every upstream request, e.g. \"X-API-Key: secret\" (repeatable)")
+	bearerTokenFlag := flag.String("bearer-token", "", "Send \"Authorization: Bearer TOKEN\" with every upstream request (default: $MCP_PROXY_TOKEN)")
+	upstreamsConfigFlag := flag.String("upstreams-config", "", "JSON file of named upstreams with per-upstream auth for aggregator mode")
+	fanoutFlag := flag.Int("fanout-parallelism", 4, "Maximum concurrent upstream requests when fanning out in aggregator mode")
+	serveFlag := flag.Bool("serve", false, "Run headless as a --broker for attached proxies until signalled (for service managers)")
+	daemonFlag := flag.Bool("daemon", false, "Share one upstream session through a background broker, started by the first invocation; this proxy attaches to it as a thin shim")
+	daemonIdleTimeoutFlag := flag.Duration("daemon-idle-timeout", 10*time.Minute, "How long the --daemon broker keeps running with no clients attached (0 until signalled)")
+	controlSocketFlag := flag.String("control-socket", "", "Unix socket path for runtime control commands (breakpoints, ...)")
+
+	// Custom usage message
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] [<streamable-http-url>...]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "A minimal stdio to Streamable HTTP proxy for Model Context Protocol (MCP).\n\n")
+		fmt.Fprintf(os.Stderr, "Arguments:\n")
+		fmt.Fprintf(os.Stderr, "  <streamable-http-url>  Target MCP server URL (required unless --url, --mcp-hub or --replay is used);\n")
+		fmt.Fprintf(os.Stderr, "                         several URLs, or name=url pairs, are aggregated like --upstream\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s http://localhost:37373/mcp\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --debug http://localhost:37373/mcp\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --timeout 300 http://localhost:37373/mcp\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --mcp-hub\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --mcp-hub --debug\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --fixtures ./fixtures http://localhost:37373/mcp\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --replay session.jsonl\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --url http://primary:8080/mcp --url http://fallback:8080/mcp\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --upstream hub=http://localhost:37373/mcp --upstream docs=http://localhost:8080/mcp\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nEnvironment Variables:\n")
+		fmt.Fprintf(os.Stderr, "  DEBUG=1  Alternative way to enable debug logging\n")
+	}
+
+	// Parse flags
+	flag.Parse()
+
+	// Load env files first so they apply to DEBUG and ${VAR} expansion in config files
+	if err := loadEnvFiles(envFileFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	// Check for debug mode (flag or environment variable)
+	logLevel, err := parseLogLevel(*logLevelFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if *debugFlag || *verboseFlag || os.Getenv("DEBUG") == "1" {
+		logLevel = LevelTrace
+	}
+	if err := setupLogging(*logFormatFlag, logLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	// Debug mode (verbose free-form logs) is the trace level; debug adds only message events
+	debug := logLevel <= LevelTrace
+
+	var url string
+	followHub := *mcpHubFlag
+
+	if *serveFlag && *brokerFlag == "" {
+		fmt.Fprintf(os.Stderr, "Error: --serve requires --broker\n")
+		return 1
+	}
+	// Attach to the background broker, starting it if needed; the daemon
+	// itself runs with --serve and does the upstream setup below
+	if *daemonFlag && !*serveFlag {
+		if *listenFlag != "" || *inFlag != "" || *outFlag != "" || *framingFlag == FramingContentLength {
+			fmt.Fprintf(os.Stderr, "Error: --daemon cannot be combined with --listen, --in, --out or --framing content-length\n")
+			return 1
+		}
+		path := *brokerFlag
+		if path == "" {
+			path = defaultDaemonSocket(os.Args[1:])
+		}
+		if err := runDaemonShim(path, os.Args[1:], debug); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	if *eagerInitFlag && (*listenFlag != "" || *replayFlag != "" || *sessionFileFlag != "") {
+		fmt.Fprintf(os.Stderr, "Error: --eager-init cannot be combined with --listen, --replay or --session-file\n")
+		return 1
+	}
+	if *listenFlag != "" && (*brokerFlag != "" || *stdioCmdFlag != "" || *shadowFlag != "" || *sessionFileFlag != "") {
+		fmt.Fprintf(os.Stderr, "Error: --listen cannot be combined with --broker, --stdio-cmd, --shadow or --session-file\n")
+		return 1
+	}
+	if *lazyFlag && (*preconnectFlag || *prewarmFlag || *eagerInitFlag) {
+		fmt.Fprintf(os.Stderr, "Error: --lazy cannot be combined with --preconnect, --prewarm or --eager-init\n")
+		return 1
+	}
+	switch *contentTypeFlag {
+	case ContentTypeLenient, ContentTypeStrict, ContentTypeSniff:
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown --content-type-check %q\n", *contentTypeFlag)
+		return 1
+	}
+	if !validProbeStrategy(*healthProbeFlag) {
+		fmt.Fprintf(os.Stderr, "Error: unknown --health-probe %q\n", *healthProbeFlag)
+		return 1
+	}
+	if *timeoutFlag < 0 || *connectTimeoutFlag < 0 || *tlsHandshakeTimeoutFlag < 0 || *responseHeaderTimeoutFlag < 0 || *idleConnTimeoutFlag < 0 || *maxIdleConnsPerHostFlag < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --timeout and the transport timeouts and limits must not be negative\n")
+		return 1
+	}
+	if *sseIdleTimeoutFlag < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --sse-idle-timeout must not be negative\n")
+		return 1
+	}
+	if *waitForBackendFlag < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --wait-for-backend must not be negative\n")
+		return 1
+	}
+	if *waitForBackendURLFlag != "" && !strings.HasPrefix(*waitForBackendURLFlag, "http://") && !strings.HasPrefix(*waitForBackendURLFlag, "https://") {
+		fmt.Fprintf(os.Stderr, "Error: --wait-for-backend-url must start with http:// or https://\n")
+		return 1
+	}
+	if *waitForBackendFlag > 0 && *lazyFlag {
+		fmt.Fprintf(os.Stderr, "Error: --wait-for-backend and --lazy are mutually exclusive\n")
+		return 1
+	}
+	if *outageQueueFlag < 0 || *outageMaxWaitFlag <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: --outage-queue must not be negative and --outage-max-wait must be positive\n")
+		return 1
+	}
+	if *maxMessageSizeFlag <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: --max-message-size must be positive\n")
+		return 1
+	}
+	if *healthIntervalFlag <= 0 || *healthTimeoutFlag <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: --health-interval and --health-timeout must be positive\n")
+		return 1
+	}
+	if *healthFailureThresholdFlag < 1 || *healthSuccessThresholdFlag < 1 {
+		fmt.Fprintf(os.Stderr, "Error: --health-failure-threshold and --health-success-threshold must be at least 1\n")
+		return 1
+	}
+	if *healthJitterFlag < 0 || *healthJitterFlag >= 1 {
+		fmt.Fprintf(os.Stderr, "Error: --health-jitter must be at least 0 and less than 1\n")
+		return 1
+	}
+	if *healthURLFlag != "" && !strings.HasPrefix(*healthURLFlag, "http://") && !strings.HasPrefix(*healthURLFlag, "https://") {
+		fmt.Fprintf(os.Stderr, "Error: --health-url must start with http:// or https://\n")
+		return 1
+	}
+
+	mcpHubSelection = hubSelector{
+		pid:    *mcpHubPIDFlag,
+		port:   *mcpHubPortFlag,
+		config: *mcpHubConfigFlag,
+		prompt: *mcpHubPromptFlag,
+	}
+	if *mcpHubAutostartFlag {
+		mcpHubAutostart = &hubAutostart{config: *mcpHubAutostartConfigFlag, port: *mcpHubAutostartPortFlag}
+	}
+
+	// Several URLs are aggregated like --upstream
+	aggregate := len(upstreamFlag) > 0 || *upstreamsConfigFlag != "" || flag.NArg() > 1
+
+	if len(urlFlag) > 0 && (aggregate || flag.NArg() > 0 || *mcpHubFlag || *spawnFlag != "" || *stdioCmdFlag != "" || *replayFlag != "") {
+		fmt.Fprintf(os.Stderr, "Error: --url cannot be combined with a positional URL, --upstream, --mcp-hub, --spawn, --stdio-cmd or --replay\n")
+		return 1
+	}
+	var failoverURLs []string
+
+	// Handle --mcp-hub mode
+	var spawner *Spawner
+	var stdioBackend *StdioBackend
+	if *stdioCmdFlag != "" {
+		if aggregate || flag.NArg() > 0 || *mcpHubFlag || *spawnFlag != "" || *replayFlag != "" {
+			fmt.Fprintf(os.Stderr, "Error: --stdio-cmd cannot be combined with an upstream URL, --mcp-hub, --spawn or --replay\n")
+			return 1
+		}
+		if *sshFlag != "" || *transportFlag == TransportSSE {
+			fmt.Fprintf(os.Stderr, "Error: --stdio-cmd cannot be combined with --ssh or --transport sse\n")
+			return 1
+		}
+		var err error
+		if stdioBackend, err = NewStdioBackend(*stdioCmdFlag, debug); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		url = stdioBackend.url()
+	} else if *spawnFlag != "" {
+		if aggregate || flag.NArg() > 0 || *mcpHubFlag || *replayFlag != "" {
+			fmt.Fprintf(os.Stderr, "Error: --spawn cannot be combined with an upstream URL, --mcp-hub or --replay\n")
+			return 1
+		}
+		var err error
+		if spawner, err = NewSpawner(*spawnFlag, *spawnReadyTimeoutFlag, debug); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		url = fmt.Sprintf("http://127.0.0.1:%d%s", spawner.port, *spawnPathFlag)
+	} else if *replayFlag != "" {
+		if aggregate || flag.NArg() > 0 || *mcpHubFlag {
+			fmt.Fprintf(os.Stderr, "Error: --replay cannot be combined with an upstream URL or --mcp-hub\n")
+			return 1
+		}
+	} else if aggregate {
+		if *listenFlag != "" || *eagerInitFlag {
+			fmt.Fprintf(os.Stderr, "Error: --listen and --eager-init cannot be used with several upstreams\n")
+			return 1
+		}
+		if flag.NArg() == 1 || *mcpHubFlag {
+			fmt.Fprintf(os.Stderr, "Error: --upstream/--upstreams-config cannot be combined with a single URL or --mcp-hub\n")
+			return 1
+		}
+		if *healthURLFlag != "" {
+			fmt.Fprintf(os.Stderr, "Error: --health-url cannot be used with several upstreams\n")
+			return 1
+		}
+	} else if *mcpHubFlag && flag.NArg() == 0 && *lazyFlag {
+		// Discovery runs in-process on the first client message
+		if debug {
+			log.Printf("[INIT] Lazy mode: deferring mcp-hub discovery until the first message")
+		}
+	} else if *mcpHubFlag && flag.NArg() == 0 {
+		// Discover the hub and carry on in this process
+		instance, err := discoverOrStartMcpHub(debug)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to discover mcp-hub port: %v\n", err)
+			return 1
+		}
+
+		url = fmt.Sprintf("http://localhost:%s/mcp", instance.Port)
+		if debug {
+			log.Printf("[INIT] Using mcp-hub config: %s", instance.ConfigPath)
+		}
+		setProcessTitle("mcp-proxy:" + instance.Port)
+	} else if len(urlFlag) > 0 {
+		// Primary URL first, then the fallbacks in order
+		for _, raw := range urlFlag {
+			resolved, err := upstreamURL(raw)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			failoverURLs = append(failoverURLs, resolved)
+		}
+		url = failoverURLs[0]
+	} else if flag.NArg() == 1 {
+		// URL provided explicitly
+		var err error
+		if url, err = upstreamURL(flag.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	} else {
+		// Invalid usage
+		flag.Usage()
+		return 1
+	}
+
+	// Open the client streams
+	var in io.Reader = os.Stdin
+	var out io.Writer = os.Stdout
+	if *inFlag != "" {
+		file, err := openStream(*inFlag, false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		in = file
+	}
+	if *outFlag != "" {
+		file, err := openStream(*outFlag, true)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+
+	// Frame messages on both streams the same way
+	framing, err := newMessageFraming(*framingFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if *framingFlag == FramingContentLength && *brokerFlag != "" {
+		fmt.Fprintf(os.Stderr, "Error: --framing content-length cannot be combined with --broker\n")
+		return 1
+	}
+
+	// Trust private CAs or, if explicitly asked, any certificate
+	transport, err := newTLSTransport(*caCertFlag, *insecureFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	proxy := &Proxy{
+		url:     url,
+		spawner: spawner,
+		stdio:   stdioBackend,
+		client: &http.Client{
+			Timeout: time.Duration(*timeoutFlag) * time.Second,
+		},
+		framing:     framing,
+		stdout:      &framedWriter{w: out, framing: framing},
+		debug:       debug,
+		breakpoints: NewBreakpoints(),
+		annotate:    *annotateFlag,
+		statusTool:  *statusToolFlag,
+		getStream:   *getStreamFlag,
+		followHub:   followHub,
+		started:     time.Now(),
+
+		failoverURLs: failoverURLs,
+
+		healthFailed: make(chan error, 1),
+
+		maxConcurrent:  *maxConcurrentFlag,
+		maxMessageSize: *maxMessageSizeFlag,
+		sseIdleTimeout: *sseIdleTimeoutFlag,
+
+		backendWait:    *waitForBackendFlag,
+		backendWaitURL: *waitForBackendURLFlag,
+		queueWhileDown: *queueWhileDownFlag,
+
+		resolveLinks:    *resolveLinksFlag,
+		resolveLinksMax: *resolveLinksMaxFlag,
+
+		accept:            *acceptFlag,
+		contentTypeMode:   *contentTypeFlag,
+		correlationHeader: *correlationHeaderFlag,
+		metaHeaders:       metaHeaderFlag,
+	}
+	legacy, err := newLegacySSE(*transportFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --transport: %v\n", err)
+		return 1
+	}
+	proxy.legacy = legacy
+
+	// Upstream traffic is bound to the proxy's lifetime, cancelled by Run
+	proxy.lifetime, proxy.stop = context.WithCancelCause(context.Background())
+	if transport != nil {
+		proxy.client.Transport = transport
+	}
+	transportOptions := TransportOptions{
+		ConnectTimeout:        *connectTimeoutFlag,
+		TLSHandshakeTimeout:   *tlsHandshakeTimeoutFlag,
+		ResponseHeaderTimeout: *responseHeaderTimeoutFlag,
+		IdleConnTimeout:       *idleConnTimeoutFlag,
+		MaxIdleConnsPerHost:   *maxIdleConnsPerHostFlag,
+		HTTP2:                 *http2Flag,
+	}
+	if err := transportOptions.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --http2: %v\n", err)
+		return 1
+	}
+	proxy.client.Transport = transportOptions.apply(proxy.client.Transport)
+	// Reach http+unix:// upstreams through their sockets
+	proxy.client.Transport = withUnixSockets(proxy.client.Transport)
+
+	// Tunnel upstream connections through SSH
+	if *sshFlag != "" {
+		tunnel, err := NewSSHTunnel(*sshFlag, debug)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		proxy.tunnel = tunnel
+		proxy.client.Transport = tunnel.transport(proxy.client.Transport)
+	}
+
+	// The stdio server answers in-process; the HTTP transport goes unused
+	if stdioBackend != nil {
+		proxy.client.Transport = stdioBackend
+	} else if *compressionFlag {
+		// Ask for compressed responses and decompress them
+		proxy.client.Transport = withDecompression(proxy.client.Transport)
+	}
+
+	if proxy.debug {
+		log.Printf("[INIT] Starting mcp-stdio-proxy, target: %s", url)
+	}
+
+	// Parse header templates
+	headerTemplates, err := parseHeaderTemplates(headerTemplateFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	proxy.headerTemplates = headerTemplates
+
+	// Authenticate to the upstream
+	headers, err := parseHeaders(headerFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	bearerToken := *bearerTokenFlag
+	if bearerToken == "" {
+		bearerToken = os.Getenv("MCP_PROXY_TOKEN")
+	}
+	var auth *AuthConfig
+	switch {
+	case *authConfigFlag != "":
+		if *bearerTokenFlag != "" {
+			fmt.Fprintf(os.Stderr, "Error: --bearer-token cannot be combined with --auth-config\n")
+			return 1
+		}
+		if auth, err = loadAuthConfig(*authConfigFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	case bearerToken != "":
+		auth = &AuthConfig{Type: AuthBearer, Token: bearerToken}
+	case *oauthFlag && url != "":
+		auth = &AuthConfig{Type: AuthAuthorizationCode}
+	}
+	proxy.client = newAuthClient(proxy.client, auth, headers, url)
+	loginOptions.openBrowser = *oauthBrowserFlag
+	loginOptions.callbackPort = *oauthCallbackPortFlag
+
+	// Aggregate several upstreams behind one session
+	if aggregate {
+		upstreams, err := parseUpstreamSpecs(upstreamFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		positional, err := upstreamsFromArgs(flag.Args())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		upstreams = append(upstreams, positional...)
+		if *upstreamsConfigFlag != "" {
+			configured, err := loadUpstreamsConfig(*upstreamsConfigFlag)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			upstreams = append(upstreams, configured...)
+		}
+
+		if *oauthFlag {
+			for i := range upstreams {
+				if upstreams[i].Auth == nil {
+					upstreams[i].Auth = &AuthConfig{Type: AuthAuthorizationCode}
+				}
+			}
+		}
+
+		aggregator, err := NewAggregator(upstreams, proxy.client, *fanoutFlag, debug)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		proxy.aggregator = aggregator
+		for _, u := range aggregator.upstreams {
+			u.proxy.lifetime = proxy.lifetime
+		}
+		if proxy.debug {
+			log.Printf("[INIT] Aggregating %d upstream(s)", len(aggregator.upstreams))
+		}
+	}
+
+	// Load response fixtures
+	if *fixturesFlag != "" {
+		fixtures, err := loadFixtures(*fixturesFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		proxy.fixtures = fixtures
+		if proxy.debug {
+			log.Printf("[INIT] Loaded %d fixture rule(s) from %s", len(fixtures), *fixturesFlag)
+		}
+	}
+
+	// Fail fast while the upstream keeps failing
+	if *circuitThresholdFlag > 0 {
+		proxy.breaker = NewCircuitBreaker(*circuitThresholdFlag, *circuitCooldownFlag)
+	}
+
+	// Serve a recorded session instead of an upstream
+	if *replayFlag != "" {
+		replay, err := loadReplay(*replayFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		proxy.replay = replay
+		if proxy.debug {
+			log.Printf("[INIT] Replaying %d recorded request(s) from %s", len(replay.responses), *replayFlag)
+		}
+	}
+
+	// Compare a second upstream against the primary
+	if *shadowFlag != "" {
+		if aggregate || *replayFlag != "" {
+			fmt.Fprintf(os.Stderr, "Error: --shadow cannot be combined with several upstreams or --replay\n")
+			return 1
+		}
+		shadow, err := NewShadow(*shadowFlag, proxy.client, debug)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		proxy.shadow = shadow
+		shadow.start(proxy)
+	}
+
+	// Ride out short upstream outages
+	if *outageQueueFlag > 0 {
+		proxy.outage = NewOutageQueue(*outageQueueFlag, *outageMaxWaitFlag)
+	}
+
+	// Keep a runaway client from hammering the upstream
+	if len(rateFlag) > 0 {
+		limiter, err := NewRateLimiter(rateFlag, *ratePolicyFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --rate: %v\n", err)
+			return 1
+		}
+		proxy.rateLimiter = limiter
+	}
+
+	// Resume the session of an earlier run
+	if *sessionFileFlag != "" {
+		if aggregate || *replayFlag != "" {
+			fmt.Fprintf(os.Stderr, "Error: --session-file cannot be combined with several upstreams or --replay\n")
+			return 1
+		}
+		sessionFile, err := LoadSessionFile(*sessionFileFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		proxy.sessionFile = sessionFile
+	}
+
+	// Enable tool result and list caching
+	if len(cacheToolsFlag) > 0 {
+		proxy.resultCache = NewResultCache(cacheToolsFlag, *cacheTTLFlag, *cacheSizeFlag, debug)
+	}
+	if *cacheListsFlag > 0 {
+		proxy.listCache = NewListCache(*cacheListsFlag, debug)
+	}
+
+	proxy.capabilityNotify = *capabilityWarningsFlag
+
+	// Rewrite the negotiated capabilities
+	if len(disableCapabilityFlag) > 0 || len(capabilityFlag) > 0 {
+		capabilityFilter, err := NewCapabilityFilter(disableCapabilityFlag, capabilityFlag, debug)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		proxy.capabilityFilter = capabilityFilter
+	}
+
+	// Validate tool arguments locally
+	if *validateArgsFlag {
+		proxy.validator = NewSchemaValidator(debug)
+	}
+
+	// Serve usage statistics as an MCP resource
+	if *statsResourceFlag {
+		proxy.usageStats = NewUsageStats()
+		proxy.addLocalResource(&localResource{
+			URI:         statsURI,
+			Name:        "proxy-stats",
+			Title:       "Proxy usage statistics",
+			Description: "Per-tool call counts, error rates and latencies observed by mcp-stdio-proxy",
+			MimeType:    "application/json",
+			render:      proxy.usageStats.render,
+		})
+	}
+
+	// Enable resource prefetching
+	if *prefetchFlag {
+		proxy.prefetcher = NewResourcePrefetcher(*prefetchTTLFlag, *prefetchSizeFlag)
+	}
+
+	// Enable notification coalescing
+	if *coalesceFlag > 0 {
+		proxy.coalescer = NewCoalescer(*coalesceFlag)
+	}
+
+	// Open the session before the client asks for it
+	if *eagerInitFlag {
+		eagerInit, err := NewEagerInit(*eagerInitClientFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --eager-init-client: %v\n", err)
+			return 1
+		}
+		proxy.eagerInit = eagerInit
+	}
+
+	// Merge identical concurrent requests
+	if *coalesceRequestsFlag {
+		proxy.requestCoalescer = NewRequestCoalescer(debug)
+	}
+
+	// Start traffic recording
+	if *recordFlag != "" {
+		recorder, err := NewRecorder(*recordFlag, RecorderOptions{
+			Gzip:    *recordGzipFlag,
+			MaxSize: *recordMaxSizeFlag,
+			MaxAge:  *recordMaxAgeFlag,
+			Keep:    *recordKeepFlag,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		proxy.recorder = recorder
+		defer recorder.Close()
+	}
+
+	// Stream a live copy of traffic to an external analyzer
+	if *teeFlag != "" {
+		tee, err := NewTee(*teeFlag, debug)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		proxy.tee = tee
+		defer tee.Close()
+	}
+
+	// Collect metrics for scraping and/or the Pushgateway
+	if *pushgatewayFlag != "" || *metricsAddrFlag != "" {
+		proxy.metrics = NewMetrics()
+		proxy.metrics.inFlight = proxy.inFlightRequests
+		if *healthCheckFlag {
+			proxy.metrics.health = proxy.healthStatuses
+		}
+	}
+	if *metricsAddrFlag != "" {
+		if err := proxy.metrics.serveMetrics(*metricsAddrFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	// Trace forwarded requests
+	otlpEndpoint := *otlpEndpointFlag
+	if otlpEndpoint == "" {
+		otlpEndpoint = os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	}
+	if otlpEndpoint == "" {
+		otlpEndpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if otlpEndpoint != "" {
+		serviceName := *otlpServiceFlag
+		if serviceName == "" {
+			serviceName = os.Getenv("OTEL_SERVICE_NAME")
+		}
+		if serviceName == "" {
+			serviceName = "mcp-stdio-proxy"
+		}
+		tracer, err := NewTracer(otlpEndpoint, serviceName, debug)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		proxy.tracer = tracer
+	}
+	if *pushgatewayFlag != "" {
+		defer func() {
+			if err := proxy.metrics.pushMetrics(*pushgatewayFlag, *pushJobFlag, debug); err != nil {
+				log.Printf("[ERROR] Failed to push metrics: %v", err)
+			}
+		}()
+	}
+
+	// newHealthChecker configures health checking for one upstream
+	newHealthChecker := func(name string, upstream *Proxy) (*HealthChecker, error) {
+		target := upstream.url
+		health, err := NewHealthChecker(target, debug)
+		if err != nil {
+			return nil, err
+		}
+		// Health checks carry the same headers and credentials as the upstream's requests
+		health.client.Transport = upstream.client.Transport
+		health.clientHeaders = upstream.setClientHeaders
+		health.name = name
+		health.strategy = *healthProbeFlag
+		health.pinger = upstream.ping
+		health.interval = *healthIntervalFlag
+		health.timeout = *healthTimeoutFlag
+		health.client.Timeout = *healthTimeoutFlag
+		health.recoveryWait = *healthRecoveryWaitFlag
+		health.jitter = *healthJitterFlag
+		health.failureThreshold = *healthFailureThresholdFlag
+		health.successThreshold = *healthSuccessThresholdFlag
+		health.healthPath = *healthPathFlag
+		health.restartPath = *healthRestartPathFlag
+		health.healthURL = *healthURLFlag
+		health.recoveryCmd = *healthRecoveryCmdFlag
+		health.recoveryCmdAfter = *healthRecoveryAfterFlag
+		if *healthAlertCmdFlag != "" || *healthAlertWebhookFlag != "" {
+			alerter := &Alerter{
+				command: *healthAlertCmdFlag,
+				webhook: *healthAlertWebhookFlag,
+				url:     target,
+				debug:   debug,
+			}
+			health.onFailed = append(health.onFailed, func(err error) { alerter.Alert(StateFailed, err) })
+		}
+		if *onUnhealthyFlag != "" || *onRecoveredFlag != "" || *onFailedFlag != "" {
+			hooks := &HealthHooks{
+				onUnhealthy: *onUnhealthyFlag,
+				onRecovered: *onRecoveredFlag,
+				onFailed:    *onFailedFlag,
+				name:        name,
+				url:         target,
+				debug:       debug,
+			}
+			health.onChange = append(health.onChange, hooks.transition)
+		}
+		if *healthExitFlag {
+			health.onFailed = append(health.onFailed, proxy.healthFailure)
+		}
+		if *healthNotifyFlag {
+			health.onChange = append(health.onChange, func(state HealthState, err error) {
+				proxy.healthNotification(name, state, err)
+			})
+		}
+		return health, nil
+	}
+
+	// Check every aggregated upstream concurrently and publish the combined status
+	if proxy.aggregator != nil && *healthCheckFlag {
+		for _, u := range proxy.aggregator.upstreams {
+			health, err := newHealthChecker(u.Name, u.proxy)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: upstream %s: %v\n", u.Name, err)
+				return 1
+			}
+			health.onChange = append(health.onChange, func(HealthState, error) { proxy.resourceUpdated(healthURI) })
+			u.health = health
+		}
+		proxy.addLocalResource(&localResource{
+			URI:         healthURI,
+			Name:        "proxy-health",
+			Title:       "Upstream health",
+			Description: "Health state, last error and probe latency of each aggregated upstream",
+			MimeType:    "application/json",
+			render:      proxy.renderHealth,
+		})
+	}
+
+	// Upstream setup; deferred to the first client message with --lazy
+	connect := func() error {
+		// Replay never touches the network
+		if proxy.replay != nil {
+			return nil
+		}
+
+		// Launch the upstream server
+		if proxy.spawner != nil {
+			if err := proxy.spawner.start(); err != nil {
+				return err
+			}
+		}
+		if proxy.stdio != nil {
+			if err := proxy.stdio.start(); err != nil {
+				return err
+			}
+		}
+
+		// Each aggregated upstream connects on the client's initialize
+		if proxy.aggregator != nil {
+			for _, u := range proxy.aggregator.upstreams {
+				if u.health != nil {
+					u.health.Start(proxy.baseContext())
+				}
+			}
+			return nil
+		}
+
+		if proxy.url == "" {
+			instance, err := discoverOrStartMcpHub(debug)
+			if err != nil {
+				return fmt.Errorf("failed to discover mcp-hub port: %w", err)
+			}
+			proxy.url = fmt.Sprintf("http://localhost:%s/mcp", instance.Port)
+			if debug {
+				log.Printf("[INIT] Using mcp-hub config %s, target: %s", instance.ConfigPath, proxy.url)
+			}
+			setProcessTitle("mcp-proxy:" + instance.Port)
+		}
+
+		// Start health checking
+		if *healthCheckFlag {
+			health, err := newHealthChecker("", proxy)
+			if err != nil {
+				return err
+			}
+			proxy.health = health
+			health.Start(proxy.baseContext())
+		}
+
+		// Open the session in the background; the client's initialize waits for it
+		if proxy.eagerInit != nil {
+			go proxy.eagerInitialize()
+		}
+
+		// Warm up the upstream in the background, reusing the prewarmed
+		// connection for the warm-up session
+		if *prewarmFlag || *preconnectFlag {
+			go func() {
+				if *prewarmFlag {
+					proxy.prewarm()
+				}
+				if *preconnectFlag {
+					proxy.preconnect()
+				}
+			}()
+		}
+
+		return nil
+	}
+
+	if *lazyFlag {
+		proxy.connect = connect
+	} else if err := connect(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	// Start control socket
+	if *controlSocketFlag != "" {
+		if err := proxy.startControlSocket(*controlSocketFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer os.Remove(*controlSocketFlag)
+	}
+
+	// Share the upstream session with other instances
+	if *brokerFlag != "" {
+		if *serveFlag {
+			in = nil
+		}
+		var idleTimeout time.Duration
+		if *daemonFlag {
+			idleTimeout = *daemonIdleTimeoutFlag
+		}
+		err := runBrokerOrShim(proxy, *brokerFlag, in, idleTimeout)
+		var sigErr *signalError
+		var healthErr *healthFailedError
+		if err != nil && !errors.As(err, &sigErr) && !errors.As(err, &healthErr) {
+			log.Printf("Broker error: %v", err)
+			return 1
+		}
+		proxy.terminateSessions()
+		proxy.release()
+		switch {
+		case sigErr != nil:
+			return sigErr.exitCode()
+		case healthErr != nil:
+			return exitHealthFailed
+		}
+		return 0
+	}
+
+	// Stop on SIGINT/SIGTERM, exiting with the signal's status
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("[SHUTDOWN] Received %v, shutting down", sig)
+		cancel(&signalError{signal: sig})
+	}()
+
+	// Serve clients connecting over the network instead of stdio
+	if *listenFlag != "" {
+		listener, err := NewListener(proxy, *listenFlag)
+		if err == nil {
+			err = listener.Run(ctx)
+		}
+		proxy.release()
+		var sigErr *signalError
+		if errors.As(err, &sigErr) {
+			return sigErr.exitCode()
+		}
+		var healthErr *healthFailedError
+		if errors.As(err, &healthErr) {
+			return exitHealthFailed
+		}
+		log.Printf("Listener error: %v", err)
+		return 1
+	}
+
+	// Run the proxy
+	if err := proxy.Run(ctx, in, out); err != nil {
+		var sigErr *signalError
+		if errors.As(err, &sigErr) {
+			return sigErr.exitCode()
+		}
+		var healthErr *healthFailedError
+		if errors.As(err, &healthErr) {
+			return exitHealthFailed
+		}
+		log.Printf("Proxy error: %v", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Coalescer merges bursts of identical server notifications into one. Only
+// notifications saying that something changed are held; the rest, such as
+// progress and log messages, carry unique data and must keep their place
+// before the response they belong to.
+type Coalescer struct {
+	mu      sync.Mutex
+	window  time.Duration
+	pending map[string]int // Notification payload -> duplicates dropped so far
+	held    sync.WaitGroup
+}
+
+// NewCoalescer creates a coalescer that holds notifications for window
+func NewCoalescer(window time.Duration) *Coalescer {
+	return &Coalescer{
+		window:  window,
+		pending: make(map[string]int),
+	}
+}
+
+// coalesceNotification holds a notification for the coalescing window,
+// dropping identical copies that arrive meanwhile. The notification is
+// written to out once the window closes.
+func (p *Proxy) coalesceNotification(out io.Writer, msg *JSONRPCMessage, data []byte) {
+	c := p.coalescer
+	key := string(data)
+
+	c.mu.Lock()
+	if _, held := c.pending[key]; held {
+		c.pending[key]++
+		c.mu.Unlock()
+		return
+	}
+	c.pending[key] = 0
+	c.held.Add(1)
+	c.mu.Unlock()
+
+	time.AfterFunc(c.window, func() {
+		defer c.held.Done()
+		defer recoverPanic("notification coalescing")
+
+		c.mu.Lock()
+		dropped := c.pending[key]
+		delete(c.pending, key)
+		c.mu.Unlock()
+
+		p.writeLine(out, data, "")
+
+		if p.debug && dropped > 0 {
+			log.Printf("[COALESCE] Merged %d duplicate %s notification(s)", dropped, msg.Method)
+		}
+	})
+}
+
+// coalescable reports whether a notification only announces a change, so
+// that a copy sent later stands for all of them
+func coalescable(method string) bool {
+	return strings.HasSuffix(method, "/list_changed") || method == "notifications/resources/updated"
+}
+
+// flush waits until all held notifications have been written
+func (c *Coalescer) flush() {
+	c.held.Wait()
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCoalescerHoldsOnlyChangeNotifications(t *testing.T) {
+	upstream := newTestUpstream(t, 0)
+	p, err := New(Config{URL: upstream.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.coalescer = NewCoalescer(300 * time.Millisecond)
+	s := startSession(t, p)
+
+	s.send(`{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":%q,"capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`, latestProtocolVersion)
+	if msg := s.receive(); msg.Result == nil {
+		t.Fatalf("unexpected initialize response: %+v", msg)
+	}
+	s.send(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	select {
+	case <-upstream.streamOpen:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the proxy did not open the GET stream")
+	}
+
+	// A burst of list_changed is merged and held back...
+	for i := 0; i < 3; i++ {
+		upstream.notify <- `{"jsonrpc":"2.0","method":"notifications/tools/list_changed"}`
+	}
+	// ...while progress still arrives ahead of its response
+	s.send(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo","arguments":{},"_meta":{"progressToken":"p"}}}`)
+	if msg := s.receive(); msg.Method != "notifications/progress" {
+		t.Fatalf("expected progress first, got %+v", msg)
+	}
+	if msg := s.receive(); string(msg.ID) != "1" {
+		t.Fatalf("expected the response, got %+v", msg)
+	}
+	if msg := s.receive(); msg.Method != "notifications/tools/list_changed" {
+		t.Fatalf("expected the merged list_changed, got %+v", msg)
+	}
+	select {
+	case line := <-s.lines:
+		t.Errorf("duplicate was not merged: %s", line)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
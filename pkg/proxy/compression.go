@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// acceptEncoding is what the proxy asks the upstream to compress responses with
+const acceptEncoding = "gzip, deflate"
+
+// decompressingTransport asks the upstream for compressed responses and
+// decompresses gzip and deflate bodies, JSON and SSE alike, so everything
+// past the transport sees plain JSON-RPC. Requests that already carry an
+// Accept-Encoding header, e.g. from --header, are passed through untouched.
+type decompressingTransport struct {
+	base http.RoundTripper
+}
+
+// withDecompression wraps base in a decompressingTransport
+func withDecompression(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &decompressingTransport{base: base}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *decompressingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept-Encoding") != "" {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.Body == nil || resp.Body == http.NoBody {
+		return resp, err
+	}
+
+	var open func(io.Reader) (io.Reader, error)
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		open = func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }
+	case "deflate":
+		open = openDeflate
+	default:
+		return resp, nil
+	}
+
+	resp.Body = &decodedBody{body: resp.Body, open: open}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// openDeflate reads a deflate body, which is meant to be zlib-wrapped but
+// is raw deflate from some servers
+func openDeflate(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+// decodedBody decompresses a response body. The decoder is created on the
+// first read, so an SSE stream whose first event is still pending doesn't
+// hold up the response headers.
+type decodedBody struct {
+	body   io.ReadCloser
+	open   func(io.Reader) (io.Reader, error)
+	reader io.Reader
+	err    error
+}
+
+// Read implements io.Reader
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.reader == nil && b.err == nil {
+		b.reader, b.err = b.open(b.body)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.reader.Read(p)
+}
+
+// Close implements io.Closer
+func (b *decodedBody) Close() error {
+	return b.body.Close()
+}
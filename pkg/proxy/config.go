@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is the upstream request timeout when Config leaves it unset
+const DefaultTimeout = 120 * time.Second
+
+// Config configures a proxy embedded in another program. The zero value of
+// every field except URL picks the same default as the command line.
+type Config struct {
+	// URL is the Streamable HTTP endpoint: http://, https:// or http+unix://
+	URL string
+
+	// Headers are added to every upstream request
+	Headers map[string]string
+	// BearerToken is sent as "Authorization: Bearer <token>"
+	BearerToken string
+
+	// HTTPClient sends upstream requests; a client with Timeout is created if nil
+	HTTPClient *http.Client
+	// Timeout bounds each upstream request, DefaultTimeout if zero and
+	// unbounded if negative
+	Timeout time.Duration
+
+	// Framing is the message framing on in and out: FramingNDJSON,
+	// FramingContentLength or FramingAuto (the default)
+	Framing string
+
+	// MaxConcurrent bounds how many requests are forwarded at once, 16 if
+	// zero; 1 processes messages one at a time
+	MaxConcurrent int
+
+	// MaxMessageSize bounds one message in either direction,
+	// DefaultMaxMessageSize if zero
+	MaxMessageSize int
+
+	// SSEIdleTimeout aborts a POST response stream silent for this long;
+	// zero waits for the request timeout
+	SSEIdleTimeout time.Duration
+
+	// WaitForBackend is how long Run waits for the upstream to accept
+	// connections, or WaitForBackendURL to answer 200, before reading in;
+	// QueueWhileDown reads and holds messages from in meanwhile
+	WaitForBackend    time.Duration
+	WaitForBackendURL string
+	QueueWhileDown    bool
+
+	// OutageQueue holds up to this many messages while the upstream is
+	// unreachable, each for at most OutageMaxWait (30s if zero); zero fails
+	// them right away
+	OutageQueue   int
+	OutageMaxWait time.Duration
+
+	// TransportMode is TransportStreamable, TransportSSE for servers still
+	// on the legacy HTTP+SSE transport, or TransportAuto (the default) to
+	// fall back to HTTP+SSE when Streamable HTTP is refused
+	TransportMode string
+
+	// Transport tunes the connection and header timeouts, keep-alive pool
+	// and HTTP/2 use of the HTTP client created when HTTPClient is nil
+	Transport TransportOptions
+
+	// DisableCompression stops asking the upstream for gzip or deflate
+	// compressed responses
+	DisableCompression bool
+
+	// RateLimits throttle forwarded requests, each "N/period" for all
+	// requests or "method=N/period" for one method; requests over a limit
+	// wait, or with RatePolicy "error" are answered with an error
+	RateLimits []string
+	RatePolicy string
+
+	// SessionFile saves the upstream session to this path and resumes it on
+	// the next run's initialize; empty opens a new session every time
+	SessionFile string
+
+	// DisableGetStream skips the standalone GET stream for server-initiated messages
+	DisableGetStream bool
+
+	// Debug enables verbose logging through the standard log package
+	Debug bool
+}
+
+// upstreamURL validates an upstream URL given by the user, resolving
+// http+unix:// URLs to their registered socket host
+func upstreamURL(url string) (string, error) {
+	switch {
+	case isUnixURL(url):
+		return resolveUnixURL(url)
+	case !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://"):
+		return "", fmt.Errorf("URL must start with http://, https:// or http+unix://")
+	}
+	return url, nil
+}
+
+// New creates a proxy from cfg; start it with Run
+func New(cfg Config) (*Proxy, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("no upstream URL")
+	}
+	url, err := upstreamURL(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	framingMode := cfg.Framing
+	if framingMode == "" {
+		framingMode = FramingAuto
+	}
+	if err := cfg.Transport.validate(); err != nil {
+		return nil, err
+	}
+
+	framing, err := newMessageFraming(framingMode)
+	if err != nil {
+		return nil, err
+	}
+
+	client := cfg.HTTPClient
+	if client == nil {
+		timeout := cfg.Timeout
+		if timeout == 0 {
+			timeout = DefaultTimeout
+		} else if timeout < 0 {
+			timeout = 0
+		}
+		client = &http.Client{Timeout: timeout, Transport: cfg.Transport.apply(nil)}
+	} else {
+		// Wrapping the transport below must not touch the caller's client
+		copied := *client
+		client = &copied
+	}
+	client.Transport = withUnixSockets(client.Transport)
+	if !cfg.DisableCompression {
+		client.Transport = withDecompression(client.Transport)
+	}
+
+	var auth *AuthConfig
+	if cfg.BearerToken != "" {
+		auth = &AuthConfig{Type: AuthBearer, Token: cfg.BearerToken}
+	}
+
+	maxConcurrent := cfg.MaxConcurrent
+	if maxConcurrent == 0 {
+		maxConcurrent = 16
+	}
+
+	var outage *OutageQueue
+	if cfg.OutageQueue > 0 {
+		maxWait := cfg.OutageMaxWait
+		if maxWait == 0 {
+			maxWait = 30 * time.Second
+		}
+		outage = NewOutageQueue(cfg.OutageQueue, maxWait)
+	}
+
+	var rateLimiter *RateLimiter
+	if len(cfg.RateLimits) > 0 {
+		if rateLimiter, err = NewRateLimiter(cfg.RateLimits, cfg.RatePolicy); err != nil {
+			return nil, err
+		}
+	}
+
+	legacy, err := newLegacySSE(cfg.TransportMode)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessionFile *SessionFile
+	if cfg.SessionFile != "" {
+		if sessionFile, err = LoadSessionFile(cfg.SessionFile); err != nil {
+			return nil, err
+		}
+	}
+
+	lifetime, stop := context.WithCancelCause(context.Background())
+	return &Proxy{
+		url:           url,
+		client:        newAuthClient(client, auth, cfg.Headers, url),
+		framing:       framing,
+		debug:         cfg.Debug,
+		breakpoints:   NewBreakpoints(),
+		getStream:     !cfg.DisableGetStream,
+		started:       time.Now(),
+		lifetime:      lifetime,
+		stop:          stop,
+		healthFailed:  make(chan error, 1),
+		maxConcurrent: maxConcurrent,
+
+		maxMessageSize: cfg.MaxMessageSize,
+		sseIdleTimeout: cfg.SSEIdleTimeout,
+		sessionFile:    sessionFile,
+		outage:         outage,
+		rateLimiter:    rateLimiter,
+		legacy:         legacy,
+
+		backendWait:    cfg.WaitForBackend,
+		backendWaitURL: cfg.WaitForBackendURL,
+		queueWhileDown: cfg.QueueWhileDown,
+	}, nil
+}
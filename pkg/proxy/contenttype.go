@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// defaultAccept is the Accept header the Streamable HTTP transport requires
+const defaultAccept = "application/json, text/event-stream"
+
+// Response content-type checking modes
+const (
+	ContentTypeLenient = "lenient" // text/event-stream is SSE, anything else is parsed as JSON
+	ContentTypeStrict  = "strict"  // Only application/json and text/event-stream are accepted
+	ContentTypeSniff   = "sniff"   // Ignore the header and detect SSE from the body
+)
+
+// jsonMediaTypes are the content types accepted as JSON in strict mode
+var jsonMediaTypes = map[string]bool{
+	"application/json": true,
+}
+
+// acceptHeader returns the Accept header sent to the upstream
+func (p *Proxy) acceptHeader() string {
+	if p.accept != "" {
+		return p.accept
+	}
+	return defaultAccept
+}
+
+// isSSEResponse decides whether a successful response body is an SSE stream,
+// according to the content-type checking mode. In sniff mode it may replace
+// resp.Body with a reader that replays the sniffed bytes.
+func (p *Proxy) isSSEResponse(resp *http.Response) (bool, error) {
+	// Accepted notifications have no body to check
+	if resp.StatusCode == http.StatusAccepted || resp.ContentLength == 0 {
+		return false, nil
+	}
+
+	header := resp.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(header))
+	}
+
+	switch p.contentTypeMode {
+	case ContentTypeStrict:
+		if mediaType == "text/event-stream" {
+			return true, nil
+		}
+		if jsonMediaTypes[mediaType] {
+			return false, nil
+		}
+		return false, fmt.Errorf("unexpected response content type %q", header)
+
+	case ContentTypeSniff:
+		reader := bufio.NewReader(resp.Body)
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{reader, resp.Body}
+
+		// JSON starts with { or [; an SSE stream with a field name or comment
+		peeked, _ := reader.Peek(64)
+		trimmed := bytes.TrimLeft(peeked, " \t\r\n")
+		return len(trimmed) > 0 && trimmed[0] != '{' && trimmed[0] != '[', nil
+	}
+
+	return mediaType == "text/event-stream", nil
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// controlCommand is a command served on the control socket
+type controlCommand struct {
+	usage   string
+	help    string
+	handler func(p *Proxy, args string) (string, error)
+}
+
+// controlCommands lists the commands understood by the control socket
+var controlCommands = map[string]controlCommand{}
+
+// registerControlCommand adds a command to the control socket
+func registerControlCommand(name, usage, help string, handler func(p *Proxy, args string) (string, error)) {
+	controlCommands[name] = controlCommand{usage: usage, help: help, handler: handler}
+}
+
+func init() {
+	registerControlCommand("help", "help", "List available commands", func(p *Proxy, args string) (string, error) {
+		names := make([]string, 0, len(controlCommands))
+		for name := range controlCommands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var b strings.Builder
+		for _, name := range names {
+			cmd := controlCommands[name]
+			fmt.Fprintf(&b, "%-28s %s\n", cmd.usage, cmd.help)
+		}
+		return strings.TrimRight(b.String(), "\n"), nil
+	})
+}
+
+// startControlSocket listens on a Unix socket and serves line-based commands
+func (p *Proxy) startControlSocket(path string) error {
+	// Remove a stale socket left by a previous run
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict control socket permissions: %w", err)
+	}
+
+	if p.debug {
+		log.Printf("[CONTROL] Listening on %s", path)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if p.debug {
+					log.Printf("[CONTROL] Accept failed: %v", err)
+				}
+				return
+			}
+			go p.serveControlConn(conn)
+		}
+	}()
+
+	return nil
+}
+
+// serveControlConn handles one control socket client
+//
+// Each request is a single line "<command> [args]". Each reply is one or more
+// lines prefixed with "OK" or "ERR", terminated by an empty line.
+func (p *Proxy) serveControlConn(conn net.Conn) {
+	defer conn.Close()
+	defer recoverPanic("control socket")
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		name, args, _ := strings.Cut(line, " ")
+		args = strings.TrimSpace(args)
+
+		if p.debug {
+			log.Printf("[CONTROL] Command: %s", line)
+		}
+
+		var reply string
+		cmd, ok := controlCommands[name]
+		if !ok {
+			reply = fmt.Sprintf("ERR unknown command %q (try \"help\")", name)
+		} else if out, err := cmd.handler(p, args); err != nil {
+			reply = "ERR " + err.Error()
+		} else if out == "" {
+			reply = "OK"
+		} else {
+			reply = "OK\n" + out
+		}
+
+		if _, err := fmt.Fprintf(conn, "%s\n\n", reply); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// statusReport describes the proxy and its upstream(s) for the control socket
+func (p *Proxy) statusReport() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Version:    %s\n", version)
+	fmt.Fprintf(&b, "Uptime:     %s\n", time.Since(p.started).Round(time.Second))
+	fmt.Fprintf(&b, "Log level:  %s\n", logLevelName(logLevel.Level()))
+	fmt.Fprintf(&b, "In flight:  %d\n", p.inFlightRequests())
+	if p.breaker != nil {
+		fmt.Fprintf(&b, "Circuit:    %s\n", p.breaker.status())
+	}
+	if statuses := p.healthStatuses(); len(statuses) > 0 {
+		fmt.Fprintf(&b, "Health:     %s\n", overallHealth(statuses))
+	}
+
+	for _, target := range p.upstreamProxies() {
+		session := target.session()
+		if session == "" {
+			session = "none"
+		}
+		fmt.Fprintf(&b, "Upstream:   %s\n", target.target())
+		fmt.Fprintf(&b, "  Session:  %s\n", session)
+		if version := target.negotiatedVersion(); version != "" {
+			fmt.Fprintf(&b, "  Protocol: %s\n", version)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// logLevelName names a level the way --log-level spells it
+func logLevelName(level slog.Level) string {
+	if level <= LevelTrace {
+		return "trace"
+	}
+	return strings.ToLower(level.String())
+}
+
+// reconnect drops the upstream session(s) and replays the client's initialize
+func (p *Proxy) reconnect() error {
+	for _, target := range p.upstreamProxies() {
+		target.reinitMu.Lock()
+		target.stopGetStream()
+		if err := target.terminateSession(); err != nil {
+			log.Printf("[CONTROL] Failed to terminate session at %s: %v", target.target(), err)
+		}
+		err := target.reinitialize()
+		target.reinitMu.Unlock()
+		if err != nil {
+			return fmt.Errorf("%s: %w", target.target(), err)
+		}
+	}
+	return nil
+}
+
+func init() {
+	registerControlCommand("status", "status", "Show the upstream, session, uptime and overall state", func(p *Proxy, args string) (string, error) {
+		return p.statusReport(), nil
+	})
+
+	registerControlCommand("set-log-level", "set-log-level <level>", "Change the log level (error, warn, info, debug or trace)", func(p *Proxy, args string) (string, error) {
+		level, err := parseLogLevel(args)
+		if err != nil {
+			return "", err
+		}
+		logLevel.Set(level)
+		return "", nil
+	})
+
+	registerControlCommand("reconnect", "reconnect", "Terminate the upstream session and initialize a new one", func(p *Proxy, args string) (string, error) {
+		if err := p.ensureConnected(); err != nil {
+			return "", err
+		}
+		if err := p.reconnect(); err != nil {
+			return "", err
+		}
+		return p.statusReport(), nil
+	})
+
+	registerControlCommand("rediscover", "rediscover", "Run mcp-hub discovery again and switch to the hub it finds", func(p *Proxy, args string) (string, error) {
+		if !p.followHub {
+			return "", fmt.Errorf("not in --mcp-hub mode")
+		}
+		if err := p.ensureConnected(); err != nil {
+			return "", err
+		}
+		current := p.target()
+		moved, err := p.rediscoverMcpHub(current, true)
+		if err != nil {
+			return "", err
+		}
+		if !moved {
+			return "mcp-hub is still at " + current, nil
+		}
+		return "switched to " + p.target(), nil
+	})
+
+	registerControlCommand("terminate-session", "terminate-session", "End the upstream session (the client must reconnect or re-initialize)", func(p *Proxy, args string) (string, error) {
+		for _, target := range p.upstreamProxies() {
+			target.stopGetStream()
+			if err := target.terminateSession(); err != nil {
+				return "", fmt.Errorf("%s: %w", target.target(), err)
+			}
+		}
+		return "", nil
+	})
+}
@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// newCorrelationID returns a random ID identifying one proxied request across
+// logs, metrics, transcripts and upstream headers
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestStats tracks one client request until its response is written
+type requestStats struct {
+	method        string
+	tool          string // params.name for tools/call and prompts/get
+	correlationID string
+	start         time.Time
+	retries       int
+	status        int               // HTTP status of the latest upstream attempt
+	headers       map[string]string // Upstream response headers passed through to _meta
+
+	// ctx is cancelled when the client cancels the request
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// requestTracker holds stats for requests awaiting their response
+type requestTracker struct {
+	mu       sync.Mutex
+	requests map[string]*requestStats
+}
+
+// trackRequest starts tracking a client request
+func (p *Proxy) trackRequest(msg *JSONRPCMessage, correlationID string) *requestStats {
+	stats := &requestStats{
+		method:        msg.Method,
+		tool:          paramsName(msg.Params),
+		correlationID: correlationID,
+		start:         time.Now(),
+	}
+	stats.ctx, stats.cancel = context.WithCancel(p.baseContext())
+
+	p.inflight.mu.Lock()
+	defer p.inflight.mu.Unlock()
+	if p.inflight.requests == nil {
+		p.inflight.requests = make(map[string]*requestStats)
+	}
+	p.inflight.requests[string(msg.ID)] = stats
+
+	return stats
+}
+
+// trackedRequest returns the stats for a request still awaiting its response, or nil
+func (p *Proxy) trackedRequest(id json.RawMessage) *requestStats {
+	p.inflight.mu.Lock()
+	defer p.inflight.mu.Unlock()
+	return p.inflight.requests[string(id)]
+}
+
+// finishRequest stops tracking a request and returns its stats, or nil if it wasn't tracked
+func (p *Proxy) finishRequest(id json.RawMessage) *requestStats {
+	p.inflight.mu.Lock()
+	defer p.inflight.mu.Unlock()
+	stats := p.inflight.requests[string(id)]
+	delete(p.inflight.requests, string(id))
+	return stats
+}
+
+// inFlightRequests returns the number of requests awaiting their response
+func (p *Proxy) inFlightRequests() int {
+	p.inflight.mu.Lock()
+	defer p.inflight.mu.Unlock()
+	return len(p.inflight.requests)
+}
+
+// cancelRequest aborts the upstream exchange of a request the client
+// cancelled, reporting whether it was still in flight
+func (p *Proxy) cancelRequest(id json.RawMessage) bool {
+	stats := p.trackedRequest(id)
+	if stats == nil {
+		return false
+	}
+	stats.cancel()
+	return true
+}
+
+// requestContext returns the context of a tracked request, or the proxy's
+// lifetime for untracked messages
+func (p *Proxy) requestContext(stats *requestStats) context.Context {
+	if stats == nil {
+		return p.baseContext()
+	}
+	return stats.ctx
+}
+
+// correlationOf returns the correlation ID of tracked stats, or "" for none
+func correlationOf(stats *requestStats) string {
+	if stats == nil {
+		return ""
+	}
+	return stats.correlationID
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// daemonReadyTimeout bounds how long a shim waits for the daemon it started
+const daemonReadyTimeout = 30 * time.Second
+
+// defaultDaemonSocket returns the broker socket for --daemon without
+// --broker. It is derived from the proxy's arguments, so invocations with
+// the same options share a daemon and different upstreams don't.
+func defaultDaemonSocket(args []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(args, "\x00")))
+	return defaultSocketPath("daemon-" + hex.EncodeToString(sum[:6]))
+}
+
+// runDaemonShim relays stdio to the background broker at path (--daemon),
+// first starting it with args if no broker is serving there yet
+func runDaemonShim(path string, args []string, debug bool) error {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		if conn, err = startDaemon(path, args, debug); err != nil {
+			return err
+		}
+	}
+	if debug {
+		log.Printf("[DAEMON] Attached to the daemon at %s", path)
+	}
+	return runShim(conn, os.Stdin, os.Stdout)
+}
+
+// startDaemon launches this executable as a headless broker on path,
+// detached so it outlives this proxy, and connects once it is listening.
+// Its output goes to a log file next to the socket.
+func startDaemon(path string, args []string, debug bool) (net.Conn, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate executable: %w", err)
+	}
+	logPath := strings.TrimSuffix(path, ".sock") + ".log"
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot start the daemon: %w", err)
+	}
+	defer logFile.Close()
+
+	// The broker options come first so they precede the upstream URL
+	cmd := exec.Command(executable, append([]string{"--serve", "--broker", path}, args...)...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	detachProcess(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start the daemon: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	if debug {
+		log.Printf("[DAEMON] Started the daemon (pid %d) on %s, logging to %s", cmd.Process.Pid, path, logPath)
+	}
+
+	// A daemon started concurrently by another shim may win the socket;
+	// this one then exits and the other serves
+	deadline := time.Now().Add(daemonReadyTimeout)
+	for {
+		if conn, err := net.Dial("unix", path); err == nil {
+			return conn, nil
+		}
+
+		select {
+		case err := <-exited:
+			if conn, dialErr := net.Dial("unix", path); dialErr == nil {
+				return conn, nil
+			}
+			return nil, fmt.Errorf("the daemon exited during start-up (%v); see %s", err, logPath)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("the daemon did not start listening within %v; see %s", daemonReadyTimeout, logPath)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EagerInit is the initialize handshake the proxy performs itself at
+// startup (--eager-init). The client's initialize is then answered from its
+// result without waiting for a slow upstream, as long as the client asks
+// for the protocol version that was negotiated; otherwise the eager session
+// is dropped and the client's handshake goes upstream as usual.
+type EagerInit struct {
+	clientInfo map[string]string
+
+	done chan struct{} // Closed once the handshake has finished
+
+	mu sync.Mutex
+	// result is the initialize result, nil if the handshake failed
+	result  json.RawMessage
+	version string
+	// settled is set once the client's initialize has been handled; the
+	// GET stream waits for it so nothing reaches the client before its
+	// initialize response
+	settled bool
+	// initializedSent is set while the client's notifications/initialized
+	// would repeat the one already sent
+	initializedSent bool
+}
+
+// NewEagerInit prepares an eager handshake identifying as clientInfo,
+// "name/version" or just a name
+func NewEagerInit(clientInfo string) (*EagerInit, error) {
+	name, ver, _ := strings.Cut(clientInfo, "/")
+	if name == "" {
+		return nil, fmt.Errorf("invalid client info %q (expected name/version)", clientInfo)
+	}
+	if ver == "" {
+		ver = version
+	}
+	return &EagerInit{
+		clientInfo: map[string]string{"name": name, "version": ver},
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// eagerInitialize opens the proxy's own session; run once at startup
+func (p *Proxy) eagerInitialize() {
+	defer recoverPanic("eager initialize")
+	e := p.eagerInit
+	defer close(e.done)
+
+	params := map[string]interface{}{
+		"protocolVersion": latestProtocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      e.clientInfo,
+	}
+	raw, _ := json.Marshal(params)
+	// Until the client's own initialize, an expired session is replayed with these
+	p.sessionMu.Lock()
+	p.initParams = raw
+	p.sessionMu.Unlock()
+
+	start := time.Now()
+	resp, err := p.call("initialize", params)
+	if err == nil && resp.Error != nil {
+		err = resp.Error
+	}
+	if err == nil {
+		err = p.notify("notifications/initialized", nil)
+	}
+	if err != nil {
+		log.Printf("[INIT] Eager initialize failed, the client's will go upstream: %v", err)
+		p.sessionMu.Lock()
+		p.sessionID = ""
+		p.sessionMu.Unlock()
+		return
+	}
+	p.captureProtocolVersion(resp.Result)
+
+	e.mu.Lock()
+	e.result = resp.Result
+	e.version = p.negotiatedVersion()
+	e.mu.Unlock()
+	if p.debug {
+		log.Printf("[INIT] Eager initialize completed in %v, session %s", time.Since(start).Round(time.Millisecond), p.session())
+	}
+}
+
+// answerEagerInit answers the client's initialize from the eager handshake,
+// waiting for it to finish, and reports whether it did
+func (p *Proxy) answerEagerInit(msg *JSONRPCMessage) bool {
+	e := p.eagerInit
+	if e == nil {
+		return false
+	}
+	select {
+	case <-e.done:
+	case <-p.baseContext().Done():
+		return false
+	}
+
+	e.mu.Lock()
+	result, negotiated, settled := e.result, e.version, e.settled
+	e.settled = true
+	e.mu.Unlock()
+	// Only the first initialize can be answered; later ones re-initialize
+	if settled || result == nil {
+		return false
+	}
+
+	var params struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	json.Unmarshal(msg.Params, &params)
+	if params.ProtocolVersion != negotiated {
+		log.Printf("[INIT] Client asked for protocol version %s, the eager session negotiated %s; initializing a new session", params.ProtocolVersion, negotiated)
+		if err := p.terminateSession(); err != nil && p.debug {
+			log.Printf("[INIT] Failed to terminate the eager session: %v", err)
+		}
+		p.stopGetStream()
+		p.sessionMu.Lock()
+		p.sessionID = ""
+		p.protocolVersion = ""
+		p.sessionMu.Unlock()
+		return false
+	}
+
+	e.mu.Lock()
+	e.initializedSent = true
+	e.mu.Unlock()
+
+	response := JSONRPCMessage{JSONRPC: "2.0", ID: msg.ID, Result: result}
+	data, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("[ERROR] Failed to marshal eager initialize result: %v", err)
+		return false
+	}
+	if p.debug {
+		log.Printf("[INIT] Answered initialize from the eager session %s", p.session())
+	}
+	p.writeMessage(&response, data)
+
+	if p.getStream {
+		p.startGetStream(p.session())
+	}
+	return true
+}
+
+// holdsGetStream reports whether the GET stream must wait for the client's
+// initialize to be handled
+func (e *EagerInit) holdsGetStream() bool {
+	if e == nil {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return !e.settled
+}
+
+// takeInitialized reports, once, whether the client's
+// notifications/initialized repeats the one the eager handshake sent
+func (e *EagerInit) takeInitialized() bool {
+	if e == nil {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	sent := e.initializedSent
+	e.initializedSent = false
+	return sent
+}
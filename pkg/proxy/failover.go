@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+)
+
+// failOver switches to the next --url after failedURL, which stopped
+// answering or whose circuit opened. When reinit is set the session is
+// re-established on the new upstream and the client is told about the
+// switch. It reports whether the upstream changed.
+func (p *Proxy) failOver(failedURL string, cause error, reinit bool) bool {
+	if len(p.failoverURLs) < 2 {
+		return false
+	}
+	p.rediscoverMu.Lock()
+	defer p.rediscoverMu.Unlock()
+
+	// A concurrent request already failed over
+	if p.target() != failedURL {
+		return true
+	}
+
+	next := p.failoverURLs[0]
+	for i, url := range p.failoverURLs {
+		if url == failedURL {
+			next = p.failoverURLs[(i+1)%len(p.failoverURLs)]
+			break
+		}
+	}
+	log.Printf("[FAILOVER] %s failed (%v); switching to %s", failedURL, cause, next)
+
+	p.sessionMu.Lock()
+	p.url = next
+	p.sessionID = ""
+	p.protocolVersion = ""
+	initialized := p.initParams != nil
+	p.sessionMu.Unlock()
+	if p.health != nil {
+		if err := p.health.setEndpoint(next); err != nil {
+			log.Printf("[FAILOVER] Failed to retarget health checks: %v", err)
+		}
+	}
+	// The breaker's failures belong to the old upstream
+	if p.breaker != nil {
+		p.breaker.reset()
+	}
+
+	// The old session stays behind on the old upstream
+	p.stopGetStream()
+
+	// Before the handshake, or while failing it over, there's no session
+	// the client needs to hear about
+	if !initialized || !reinit {
+		return true
+	}
+	message := fmt.Sprintf("Upstream %s failed; switched to %s and re-established the session", failedURL, next)
+	if err := p.reinitialize(); err != nil {
+		log.Printf("[FAILOVER] Failed to initialize a session on %s: %v", next, err)
+		message = fmt.Sprintf("Upstream %s failed; switched to %s, but initializing a session there failed: %v", failedURL, next, err)
+	}
+	p.sendLogMessage("warning", message)
+
+	return true
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Fixture modes control when a canned response is served
+const (
+	FixtureModeFallback = "fallback" // Serve only when the upstream is unreachable
+	FixtureModeAlways   = "always"   // Serve without contacting the upstream
+)
+
+// FixtureRule maps a method (and optionally a tool name) to a canned response
+type FixtureRule struct {
+	Method string          `json:"method"`           // Glob pattern matched against the JSON-RPC method
+	Tool   string          `json:"tool,omitempty"`   // Glob pattern matched against params.name (tools/call, prompts/get)
+	Mode   string          `json:"mode,omitempty"`   // "fallback" (default) or "always"
+	Result json.RawMessage `json:"result,omitempty"` // Result to return
+	Error  *JSONRPCError   `json:"error,omitempty"`  // Error to return instead of a result
+	source string          // File the rule was loaded from, for debug output
+}
+
+// loadFixtures reads all *.json files in dir; each file holds one rule or an array of rules
+func loadFixtures(dir string) ([]FixtureRule, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list fixtures: %w", err)
+	}
+	sort.Strings(files)
+
+	var rules []FixtureRule
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fixture %s: %w", file, err)
+		}
+
+		var fileRules []FixtureRule
+		if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+			err = json.Unmarshal(data, &fileRules)
+		} else {
+			var rule FixtureRule
+			err = json.Unmarshal(data, &rule)
+			fileRules = []FixtureRule{rule}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid fixture %s: %w", file, err)
+		}
+
+		for i := range fileRules {
+			rule := &fileRules[i]
+			if rule.Method == "" {
+				return nil, fmt.Errorf("invalid fixture %s: method is required", file)
+			}
+			if rule.Mode == "" {
+				rule.Mode = FixtureModeFallback
+			}
+			if rule.Mode != FixtureModeFallback && rule.Mode != FixtureModeAlways {
+				return nil, fmt.Errorf("invalid fixture %s: unknown mode %q", file, rule.Mode)
+			}
+			if _, err := path.Match(rule.Method, ""); err != nil {
+				return nil, fmt.Errorf("invalid fixture %s: bad method pattern: %w", file, err)
+			}
+			if _, err := path.Match(rule.Tool, ""); err != nil {
+				return nil, fmt.Errorf("invalid fixture %s: bad tool pattern: %w", file, err)
+			}
+			rule.source = filepath.Base(file)
+		}
+		rules = append(rules, fileRules...)
+	}
+
+	return rules, nil
+}
+
+// matchFixture returns the first rule matching the message for the given mode, or nil
+func (p *Proxy) matchFixture(msg *JSONRPCMessage, mode string) *FixtureRule {
+	if msg.ID == nil || msg.Method == "" {
+		return nil
+	}
+
+	var toolName string
+	for i := range p.fixtures {
+		rule := &p.fixtures[i]
+		if rule.Mode != mode {
+			continue
+		}
+		if ok, _ := path.Match(rule.Method, msg.Method); !ok {
+			continue
+		}
+		if rule.Tool != "" {
+			if toolName == "" {
+				toolName = paramsName(msg.Params)
+			}
+			if ok, _ := path.Match(rule.Tool, toolName); !ok {
+				continue
+			}
+		}
+		return rule
+	}
+
+	return nil
+}
+
+// serveFixture writes the canned response for a rule to stdout
+func (p *Proxy) serveFixture(rule *FixtureRule, msg *JSONRPCMessage) {
+	resp := JSONRPCMessage{
+		JSONRPC: "2.0",
+		ID:      msg.ID,
+		Result:  rule.Result,
+		Error:   rule.Error,
+	}
+	if resp.Error == nil && resp.Result == nil {
+		resp.Result = json.RawMessage("{}")
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("[ERROR] Failed to marshal fixture response: %v", err)
+		return
+	}
+
+	p.writeMessage(&resp, data)
+	if p.debug {
+		log.Printf("[FIXTURE] Served %s (%s) from %s: %s", msg.Method, rule.Mode, rule.source, data)
+	}
+}
+
+// paramsName extracts params.name, used by tools/call and prompts/get
+func paramsName(params json.RawMessage) string {
+	var named struct {
+		Name string `json:"name"`
+	}
+	if len(params) == 0 || json.Unmarshal(params, &named) != nil {
+		return ""
+	}
+	return named.Name
+}
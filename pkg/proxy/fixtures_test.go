@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFallbackFixtureOnlyWhenUnreachable(t *testing.T) {
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	// initialize is the one request not held until the handshake is done
+	fixture := FixtureRule{Method: "initialize", Mode: FixtureModeFallback, Result: json.RawMessage(`{"canned":true}`), source: "test"}
+	tests := []struct {
+		name        string
+		url         string
+		wantFixture bool
+	}{
+		{name: "unreachable", url: down.URL, wantFixture: true},
+		{name: "rejected", url: rejecting.URL, wantFixture: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(Config{URL: tt.url, DisableGetStream: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.fixtures = []FixtureRule{fixture}
+			s := startSession(t, p)
+
+			s.send(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":%q,"capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`, latestProtocolVersion)
+			msg := s.receive()
+			if served := msg.Result != nil && string(msg.Result) == `{"canned":true}`; served != tt.wantFixture {
+				t.Errorf("fixture served: %v, want %v (got %+v)", served, tt.wantFixture, msg)
+			}
+			if !tt.wantFixture && msg.Error == nil {
+				t.Errorf("expected the upstream's rejection, got %+v", msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,422 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Stdio framing modes
+const (
+	FramingNDJSON        = "ndjson"         // One JSON message per line; input may be laid out freely
+	FramingContentLength = "content-length" // LSP-style "Content-Length: N" headers
+	FramingAuto          = "auto"           // Detected from the first bytes of input
+)
+
+// contentLengthHeader starts every Content-Length framed message
+const contentLengthHeader = "content-length:"
+
+// messageFraming splits client input into messages and frames output the
+// same way, settling on a mode from the first input in auto mode
+type messageFraming struct {
+	mode atomic.Value // string
+
+	// maxSize bounds one input message; oversized is called with the ID
+	// (nil if it couldn't be found) of each message skipped for exceeding it
+	maxSize   int
+	oversized func(id json.RawMessage)
+
+	// The rest of a skipped message: discard bytes of a Content-Length
+	// body, the remainder of a JSON value with skipper, or up to the next
+	// newline with discardLine
+	discard     int
+	skipper     *valueSkipper
+	discardLine bool
+
+	// What is known about an incomplete value at the start of the input,
+	// so that each read only scans the new data
+	pending *partialValue
+}
+
+// partialValue records the progress made on a JSON value still being read
+type partialValue struct {
+	newline     int  // Offset just past the first newline, 0 until one is found
+	searched    int  // How far the newline search got
+	lineChecked bool // Whether the first line has been tried as a message
+	skipper     valueSkipper
+	scanned     int // How far skipper got
+	decoded     int // Length decoded when last checked for invalid JSON
+}
+
+// newMessageFraming validates a --framing value
+func newMessageFraming(mode string) (*messageFraming, error) {
+	switch mode {
+	case FramingNDJSON, FramingContentLength, FramingAuto:
+	default:
+		return nil, fmt.Errorf("unknown --framing %q (expected ndjson, content-length or auto)", mode)
+	}
+	f := &messageFraming{}
+	f.mode.Store(mode)
+	return f, nil
+}
+
+// current returns the framing in use, FramingAuto until it is detected
+func (f *messageFraming) current() string {
+	return f.mode.Load().(string)
+}
+
+// split is a bufio.SplitFunc returning one client message per token
+func (f *messageFraming) split(data []byte, atEOF bool) (int, []byte, error) {
+	mode := f.current()
+	if mode == FramingAuto {
+		start := bytes.TrimLeft(data, " \t\r\n")
+		switch {
+		case len(start) == 0:
+			if atEOF {
+				return len(data), nil, nil
+			}
+			return 0, nil, nil
+		case hasPrefixFold(start, contentLengthHeader):
+			mode = FramingContentLength
+		case !atEOF && len(start) < len(contentLengthHeader) && hasPrefixFold([]byte(contentLengthHeader), string(start)):
+			// Could still become a Content-Length header
+			return 0, nil, nil
+		default:
+			mode = FramingNDJSON
+		}
+		f.mode.Store(mode)
+	}
+
+	if f.skipper != nil {
+		n, done := f.skipper.skip(data)
+		if done {
+			f.skipper = nil
+		}
+		return n, nil, nil
+	}
+	if f.discardLine {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			f.discardLine = false
+			return i + 1, nil, nil
+		}
+		return len(data), nil, nil
+	}
+	if f.discard > 0 {
+		n := min(f.discard, len(data))
+		f.discard -= n
+		return n, nil, nil
+	}
+
+	if mode == FramingNDJSON {
+		return f.splitJSON(data, atEOF)
+	}
+	return f.splitContentLength(data, atEOF)
+}
+
+// splitJSON reads one JSON value, however it is spread over lines: values
+// may be pretty-printed, share a line or lack a trailing newline. Input
+// that isn't valid JSON is returned a line at a time to be reported.
+//
+// A value arriving over many reads is scanned incrementally, and decoded
+// again only once it has doubled in size since the last check, so reading
+// it stays linear in its size. Invalid JSON inside a long value may
+// therefore only be noticed once more input arrives.
+func (f *messageFraming) splitJSON(data []byte, atEOF bool) (int, []byte, error) {
+	start := len(data) - len(bytes.TrimLeft(data, " \t\r\n"))
+	rest := data[start:]
+	if len(rest) == 0 {
+		return len(data), nil, nil
+	}
+
+	// Pick up where the previous read left off; pending is only kept when
+	// the value is still incomplete
+	v := f.pending
+	f.pending = nil
+	if v == nil {
+		v = &partialValue{}
+	}
+	if v.newline == 0 {
+		if i := bytes.IndexByte(rest[v.searched:], '\n'); i >= 0 {
+			v.newline = v.searched + i + 1
+		} else {
+			v.searched = len(rest)
+		}
+	}
+	line := rest
+	if v.newline > 0 {
+		line = bytes.TrimRight(rest[:v.newline-1], "\r")
+	}
+
+	// Most clients send exactly one message per line
+	if (v.newline > 0 && !v.lineChecked) || atEOF {
+		v.lineChecked = v.newline > 0
+		if json.Valid(line) {
+			if f.maxSize > 0 && len(line) > f.maxSize {
+				f.skipOversized(line)
+				return start + len(line), nil, nil
+			}
+			return start + len(line), line, nil
+		}
+	}
+
+	// An object or array ends where its brackets balance
+	container := rest[0] == '{' || rest[0] == '['
+	if container {
+		n, closed := v.skipper.skip(rest[v.scanned:])
+		v.scanned += n
+		if closed {
+			if value := rest[:v.scanned]; json.Valid(value) {
+				return f.compactValue(start, value)
+			}
+			return f.invalidJSON(start, rest, line, v, atEOF)
+		}
+	}
+
+	if !atEOF && f.maxSize > 0 && len(rest) > f.maxSize {
+		f.skipOversized(rest)
+		if container {
+			f.skipper = &v.skipper
+			return len(data), nil, nil
+		}
+		return start + f.skipValue(rest), nil, nil
+	}
+	if !atEOF && len(rest) < 2*v.decoded {
+		f.pending = v
+		return start, nil, nil
+	}
+
+	// Decode to tell an incomplete value from invalid JSON, and to find
+	// the end of anything else
+	v.decoded = len(rest)
+	dec := json.NewDecoder(bytes.NewReader(rest))
+	var value json.RawMessage
+	err := dec.Decode(&value)
+	end := int(dec.InputOffset())
+	if err == nil && (atEOF || end < len(rest)) {
+		return f.compactValue(start, rest[:end])
+	}
+	if !atEOF && (err == nil || err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF)) {
+		// The value continues past the data read so far
+		f.pending = v
+		return start, nil, nil
+	}
+	return f.invalidJSON(start, rest, line, v, atEOF)
+}
+
+// compactValue returns a complete JSON value at the start of rest, which
+// begins start bytes into the input, as a single line, as messages are
+// recorded and logged that way
+func (f *messageFraming) compactValue(start int, value []byte) (int, []byte, error) {
+	if f.maxSize > 0 && len(value) > f.maxSize {
+		f.skipOversized(value)
+		return start + len(value), nil, nil
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, value); err != nil {
+		return 0, nil, err
+	}
+	return start + len(value), compact.Bytes(), nil
+}
+
+// invalidJSON passes on the first line of rest so the caller can report it
+func (f *messageFraming) invalidJSON(start int, rest, line []byte, v *partialValue, atEOF bool) (int, []byte, error) {
+	if v.newline > 0 {
+		return start + v.newline, line, nil
+	}
+	if atEOF {
+		return start + len(rest), rest, nil
+	}
+	if f.maxSize > 0 && len(rest) > f.maxSize {
+		f.discardLine = true
+		return start + len(rest), nil, nil
+	}
+	f.pending = v
+	return start, nil, nil
+}
+
+// skipValue starts discarding an oversized value that begins at the start
+// of data, returning how much of data it covers
+func (f *messageFraming) skipValue(data []byte) int {
+	if data[0] != '{' && data[0] != '[' {
+		f.discardLine = true
+		return len(data)
+	}
+	skipper := &valueSkipper{}
+	n, done := skipper.skip(data)
+	if !done {
+		f.skipper = skipper
+	}
+	return n
+}
+
+// valueSkipper finds the end of a JSON object or array without buffering it
+type valueSkipper struct {
+	depth    int
+	inString bool
+	escaped  bool
+}
+
+// skip consumes data, returning how much of it belongs to the value and
+// whether the value ended there
+func (s *valueSkipper) skip(data []byte) (int, bool) {
+	for i, c := range data {
+		switch {
+		case s.escaped:
+			s.escaped = false
+		case s.inString:
+			switch c {
+			case '\\':
+				s.escaped = true
+			case '"':
+				s.inString = false
+			}
+		case c == '"':
+			s.inString = true
+		case c == '{' || c == '[':
+			s.depth++
+		case c == '}' || c == ']':
+			s.depth--
+			if s.depth == 0 {
+				return i + 1, true
+			}
+		}
+	}
+	return len(data), false
+}
+
+// skipOversized reports a message over maxSize, identified from its first bytes
+func (f *messageFraming) skipOversized(start []byte) {
+	if f.oversized != nil {
+		f.oversized(leadingID(start))
+	}
+}
+
+// splitContentLength reads one Content-Length framed message
+func (f *messageFraming) splitContentLength(data []byte, atEOF bool) (int, []byte, error) {
+	// Skip blank lines between messages
+	skipped := len(data) - len(bytes.TrimLeft(data, "\r\n"))
+	rest := data[skipped:]
+	if len(rest) == 0 {
+		if atEOF {
+			return len(data), nil, nil
+		}
+		return 0, nil, nil
+	}
+
+	// Only look for bare LF line endings within the headers, not the body
+	headerEnd, bodyStart := bytes.Index(rest, []byte("\r\n\r\n")), 4
+	headers := rest
+	if headerEnd >= 0 {
+		headers = rest[:headerEnd]
+	}
+	if lf := bytes.Index(headers, []byte("\n\n")); lf >= 0 {
+		headerEnd, bodyStart = lf, 2
+	}
+	if headerEnd < 0 {
+		if atEOF {
+			return 0, nil, fmt.Errorf("incomplete message headers: %w", io.ErrUnexpectedEOF)
+		}
+		return 0, nil, nil
+	}
+
+	length := -1
+	for _, line := range strings.Split(string(rest[:headerEnd]), "\n") {
+		name, value, _ := strings.Cut(strings.TrimSpace(line), ":")
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || n < 0 {
+				return 0, nil, fmt.Errorf("invalid Content-Length %q", strings.TrimSpace(value))
+			}
+			length = n
+		}
+	}
+	if length < 0 {
+		return 0, nil, fmt.Errorf("message headers have no Content-Length")
+	}
+
+	end := headerEnd + bodyStart + length
+	if f.maxSize > 0 && length > f.maxSize {
+		// Wait for as much of the body as a message may have, where its ID
+		// should be found
+		if !atEOF && len(rest) < headerEnd+bodyStart+f.maxSize {
+			return 0, nil, nil
+		}
+		f.skipOversized(rest[headerEnd+bodyStart:])
+		if len(rest) >= end {
+			return skipped + end, nil, nil
+		}
+		f.discard = end - len(rest)
+		return len(data), nil, nil
+	}
+	if len(rest) < end {
+		if atEOF {
+			return 0, nil, fmt.Errorf("incomplete message body: %w", io.ErrUnexpectedEOF)
+		}
+		return 0, nil, nil
+	}
+	return skipped + end, rest[headerEnd+bodyStart : end], nil
+}
+
+// hasPrefixFold reports whether data starts with prefix, ignoring case
+func hasPrefixFold(data []byte, prefix string) bool {
+	return len(data) >= len(prefix) && strings.EqualFold(string(data[:len(prefix)]), prefix)
+}
+
+// framedWriter frames each newline-terminated message written to it with
+// Content-Length headers when that is the framing in use
+type framedWriter struct {
+	w       io.Writer
+	framing *messageFraming
+}
+
+// Write implements io.Writer; p must hold exactly one message
+func (w *framedWriter) Write(p []byte) (int, error) {
+	if w.framing.current() != FramingContentLength {
+		return w.w.Write(p)
+	}
+
+	if err := writeFrame(w, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// frameWriters pools the buffers messages are written to the client through
+var frameWriters = sync.Pool{
+	New: func() any { return bufio.NewWriterSize(nil, 32*1024) },
+}
+
+// writeFrame writes one message to out, newline-terminated or with a
+// Content-Length header when out is a framedWriter in that mode. Messages
+// larger than the pooled buffer are passed through without being copied.
+func writeFrame(out io.Writer, data []byte) error {
+	contentLength := false
+	if w, ok := out.(*framedWriter); ok {
+		out = w.w
+		contentLength = w.framing.current() == FramingContentLength
+	}
+
+	bw := frameWriters.Get().(*bufio.Writer)
+	bw.Reset(out)
+	defer func() {
+		bw.Reset(nil)
+		frameWriters.Put(bw)
+	}()
+
+	if contentLength {
+		body := bytes.TrimSuffix(data, []byte("\n"))
+		fmt.Fprintf(bw, "Content-Length: %d\r\n\r\n", len(body))
+		bw.Write(body)
+	} else {
+		bw.Write(data)
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestMessageFramingSplit(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		input     string
+		want      []string
+		wantMode  string // Mode settled on; defaults to mode
+		wantErr   bool
+		maxSize   int
+		oversized []string // IDs reported for skipped messages
+	}{
+		// NDJSON
+		{name: "one per line", mode: FramingNDJSON, input: "{\"a\":1}\n{\"b\":2}\n", want: []string{`{"a":1}`, `{"b":2}`}},
+		{name: "CRLF", mode: FramingNDJSON, input: "{\"a\":1}\r\n{\"b\":2}\r\n", want: []string{`{"a":1}`, `{"b":2}`}},
+		{name: "no trailing newline", mode: FramingNDJSON, input: `{"a":1}`, want: []string{`{"a":1}`}},
+		{name: "blank lines", mode: FramingNDJSON, input: "\n\n  {\"a\":1}\n\n\n", want: []string{`{"a":1}`}},
+		{name: "shared line", mode: FramingNDJSON, input: "{\"a\":1} {\"b\":2}[3]\n", want: []string{`{"a":1}`, `{"b":2}`, `[3]`}},
+		{name: "pretty-printed", mode: FramingNDJSON, input: "{\n  \"a\": [\n    1,\n    2\n  ]\n}\n{\"b\":2}\n", want: []string{`{"a":[1,2]}`, `{"b":2}`}},
+		{name: "brackets in strings", mode: FramingNDJSON, input: "{\"s\": \"}]\\\"{\"\n}\n", want: []string{`{"s":"}]\"{"}`}},
+		{name: "garbage line", mode: FramingNDJSON, input: "not json\n{\"a\":1}\n", want: []string{"not json", `{"a":1}`}},
+		{name: "unbalanced garbage", mode: FramingNDJSON, input: "{oops\n{\"a\":1}\n", want: []string{"{oops", `{"a":1}`}},
+		{name: "mismatched brackets", mode: FramingNDJSON, input: "{\"a\":1]\n{\"b\":2}\n", want: []string{`{"a":1]`, `{"b":2}`}},
+		{name: "garbage at EOF", mode: FramingNDJSON, input: "{\"a\":1}\ntrailing", want: []string{`{"a":1}`, "trailing"}},
+		{
+			name: "oversized", mode: FramingNDJSON, maxSize: 20,
+			input:     "{\"id\":1,\"params\":\"0123456789abcdef\"}\n{\"a\":1}\n",
+			want:      []string{`{"a":1}`},
+			oversized: []string{"1"},
+		},
+		{
+			name: "oversized pretty-printed", mode: FramingNDJSON, maxSize: 20,
+			input:     "{\n  \"id\": \"x\",\n  \"params\": \"0123456789abcdef\"\n}\n{\"a\":1}\n",
+			want:      []string{`{"a":1}`},
+			oversized: []string{`"x"`},
+		},
+
+		// Content-Length
+		{name: "content-length", mode: FramingContentLength, input: "Content-Length: 7\r\n\r\n{\"a\":1}Content-Length: 7\r\n\r\n{\"b\":2}", want: []string{`{"a":1}`, `{"b":2}`}},
+		{name: "LF headers", mode: FramingContentLength, input: "Content-Length: 7\n\n{\"a\":1}\n", want: []string{`{"a":1}`}},
+		{name: "other headers", mode: FramingContentLength, input: "Content-Type: application/json\r\ncontent-length: 7\r\n\r\n{\"a\":1}", want: []string{`{"a":1}`}},
+		{name: "blank lines in body", mode: FramingContentLength, input: "Content-Length: 9\r\n\r\n{\"a\":\n\n1}\r\n", want: []string{"{\"a\":\n\n1}"}},
+		{name: "no length", mode: FramingContentLength, input: "Content-Type: x\r\n\r\n{}", wantErr: true},
+		{name: "invalid length", mode: FramingContentLength, input: "Content-Length: -1\r\n\r\n{}", wantErr: true},
+		{name: "truncated body", mode: FramingContentLength, input: "Content-Length: 9\r\n\r\n{\"a\":1}", wantErr: true},
+		{name: "truncated headers", mode: FramingContentLength, input: "Content-Length: 7\r\n", wantErr: true},
+		{
+			name: "oversized body", mode: FramingContentLength, maxSize: 10,
+			input:     "Content-Length: 17\r\n\r\n{\"id\":2,\"x\":\"yy\"}Content-Length: 7\r\n\r\n{\"a\":1}",
+			want:      []string{`{"a":1}`},
+			oversized: []string{"2"},
+		},
+
+		// Auto-detection
+		{name: "auto NDJSON", mode: FramingAuto, input: "{\"a\":1}\n", want: []string{`{"a":1}`}, wantMode: FramingNDJSON},
+		{name: "auto content-length", mode: FramingAuto, input: "Content-Length: 7\r\n\r\n{\"a\":1}", want: []string{`{"a":1}`}, wantMode: FramingContentLength},
+		{name: "auto after whitespace", mode: FramingAuto, input: "\r\n  content-length: 7\r\n\r\n{\"a\":1}", want: []string{`{"a":1}`}, wantMode: FramingContentLength},
+		{name: "auto garbage", mode: FramingAuto, input: "Content-Typo\n", want: []string{"Content-Typo"}, wantMode: FramingNDJSON},
+		{name: "auto empty", mode: FramingAuto, input: "\n\n", wantMode: FramingAuto},
+	}
+
+	readers := map[string]func(io.Reader) io.Reader{
+		"whole":    func(r io.Reader) io.Reader { return r },
+		"one byte": iotest.OneByteReader,
+		"halves":   iotest.HalfReader,
+	}
+	for _, tt := range tests {
+		for readerName, reader := range readers {
+			t.Run(tt.name+"/"+readerName, func(t *testing.T) {
+				f, err := newMessageFraming(tt.mode)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var oversized []string
+				f.maxSize = tt.maxSize
+				f.oversized = func(id json.RawMessage) { oversized = append(oversized, string(id)) }
+
+				scanner := bufio.NewScanner(reader(strings.NewReader(tt.input)))
+				scanner.Split(f.split)
+				var got []string
+				for scanner.Scan() {
+					got = append(got, scanner.Text())
+				}
+
+				if err := scanner.Err(); (err != nil) != tt.wantErr {
+					t.Fatalf("error %v, want error: %v", err, tt.wantErr)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got messages %q, want %q", got, tt.want)
+				}
+				if !reflect.DeepEqual(oversized, tt.oversized) {
+					t.Errorf("got oversized %q, want %q", oversized, tt.oversized)
+				}
+				wantMode := tt.wantMode
+				if wantMode == "" {
+					wantMode = tt.mode
+				}
+				if mode := f.current(); mode != wantMode {
+					t.Errorf("settled on %s, want %s", mode, wantMode)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// Reconnect backoff for the standalone GET stream
+const (
+	getStreamMinBackoff = time.Second
+	getStreamMaxBackoff = 30 * time.Second
+)
+
+// sseResumeAttempts bounds how often a dropped POST stream is resumed
+const sseResumeAttempts = 3
+
+// errGetStreamUnsupported means the server doesn't offer a standalone GET stream
+var errGetStreamUnsupported = errors.New("server does not offer a GET stream")
+
+// startGetStream opens the standalone SSE stream for a new session, replacing
+// the previous session's stream
+func (p *Proxy) startGetStream(sessionID string) {
+	p.getStreamMu.Lock()
+	if p.getStreamCancel != nil {
+		p.getStreamCancel()
+	}
+	ctx, cancel := context.WithCancel(p.baseContext())
+	p.getStreamCancel = cancel
+	p.getStreamMu.Unlock()
+
+	go p.runGetStream(ctx, sessionID)
+}
+
+// stopGetStream closes the standalone SSE stream, if open
+func (p *Proxy) stopGetStream() {
+	p.getStreamMu.Lock()
+	defer p.getStreamMu.Unlock()
+	if p.getStreamCancel != nil {
+		p.getStreamCancel()
+		p.getStreamCancel = nil
+	}
+}
+
+// runGetStream keeps the GET stream open for as long as the session lasts,
+// reconnecting with backoff when it drops
+func (p *Proxy) runGetStream(ctx context.Context, sessionID string) {
+	defer recoverPanic("GET stream")
+
+	backoff := getStreamMinBackoff
+	var cursor sseCursor
+	for ctx.Err() == nil && p.session() == sessionID {
+		opened, err := p.readGetStream(ctx, sessionID, &cursor)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errGetStreamUnsupported) {
+			if p.debug {
+				log.Printf("[SSE] GET stream not available: %v", err)
+			}
+			return
+		}
+
+		// A stream that was open for a while reconnects promptly, or after
+		// the delay the server asked for
+		if opened {
+			backoff = getStreamMinBackoff
+		}
+		delay := cursor.reconnectDelay(backoff)
+		if err != nil {
+			log.Printf("[SSE] GET stream failed, reconnecting in %v: %v", delay, err)
+		} else if p.debug {
+			log.Printf("[SSE] GET stream closed by the server, reconnecting in %v", delay)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		backoff = min(backoff*2, getStreamMaxBackoff)
+	}
+}
+
+// readGetStream opens one GET stream and forwards its messages to the client
+// until it ends, resuming after the cursor's last event ID if set. It
+// reports whether the stream was established.
+func (p *Proxy) readGetStream(ctx context.Context, sessionID string, cursor *sseCursor) (bool, error) {
+	resp, err := p.openEventStream(ctx, sessionID, cursor.lastEventID)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if p.debug {
+		log.Printf("[SSE] GET stream open for session %s", sessionID)
+	}
+
+	err = p.readSSE(resp.Body, cursor, func(data []byte) {
+		if _, err := p.writeSSEData(p.stdout, data); err != nil {
+			log.Printf("[ERROR] GET stream: %v", err)
+		}
+	})
+	if ctx.Err() != nil {
+		return true, nil
+	}
+	return true, err
+}
+
+// resumeSSE asks the server to replay a dropped stream's events after the
+// cursor's last event ID and reads them until the replayed stream ends or ctx is done
+func (p *Proxy) resumeSSE(ctx context.Context, cursor *sseCursor, onData func(data []byte)) error {
+	resp, err := p.openEventStream(ctx, p.session(), cursor.lastEventID)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	stream, stop := p.watchStream(resp.Body)
+	defer stop()
+	return p.readSSE(stream, cursor, onData)
+}
+
+// reconnectDelay returns the server's retry interval if it sent one, else backoff
+func (c *sseCursor) reconnectDelay(backoff time.Duration) time.Duration {
+	if c.retry > 0 {
+		return c.retry
+	}
+	return backoff
+}
+
+// openEventStream sends a GET for an SSE stream, with Last-Event-ID when resuming
+func (p *Proxy) openEventStream(ctx context.Context, sessionID, lastEventID string) (*http.Response, error) {
+	req, err := p.newUpstreamRequest("GET", "", sessionID)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+		if p.debug {
+			log.Printf("[SSE] Resuming after event %s", lastEventID)
+		}
+	}
+
+	// The stream is long-lived, so the per-request timeout doesn't apply
+	client := *p.client
+	client.Timeout = 0
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusMethodNotAllowed:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w (HTTP 405)", errGetStreamUnsupported)
+	case resp.StatusCode == http.StatusNotFound:
+		// The session is gone; whoever re-establishes it starts a new stream
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w for this session (HTTP 404)", errGetStreamUnsupported)
+	case resp.StatusCode >= 400:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/event-stream" {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w (Content-Type %q)", errGetStreamUnsupported, resp.Header.Get("Content-Type"))
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// initializedNotification is sent on the client's behalf when it skips it
+const initializedNotification = `{"jsonrpc":"2.0","method":"notifications/initialized"}`
+
+// handshakeGate keeps client requests from reaching the upstream before the
+// initialize handshake has finished. Requests read earlier are held and
+// released in arrival order once the client's notifications/initialized
+// has gone upstream; pings, notifications and responses pass straight
+// through. A client that starts sending requests after a successful
+// initialize without notifying has the notification sent for it. It is
+// only used from Run's reading goroutine.
+type handshakeGate struct {
+	proxy    *Proxy
+	dispatch func(line string)
+
+	open        bool
+	initialized atomic.Bool // initialize was answered successfully
+	sentForUs   bool        // The proxy sent notifications/initialized itself
+	held        []string
+}
+
+// newHandshakeGate creates a closed gate forwarding through dispatch
+func newHandshakeGate(p *Proxy, dispatch func(line string)) *handshakeGate {
+	return &handshakeGate{proxy: p, dispatch: dispatch}
+}
+
+// admit dispatches a client message, or holds it until the handshake is done
+func (g *handshakeGate) admit(line string) {
+	var msg messageEnvelope
+	if err := json.Unmarshal([]byte(line), &msg); err != nil {
+		// Invalid messages are reported by handleLine
+		g.dispatch(line)
+		return
+	}
+
+	switch {
+	case msg.Method == "notifications/initialized" && g.sentForUs:
+		g.sentForUs = false
+		if g.proxy.debug {
+			log.Printf("[INIT] Dropped the client's late notifications/initialized, already sent on its behalf")
+		}
+	case g.open || msg.ID == nil || msg.Method == "" || msg.Method == "ping":
+		g.dispatch(line)
+		if msg.Method == "notifications/initialized" {
+			g.release()
+		}
+	case msg.Method == "initialize":
+		// Nothing is read until initialize is answered; a failed handshake
+		// has nothing left to wait for
+		answered := make(chan struct{})
+		g.proxy.onResponse(msg.ID, func(resp *JSONRPCMessage) {
+			g.initialized.Store(resp.Error == nil)
+			close(answered)
+		})
+		g.dispatch(line)
+		select {
+		case <-answered:
+		case <-g.proxy.baseContext().Done():
+		}
+		if !g.initialized.Load() {
+			g.release()
+		}
+	case g.initialized.Load():
+		if g.proxy.debug {
+			log.Printf("[INIT] Client sent %s before notifications/initialized; sending it on the client's behalf", msg.Method)
+		}
+		g.sentForUs = true
+		g.dispatch(initializedNotification)
+		g.release()
+		g.dispatch(line)
+	default:
+		g.hold(line, &msg)
+	}
+}
+
+// hold queues a request read before initialize; once the queue is full,
+// requests are answered with an error instead
+func (g *handshakeGate) hold(line string, msg *messageEnvelope) {
+	if len(g.held) >= maxQueuedMessages {
+		g.proxy.sendErrorResponse(msg.ID, -32603, fmt.Sprintf("Internal error: %d requests already waiting for the initialize handshake", maxQueuedMessages))
+		return
+	}
+	if g.proxy.debug {
+		log.Printf("[INIT] Holding %s %s until the initialize handshake has finished", msg.Method, msg.ID)
+	}
+	g.held = append(g.held, line)
+}
+
+// release opens the gate and forwards the held requests in order
+func (g *handshakeGate) release() {
+	if g.open {
+		return
+	}
+	g.open = true
+	if g.proxy.debug && len(g.held) > 0 {
+		log.Printf("[INIT] Handshake finished, forwarding %d held request(s)", len(g.held))
+	}
+	for _, line := range g.held {
+		g.dispatch(line)
+	}
+	g.held = nil
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// HeaderTemplate renders an upstream request header from the message being forwarded
+type HeaderTemplate struct {
+	name string
+	tmpl *template.Template
+}
+
+// headerTemplateData is what header templates can reference
+type headerTemplateData struct {
+	Method string                 // JSON-RPC method
+	ID     string                 // Request ID as sent by the client, e.g. 42 or "abc"
+	Tool   string                 // params.name for tools/call and prompts/get
+	Params map[string]interface{} // Decoded params, e.g. {{.Params.arguments.tenant}}
+}
+
+// rememberClient takes the client's name and version from its initialize
+// params, for the User-Agent and X-MCP-Client headers
+func (p *Proxy) rememberClient(params json.RawMessage) {
+	var request struct {
+		ClientInfo struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"clientInfo"`
+	}
+	json.Unmarshal(params, &request)
+	client := headerSafe(request.ClientInfo.Name)
+	if client == "" {
+		return
+	}
+	if ver := headerSafe(request.ClientInfo.Version); ver != "" {
+		client += "/" + ver
+	}
+
+	p.sessionMu.Lock()
+	p.clientInfo = client
+	p.sessionMu.Unlock()
+}
+
+// setClientHeaders identifies the proxy, and the client once it has sent
+// initialize, so backend logs can attribute traffic. A static --header of
+// the same name takes precedence.
+func (p *Proxy) setClientHeaders(req *http.Request) {
+	userAgent := "mcp-stdio-proxy/" + version
+	p.sessionMu.Lock()
+	client := p.clientInfo
+	p.sessionMu.Unlock()
+	if client != "" {
+		req.Header.Set("X-MCP-Client", client)
+		// User-Agent product tokens can't contain spaces
+		userAgent = strings.ReplaceAll(client, " ", "-") + " " + userAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+}
+
+// headerSafe drops control characters that can't appear in a header value
+func headerSafe(value string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, value))
+}
+
+// parseHeaders parses static "Name: value" header specs
+func parseHeaders(specs []string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, spec := range specs {
+		name, value, ok := strings.Cut(spec, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header %q (expected \"Name: value\")", spec)
+		}
+		headers[name] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// parseHeaderTemplates parses "Name: template" specs
+func parseHeaderTemplates(specs []string) ([]HeaderTemplate, error) {
+	var templates []HeaderTemplate
+	for _, spec := range specs {
+		name, text, ok := strings.Cut(spec, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header template %q (expected \"Name: template\")", spec)
+		}
+
+		tmpl, err := template.New(name).Option("missingkey=zero").Parse(strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("invalid header template %q: %w", name, err)
+		}
+		templates = append(templates, HeaderTemplate{name: name, tmpl: tmpl})
+	}
+	return templates, nil
+}
+
+// requestHeaders returns the extra headers for forwarding msg: the correlation
+// header and any header templates. Templates that render empty are skipped.
+func (p *Proxy) requestHeaders(msg *JSONRPCMessage, correlationID string) http.Header {
+	headers := make(http.Header)
+	if p.correlationHeader != "" && correlationID != "" {
+		headers.Set(p.correlationHeader, correlationID)
+	}
+	if len(p.headerTemplates) == 0 {
+		return headers
+	}
+
+	data := headerTemplateData{
+		Method: msg.Method,
+		ID:     strings.Trim(string(msg.ID), `"`),
+		Tool:   paramsName(msg.Params),
+	}
+	if len(msg.Params) > 0 {
+		json.Unmarshal(msg.Params, &data.Params)
+	}
+
+	for _, h := range p.headerTemplates {
+		var b strings.Builder
+		if err := h.tmpl.Execute(&b, data); err != nil {
+			// Usually a params path this message doesn't have; skip the header
+			if p.debug {
+				log.Printf("[HTTP] Header template %s skipped: %v", h.name, err)
+			}
+			continue
+		}
+		// Missing map keys render as "<no value>"
+		value := strings.TrimSpace(strings.ReplaceAll(b.String(), "<no value>", ""))
+		if value == "" || strings.ContainsAny(value, "\r\n") {
+			continue
+		}
+		headers.Set(h.name, value)
+	}
+
+	return headers
+}
@@ -0,0 +1,516 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// HealthState is the HealthChecker's view of the upstream
+type HealthState int
+
+const (
+	StateHealthy    HealthState = iota // Probes succeed
+	StateUnhealthy                     // Last probe failed
+	StateRecovering                    // Restart requested, waiting for the upstream to come back
+	StateFailed                        // Recovery attempts exhausted; checking has stopped
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case StateHealthy:
+		return "healthy"
+	case StateUnhealthy:
+		return "unhealthy"
+	case StateRecovering:
+		return "recovering"
+	case StateFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// Health probe strategies
+const (
+	ProbeAuto         = "auto"          // REST endpoint, falling back to MCP ping if it doesn't exist
+	ProbeHTTPEndpoint = "http-endpoint" // GET the health endpoint (/api/health on mcp-hub)
+	ProbeMCPPing      = "mcp-ping"      // JSON-RPC ping over the existing session
+	ProbeTCPConnect   = "tcp-connect"   // Open a TCP connection to the upstream's host and port
+)
+
+// probeStrategies maps each explicit strategy to its probe; auto picks
+// between http-endpoint and mcp-ping at run time
+var probeStrategies = map[string]func(h *HealthChecker, ctx context.Context) error{
+	ProbeHTTPEndpoint: (*HealthChecker).probeHTTP,
+	ProbeMCPPing:      (*HealthChecker).probePing,
+	ProbeTCPConnect:   (*HealthChecker).probeTCP,
+}
+
+// validProbeStrategy reports whether name can be passed as --health-probe
+func validProbeStrategy(name string) bool {
+	_, ok := probeStrategies[name]
+	return ok || name == ProbeAuto
+}
+
+// Default REST endpoint paths, as served by mcp-hub
+const (
+	DefaultHealthPath  = "/api/health"
+	DefaultRestartPath = "/api/restart"
+)
+
+// errNoHealthEndpoint means the upstream doesn't serve a REST health endpoint
+var errNoHealthEndpoint = errors.New("no REST health endpoint")
+
+// HealthChecker periodically probes the upstream and tries to recover it when
+// the probe fails. For mcp-hub it uses the REST health and restart endpoints;
+// for generic MCP servers it pings over the existing session or just checks
+// that the server accepts connections.
+type HealthChecker struct {
+	name         string // Upstream name in aggregator mode
+	baseURL      string // Scheme and host of the upstream, e.g. http://localhost:37373
+	healthPath   string // Appended to baseURL for the http-endpoint probe
+	restartPath  string // Appended to baseURL for the REST restart
+	healthURL    string // Full health endpoint URL, overriding baseURL+healthPath
+	client       *http.Client
+	strategy     string
+	interval     time.Duration
+	timeout      time.Duration
+	recoveryWait time.Duration
+	maxRestarts  int
+	jitter       float64 // Fraction of interval each probe is randomly shifted by
+	debug        bool
+
+	// failureThreshold consecutive failed probes make the upstream unhealthy;
+	// successThreshold consecutive successes make it healthy again
+	failureThreshold int
+	successThreshold int
+
+	// recoveryCmd is a shell command run to recover the upstream, either
+	// instead of or after the REST restart call
+	recoveryCmd      string
+	recoveryCmdAfter bool
+
+	// pinger sends an MCP ping over the proxy's session (mcp-ping strategy)
+	pinger func(ctx context.Context, timeout time.Duration) error
+
+	// clientHeaders, if set, identifies the proxy and its client on probe
+	// and restart requests, as on the upstream's own requests
+	clientHeaders func(req *http.Request)
+
+	// onFailed hooks are called once when the checker gives up
+	onFailed []func(err error)
+
+	// onChange hooks are called after every state transition
+	onChange []func(state HealthState, err error)
+
+	mu        sync.Mutex
+	state     HealthState
+	lastErr   error
+	restarts  int
+	failures  int           // Consecutive failed probes
+	successes int           // Consecutive successful probes
+	latency   time.Duration // Duration of the last probe
+	checked   time.Time     // When the last probe finished
+}
+
+// NewHealthChecker creates a checker for the server hosting the given MCP endpoint URL
+func NewHealthChecker(endpoint string, debug bool) (*HealthChecker, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream URL: %w", err)
+	}
+
+	return &HealthChecker{
+		baseURL:      u.Scheme + "://" + u.Host,
+		healthPath:   DefaultHealthPath,
+		restartPath:  DefaultRestartPath,
+		client:       &http.Client{Timeout: 5 * time.Second},
+		strategy:     ProbeAuto,
+		interval:     30 * time.Second,
+		timeout:      5 * time.Second,
+		recoveryWait: 10 * time.Second,
+		maxRestarts:  3,
+		debug:        debug,
+
+		failureThreshold: 1,
+		successThreshold: 1,
+	}, nil
+}
+
+// setEndpoint points the checker at the server hosting a new MCP endpoint URL
+func (h *HealthChecker) setEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid upstream URL: %w", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.baseURL = u.Scheme + "://" + u.Host
+	return nil
+}
+
+// base returns the scheme and host the checker probes
+func (h *HealthChecker) base() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.baseURL
+}
+
+// healthEndpoint returns the URL probed by the http-endpoint strategy
+func (h *HealthChecker) healthEndpoint() string {
+	if h.healthURL != "" {
+		return h.healthURL
+	}
+	return h.base() + h.healthPath
+}
+
+// State returns the current state and the last probe error
+func (h *HealthChecker) State() (HealthState, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state, h.lastErr
+}
+
+// probeTiming returns how long the last probe took and when it finished
+func (h *HealthChecker) probeTiming() (time.Duration, time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.latency, h.checked
+}
+
+// setState records a state transition
+func (h *HealthChecker) setState(state HealthState, err error) {
+	h.mu.Lock()
+	previous := h.state
+	h.state = state
+	h.lastErr = err
+	h.mu.Unlock()
+
+	if previous != state {
+		if err != nil {
+			log.Printf("[HEALTH] %s%s -> %s: %v", h.label(), previous, state, err)
+		} else {
+			log.Printf("[HEALTH] %s%s -> %s", h.label(), previous, state)
+		}
+		for _, hook := range h.onChange {
+			hook(state, err)
+		}
+	}
+}
+
+// label prefixes log lines with the upstream name in aggregator mode
+func (h *HealthChecker) label() string {
+	if h.name == "" {
+		return ""
+	}
+	return h.name + ": "
+}
+
+// Start runs the check loop in the background until the checker fails or
+// ctx is done; cancelling ctx also aborts a probe or recovery in progress
+func (h *HealthChecker) Start(ctx context.Context) {
+	if h.debug {
+		log.Printf("[HEALTH] Checking %s every %v (probe: %s)", h.healthEndpoint(), h.interval, h.strategy)
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(h.nextInterval()):
+			}
+			if !h.safeRunCheck(ctx) {
+				return
+			}
+		}
+	}()
+}
+
+// nextInterval returns the probe interval shifted by up to ±jitter of
+// itself, so proxies started together don't probe in lockstep
+func (h *HealthChecker) nextInterval() time.Duration {
+	if h.jitter <= 0 {
+		return h.interval
+	}
+	spread := float64(h.interval) * h.jitter
+	return h.interval + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+// safeRunCheck runs one check, surviving a panic so checking continues
+func (h *HealthChecker) safeRunCheck(ctx context.Context) (ok bool) {
+	// A panic skips the return below and leaves ok set
+	ok = true
+	defer recoverPanic("health check")
+	return h.runCheck(ctx)
+}
+
+// runCheck probes once and attempts recovery once failureThreshold probes
+// in a row have failed. It returns false once the checker has reached
+// StateFailed or ctx is done.
+func (h *HealthChecker) runCheck(ctx context.Context) bool {
+	err := h.probe(ctx)
+	if ctx.Err() != nil {
+		return false
+	}
+	failures, successes := h.countProbe(err)
+	if err == nil {
+		h.probeSucceeded(successes)
+		return true
+	}
+	if failures < h.failureThreshold {
+		if h.debug {
+			log.Printf("[HEALTH] %sProbe failed (%d/%d before unhealthy): %v", h.label(), failures, h.failureThreshold, err)
+		}
+		return true
+	}
+
+	h.setState(StateUnhealthy, err)
+
+	for {
+		h.mu.Lock()
+		if h.restarts >= h.maxRestarts {
+			h.mu.Unlock()
+			failure := fmt.Errorf("upstream still unhealthy after %d restart attempt(s): %w", h.maxRestarts, err)
+			h.setState(StateFailed, failure)
+			for _, hook := range h.onFailed {
+				hook(failure)
+			}
+			return false
+		}
+		h.restarts++
+		attempt := h.restarts
+		h.mu.Unlock()
+
+		h.setState(StateRecovering, err)
+		h.recover(ctx, attempt)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(h.recoveryWait):
+		}
+
+		err = h.probe(ctx)
+		if ctx.Err() != nil {
+			return false
+		}
+		_, successes := h.countProbe(err)
+		if err == nil {
+			h.probeSucceeded(successes)
+			return true
+		}
+	}
+}
+
+// countProbe records a probe outcome and returns the consecutive failure and success counts
+func (h *HealthChecker) countProbe(err error) (failures, successes int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		h.failures = 0
+		h.successes++
+	} else {
+		h.successes = 0
+		h.failures++
+	}
+	return h.failures, h.successes
+}
+
+// probeSucceeded marks the upstream healthy, unless it is coming back from
+// a failure and hasn't yet passed successThreshold probes in a row
+func (h *HealthChecker) probeSucceeded(successes int) {
+	state, _ := h.State()
+	if state != StateHealthy && successes < h.successThreshold {
+		if h.debug {
+			log.Printf("[HEALTH] %sProbe succeeded (%d/%d before healthy)", h.label(), successes, h.successThreshold)
+		}
+		return
+	}
+
+	h.mu.Lock()
+	h.restarts = 0
+	h.mu.Unlock()
+	h.setState(StateHealthy, nil)
+}
+
+// recover runs the configured recovery actions for one attempt
+func (h *HealthChecker) recover(ctx context.Context, attempt int) {
+	// Generic servers have no restart API; a command (if any) is all we can do
+	useAPI := h.probeStrategy() == ProbeHTTPEndpoint && h.restartPath != "" && (h.recoveryCmd == "" || h.recoveryCmdAfter)
+
+	if useAPI {
+		if err := h.restart(ctx); err != nil {
+			log.Printf("[HEALTH] Restart attempt %d failed: %v", attempt, err)
+		} else if h.debug {
+			log.Printf("[HEALTH] Restart attempt %d requested", attempt)
+		}
+	}
+
+	if h.recoveryCmd != "" {
+		if err := h.runRecoveryCommand(ctx, attempt); err != nil {
+			log.Printf("[HEALTH] Recovery command attempt %d failed: %v", attempt, err)
+		} else if h.debug {
+			log.Printf("[HEALTH] Recovery command attempt %d completed", attempt)
+		}
+	}
+
+	if h.debug {
+		log.Printf("[HEALTH] Waiting %v for the upstream to recover", h.recoveryWait)
+	}
+}
+
+// runRecoveryCommand executes the recovery command
+func (h *HealthChecker) runRecoveryCommand(ctx context.Context, attempt int) error {
+	ctx, cancel := context.WithTimeout(ctx, recoveryCommandTimeout)
+	defer cancel()
+
+	return runHookCommand(ctx, h.recoveryCmd, []string{
+		"MCP_PROXY_UPSTREAM=" + h.base(),
+		fmt.Sprintf("MCP_PROXY_RECOVERY_ATTEMPT=%d", attempt),
+	})
+}
+
+// recoveryCommandTimeout bounds how long a recovery command may run
+const recoveryCommandTimeout = 2 * time.Minute
+
+// probeStrategy returns the strategy in effect, resolved once auto has decided
+func (h *HealthChecker) probeStrategy() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.strategy
+}
+
+// probe checks the upstream using the configured strategy
+func (h *HealthChecker) probe(ctx context.Context) error {
+	start := time.Now()
+	defer func() {
+		h.mu.Lock()
+		h.latency = time.Since(start)
+		h.checked = time.Now()
+		h.mu.Unlock()
+	}()
+
+	if probe, ok := probeStrategies[h.probeStrategy()]; ok {
+		return probe(h, ctx)
+	}
+
+	// Auto: use the REST endpoint if the server has one, otherwise switch to ping for good
+	err := h.probeHTTP(ctx)
+	if errors.Is(err, errNoHealthEndpoint) && h.pinger != nil {
+		if h.debug {
+			log.Printf("[HEALTH] %s is not served, probing with MCP ping", h.healthEndpoint())
+		}
+		h.mu.Lock()
+		h.strategy = ProbeMCPPing
+		h.mu.Unlock()
+		return h.probePing(ctx)
+	}
+	if err == nil {
+		h.mu.Lock()
+		h.strategy = ProbeHTTPEndpoint
+		h.mu.Unlock()
+	}
+	return err
+}
+
+// probePing sends an MCP ping over the proxy's session
+func (h *HealthChecker) probePing(ctx context.Context) error {
+	err := h.pinger(ctx, h.timeout)
+	if errors.Is(err, errNoSession) {
+		// Nothing to ping until the client initializes
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ping probe failed: %w", err)
+	}
+	return nil
+}
+
+// probeTCP checks that the upstream accepts connections, for servers with
+// neither a health endpoint nor a session to ping
+func (h *HealthChecker) probeTCP(ctx context.Context) error {
+	u, err := url.Parse(h.base())
+	if err != nil {
+		return fmt.Errorf("invalid upstream URL: %w", err)
+	}
+
+	network, addr := "tcp", u.Host
+	unixSockets.Lock()
+	socket, isUnix := unixSockets.hosts[u.Hostname()]
+	unixSockets.Unlock()
+	if isUnix {
+		network, addr = "unix", socket
+	} else if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	dialer := net.Dialer{Timeout: h.timeout}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return fmt.Errorf("connect probe failed: %w", err)
+	}
+	conn.Close()
+	return nil
+}
+
+// probeHTTP checks the REST health endpoint
+func (h *HealthChecker) probeHTTP(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", h.healthEndpoint(), nil)
+	if err != nil {
+		return fmt.Errorf("health probe failed: %w", err)
+	}
+	if h.clientHeaders != nil {
+		h.clientHeaders(req)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("health probe failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return errNoHealthEndpoint
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health probe returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// restart asks mcp-hub to restart via its REST API
+func (h *HealthChecker) restart(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", h.base()+h.restartPath, nil)
+	if err != nil {
+		return fmt.Errorf("restart request failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.clientHeaders != nil {
+		h.clientHeaders(req)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("restart request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("restart returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
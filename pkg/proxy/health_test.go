@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckSurvivesPanic(t *testing.T) {
+	h, err := NewHealthChecker("http://127.0.0.1:1/mcp", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.strategy = ProbeMCPPing
+	h.interval = 10 * time.Millisecond
+
+	var probes atomic.Int32
+	h.pinger = func(ctx context.Context, timeout time.Duration) error {
+		if probes.Add(1) == 1 {
+			panic("probe bug")
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.Start(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for probes.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("checking stopped after %d probe(s)", probes.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHealthRequestsIdentifyClient(t *testing.T) {
+	agents := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents <- r.Header.Get("X-MCP-Client") + " " + r.Header.Get("User-Agent")
+	}))
+	defer server.Close()
+
+	p, err := New(Config{URL: server.URL + "/mcp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.rememberClient([]byte(`{"clientInfo":{"name":"Test Client","version":"1.2"}}`))
+	h, err := NewHealthChecker(server.URL+"/mcp", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.clientHeaders = p.setClientHeaders
+
+	if err := h.probeHTTP(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.restart(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := "Test Client/1.2 Test-Client/1.2 mcp-stdio-proxy/" + version
+	for _, request := range []string{"probe", "restart"} {
+		if got := <-agents; got != want {
+			t.Errorf("%s identified as %q, want %q", request, got, want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+)
+
+// HealthHooks runs operator commands on health state transitions, e.g. to
+// post to chat or trigger custom remediation
+type HealthHooks struct {
+	onUnhealthy string // Shell command run when the upstream turns unhealthy
+	onRecovered string // Shell command run when it is healthy again
+	onFailed    string // Shell command run when recovery is given up
+	name        string // Upstream name in aggregator mode
+	url         string // Upstream URL, included as context
+	debug       bool
+}
+
+// transition runs the command for a new state, if one is configured. The
+// command runs in the background so it can't delay the next probe.
+func (h *HealthHooks) transition(state HealthState, err error) {
+	var command string
+	switch state {
+	case StateUnhealthy:
+		command = h.onUnhealthy
+	case StateHealthy:
+		command = h.onRecovered
+	case StateFailed:
+		command = h.onFailed
+	}
+	if command == "" {
+		return
+	}
+
+	errText := ""
+	if err != nil {
+		errText = err.Error()
+	}
+
+	go func() {
+		defer recoverPanic("health hook")
+		if err := h.run(command, state, errText); err != nil {
+			log.Printf("[HEALTH] Hook for state %s failed: %v", state, err)
+		} else if h.debug {
+			log.Printf("[HEALTH] Ran hook for state %s", state)
+		}
+	}()
+}
+
+// runHookCommand runs an operator command line through the shell with env
+// added to the proxy's environment. Its output goes to stderr, clear of the
+// JSON-RPC stream; once ctx is done it is stopped along with everything it
+// started.
+func runHookCommand(ctx context.Context, line string, env []string) error {
+	cmd := shellCommand(line)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	var err error
+	go func() {
+		err = cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+		return err
+	case <-ctx.Done():
+		stopProcessTree(cmd, exited, spawnStopGrace)
+		return fmt.Errorf("stopped: %w", ctx.Err())
+	}
+}
+
+// run executes a hook command with the transition in its environment
+func (h *HealthHooks) run(command string, state HealthState, errText string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), recoveryCommandTimeout)
+	defer cancel()
+
+	return runHookCommand(ctx, command, []string{
+		"MCP_PROXY_HEALTH_STATE=" + state.String(),
+		"MCP_PROXY_UPSTREAM=" + h.url,
+		"MCP_PROXY_UPSTREAM_NAME=" + h.name,
+		"MCP_PROXY_ERROR=" + errText,
+		fmt.Sprintf("MCP_PROXY_PID=%d", os.Getpid()),
+	})
+}
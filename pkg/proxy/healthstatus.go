@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// healthURI is the resource exposing combined upstream health in aggregator mode
+const healthURI = "proxy://health"
+
+// upstreamHealth is one upstream's entry in the combined health status
+type upstreamHealth struct {
+	Name      string     `json:"name"`
+	URL       string     `json:"url"`
+	State     string     `json:"state"`
+	LastError string     `json:"lastError,omitempty"`
+	LatencyMs float64    `json:"latencyMs"`
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+}
+
+// healthStatuses returns the state of every health-checked upstream
+func (p *Proxy) healthStatuses() []upstreamHealth {
+	var statuses []upstreamHealth
+	add := func(name, url string, h *HealthChecker) {
+		if h == nil {
+			return
+		}
+		state, err := h.State()
+		latency, checked := h.probeTiming()
+		status := upstreamHealth{
+			Name:      name,
+			URL:       url,
+			State:     state.String(),
+			LatencyMs: float64(latency.Microseconds()) / 1000,
+		}
+		if !checked.IsZero() {
+			status.CheckedAt = &checked
+		}
+		if err != nil {
+			status.LastError = err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+
+	if p.aggregator != nil {
+		for _, u := range p.aggregator.upstreams {
+			add(u.Name, u.proxy.target(), u.health)
+		}
+	} else {
+		add("upstream", p.target(), p.health)
+	}
+	return statuses
+}
+
+// overallHealth summarizes upstream states: healthy when all are, unhealthy
+// when none are, degraded otherwise
+func overallHealth(statuses []upstreamHealth) string {
+	healthy := 0
+	for _, status := range statuses {
+		if status.State == StateHealthy.String() {
+			healthy++
+		}
+	}
+	switch {
+	case healthy == len(statuses):
+		return "healthy"
+	case healthy == 0:
+		return "unhealthy"
+	}
+	return "degraded"
+}
+
+// renderHealth returns the proxy://health resource contents
+func (p *Proxy) renderHealth() ([]byte, error) {
+	statuses := p.healthStatuses()
+	return json.MarshalIndent(map[string]interface{}{
+		"status":    overallHealth(statuses),
+		"upstreams": statuses,
+	}, "", "  ")
+}
+
+// healthNotification tells the client about an upstream health transition,
+// so tools failing while the upstream is down don't go unexplained
+func (p *Proxy) healthNotification(name string, state HealthState, err error) {
+	// Nothing may be sent before the client initializes
+	p.sessionMu.Lock()
+	initialized := p.initParams != nil
+	p.sessionMu.Unlock()
+	if !initialized {
+		return
+	}
+
+	upstream := "The upstream"
+	if name != "" {
+		upstream = fmt.Sprintf("Upstream %s", name)
+	}
+
+	level, message := "warning", ""
+	switch state {
+	case StateHealthy:
+		level, message = "info", upstream+" is healthy again"
+	case StateUnhealthy:
+		message = fmt.Sprintf("%s is unhealthy: %v", upstream, err)
+	case StateRecovering:
+		message = fmt.Sprintf("%s is being restarted after failing health checks: %v", upstream, err)
+	case StateFailed:
+		level, message = "error", fmt.Sprintf("%s could not be recovered; requests will fail until it is restarted: %v", upstream, err)
+	default:
+		return
+	}
+	p.sendLogMessage(level, message)
+}
+
+func init() {
+	registerControlCommand("health", "health", "Show the health of each upstream", func(p *Proxy, args string) (string, error) {
+		statuses := p.healthStatuses()
+		if len(statuses) == 0 {
+			return "", fmt.Errorf("health checking is not enabled")
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "Overall: %s", overallHealth(statuses))
+		for _, status := range statuses {
+			checked := "never"
+			if status.CheckedAt != nil {
+				checked = time.Since(*status.CheckedAt).Round(time.Second).String() + " ago"
+			}
+			fmt.Fprintf(&b, "\n%-16s %-10s %8.1fms  checked %s", status.Name, status.State, status.LatencyMs, checked)
+			if status.LastError != "" {
+				fmt.Fprintf(&b, "  error: %s", status.LastError)
+			}
+		}
+		return b.String(), nil
+	})
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+)
+
+// rediscoverMcpHub looks for mcp-hub again after every attempt to reach it at
+// failedURL was refused, e.g. because it restarted on another port. If the
+// hub is now listening elsewhere the proxy switches to it, replays the
+// client's initialize handshake when reinit is set, and tells the client.
+// It reports whether the upstream moved.
+func (p *Proxy) rediscoverMcpHub(failedURL string, reinit bool) (bool, error) {
+	p.rediscoverMu.Lock()
+	defer p.rediscoverMu.Unlock()
+
+	// A concurrent request already followed the hub
+	if p.target() != failedURL {
+		return true, nil
+	}
+
+	log.Printf("[DISCOVERY] %s refuses connections; looking for mcp-hub again", failedURL)
+	instance, err := discoverMcpHubInstance(p.debug)
+	if err != nil {
+		return false, err
+	}
+	url := fmt.Sprintf("http://localhost:%s/mcp", instance.Port)
+	if url == failedURL {
+		if p.debug {
+			log.Printf("[DISCOVERY] mcp-hub is still registered at %s", url)
+		}
+		return false, nil
+	}
+
+	log.Printf("[DISCOVERY] mcp-hub moved to %s (config %s)", url, instance.ConfigPath)
+	p.sessionMu.Lock()
+	p.url = url
+	p.sessionID = ""
+	p.protocolVersion = ""
+	p.sessionMu.Unlock()
+	setProcessTitle("mcp-proxy:" + instance.Port)
+	if p.health != nil {
+		if err := p.health.setEndpoint(url); err != nil {
+			log.Printf("[DISCOVERY] Failed to retarget health checks: %v", err)
+		}
+	}
+
+	// The old session died with the old hub
+	p.stopGetStream()
+	p.sessionMu.Lock()
+	initialized := p.initParams != nil
+	p.sessionMu.Unlock()
+	if !initialized {
+		return true, nil
+	}
+	message := fmt.Sprintf("mcp-hub moved to %s; reconnected", url)
+	if reinit {
+		if err := p.reinitialize(); err != nil {
+			log.Printf("[DISCOVERY] Failed to re-initialize the session: %v", err)
+			message = fmt.Sprintf("mcp-hub moved to %s; re-initializing the session failed: %v", url, err)
+		} else {
+			message = fmt.Sprintf("mcp-hub moved to %s; the session was re-established", url)
+		}
+	}
+	p.sendLogMessage("warning", message)
+
+	return true, nil
+}
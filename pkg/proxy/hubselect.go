@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// hubSelector narrows down discovered mcp-hub instances
+type hubSelector struct {
+	pid    int
+	port   int
+	config string
+	prompt bool // Ask on the terminal when several instances remain
+}
+
+// mcpHubSelection is set from the --mcp-hub-* selection flags
+var mcpHubSelection hubSelector
+
+// active reports whether any selector is set
+func (s *hubSelector) active() bool {
+	return s.pid != 0 || s.port != 0 || s.config != ""
+}
+
+// matches reports whether an instance satisfies every selector
+func (s *hubSelector) matches(inst *McpHubInstance) bool {
+	if s.pid != 0 && inst.PID != strconv.Itoa(s.pid) {
+		return false
+	}
+	if s.port != 0 && inst.Port != strconv.Itoa(s.port) {
+		return false
+	}
+	if s.config != "" {
+		want := expandConfigPath(s.config)
+		for _, config := range inst.ConfigFiles {
+			if expandConfigPath(config) == want {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// filter returns the instances matching the selectors, or an error listing
+// the candidates if none do
+func (s *hubSelector) filter(instances []McpHubInstance) ([]McpHubInstance, error) {
+	if !s.active() {
+		return instances, nil
+	}
+
+	var selected []McpHubInstance
+	for _, inst := range instances {
+		if s.matches(&inst) {
+			selected = append(selected, inst)
+		}
+	}
+	if len(selected) == 0 {
+		var b strings.Builder
+		for _, inst := range instances {
+			fmt.Fprintf(&b, "\n  pid %s, port %s, config %s", inst.PID, inst.Port, strings.Join(inst.ConfigFiles, ", "))
+		}
+		return nil, fmt.Errorf("no running mcp-hub matches the --mcp-hub-pid/--mcp-hub-port/--mcp-hub-config selection; found:%s", b.String())
+	}
+	return selected, nil
+}
+
+// expandConfigPath makes a config path absolute for comparison, expanding ~
+func expandConfigPath(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = home + rest
+		}
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// promptForMcpHubInstance lists ranked instances on the terminal and reads
+// the user's choice. The terminal is opened directly because stdin and
+// stdout carry the MCP stream; without one the best-ranked instance is used.
+func promptForMcpHubInstance(scored []scoredInstance) (*McpHubInstance, error) {
+	in, out, err := openTerminal()
+	if err != nil {
+		return scored[0].instance, nil
+	}
+	defer in.Close()
+	if out != in {
+		defer out.Close()
+	}
+
+	fmt.Fprintf(out, "Several mcp-hub instances are running:\n")
+	for i, s := range scored {
+		fmt.Fprintf(out, "  %d) port %s, pid %s, score %d - %s\n", i+1, s.instance.Port, s.instance.PID, s.score, s.reason)
+	}
+
+	reader := bufio.NewReader(in)
+	for {
+		fmt.Fprintf(out, "Use which instance? [1] ")
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if err != nil {
+				return nil, fmt.Errorf("no mcp-hub instance chosen")
+			}
+			return scored[0].instance, nil
+		}
+		if n, convErr := strconv.Atoi(line); convErr == nil && n >= 1 && n <= len(scored) {
+			return scored[n-1].instance, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid mcp-hub instance choice %q", line)
+		}
+		fmt.Fprintf(out, "Enter a number from 1 to %d\n", len(scored))
+	}
+}
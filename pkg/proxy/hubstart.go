@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// hubReadyTimeout bounds how long an auto-started mcp-hub may take to become healthy
+const hubReadyTimeout = 60 * time.Second
+
+// hubAutostart launches mcp-hub when discovery finds no running instance
+type hubAutostart struct {
+	config string
+	port   int
+}
+
+// mcpHubAutostart is set by --mcp-hub-autostart; nil disables auto-starting
+var mcpHubAutostart *hubAutostart
+
+// defaultHubConfig is mcp-hub's conventional config location
+func defaultHubConfig() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "mcphub", "servers.json")
+}
+
+// discoverOrStartMcpHub discovers a running mcp-hub, starting one first if
+// none is found and auto-starting is enabled
+func discoverOrStartMcpHub(debug bool) (*McpHubInstance, error) {
+	instance, err := discoverMcpHubInstance(debug)
+	if err == nil || mcpHubAutostart == nil {
+		return instance, err
+	}
+	log.Printf("[DISCOVERY] No running mcp-hub found (%v); starting one on port %d", err, mcpHubAutostart.port)
+	return mcpHubAutostart.start(debug)
+}
+
+// start launches mcp-hub detached from the proxy, so it keeps serving other
+// clients after this proxy exits, and waits for /api/health to succeed
+func (a *hubAutostart) start(debug bool) (*McpHubInstance, error) {
+	command, err := exec.LookPath("mcp-hub")
+	if err != nil {
+		return nil, fmt.Errorf("cannot auto-start mcp-hub: %w", err)
+	}
+	if _, err := os.Stat(a.config); err != nil {
+		return nil, fmt.Errorf("cannot auto-start mcp-hub: config %s: %w", a.config, err)
+	}
+
+	port := strconv.Itoa(a.port)
+	logPath := filepath.Join(os.TempDir(), fmt.Sprintf("mcp-hub-%s.log", port))
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot auto-start mcp-hub: %w", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(command, "--port", port, "--config", a.config)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	detachProcess(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start mcp-hub: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	if debug {
+		log.Printf("[DISCOVERY] Started mcp-hub (pid %d), logging to %s", cmd.Process.Pid, logPath)
+	}
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	healthURL := fmt.Sprintf("http://localhost:%s/api/health", port)
+	deadline := time.Now().Add(hubReadyTimeout)
+	for {
+		if resp, err := client.Get(healthURL); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				break
+			}
+		}
+
+		select {
+		case err := <-exited:
+			return nil, fmt.Errorf("mcp-hub exited during start-up (%v); see %s", err, logPath)
+		case <-time.After(500 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("mcp-hub did not become healthy within %v; see %s", hubReadyTimeout, logPath)
+		}
+	}
+
+	log.Printf("[DISCOVERY] mcp-hub is ready on port %s", port)
+	return &McpHubInstance{
+		Port:        port,
+		ConfigFiles: []string{a.config},
+		ConfigPath:  a.config,
+		PID:         strconv.Itoa(cmd.Process.Pid),
+		CommandLine: cmd.String(),
+	}, nil
+}
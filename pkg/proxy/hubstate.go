@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// hubStateEntry is one workspace in mcp-hub's state file
+type hubStateEntry struct {
+	Cwd         string   `json:"cwd"`
+	ConfigFiles []string `json:"config_files"`
+	PID         int      `json:"pid"`
+	Port        int      `json:"port"`
+	State       string   `json:"state"` // "active" or "shutting_down"
+}
+
+// hubStateFile returns the path of the workspace cache mcp-hub keeps under
+// the XDG state directory
+func hubStateFile() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "mcp-hub", "workspaces.json")
+}
+
+// findMcpHubInstancesFromState reads running hubs from mcp-hub's state file.
+// Entries whose process is gone or that are shutting down are skipped, since
+// a crashed hub leaves its entry behind.
+func findMcpHubInstancesFromState(debug bool) ([]McpHubInstance, error) {
+	path := hubStateFile()
+	if path == "" {
+		return nil, fmt.Errorf("cannot locate the mcp-hub state directory")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries map[string]hubStateEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid mcp-hub state file %s: %w", path, err)
+	}
+
+	var instances []McpHubInstance
+	for _, key := range sortedKeys(entries) {
+		entry := entries[key]
+		if entry.Port == 0 || entry.PID == 0 {
+			continue
+		}
+		if entry.State != "" && entry.State != "active" {
+			if debug {
+				log.Printf("[DISCOVERY] Skipping %s hub on port %d (pid %d)", entry.State, entry.Port, entry.PID)
+			}
+			continue
+		}
+		if !processAlive(entry.PID) {
+			if debug {
+				log.Printf("[DISCOVERY] Skipping stale state entry for port %d: pid %d is not running", entry.Port, entry.PID)
+			}
+			continue
+		}
+		instances = append(instances, McpHubInstance{
+			Port:        strconv.Itoa(entry.Port),
+			ConfigFiles: entry.ConfigFiles,
+			PID:         strconv.Itoa(entry.PID),
+			CommandLine: "(from " + path + ")",
+		})
+	}
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no live mcp-hub instances in %s", path)
+	}
+	return instances, nil
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+)
+
+// idTable translates identifiers that several clients chose independently,
+// such as request IDs or progress tokens, into proxy-assigned ones that are
+// unique on the upstream session they share, and back. Forward lookups by
+// the owner's original identifier translate references to it, e.g. the
+// requestId of a cancellation.
+type idTable struct {
+	mu      sync.Mutex
+	next    int64
+	routes  map[string]brokerRoute      // Proxy-assigned ID -> owner and original ID
+	proxied map[idOwner]json.RawMessage // Owner and original ID -> proxy-assigned ID
+}
+
+// idOwner identifies one client's original identifier
+type idOwner struct {
+	client int
+	id     string
+}
+
+// newIDTable creates an empty translation table
+func newIDTable() *idTable {
+	return &idTable{
+		routes:  make(map[string]brokerRoute),
+		proxied: make(map[idOwner]json.RawMessage),
+	}
+}
+
+// assign allocates a proxy ID for a client's original identifier
+func (t *idTable) assign(route brokerRoute) json.RawMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	proxyID := json.RawMessage(strconv.FormatInt(t.next, 10))
+	t.routes[string(proxyID)] = route
+	t.proxied[idOwner{route.client.id, string(route.originalID)}] = proxyID
+	return proxyID
+}
+
+// lookup returns the owner of a proxy ID
+func (t *idTable) lookup(proxyID json.RawMessage) (brokerRoute, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	route, ok := t.routes[string(proxyID)]
+	return route, ok
+}
+
+// translate returns the proxy ID assigned to a client's original identifier
+func (t *idTable) translate(client *brokerClient, originalID json.RawMessage) (json.RawMessage, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	proxyID, ok := t.proxied[idOwner{client.id, string(originalID)}]
+	return proxyID, ok
+}
+
+// release forgets a proxy ID once it is done with, returning its owner
+func (t *idTable) release(proxyID json.RawMessage) (brokerRoute, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	route, ok := t.routes[string(proxyID)]
+	if ok {
+		delete(t.routes, string(proxyID))
+		delete(t.proxied, idOwner{route.client.id, string(route.originalID)})
+	}
+	return route, ok
+}
+
+// owned returns the proxy IDs a client still holds
+func (t *idTable) owned(client *brokerClient) []json.RawMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var ids []json.RawMessage
+	for proxyID, route := range t.routes {
+		if route.client == client {
+			ids = append(ids, json.RawMessage(proxyID))
+		}
+	}
+	return ids
+}
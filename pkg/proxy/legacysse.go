@@ -0,0 +1,485 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upstream transports (--transport)
+const (
+	TransportStreamable = "streamable" // Streamable HTTP: POST to one endpoint, answered inline
+	TransportSSE        = "sse"        // Legacy HTTP+SSE (2024-11-05): GET stream plus POSTs to its endpoint
+	TransportAuto       = "auto"       // Streamable HTTP, falling back to HTTP+SSE when initialize is refused
+)
+
+// legacyEndpointTimeout bounds the wait for a new stream's endpoint event
+const legacyEndpointTimeout = 30 * time.Second
+
+// errLegacyStreamClosed fails requests whose response was due on a stream that dropped
+var errLegacyStreamClosed = errors.New("SSE stream closed before the response arrived")
+
+// legacySSE speaks the deprecated HTTP+SSE transport of protocol version
+// 2024-11-05: a long-lived GET stream announces, in an "endpoint" event,
+// where to POST messages, and carries every response and server message.
+// The session lives as long as the stream, so a stream that drops is
+// reopened and the session re-initialized.
+type legacySSE struct {
+	mode string
+	// active is set once the HTTP+SSE transport is in use; decided once
+	// auto-detection saw the upstream accept Streamable HTTP
+	active  atomic.Bool
+	decided atomic.Bool
+	started sync.Once
+
+	mu      sync.Mutex
+	conn    *legacyConn
+	pending map[string]*legacyWaiter // Requests awaiting their response on the stream, by ID
+	// reopened is set once a stream announced its endpoint, so the next
+	// one needs a new session
+	reopened bool
+}
+
+// legacyConn is one connection of the GET stream
+type legacyConn struct {
+	endpoint  string        // POST URL from the endpoint event
+	connected chan struct{} // Closed once the endpoint is known
+	ready     chan struct{} // Closed once the session can take messages
+	dead      chan struct{} // Closed when the stream drops
+	cancel    context.CancelFunc
+}
+
+// legacyWaiter waits for the response to one request POSTed to the endpoint
+type legacyWaiter struct {
+	capture bool                 // Hand the response to the sender instead of the client
+	done    chan *JSONRPCMessage // Receives the response, or nil if the stream dropped
+}
+
+// newLegacySSE creates the transport state for a --transport mode
+func newLegacySSE(mode string) (*legacySSE, error) {
+	switch mode {
+	case "":
+		mode = TransportAuto
+	case TransportStreamable, TransportSSE, TransportAuto:
+	default:
+		return nil, fmt.Errorf("invalid transport %q (expected %s, %s or %s)", mode, TransportStreamable, TransportSSE, TransportAuto)
+	}
+	l := &legacySSE{mode: mode, pending: make(map[string]*legacyWaiter)}
+	l.active.Store(mode == TransportSSE)
+	return l, nil
+}
+
+// inUse reports whether messages go over the HTTP+SSE transport
+func (l *legacySSE) inUse() bool {
+	return l != nil && l.active.Load()
+}
+
+// streamableWorked records that the upstream accepted a Streamable HTTP
+// request, which settles auto-detection
+func (l *legacySSE) streamableWorked() {
+	if l != nil {
+		l.decided.Store(true)
+	}
+}
+
+// current returns the connection being used or set up, creating one if the
+// last one dropped
+func (l *legacySSE) current() *legacyConn {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		l.conn = &legacyConn{
+			connected: make(chan struct{}),
+			ready:     make(chan struct{}),
+			dead:      make(chan struct{}),
+		}
+	}
+	return l.conn
+}
+
+// disconnect retires a dropped connection and fails the requests still
+// waiting on it
+func (l *legacySSE) disconnect(conn *legacyConn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == conn {
+		l.conn = nil
+	}
+	close(conn.dead)
+	for id, waiter := range l.pending {
+		waiter.done <- nil
+		delete(l.pending, id)
+	}
+}
+
+// drop closes the stream if endpoint is still its endpoint, so it is
+// reopened with a new session
+func (l *legacySSE) drop(endpoint string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn != nil && l.conn.endpoint == endpoint && l.conn.cancel != nil {
+		l.conn.cancel()
+	}
+}
+
+// wait registers a request whose response is due on the stream
+func (l *legacySSE) wait(id json.RawMessage, capture bool) *legacyWaiter {
+	waiter := &legacyWaiter{capture: capture, done: make(chan *JSONRPCMessage, 1)}
+	l.mu.Lock()
+	l.pending[string(id)] = waiter
+	l.mu.Unlock()
+	return waiter
+}
+
+// forget stops waiting for a request's response
+func (l *legacySSE) forget(id json.RawMessage) {
+	l.mu.Lock()
+	delete(l.pending, string(id))
+	l.mu.Unlock()
+}
+
+// waiter removes and returns who waits for the response with id, or nil
+func (l *legacySSE) waiter(id json.RawMessage) *legacyWaiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	waiter := l.pending[string(id)]
+	delete(l.pending, string(id))
+	return waiter
+}
+
+// fallBackToLegacy switches an auto-detecting proxy to HTTP+SSE when the
+// upstream refused a Streamable HTTP POST before ever accepting one, and
+// its URL turns out to serve an HTTP+SSE stream. It reports whether it did.
+func (p *Proxy) fallBackToLegacy(ctx context.Context, err error) bool {
+	l := p.legacy
+	if l == nil || l.mode != TransportAuto || l.decided.Load() || l.active.Load() {
+		return false
+	}
+	var status *httpStatusError
+	if !errors.As(err, &status) || (status.code != http.StatusBadRequest && status.code != http.StatusNotFound && status.code != http.StatusMethodNotAllowed) {
+		return false
+	}
+
+	if p.debug {
+		log.Printf("[SSE] Streamable HTTP POST refused (HTTP %d); trying the HTTP+SSE transport", status.code)
+	}
+	if probeErr := p.probeLegacy(ctx); probeErr != nil {
+		if p.debug {
+			log.Printf("[SSE] No HTTP+SSE stream either: %v", probeErr)
+		}
+		return false
+	}
+	l.decided.Store(true)
+	l.active.Store(true)
+	log.Printf("[SSE] Upstream %s speaks the legacy HTTP+SSE transport", p.target())
+	return true
+}
+
+// probeLegacy checks that the upstream URL serves an HTTP+SSE stream by
+// opening one and waiting for its endpoint event
+func (p *Proxy) probeLegacy(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, legacyEndpointTimeout)
+	defer cancel()
+
+	resp, err := p.openEventStream(ctx, "", "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	found := false
+	err = p.readSSEEvents(resp.Body, nil, func(eventType string, data []byte) {
+		if eventType == "endpoint" {
+			found = true
+			cancel()
+		}
+	})
+	if found {
+		return nil
+	}
+	if err == nil {
+		err = errors.New("stream ended without an endpoint event")
+	}
+	return err
+}
+
+// legacyEndpoint returns the URL to POST messages to, opening the stream if
+// needed. The handshake may go out as soon as the endpoint is known; other
+// messages wait until a reopened stream's session is re-initialized.
+func (p *Proxy) legacyEndpoint(ctx context.Context, handshake bool) (string, error) {
+	l := p.legacy
+	l.started.Do(func() { go p.runLegacyStream() })
+
+	timer := time.NewTimer(legacyEndpointTimeout)
+	defer timer.Stop()
+	for {
+		conn := l.current()
+		wanted := conn.ready
+		if handshake {
+			wanted = conn.connected
+		}
+		select {
+		case <-wanted:
+			return conn.endpoint, nil
+		case <-conn.dead:
+			// Wait for the next connection
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-timer.C:
+			return "", fmt.Errorf("no HTTP+SSE endpoint from %s after %v", p.target(), legacyEndpointTimeout)
+		}
+	}
+}
+
+// runLegacyStream keeps the HTTP+SSE stream open for the proxy's lifetime,
+// reopening it with backoff when it drops
+func (p *Proxy) runLegacyStream() {
+	defer recoverPanic("HTTP+SSE stream")
+
+	ctx := p.baseContext()
+	backoff := getStreamMinBackoff
+	var cursor sseCursor
+	for ctx.Err() == nil {
+		conn := p.legacy.current()
+		opened, err := p.readLegacyStream(ctx, conn, &cursor)
+		p.legacy.disconnect(conn)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if opened {
+			backoff = getStreamMinBackoff
+		}
+		delay := cursor.reconnectDelay(backoff)
+		if err != nil {
+			log.Printf("[SSE] HTTP+SSE stream failed, reconnecting in %v: %v", delay, err)
+		} else {
+			log.Printf("[SSE] HTTP+SSE stream closed by the server, reconnecting in %v", delay)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		backoff = min(backoff*2, getStreamMaxBackoff)
+	}
+}
+
+// readLegacyStream opens one connection of the HTTP+SSE stream and serves
+// it until it ends. It reports whether the stream was established.
+func (p *Proxy) readLegacyStream(ctx context.Context, conn *legacyConn, cursor *sseCursor) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	p.legacy.mu.Lock()
+	conn.cancel = cancel
+	p.legacy.mu.Unlock()
+
+	resp, err := p.openEventStream(ctx, "", "")
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	err = p.readSSEEvents(resp.Body, cursor, func(eventType string, data []byte) {
+		switch eventType {
+		case "endpoint":
+			p.legacyConnected(conn, string(data))
+		case "", "message":
+			p.legacyMessage(data)
+		default:
+			if p.debug {
+				log.Printf("[SSE] Ignoring %q event: %s", eventType, data)
+			}
+		}
+	})
+	if ctx.Err() != nil {
+		return true, nil
+	}
+	return true, err
+}
+
+// legacyConnected takes the endpoint a new stream announced. A stream
+// reopened after the client initialized gets a new session first.
+func (p *Proxy) legacyConnected(conn *legacyConn, endpoint string) {
+	base, err := url.Parse(p.target())
+	if err != nil {
+		log.Printf("[ERROR] Invalid upstream URL: %v", err)
+		return
+	}
+	ref, err := url.Parse(strings.TrimSpace(endpoint))
+	if err != nil {
+		log.Printf("[ERROR] Invalid HTTP+SSE endpoint %q: %v", endpoint, err)
+		return
+	}
+
+	p.legacy.mu.Lock()
+	if conn.endpoint != "" {
+		p.legacy.mu.Unlock()
+		return
+	}
+	conn.endpoint = base.ResolveReference(ref).String()
+	reopened := p.legacy.reopened
+	p.legacy.reopened = true
+	p.legacy.mu.Unlock()
+	close(conn.connected)
+	if p.debug {
+		log.Printf("[SSE] HTTP+SSE endpoint: %s", conn.endpoint)
+	}
+
+	p.sessionMu.Lock()
+	initialized := p.initParams != nil
+	p.sessionMu.Unlock()
+	if !reopened || !initialized {
+		close(conn.ready)
+		return
+	}
+
+	// Re-initializing waits for responses this stream delivers
+	go func() {
+		defer close(conn.ready)
+		log.Printf("[SSE] HTTP+SSE stream reopened; re-initializing the session")
+		p.reinitMu.Lock()
+		err := p.reinitialize()
+		p.reinitMu.Unlock()
+		if err != nil {
+			log.Printf("[SSE] Re-initializing failed: %v", err)
+			p.sendLogMessage("warning", fmt.Sprintf("Upstream HTTP+SSE stream reopened, but re-initializing the session failed: %v", err))
+		}
+	}()
+}
+
+// legacyMessage delivers one message from the stream: responses the proxy
+// asked for itself go to their sender, everything else to the client
+func (p *Proxy) legacyMessage(data []byte) {
+	var envelope messageEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		log.Printf("[ERROR] HTTP+SSE stream: invalid JSON in SSE data: %v", err)
+		return
+	}
+	var waiter *legacyWaiter
+	if envelope.ID != nil && envelope.Method == "" {
+		waiter = p.legacy.waiter(envelope.ID)
+	}
+
+	if waiter != nil && waiter.capture {
+		var msg JSONRPCMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			waiter.done <- nil
+			return
+		}
+		waiter.done <- &msg
+		return
+	}
+
+	msg, err := p.writeSSEData(p.stdout, data)
+	if err != nil {
+		log.Printf("[ERROR] HTTP+SSE stream: %v", err)
+	}
+	if waiter != nil {
+		waiter.done <- msg
+	}
+}
+
+// legacyPost POSTs a message to the HTTP+SSE endpoint and, for a request,
+// waits for its response on the stream. A captured response is returned;
+// otherwise it has been written to the client.
+func (p *Proxy) legacyPost(ctx context.Context, body string, id json.RawMessage, headers http.Header, capture bool) (*JSONRPCMessage, error) {
+	var envelope messageEnvelope
+	json.Unmarshal([]byte(body), &envelope)
+	handshake := envelope.Method == "initialize" || envelope.Method == "notifications/initialized"
+
+	endpoint, err := p.legacyEndpoint(ctx, handshake)
+	if err != nil {
+		return nil, err
+	}
+	target, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP+SSE endpoint: %w", err)
+	}
+	req, err := p.newUpstreamRequest("POST", body, "")
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.URL, req.Host = target, target.Host
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+
+	var waiter *legacyWaiter
+	if id != nil {
+		waiter = p.legacy.wait(id, capture)
+		defer p.legacy.forget(id)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	p.noteProtocol(resp.Proto)
+	if id != nil && !capture {
+		if stats := p.trackedRequest(id); stats != nil {
+			stats.status = resp.StatusCode
+		}
+	}
+	data, readErr := io.ReadAll(io.LimitReader(resp.Body, int64(p.messageLimit())+1))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		// The server forgot the stream's session; reopen it for a new one
+		p.legacy.drop(endpoint)
+		return nil, &httpStatusError{code: resp.StatusCode, body: string(data)}
+	case resp.StatusCode >= 400:
+		return nil, &httpStatusError{code: resp.StatusCode, body: string(data)}
+	case readErr != nil:
+		return nil, fmt.Errorf("failed to read response body: %w", readErr)
+	}
+
+	// Some servers answer inline instead of on the stream
+	if trimmed := strings.TrimSpace(string(data)); id != nil && strings.HasPrefix(trimmed, "{") {
+		var inline messageEnvelope
+		if json.Unmarshal(data, &inline) == nil && inline.Method == "" && string(inline.ID) == string(id) {
+			p.legacy.forget(id)
+			if capture {
+				var msg JSONRPCMessage
+				if err := json.Unmarshal(data, &msg); err != nil {
+					return nil, fmt.Errorf("invalid JSON response: %w", err)
+				}
+				return &msg, nil
+			}
+			return p.writeSSEData(p.stdout, data)
+		}
+	}
+	if waiter == nil {
+		return nil, nil
+	}
+
+	var timeout <-chan time.Time
+	if p.client.Timeout > 0 {
+		timer := time.NewTimer(p.client.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case msg := <-waiter.done:
+		if msg == nil {
+			return nil, errLegacyStreamClosed
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timeout:
+		return nil, fmt.Errorf("no response on the HTTP+SSE stream after %v", p.client.Timeout)
+	}
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// resolveResourceLinks replaces resource_link blocks in a tools/call result
+// with the embedded resources they point to, as long as the fetched contents
+// fit in p.resolveLinksMax bytes in total. Links that fail to resolve are kept.
+func (p *Proxy) resolveResourceLinks(msg *JSONRPCMessage, data []byte, tool string) []byte {
+	if msg.Result == nil || len(resourceLinks(msg.Result)) == 0 {
+		return data
+	}
+
+	read := p.resourceReader(tool)
+	if read == nil {
+		return data
+	}
+
+	return rewriteResult(msg, data, func(result map[string]json.RawMessage) bool {
+		var content []json.RawMessage
+		if json.Unmarshal(result["content"], &content) != nil {
+			return false
+		}
+
+		budget := p.resolveLinksMax
+		changed := false
+		resolved := make([]json.RawMessage, 0, len(content))
+		for _, block := range content {
+			var link struct {
+				Type string `json:"type"`
+				URI  string `json:"uri"`
+			}
+			json.Unmarshal(block, &link)
+			if link.Type != "resource_link" || link.URI == "" {
+				resolved = append(resolved, block)
+				continue
+			}
+
+			embedded, size, err := readEmbedded(read, link.URI)
+			switch {
+			case err != nil:
+				log.Printf("[LINKS] Failed to resolve %s: %v", link.URI, err)
+			case size > budget:
+				if p.debug {
+					log.Printf("[LINKS] Not embedding %s: %d bytes exceeds the remaining %d", link.URI, size, budget)
+				}
+			default:
+				budget -= size
+				resolved = append(resolved, embedded...)
+				changed = true
+				if p.debug {
+					log.Printf("[LINKS] Embedded %s (%d bytes)", link.URI, size)
+				}
+				continue
+			}
+			resolved = append(resolved, block)
+		}
+
+		if changed {
+			result["content"], _ = json.Marshal(resolved)
+		}
+		return changed
+	})
+}
+
+// resourceReader returns how to read resources linked from a tool's result:
+// from the tool's owning upstream in aggregator mode, otherwise the upstream
+func (p *Proxy) resourceReader(tool string) func(uri string) (*JSONRPCMessage, error) {
+	target := p
+	if p.aggregator != nil {
+		upstream, _, err := p.aggregator.resolve(tool)
+		if err != nil {
+			return nil
+		}
+		target = upstream.proxy
+	}
+	return func(uri string) (*JSONRPCMessage, error) {
+		return target.call("resources/read", map[string]string{"uri": uri})
+	}
+}
+
+// readEmbedded reads a resource and returns it as embedded resource content
+// blocks, one per returned content, with the size of their text and blobs
+func readEmbedded(read func(uri string) (*JSONRPCMessage, error), uri string) ([]json.RawMessage, int64, error) {
+	resp, err := read(uri)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var result struct {
+		Contents []map[string]interface{} `json:"contents"`
+	}
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, 0, fmt.Errorf("invalid resources/read result: %w", err)
+	}
+	if len(result.Contents) == 0 {
+		return nil, 0, fmt.Errorf("resource has no contents")
+	}
+
+	var size int64
+	blocks := make([]json.RawMessage, 0, len(result.Contents))
+	for _, contents := range result.Contents {
+		for _, key := range []string{"text", "blob"} {
+			if value, ok := contents[key].(string); ok {
+				size += int64(len(value))
+			}
+		}
+		if contentURI, _ := contents["uri"].(string); contentURI == "" {
+			contents["uri"] = uri
+		}
+		block, err := json.Marshal(map[string]interface{}{"type": "resource", "resource": contents})
+		if err != nil {
+			return nil, 0, err
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks, size, nil
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listChanges maps each list_changed notification to the list methods it invalidates
+var listChanges = map[string][]string{
+	"notifications/tools/list_changed":     {"tools/list"},
+	"notifications/prompts/list_changed":   {"prompts/list"},
+	"notifications/resources/list_changed": {"resources/list", "resources/templates/list"},
+}
+
+// ListCache caches tools/list, prompts/list and resources/list results for a
+// TTL, or until the server announces that the list changed (--cache-lists)
+type ListCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*cacheEntry // Keyed by session, method and cursor
+	debug   bool
+}
+
+// NewListCache creates a list cache whose entries live for ttl
+func NewListCache(ttl time.Duration, debug bool) *ListCache {
+	return &ListCache{ttl: ttl, entries: make(map[string]*cacheEntry), debug: debug}
+}
+
+// listMethod reports whether results of method are cached
+func listMethod(method string) bool {
+	for _, methods := range listChanges {
+		for _, m := range methods {
+			if m == method {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key returns the cache key for a list request, or "" if it isn't cacheable.
+// Each page is cached separately, and a new session starts out empty.
+func (c *ListCache) key(msg *JSONRPCMessage, session string) string {
+	if msg.ID == nil || !listMethod(msg.Method) {
+		return ""
+	}
+	var params struct {
+		Cursor string `json:"cursor"`
+	}
+	if len(msg.Params) > 0 && json.Unmarshal(msg.Params, &params) != nil {
+		return ""
+	}
+	return session + "\x00" + msg.Method + "\x00" + params.Cursor
+}
+
+// get returns a cached result if present and not expired
+func (c *ListCache) get(key string) (json.RawMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.result, true
+}
+
+// put stores a result
+func (c *ListCache) put(key string, result json.RawMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = &cacheEntry{result: result, expires: time.Now().Add(c.ttl)}
+}
+
+// invalidate drops the lists a list_changed notification says are stale
+func (c *ListCache) invalidate(notification string) {
+	methods, ok := listChanges[notification]
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		parts := strings.SplitN(key, "\x00", 3)
+		for _, method := range methods {
+			if parts[1] == method {
+				delete(c.entries, key)
+			}
+		}
+	}
+	if c.debug {
+		log.Printf("[CACHE] %s: dropped cached %v", notification, methods)
+	}
+}
+
+// serveCachedList answers a list request from the cache, returning false on
+// a miss. On a miss the eventual upstream result is stored for next time.
+func (p *Proxy) serveCachedList(msg *JSONRPCMessage) bool {
+	key := p.listCache.key(msg, p.session())
+	if key == "" {
+		return false
+	}
+
+	if result, ok := p.listCache.get(key); ok {
+		resp := JSONRPCMessage{JSONRPC: "2.0", ID: msg.ID, Result: result}
+		data, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("[ERROR] Failed to marshal cached response: %v", err)
+			return false
+		}
+		p.writeMessage(&resp, data)
+		if p.debug {
+			log.Printf("[CACHE] Hit for %s", msg.Method)
+		}
+		return true
+	}
+
+	if p.debug {
+		log.Printf("[CACHE] Miss for %s", msg.Method)
+	}
+	p.onResponse(msg.ID, func(resp *JSONRPCMessage) {
+		if resp.Error == nil && resp.Result != nil {
+			p.listCache.put(key, resp.Result)
+		}
+	})
+	return false
+}
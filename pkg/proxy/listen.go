@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Listener serves MCP clients connecting over TCP or a Unix socket instead
+// of stdio (--listen). Every connection is bridged to its own upstream
+// session by a proxy sharing the listener's configuration, caches, metrics
+// and health checks; the listener's own proxy never opens a session.
+type Listener struct {
+	proxy   *Proxy
+	network string
+	address string
+
+	connections sync.WaitGroup
+}
+
+// NewListener creates a listener for addr: a Unix socket path (with a "/"
+// or a "unix:" prefix) or else a TCP host:port
+func NewListener(proxy *Proxy, addr string) (*Listener, error) {
+	l := &Listener{proxy: proxy, network: "tcp", address: addr}
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok || strings.Contains(addr, "/") {
+		if !ok {
+			path = addr
+		}
+		l.network, l.address = "unix", path
+	}
+	if l.address == "" {
+		return nil, errors.New("empty --listen address")
+	}
+	return l, nil
+}
+
+// listen opens the listening socket. A stale Unix socket left by a dead
+// proxy is replaced; one still being served is not.
+func (l *Listener) listen() (net.Listener, error) {
+	if l.network == "unix" {
+		if conn, err := net.Dial("unix", l.address); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is already being served", l.address)
+		} else if errors.Is(err, syscall.ECONNREFUSED) {
+			os.Remove(l.address)
+		}
+	}
+
+	listener, err := net.Listen(l.network, l.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", l.address, err)
+	}
+	if l.network == "unix" {
+		if err := os.Chmod(l.address, 0600); err != nil {
+			listener.Close()
+			os.Remove(l.address)
+			return nil, fmt.Errorf("failed to restrict socket permissions: %w", err)
+		}
+	}
+	return listener, nil
+}
+
+// Run serves connections until ctx is cancelled or a health recovery fails,
+// then ends every connection's session and returns the cause
+func (l *Listener) Run(ctx context.Context) error {
+	listener, err := l.listen()
+	if err != nil {
+		return err
+	}
+	if l.network == "unix" {
+		defer os.Remove(l.address)
+	}
+	log.Printf("[LISTEN] Accepting MCP clients on %s", listener.Addr())
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		select {
+		case err := <-l.proxy.healthFailed:
+			log.Printf("[SHUTDOWN] Health recovery failed, shutting down: %v", err)
+			cancel(&healthFailedError{err: err})
+		case <-ctx.Done():
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	next := 1
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("[ERROR] Failed to accept a connection: %v", err)
+				cancel(err)
+			}
+			break
+		}
+
+		id := next
+		next++
+		l.connections.Add(1)
+		go func() {
+			defer l.connections.Done()
+			defer recoverPanic(fmt.Sprintf("connection %d", id))
+			l.serve(ctx, id, conn)
+		}()
+	}
+
+	l.connections.Wait()
+	l.proxy.stop(context.Cause(ctx))
+	return context.Cause(ctx)
+}
+
+// serve bridges one connection to its own upstream session until the
+// client disconnects or ctx is cancelled
+func (l *Listener) serve(ctx context.Context, id int, conn net.Conn) {
+	defer conn.Close()
+	if l.proxy.debug {
+		log.Printf("[LISTEN] Client %d connected from %s", id, remoteName(conn))
+	}
+
+	session := l.proxy.forConnection()
+	err := session.Run(ctx, conn, conn)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("[LISTEN] Client %d: %v", id, err)
+	}
+	if l.proxy.debug {
+		log.Printf("[LISTEN] Client %d disconnected", id)
+	}
+}
+
+// remoteName describes a connection's peer; Unix socket peers have no address
+func remoteName(conn net.Conn) string {
+	if addr := conn.RemoteAddr(); addr != nil && addr.Network() != "unix" {
+		return addr.String()
+	}
+	return "the socket"
+}
+
+// forConnection returns a proxy for one --listen connection. It shares this
+// proxy's configuration and long-lived components but has its own session,
+// output and per-session state, and leaves the shared upstream setup
+// (spawned servers, tunnels, tracing) to this proxy.
+func (p *Proxy) forConnection() *Proxy {
+	framing, _ := newMessageFraming(p.framing.mode.Load().(string))
+	c := &Proxy{
+		url:      p.target(),
+		client:   p.client,
+		framing:  framing,
+		stdout:   io.Discard,
+		debug:    p.debug,
+		fixtures: p.fixtures,
+		replay:   p.replay,
+
+		failoverURLs: p.failoverURLs,
+		followHub:    p.followHub,
+
+		breakpoints: p.breakpoints,
+		resultCache: p.resultCache,
+		listCache:   p.listCache,
+		prefetcher:  p.prefetcher,
+		recorder:    p.recorder,
+		tee:         p.tee,
+		metrics:     p.metrics,
+		breaker:     p.breaker,
+		tracer:      p.tracer,
+		usageStats:  p.usageStats,
+		validator:   p.validator,
+		health:      p.health,
+
+		localResources:   p.localResources,
+		capabilityNotify: p.capabilityNotify,
+		capabilityFilter: p.capabilityFilter,
+		resolveLinks:     p.resolveLinks,
+		resolveLinksMax:  p.resolveLinksMax,
+		statusTool:       p.statusTool,
+		annotate:         p.annotate,
+
+		rateLimiter:       p.rateLimiter,
+		requestCoalescer:  p.requestCoalescer,
+		accept:            p.accept,
+		contentTypeMode:   p.contentTypeMode,
+		headerTemplates:   p.headerTemplates,
+		metaHeaders:       p.metaHeaders,
+		correlationHeader: p.correlationHeader,
+
+		started:        p.started,
+		maxConcurrent:  p.maxConcurrent,
+		maxMessageSize: p.maxMessageSize,
+		sseIdleTimeout: p.sseIdleTimeout,
+		backendWait:    p.backendWait,
+		backendWaitURL: p.backendWaitURL,
+		queueWhileDown: p.queueWhileDown,
+		getStream:      p.getStream,
+
+		listening: true,
+	}
+	c.lifetime, c.stop = context.WithCancelCause(p.lifetime)
+
+	// Deferred upstream setup (--lazy) runs once, for the first connection,
+	// and may discover the upstream and start its health checks
+	p.connectMu.Lock()
+	if p.connect != nil {
+		c.connect = func() error {
+			if err := p.ensureConnected(); err != nil {
+				return err
+			}
+			c.sessionMu.Lock()
+			c.url = p.target()
+			c.sessionMu.Unlock()
+			c.health = p.health
+			return nil
+		}
+	}
+	p.connectMu.Unlock()
+	if p.legacy != nil {
+		c.legacy, _ = newLegacySSE(p.legacy.mode)
+	}
+	if p.coalescer != nil {
+		c.coalescer = NewCoalescer(p.coalescer.window)
+	}
+	if p.outage != nil {
+		c.outage = NewOutageQueue(p.outage.size, p.outage.maxWait)
+	}
+	return c
+}
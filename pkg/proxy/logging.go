@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LevelTrace is below debug, adding full message payloads to message logs
+const LevelTrace = slog.LevelDebug - 4
+
+// logLevel is the minimum level logged; the control socket can change it at run time
+var logLevel = new(slog.LevelVar)
+
+// logger receives all proxy logging, including log.Printf output
+var logger = slog.New(&textHandler{w: os.Stderr, level: logLevel, mu: &sync.Mutex{}})
+
+// parseLogLevel parses a --log-level name
+func parseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "error":
+		return slog.LevelError, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "trace":
+		return LevelTrace, nil
+	}
+	return 0, fmt.Errorf("unknown --log-level %q (expected error, warn, info, debug or trace)", name)
+}
+
+// setupLogging sends logs to stderr in the given format ("text" or "json"),
+// dropping those below level. log.Printf output is routed through the same
+// handler, with its "[TAG]" prefix as the component.
+func setupLogging(format string, level slog.Level) error {
+	logLevel.Set(level)
+
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = &textHandler{w: os.Stderr, level: logLevel, mu: &sync.Mutex{}}
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+			Level: logLevel,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.LevelKey && a.Value.Any() == LevelTrace {
+					a.Value = slog.StringValue("TRACE")
+				}
+				return a
+			},
+		})
+	default:
+		return fmt.Errorf("unknown --log-format %q (expected text or json)", format)
+	}
+
+	logger = slog.New(handler)
+	log.SetFlags(0)
+	log.SetOutput(logBridge{})
+	return nil
+}
+
+// logBridge turns log.Printf lines into log records. The level is inferred
+// from the tag: [ERROR] and [PANIC] are errors, warnings say so, the rest is
+// info (messages the proxy only logs in debug mode are already filtered).
+type logBridge struct{}
+
+// Write implements io.Writer
+func (logBridge) Write(p []byte) (int, error) {
+	line := strings.TrimSuffix(string(p), "\n")
+
+	var attrs []slog.Attr
+	level := slog.LevelInfo
+	if strings.HasPrefix(line, "[") {
+		if end := strings.Index(line, "] "); end > 0 {
+			component := line[1:end]
+			line = line[end+2:]
+			attrs = append(attrs, slog.String("component", component))
+			switch {
+			case component == "ERROR" || component == "PANIC":
+				level = slog.LevelError
+			case component == "WARN" || strings.HasPrefix(line, "Warning"):
+				level = slog.LevelWarn
+			}
+		}
+	}
+
+	logger.LogAttrs(context.Background(), level, line, attrs...)
+	return len(p), nil
+}
+
+// logMessage logs a message passing through the proxy with structured fields:
+// direction, method, id, session and, for responses, the request's latency,
+// retries and upstream HTTP status. At trace level the payload is included.
+func (p *Proxy) logMessage(direction string, msg *JSONRPCMessage, data []byte, stats *requestStats) {
+	ctx := context.Background()
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	attrs := []slog.Attr{slog.String("component", "MESSAGE"), slog.String("direction", direction)}
+	method := msg.Method
+	if method == "" && stats != nil {
+		method = stats.method
+	}
+	if method != "" {
+		attrs = append(attrs, slog.String("method", method))
+	}
+	if msg.ID != nil {
+		attrs = append(attrs, slog.String("id", string(msg.ID)))
+	}
+	if session := p.session(); session != "" {
+		attrs = append(attrs, slog.String("session", session))
+	}
+	if stats != nil {
+		if direction == DirectionOut {
+			attrs = append(attrs,
+				slog.Float64("latency_ms", float64(time.Since(stats.start).Microseconds())/1000),
+				slog.Int("retries", stats.retries))
+			if stats.status != 0 {
+				attrs = append(attrs, slog.Int("http_status", stats.status))
+			}
+		}
+		attrs = append(attrs, slog.String("cid", stats.correlationID))
+	}
+	if msg.Error != nil {
+		attrs = append(attrs, slog.Int("error_code", msg.Error.Code))
+	}
+	if logger.Enabled(ctx, LevelTrace) {
+		attrs = append(attrs, slog.Any("payload", json.RawMessage(bytes.TrimSpace(data))))
+	}
+
+	logger.LogAttrs(ctx, slog.LevelDebug, "Message "+direction, attrs...)
+}
+
+// textHandler writes records in the proxy's traditional format:
+// "2006/01/02 15:04:05 [COMPONENT] message key=value ..."
+type textHandler struct {
+	w     io.Writer
+	level slog.Leveler
+	attrs []slog.Attr
+	mu    *sync.Mutex
+}
+
+// Enabled implements slog.Handler
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle implements slog.Handler
+func (h *textHandler) Handle(_ context.Context, record slog.Record) error {
+	var b strings.Builder
+	b.WriteString(record.Time.Format("2006/01/02 15:04:05 "))
+
+	var fields []slog.Attr
+	component := ""
+	collect := func(a slog.Attr) bool {
+		if a.Key == "component" {
+			component = a.Value.String()
+		} else {
+			fields = append(fields, a)
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		collect(a)
+	}
+	record.Attrs(collect)
+
+	if component != "" {
+		fmt.Fprintf(&b, "[%s] ", component)
+	}
+	b.WriteString(record.Message)
+	for _, a := range fields {
+		value := a.Value.Resolve()
+		text := value.String()
+		if raw, ok := value.Any().(json.RawMessage); ok {
+			text = string(raw)
+		}
+		if strings.ContainsAny(text, " \"") && value.Kind() == slog.KindString {
+			text = fmt.Sprintf("%q", text)
+		}
+		fmt.Fprintf(&b, " %s=%s", a.Key, text)
+	}
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+// WithAttrs implements slog.Handler
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &clone
+}
+
+// WithGroup implements slog.Handler; groups aren't used, so attributes stay flat
+func (h *textHandler) WithGroup(string) slog.Handler {
+	return h
+}
@@ -0,0 +1,298 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the histogram upper bounds in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// Metrics collects proxy counters in Prometheus text exposition format
+type Metrics struct {
+	mu       sync.Mutex
+	requests map[string]uint64 // Forwarded messages by method
+	errors   map[int]uint64    // Error responses sent to the client by JSON-RPC code
+	retries  uint64
+	latency  map[string]*histogram // Upstream round-trip time by method
+
+	// health reports upstream health for gauges; nil without health checking
+	health func() []upstreamHealth
+	// inFlight reports requests awaiting their response; nil to omit the gauge
+	inFlight func() int
+}
+
+// histogram is a cumulative Prometheus histogram
+type histogram struct {
+	counts    []uint64 // Per bucket, non-cumulative
+	exemplars []*exemplar
+	count     uint64
+	sum       float64
+}
+
+// exemplar links a histogram bucket to the most recent request observed in it
+type exemplar struct {
+	correlationID string
+	value         float64
+	time          time.Time
+}
+
+// NewMetrics creates an empty metrics registry
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: make(map[string]uint64),
+		errors:   make(map[int]uint64),
+		latency:  make(map[string]*histogram),
+	}
+}
+
+// observeRequest records a forwarded message and its upstream latency.
+// A non-empty correlationID becomes the exemplar of the latency bucket.
+func (m *Metrics) observeRequest(method string, elapsed time.Duration, correlationID string) {
+	if method == "" {
+		method = "response"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[method]++
+
+	h, ok := m.latency[method]
+	if !ok {
+		h = &histogram{
+			counts:    make([]uint64, len(latencyBuckets)),
+			exemplars: make([]*exemplar, len(latencyBuckets)+1), // Last is +Inf
+		}
+		m.latency[method] = h
+	}
+	seconds := elapsed.Seconds()
+	bucket := len(latencyBuckets)
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			bucket = i
+			break
+		}
+	}
+	if correlationID != "" {
+		h.exemplars[bucket] = &exemplar{correlationID: correlationID, value: seconds, time: time.Now()}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// observeError records an error response sent to the client
+func (m *Metrics) observeError(code int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[code]++
+}
+
+// observeRetry records a retried upstream request
+func (m *Metrics) observeRetry() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries++
+}
+
+// WriteTo writes all metrics in Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	return m.write(w, false)
+}
+
+// WriteOpenMetrics writes all metrics in OpenMetrics text format, which
+// unlike the Prometheus format carries exemplars
+func (m *Metrics) WriteOpenMetrics(w io.Writer) (int64, error) {
+	return m.write(w, true)
+}
+
+// write renders the metrics; counters' TYPE lines and exemplars differ between formats
+func (m *Metrics) write(w io.Writer, openMetrics bool) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b bytes.Buffer
+
+	// OpenMetrics names the counter family without the _total suffix
+	counterType := func(name string) string {
+		if openMetrics {
+			return strings.TrimSuffix(name, "_total")
+		}
+		return name
+	}
+
+	fmt.Fprintf(&b, "# HELP %s Messages forwarded to the upstream by JSON-RPC method.\n", counterType("mcp_proxy_requests_total"))
+	fmt.Fprintf(&b, "# TYPE %s counter\n", counterType("mcp_proxy_requests_total"))
+	for _, method := range sortedKeys(m.requests) {
+		fmt.Fprintf(&b, "mcp_proxy_requests_total{method=%q} %d\n", method, m.requests[method])
+	}
+
+	fmt.Fprintf(&b, "# HELP %s Error responses sent to the client by JSON-RPC error code.\n", counterType("mcp_proxy_errors_total"))
+	fmt.Fprintf(&b, "# TYPE %s counter\n", counterType("mcp_proxy_errors_total"))
+	codes := make([]int, 0, len(m.errors))
+	for code := range m.errors {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(&b, "mcp_proxy_errors_total{code=\"%d\"} %d\n", code, m.errors[code])
+	}
+
+	fmt.Fprintf(&b, "# HELP %s Upstream requests retried after a failure.\n", counterType("mcp_proxy_retries_total"))
+	fmt.Fprintf(&b, "# TYPE %s counter\n", counterType("mcp_proxy_retries_total"))
+	fmt.Fprintf(&b, "mcp_proxy_retries_total %d\n", m.retries)
+
+	fmt.Fprintf(&b, "# HELP mcp_proxy_request_duration_seconds Upstream round-trip time by JSON-RPC method.\n")
+	fmt.Fprintf(&b, "# TYPE mcp_proxy_request_duration_seconds histogram\n")
+	for _, method := range sortedKeys(m.latency) {
+		h := m.latency[method]
+		bucketExemplar := func(i int) string {
+			e := h.exemplars[i]
+			if !openMetrics || e == nil {
+				return ""
+			}
+			return fmt.Sprintf(" # {correlation_id=%q} %g %.3f", e.correlationID, e.value, float64(e.time.UnixMilli())/1000)
+		}
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "mcp_proxy_request_duration_seconds_bucket{method=%q,le=\"%g\"} %d%s\n", method, bound, cumulative, bucketExemplar(i))
+		}
+		fmt.Fprintf(&b, "mcp_proxy_request_duration_seconds_bucket{method=%q,le=\"+Inf\"} %d%s\n", method, h.count, bucketExemplar(len(latencyBuckets)))
+		fmt.Fprintf(&b, "mcp_proxy_request_duration_seconds_sum{method=%q} %g\n", method, h.sum)
+		fmt.Fprintf(&b, "mcp_proxy_request_duration_seconds_count{method=%q} %d\n", method, h.count)
+	}
+
+	if m.inFlight != nil {
+		fmt.Fprintf(&b, "# HELP mcp_proxy_requests_in_flight Client requests awaiting their response.\n")
+		fmt.Fprintf(&b, "# TYPE mcp_proxy_requests_in_flight gauge\n")
+		fmt.Fprintf(&b, "mcp_proxy_requests_in_flight %d\n", m.inFlight())
+	}
+
+	if m.health != nil {
+		statuses := m.health()
+		fmt.Fprintf(&b, "# HELP mcp_proxy_upstream_up Whether the upstream's last health check succeeded.\n")
+		fmt.Fprintf(&b, "# TYPE mcp_proxy_upstream_up gauge\n")
+		for _, status := range statuses {
+			up := 0
+			if status.State == StateHealthy.String() {
+				up = 1
+			}
+			fmt.Fprintf(&b, "mcp_proxy_upstream_up{upstream=%q} %d\n", status.Name, up)
+		}
+		fmt.Fprintf(&b, "# HELP mcp_proxy_upstream_probe_duration_seconds Duration of the upstream's last health probe.\n")
+		fmt.Fprintf(&b, "# TYPE mcp_proxy_upstream_probe_duration_seconds gauge\n")
+		for _, status := range statuses {
+			fmt.Fprintf(&b, "mcp_proxy_upstream_probe_duration_seconds{upstream=%q} %g\n", status.Name, status.LatencyMs/1000)
+		}
+	}
+
+	if openMetrics {
+		fmt.Fprintf(&b, "# EOF\n")
+	}
+
+	return b.WriteTo(w)
+}
+
+func init() {
+	registerControlCommand("metrics", "metrics", "Print metrics in OpenMetrics format, with correlation ID exemplars", func(p *Proxy, args string) (string, error) {
+		if p.metrics == nil {
+			return "", fmt.Errorf("metrics are not enabled")
+		}
+		var b strings.Builder
+		p.metrics.WriteOpenMetrics(&b)
+		return strings.TrimRight(b.String(), "\n"), nil
+	})
+}
+
+// serveMetrics exposes the metrics for scraping at /metrics on addr. Scrapers
+// that accept OpenMetrics get exemplars too.
+func (m *Metrics) serveMetrics(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text") {
+			w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+			m.WriteOpenMetrics(w)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.WriteTo(w)
+	})
+
+	log.Printf("[METRICS] Serving metrics on http://%s/metrics", listener.Addr())
+	go func() {
+		defer recoverPanic("metrics listener")
+		if err := http.Serve(listener, mux); err != nil {
+			log.Printf("[ERROR] Metrics listener stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// sortedKeys returns the keys of a string-keyed map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// pushMetrics sends the final metrics to a Prometheus Pushgateway, replacing
+// the group for this job and instance
+func (m *Metrics) pushMetrics(gateway, job string, debug bool) error {
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = "unknown"
+	}
+	instance = fmt.Sprintf("%s-%d", instance, os.Getpid())
+
+	target := fmt.Sprintf("%s/metrics/job/%s/instance/%s",
+		strings.TrimRight(gateway, "/"), url.PathEscape(job), url.PathEscape(instance))
+
+	var body bytes.Buffer
+	if _, err := m.WriteTo(&body); err != nil {
+		return fmt.Errorf("failed to render metrics: %w", err)
+	}
+
+	req, err := http.NewRequest("PUT", target, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create push request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("push failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("push failed: HTTP %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	if debug {
+		log.Printf("[METRICS] Pushed metrics to %s", target)
+	}
+
+	return nil
+}
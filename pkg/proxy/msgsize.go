@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// DefaultMaxMessageSize bounds a single JSON-RPC message unless configured otherwise
+const DefaultMaxMessageSize = 1024 * 1024
+
+// frameOverhead leaves room for framing around a message of the maximum size
+const frameOverhead = 4096
+
+// newMessageBuffer returns the initial scanner buffer for messages up to limit
+func newMessageBuffer(limit int) []byte {
+	return make([]byte, 0, min(64*1024, limit+frameOverhead))
+}
+
+// messageTooLargeError reports an upstream message over --max-message-size
+type messageTooLargeError struct {
+	limit int
+}
+
+// Error implements error
+func (e *messageTooLargeError) Error() string {
+	return fmt.Sprintf("response exceeds the maximum message size of %d bytes (--max-message-size)", e.limit)
+}
+
+// messageLimit returns the maximum size of one message in either direction
+func (p *Proxy) messageLimit() int {
+	if p.maxMessageSize <= 0 {
+		return DefaultMaxMessageSize
+	}
+	return p.maxMessageSize
+}
+
+// tooLarge turns the scanner's overflow into a messageTooLargeError
+func (p *Proxy) tooLarge(err error) error {
+	if errors.Is(err, bufio.ErrTooLong) {
+		return &messageTooLargeError{limit: p.messageLimit()}
+	}
+	return err
+}
+
+// rejectOversized answers a client message that was skipped for exceeding
+// the limit; without an ID it may have been a notification, so it is only logged
+func (p *Proxy) rejectOversized(id json.RawMessage) {
+	log.Printf("[ERROR] Skipped a client message over the maximum message size of %d bytes", p.messageLimit())
+	if id == nil {
+		return
+	}
+	p.sendErrorResponse(id, -32600, fmt.Sprintf("Request exceeds the maximum message size of %d bytes (--max-message-size)", p.messageLimit()))
+}
+
+// leadingID finds the top-level "id" of a message from its first bytes, as
+// long as no member before it was cut off
+func leadingID(start []byte) json.RawMessage {
+	dec := json.NewDecoder(bytes.NewReader(start))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil
+		}
+		if key == "id" {
+			return value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientName identifies the proxy to authorization servers during registration
+const clientName = "mcp-stdio-proxy"
+
+// authServerMetadata is the subset of RFC 8414 metadata the proxy uses
+type authServerMetadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	RegistrationEndpoint  string   `json:"registration_endpoint"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// fetchJSON GETs a URL and decodes a JSON object from it
+func fetchJSON(client *http.Client, target string, v interface{}) error {
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d from %s", resp.StatusCode, target)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON from %s: %w", target, err)
+	}
+	return nil
+}
+
+// wellKnownURLs returns the RFC 8414/9728 locations of a well-known document
+// for a URL: inserted before the path first, then at the origin root
+func wellKnownURLs(base, name string) ([]string, error) {
+	u, err := url.Parse(base)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", base)
+	}
+	origin := u.Scheme + "://" + u.Host
+	path := strings.TrimRight(u.Path, "/")
+
+	urls := []string{}
+	if path != "" {
+		urls = append(urls, origin+"/.well-known/"+name+path)
+	}
+	return append(urls, origin+"/.well-known/"+name), nil
+}
+
+// discoverAuthServer finds the authorization server protecting an MCP server
+// (RFC 9728 protected resource metadata) unless an issuer is given, and
+// fetches its metadata
+func discoverAuthServer(client *http.Client, resource, issuer string) (*authServerMetadata, error) {
+	if issuer == "" {
+		candidates, err := wellKnownURLs(resource, "oauth-protected-resource")
+		if err != nil {
+			return nil, err
+		}
+		var lastErr error
+		for _, candidate := range candidates {
+			var metadata struct {
+				AuthorizationServers []string `json:"authorization_servers"`
+			}
+			if lastErr = fetchJSON(client, candidate, &metadata); lastErr == nil {
+				if len(metadata.AuthorizationServers) == 0 {
+					lastErr = fmt.Errorf("%s lists no authorization servers", candidate)
+					continue
+				}
+				issuer = metadata.AuthorizationServers[0]
+				break
+			}
+		}
+		if issuer == "" {
+			return nil, fmt.Errorf("protected resource metadata discovery failed: %w", lastErr)
+		}
+	}
+
+	candidates, err := wellKnownURLs(issuer, "oauth-authorization-server")
+	if err != nil {
+		return nil, err
+	}
+	openid, _ := wellKnownURLs(issuer, "openid-configuration")
+	candidates = append(candidates, openid...)
+
+	var lastErr error
+	for _, candidate := range candidates {
+		var metadata authServerMetadata
+		if lastErr = fetchJSON(client, candidate, &metadata); lastErr == nil {
+			return &metadata, nil
+		}
+	}
+	return nil, fmt.Errorf("authorization server metadata discovery for %s failed: %w", issuer, lastErr)
+}
+
+// registeredClient holds credentials issued by dynamic client registration
+type registeredClient struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	ExpiresAt    int64  `json:"client_secret_expires_at,omitempty"` // Unix seconds; 0 = never
+}
+
+// expired reports whether the client secret has expired
+func (c *registeredClient) expired() bool {
+	return c.ExpiresAt > 0 && time.Now().Unix() >= c.ExpiresAt
+}
+
+// registerClient performs RFC 7591 dynamic client registration
+func registerClient(client *http.Client, endpoint string, request map[string]interface{}) (*registeredClient, error) {
+	request["client_name"] = clientName
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create registration request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("client registration failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registration response: %w", err)
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("client registration returned HTTP %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var registered registeredClient
+	if err := json.Unmarshal(respBody, &registered); err != nil {
+		return nil, fmt.Errorf("invalid registration response: %w", err)
+	}
+	if registered.ClientID == "" {
+		return nil, fmt.Errorf("registration response has no client_id")
+	}
+	return &registered, nil
+}
+
+// credentialStore persists OAuth credentials (registered clients or tokens)
+// as a JSON object keyed by endpoint, in a file only the current user can read
+type credentialStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// credentialStores are shared per path so concurrent upstreams don't clobber each other's writes
+var (
+	credentialStoresMu sync.Mutex
+	credentialStores   = make(map[string]*credentialStore)
+)
+
+// openCredentialStore returns the store at path, or the per-user file named
+// defaultName when empty
+func openCredentialStore(path, defaultName string) (*credentialStore, error) {
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("no config directory for OAuth credentials: %w", err)
+		}
+		path = filepath.Join(dir, "mcp-stdio-proxy", defaultName)
+	}
+
+	credentialStoresMu.Lock()
+	defer credentialStoresMu.Unlock()
+	if store, ok := credentialStores[path]; ok {
+		return store, nil
+	}
+	store := &credentialStore{path: path}
+	credentialStores[path] = store
+	return store, nil
+}
+
+// openClientStore returns the store of registered clients
+func openClientStore(path string) (*credentialStore, error) {
+	return openCredentialStore(path, "oauth-clients.json")
+}
+
+// load reads all stored entries; a missing file is empty
+func (s *credentialStore) load() (map[string]json.RawMessage, error) {
+	entries := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OAuth credentials: %w", err)
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid OAuth credentials file %s: %w", s.path, err)
+	}
+	return entries, nil
+}
+
+// get decodes the entry for key into v, reporting whether there was one
+func (s *credentialStore) get(key string, v interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	entry, ok := entries[key]
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(entry, v); err != nil {
+		return false, fmt.Errorf("invalid OAuth credentials for %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// put stores (or, with nil, removes) the entry for key
+func (s *credentialStore) put(key string, v interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		delete(entries, key)
+	} else {
+		entry, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		entries[key] = entry
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create credentials directory: %w", err)
+	}
+
+	// Write atomically so a crash can't leave a truncated file behind
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".oauth-*")
+	if err != nil {
+		return fmt.Errorf("failed to write OAuth credentials: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to restrict OAuth credentials permissions: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write OAuth credentials: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write OAuth credentials: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write OAuth credentials: %w", err)
+	}
+	return nil
+}
+
+// registeredClientFor returns credentials stored under key, registering a new
+// client at endpoint when there are none (or they expired)
+func registeredClientFor(client *http.Client, store *credentialStore, key, endpoint string, request map[string]interface{}) (*registeredClient, error) {
+	var stored registeredClient
+	if found, err := store.get(key, &stored); err != nil {
+		return nil, err
+	} else if found && !stored.expired() {
+		return &stored, nil
+	}
+
+	registered, err := registerClient(client, endpoint, request)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.put(key, registered); err != nil {
+		// The credentials still work for this run
+		log.Printf("[AUTH] Failed to persist registered client: %v", err)
+	}
+	log.Printf("[AUTH] Registered OAuth client %s at %s", registered.ClientID, endpoint)
+	return registered, nil
+}
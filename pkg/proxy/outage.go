@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// outageRetryInterval is how often the head of the outage queue is retried
+const outageRetryInterval = time.Second
+
+// OutageQueue holds client messages while the upstream is unreachable and
+// forwards them in order once it answers again (--outage-queue)
+type OutageQueue struct {
+	size    int           // Most messages held at once
+	maxWait time.Duration // How long a message may be held before it fails
+
+	mu      sync.Mutex
+	pending []*heldMessage
+	active  bool // An outage is in progress and a flusher is running
+}
+
+// heldMessage is a client message waiting for the upstream to come back
+type heldMessage struct {
+	line   string
+	msg    *JSONRPCMessage
+	held   time.Time
+	result chan error // Receives the outcome once forwarded or given up on
+}
+
+// NewOutageQueue creates a queue holding up to size messages for at most maxWait each
+func NewOutageQueue(size int, maxWait time.Duration) *OutageQueue {
+	return &OutageQueue{size: size, maxWait: maxWait}
+}
+
+// unreachable reports whether a forwarding error means the upstream is down,
+// as opposed to it rejecting the message
+func unreachable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var status *httpStatusError
+	if errors.As(err, &status) {
+		return status.code == http.StatusBadGateway || status.code == http.StatusServiceUnavailable || status.code == http.StatusGatewayTimeout
+	}
+	var opErr *net.OpError
+	return connectionRefused(err) || (errors.As(err, &opErr) && opErr.Op == "dial")
+}
+
+// forward sends a client message upstream. With --outage-queue, a message
+// that can't reach the upstream, or arrives while earlier ones are held,
+// waits in the queue; requests block until forwarded so their response or
+// error is still written by the caller.
+func (p *Proxy) forward(line string, msg *JSONRPCMessage) error {
+	if p.outage == nil {
+		return p.forwardMessage(line, msg)
+	}
+
+	p.outage.mu.Lock()
+	active := p.outage.active
+	p.outage.mu.Unlock()
+	if !active {
+		err := p.forwardMessage(line, msg)
+		if !unreachable(err) {
+			return err
+		}
+		log.Printf("[OUTAGE] Upstream unreachable, holding messages: %v", err)
+	}
+
+	held := &heldMessage{line: line, msg: msg, held: time.Now(), result: make(chan error, 1)}
+	if err := p.holdMessage(held); err != nil {
+		return err
+	}
+
+	// Notifications and responses are forwarded in the background
+	if msg.ID == nil || msg.Method == "" {
+		return nil
+	}
+	p.sendProgress(msg, "Upstream unreachable, waiting for it to come back")
+	return <-held.result
+}
+
+// holdMessage appends a message to the outage queue, starting the flusher
+// when it begins an outage
+func (p *Proxy) holdMessage(held *heldMessage) error {
+	q := p.outage
+	q.mu.Lock()
+	if len(q.pending) >= q.size {
+		q.mu.Unlock()
+		return fmt.Errorf("upstream unreachable and %d messages already held (--outage-queue)", q.size)
+	}
+	q.pending = append(q.pending, held)
+	starting := !q.active
+	q.active = true
+	q.mu.Unlock()
+
+	if starting {
+		p.sendLogMessage("warning", fmt.Sprintf("Upstream unreachable; holding messages for up to %v until it is back", q.maxWait))
+		go p.flushOutage()
+	}
+	return nil
+}
+
+// flushOutage retries the oldest held message until the upstream takes it,
+// then forwards the rest in order. Messages held longer than maxWait fail.
+func (p *Proxy) flushOutage() {
+	defer recoverPanic("outage queue")
+
+	q := p.outage
+	ctx := p.baseContext()
+	forwarded := 0
+	for {
+		q.mu.Lock()
+		p.expireHeld(time.Now())
+		if len(q.pending) == 0 {
+			q.active = false
+			q.mu.Unlock()
+			break
+		}
+		head := q.pending[0]
+		q.mu.Unlock()
+
+		if ctx.Err() != nil {
+			p.failHeld(ctx.Err())
+			return
+		}
+
+		err := p.forwardMessage(head.line, head.msg)
+		var open *circuitOpenError
+		if unreachable(err) || errors.As(err, &open) {
+			if p.debug {
+				log.Printf("[OUTAGE] Upstream still unreachable: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(outageRetryInterval):
+			}
+			continue
+		}
+
+		q.mu.Lock()
+		if len(q.pending) > 0 && q.pending[0] == head {
+			q.pending = q.pending[1:]
+		}
+		q.mu.Unlock()
+		if err != nil && (head.msg.ID == nil || head.msg.Method == "") {
+			log.Printf("[ERROR] Failed to forward held message: %v", err)
+		}
+		head.result <- err
+		forwarded++
+	}
+
+	log.Printf("[OUTAGE] Upstream reachable again, forwarded %d held message(s)", forwarded)
+	p.sendLogMessage("info", fmt.Sprintf("Upstream reachable again; forwarded %d held message(s)", forwarded))
+}
+
+// expireHeld fails messages held longer than maxWait; q.mu must be held
+func (p *Proxy) expireHeld(now time.Time) {
+	q := p.outage
+	kept := q.pending[:0]
+	for _, held := range q.pending {
+		if now.Sub(held.held) < q.maxWait {
+			kept = append(kept, held)
+			continue
+		}
+		err := fmt.Errorf("upstream unreachable for %v (--outage-max-wait)", q.maxWait)
+		if held.msg.ID == nil || held.msg.Method == "" {
+			log.Printf("[ERROR] Dropped held message: %v", err)
+		}
+		held.result <- err
+	}
+	q.pending = kept
+}
+
+// failHeld gives up on every held message, e.g. when the proxy shuts down
+func (p *Proxy) failHeld(err error) {
+	q := p.outage
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, held := range q.pending {
+		held.result <- err
+	}
+	q.pending = nil
+	q.active = false
+}
+
+// sendProgress reports progress on a request whose params carry a
+// _meta.progressToken; other requests get nothing
+func (p *Proxy) sendProgress(msg *JSONRPCMessage, message string) {
+	var params struct {
+		Meta struct {
+			ProgressToken json.RawMessage `json:"progressToken"`
+		} `json:"_meta"`
+	}
+	if json.Unmarshal(msg.Params, &params) != nil || params.Meta.ProgressToken == nil {
+		return
+	}
+
+	raw, _ := json.Marshal(map[string]interface{}{
+		"progressToken": params.Meta.ProgressToken,
+		"progress":      0,
+		"message":       message,
+	})
+	note := JSONRPCMessage{JSONRPC: "2.0", Method: "notifications/progress", Params: raw}
+	data, err := json.Marshal(note)
+	if err != nil {
+		return
+	}
+	p.writeMessage(&note, data)
+}
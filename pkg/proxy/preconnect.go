@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// preconnect warms up the upstream before the client sends anything: it opens
+// a throwaway session, runs initialize and tools/list, and terminates it.
+// This pays connection, TLS and backend start-up costs (e.g. mcp-hub
+// connecting its servers) ahead of the first user-visible request while
+// leaving the client's own session untouched. Keep-alive connections stay in
+// the shared HTTP client's pool for reuse.
+func (p *Proxy) preconnect() {
+	defer recoverPanic("preconnect")
+
+	start := time.Now()
+	if err := p.warmUp(); err != nil {
+		log.Printf("[PRECONNECT] Warm-up failed: %v", err)
+		return
+	}
+
+	if p.debug {
+		log.Printf("[PRECONNECT] Warm-up completed in %v", time.Since(start))
+	}
+}
+
+// warmUp performs the throwaway session exchange
+func (p *Proxy) warmUp() error {
+	initialize := fmt.Sprintf(`{"jsonrpc":"2.0","id":"mcp-stdio-proxy-preconnect-1","method":"initialize","params":{"protocolVersion":%q,"capabilities":{},"clientInfo":{"name":"mcp-stdio-proxy","version":%q}}}`,
+		latestProtocolVersion, version)
+
+	resp, err := p.warmUpRequest("POST", initialize, "")
+	if err != nil {
+		return fmt.Errorf("initialize: %w", err)
+	}
+	sessionID := resp.Header.Get("Mcp-Session-Id")
+
+	steps := []struct {
+		name string
+		body string
+	}{
+		{"initialized", `{"jsonrpc":"2.0","method":"notifications/initialized"}`},
+		{"tools/list", `{"jsonrpc":"2.0","id":"mcp-stdio-proxy-preconnect-2","method":"tools/list"}`},
+	}
+	for _, step := range steps {
+		if _, err := p.warmUpRequest("POST", step.body, sessionID); err != nil {
+			return fmt.Errorf("%s: %w", step.name, err)
+		}
+	}
+
+	// Terminate the throwaway session; servers without session support may refuse
+	if sessionID != "" {
+		if _, err := p.warmUpRequest("DELETE", "", sessionID); err != nil && p.debug {
+			log.Printf("[PRECONNECT] Could not terminate warm-up session: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// warmUpRequest sends one warm-up request and drains the response so the
+// connection returns to the pool
+func (p *Proxy) warmUpRequest(method, body, sessionID string) (*http.Response, error) {
+	req, err := p.newUpstreamRequest(method, body, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return resp, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	sse, err := p.isSSEResponse(resp)
+	if err != nil {
+		return resp, err
+	}
+	if sse {
+		err = p.readSSE(resp.Body, nil, func(data []byte) {})
+	} else {
+		_, err = io.Copy(io.Discard, resp.Body)
+	}
+	if err != nil {
+		return resp, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	return resp, nil
+}
+
+// prewarmTimeout bounds the request opening the prewarmed connection
+const prewarmTimeout = 10 * time.Second
+
+// prewarm opens a pooled connection to the upstream at startup, paying for
+// DNS, TCP, TLS and HTTP/2 negotiation before the first request (--prewarm).
+// It sends an OPTIONS request, which any answer completes; no session is
+// opened.
+func (p *Proxy) prewarm() {
+	defer recoverPanic("prewarm")
+
+	start := time.Now()
+	req, err := p.newUpstreamRequest("OPTIONS", "", "")
+	if err != nil {
+		log.Printf("[PRECONNECT] Prewarm failed: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(p.baseContext(), prewarmTimeout)
+	defer cancel()
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		log.Printf("[PRECONNECT] Prewarm failed: %v", err)
+		return
+	}
+	// Drain the body so the connection returns to the pool
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	p.noteProtocol(resp.Proto)
+
+	if p.debug {
+		log.Printf("[PRECONNECT] Connected to %s over %s in %v", p.target(), resp.Proto, time.Since(start).Round(time.Millisecond))
+	}
+}
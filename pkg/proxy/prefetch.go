@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// ResourcePrefetcher reads resources linked from tool results in the background
+// so the client's later resources/read calls can be answered locally
+type ResourcePrefetcher struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]*cacheEntry
+	order      []string // Insertion order, oldest first, for eviction
+	inflight   map[string]bool
+	slots      chan struct{} // Bounds concurrent background reads
+}
+
+// NewResourcePrefetcher creates a prefetcher whose entries expire after ttl,
+// holding at most maxEntries resources (0 for no limit)
+func NewResourcePrefetcher(ttl time.Duration, maxEntries int) *ResourcePrefetcher {
+	return &ResourcePrefetcher{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*cacheEntry),
+		inflight:   make(map[string]bool),
+		slots:      make(chan struct{}, 4),
+	}
+}
+
+// get returns a prefetched resources/read result if it hasn't expired
+func (r *ResourcePrefetcher) get(uri string) (json.RawMessage, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[uri]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		r.remove(uri)
+		return nil, false
+	}
+	return entry.result, true
+}
+
+// put stores a resource, dropping expired entries and then the oldest ones
+// when over the size limit. Entries share one TTL, so the oldest expire
+// first. Callers must hold r.mu.
+func (r *ResourcePrefetcher) put(uri string, result json.RawMessage) {
+	if _, exists := r.entries[uri]; exists {
+		r.remove(uri)
+	}
+	now := time.Now()
+	r.entries[uri] = &cacheEntry{result: result, expires: now.Add(r.ttl)}
+	r.order = append(r.order, uri)
+
+	for len(r.order) > 0 && now.After(r.entries[r.order[0]].expires) {
+		r.remove(r.order[0])
+	}
+	for r.maxEntries > 0 && len(r.entries) > r.maxEntries {
+		r.remove(r.order[0])
+	}
+}
+
+// remove deletes an entry; callers must hold r.mu
+func (r *ResourcePrefetcher) remove(uri string) {
+	delete(r.entries, uri)
+	for i, u := range r.order {
+		if u == uri {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// resourceLinks returns the URIs of resource_link content blocks in a tool result
+func resourceLinks(result json.RawMessage) []string {
+	var toolResult struct {
+		Content []struct {
+			Type string `json:"type"`
+			URI  string `json:"uri"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(result, &toolResult); err != nil {
+		return nil
+	}
+
+	var uris []string
+	for _, block := range toolResult.Content {
+		if block.Type == "resource_link" && block.URI != "" {
+			uris = append(uris, block.URI)
+		}
+	}
+	return uris
+}
+
+// prefetchLinks starts background reads for resources linked from a tool result
+func (p *Proxy) prefetchLinks(resp *JSONRPCMessage) {
+	if resp.Result == nil {
+		return
+	}
+
+	r := p.prefetcher
+	for _, uri := range resourceLinks(resp.Result) {
+		r.mu.Lock()
+		if _, cached := r.entries[uri]; cached || r.inflight[uri] {
+			r.mu.Unlock()
+			continue
+		}
+		r.inflight[uri] = true
+		r.mu.Unlock()
+
+		go func(uri string) {
+			r.slots <- struct{}{}
+			defer func() { <-r.slots }()
+			defer recoverPanic("prefetch " + uri)
+
+			result, err := p.call("resources/read", map[string]string{"uri": uri})
+
+			r.mu.Lock()
+			delete(r.inflight, uri)
+			if err == nil {
+				r.put(uri, result.Result)
+			}
+			r.mu.Unlock()
+
+			if err != nil {
+				log.Printf("[PREFETCH] Failed to read %s: %v", uri, err)
+			} else if p.debug {
+				log.Printf("[PREFETCH] Cached %s", uri)
+			}
+		}(uri)
+	}
+}
+
+// servePrefetched answers a resources/read from the prefetch cache, returning false on a miss
+func (p *Proxy) servePrefetched(msg *JSONRPCMessage) bool {
+	var params struct {
+		URI string `json:"uri"`
+	}
+	if msg.ID == nil || json.Unmarshal(msg.Params, &params) != nil || params.URI == "" {
+		return false
+	}
+
+	result, ok := p.prefetcher.get(params.URI)
+	if !ok {
+		return false
+	}
+
+	resp := JSONRPCMessage{JSONRPC: "2.0", ID: msg.ID, Result: result}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("[ERROR] Failed to marshal prefetched response: %v", err)
+		return false
+	}
+	p.writeMessage(&resp, data)
+	if p.debug {
+		log.Printf("[PREFETCH] Served %s from cache", params.URI)
+	}
+
+	return true
+}
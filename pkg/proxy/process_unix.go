@@ -0,0 +1,136 @@
+//go:build !windows
+
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// listProcesses returns every process with its full command line, from ps
+func listProcesses() ([]processInfo, error) {
+	output, err := exec.Command("ps", "-Ao", "pid=,args=").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run ps: %w", err)
+	}
+
+	var processes []processInfo
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		pid, args, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if !ok {
+			continue
+		}
+		processes = append(processes, processInfo{PID: pid, CommandLine: strings.TrimSpace(args)})
+	}
+	return processes, nil
+}
+
+// findPortInNetstat searches for mcp-hub listening port using ss or netstat
+func findPortInNetstat(debug bool) (string, error) {
+	// Try ss first (modern Linux)
+	port, err := tryNetworkCommand("ss", []string{"-tlnp"}, debug)
+	if err == nil {
+		return port, nil
+	}
+
+	// Fall back to netstat
+	port, err = tryNetworkCommand("netstat", []string{"-tlnp"}, debug)
+	if err == nil {
+		return port, nil
+	}
+
+	return "", fmt.Errorf("could not find mcp-hub listening port")
+}
+
+// tryNetworkCommand tries to run a network command (ss or netstat) and find mcp-hub
+func tryNetworkCommand(command string, args []string, debug bool) (string, error) {
+	cmd := exec.Command(command, args...)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run %s: %w", command, err)
+	}
+
+	// Look for lines containing "node" or "mcp-hub" that are LISTEN
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	portRegex := regexp.MustCompile(`:(\d+)\s`)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.Contains(line, "LISTEN") {
+			continue
+		}
+		if !strings.Contains(line, "node") && !strings.Contains(line, "mcp-hub") {
+			continue
+		}
+
+		// Extract port from address (format: 0.0.0.0:PORT or :::PORT)
+		matches := portRegex.FindStringSubmatch(line)
+		if len(matches) >= 2 {
+			port := matches[1]
+			if debug {
+				log.Printf("[DISCOVERY] Found potential mcp-hub port in %s: %s", command, line)
+				log.Printf("[DISCOVERY] Extracted port: %s", port)
+			}
+			return port, nil
+		}
+	}
+
+	return "", fmt.Errorf("no matching process found in %s output", command)
+}
+
+// shellCommand runs a command line through the shell in its own process
+// group, so stopProcessTree reaches everything it starts
+func shellCommand(line string) *exec.Cmd {
+	cmd := exec.Command("sh", "-c", line)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+// stopProcessTree asks a shellCommand's process group to terminate and
+// kills it if it hasn't exited when exited is closed or after grace
+func stopProcessTree(cmd *exec.Cmd, exited <-chan struct{}, grace time.Duration) {
+	pgid := -cmd.Process.Pid
+	syscall.Kill(pgid, syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-time.After(grace):
+		syscall.Kill(pgid, syscall.SIGKILL)
+		<-exited
+	}
+}
+
+// detachProcess starts cmd in its own session, so it outlives the proxy and
+// doesn't receive signals aimed at the proxy's process group
+func detachProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+// processAlive reports whether a process with the given PID exists
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// openTerminal opens the controlling terminal for prompts
+func openTerminal() (in, out *os.File, err error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tty, tty, nil
+}
+
+// connectionRefused reports whether err is a refused TCP connection
+func connectionRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
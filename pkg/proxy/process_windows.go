@@ -0,0 +1,140 @@
+//go:build windows
+
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// listProcesses returns every process with its full command line, from WMI
+// via PowerShell since Windows has no ps
+func listProcesses() ([]processInfo, error) {
+	output, err := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command",
+		"Get-CimInstance Win32_Process | Select-Object ProcessId,CommandLine | ConvertTo-Json -Compress").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+
+	// A single process is printed as an object rather than an array
+	output = bytes.TrimSpace(output)
+	if bytes.HasPrefix(output, []byte("{")) {
+		output = append(append([]byte("["), output...), ']')
+	}
+	var entries []struct {
+		ProcessId   int
+		CommandLine *string
+	}
+	if err := json.Unmarshal(output, &entries); err != nil {
+		return nil, fmt.Errorf("invalid process list: %w", err)
+	}
+
+	processes := make([]processInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.CommandLine == nil {
+			continue
+		}
+		processes = append(processes, processInfo{PID: strconv.Itoa(entry.ProcessId), CommandLine: *entry.CommandLine})
+	}
+	return processes, nil
+}
+
+// findPortInNetstat searches for the mcp-hub listening port with netstat,
+// matching listening sockets' owning PIDs against node and mcp-hub processes
+func findPortInNetstat(debug bool) (string, error) {
+	processes, err := listProcesses()
+	if err != nil {
+		return "", err
+	}
+	candidates := make(map[string]bool)
+	for _, process := range processes {
+		if strings.Contains(process.CommandLine, "mcp-hub") || strings.Contains(process.CommandLine, "node") {
+			candidates[process.PID] = true
+		}
+	}
+
+	output, err := exec.Command("netstat", "-ano", "-p", "TCP").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run netstat: %w", err)
+	}
+
+	// Lines look like: TCP    127.0.0.1:37373    0.0.0.0:0    LISTENING    1234
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[3] != "LISTENING" || !candidates[fields[4]] {
+			continue
+		}
+		address := fields[1]
+		port := address[strings.LastIndex(address, ":")+1:]
+		if debug {
+			log.Printf("[DISCOVERY] Found potential mcp-hub port in netstat: %s", scanner.Text())
+			log.Printf("[DISCOVERY] Extracted port: %s", port)
+		}
+		return port, nil
+	}
+
+	return "", fmt.Errorf("could not find mcp-hub listening port")
+}
+
+// shellCommand runs a command line through cmd.exe
+func shellCommand(line string) *exec.Cmd {
+	return exec.Command("cmd", "/C", line)
+}
+
+// stopProcessTree kills a shellCommand and its children; Windows has no
+// graceful termination signal for console processes, so grace is unused
+func stopProcessTree(cmd *exec.Cmd, exited <-chan struct{}, grace time.Duration) {
+	exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+	<-exited
+}
+
+// detachProcess starts cmd in its own process group, so it outlives the
+// proxy and doesn't receive the proxy's console control events
+func detachProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// processAlive reports whether a process with the given PID exists
+func processAlive(pid int) bool {
+	const processQueryLimitedInformation = 0x1000
+	handle, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(handle)
+
+	var code uint32
+	const stillActive = 259
+	return syscall.GetExitCodeProcess(handle, &code) == nil && code == stillActive
+}
+
+// openTerminal opens the console for prompts
+func openTerminal() (in, out *os.File, err error) {
+	if in, err = os.OpenFile("CONIN$", os.O_RDWR, 0); err != nil {
+		return nil, nil, err
+	}
+	if out, err = os.OpenFile("CONOUT$", os.O_RDWR, 0); err != nil {
+		in.Close()
+		return nil, nil, err
+	}
+	return in, out, nil
+}
+
+// wsaeConnRefused is Winsock's WSAECONNREFUSED, which syscall doesn't name
+const wsaeConnRefused = syscall.Errno(10061)
+
+// connectionRefused reports whether err is a refused TCP connection
+func connectionRefused(err error) bool {
+	return errors.Is(err, wsaeConnRefused)
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package proxy
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// prSetName is prctl's PR_SET_NAME option
+const prSetName = 15
+
+// setProcessTitle sets the process name shown by top and ps -o comm, so
+// proxies connected to different mcp-hub ports can be told apart. Linux
+// truncates it to 15 bytes; the command line itself is left alone.
+func setProcessTitle(title string) {
+	name := make([]byte, 16)
+	copy(name[:15], title)
+	syscall.RawSyscall(syscall.SYS_PRCTL, prSetName, uintptr(unsafe.Pointer(&name[0])), 0)
+}
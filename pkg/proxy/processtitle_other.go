@@ -0,0 +1,6 @@
+//go:build !linux
+
+package proxy
+
+// setProcessTitle is a no-op where the process name can't be changed portably
+func setProcessTitle(title string) {}
@@ -0,0 +1,1685 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// version is the proxy version, overridable at build time with
+// -ldflags "-X github.com/aleksandr-kiusev/mcp-stdio-proxy/pkg/proxy.version=..."
+var version = "dev"
+
+// latestProtocolVersion is the MCP protocol version the proxy speaks on its own behalf
+const latestProtocolVersion = "2025-06-18"
+
+// Proxy handles the stdio to Streamable HTTP bridge
+type Proxy struct {
+	url       string // Guarded by sessionMu once running, see target
+	sessionID string
+	sessionMu sync.Mutex
+	// initParams holds the client's initialize params for replaying the handshake
+	initParams json.RawMessage
+	// clientInfo is the client's "name/version" from initialize, sent upstream
+	// as X-MCP-Client and in the User-Agent
+	clientInfo string
+	client     *http.Client
+	framing    *messageFraming // Framing of the client streams
+	stdout     io.Writer
+	debug      bool
+	fixtures   []FixtureRule
+	replay     *Replay
+
+	// reinitMu serializes replacing an expired session
+	reinitMu sync.Mutex
+	// protocolVersion is the version negotiated by initialize, sent as
+	// MCP-Protocol-Version on later requests; guarded by sessionMu
+	protocolVersion string
+
+	// connect performs deferred upstream setup (--lazy); nil once connected
+	connect   func() error
+	connectMu sync.Mutex
+
+	// failoverURLs are the --url upstreams in order of preference; the proxy
+	// moves on to the next when the current one is unreachable
+	failoverURLs []string
+
+	// followHub re-runs mcp-hub discovery when the upstream keeps refusing
+	// connections; rediscoverMu also serializes failing over
+	followHub    bool
+	rediscoverMu sync.Mutex
+
+	breakpoints *Breakpoints
+	resultCache *ResultCache
+	listCache   *ListCache
+	prefetcher  *ResourcePrefetcher
+	coalescer   *Coalescer
+	recorder    *Recorder
+	tee         *Tee
+	metrics     *Metrics
+	breaker     *CircuitBreaker
+	tracer      *Tracer
+	tunnel      *SSHTunnel
+	spawner     *Spawner
+	stdio       *StdioBackend
+	usageStats  *UsageStats
+	validator   *SchemaValidator
+	health      *HealthChecker
+	aggregator  *Aggregator
+
+	// localResources are served by the proxy itself, e.g. proxy://stats
+	localResources *LocalResources
+
+	// capabilities tracks the initialize negotiation; capabilityNotify also
+	// reports mismatches to the client as log notifications
+	capabilities     capabilityProbe
+	capabilityNotify bool
+	// capabilityFilter disables or overrides negotiated capabilities
+	capabilityFilter *CapabilityFilter
+
+	// resolveLinks embeds resources linked from tool results, up to resolveLinksMax bytes per result
+	resolveLinks    bool
+	resolveLinksMax int64
+
+	// statusTool serves the synthetic proxy.status tool
+	statusTool bool
+	retries    atomic.Int64 // Upstream attempts retried, for proxy.status
+
+	// annotate adds transport details to results as _meta.proxy
+	annotate bool
+	inflight requestTracker
+
+	// sessionFile keeps the session across restarts (--session-file)
+	sessionFile *SessionFile
+
+	// serverRequests routes the client's answers to server requests back upstream
+	serverRequests serverRequestTracker
+
+	// legacy speaks the HTTP+SSE transport to servers that haven't
+	// migrated to Streamable HTTP (--transport); nil means Streamable HTTP only
+	legacy *legacySSE
+
+	// httpProtocol is the HTTP version of the last upstream response, e.g. HTTP/2.0
+	httpProtocol atomic.Value
+
+	// rateLimiter throttles requests forwarded upstream (--rate)
+	rateLimiter *RateLimiter
+
+	// requestCoalescer shares one upstream call between identical concurrent requests (--coalesce-requests)
+	requestCoalescer *RequestCoalescer
+
+	// accept overrides the Accept header; contentTypeMode selects how response content types are checked
+	accept          string
+	contentTypeMode string
+
+	// headerTemplates render extra upstream headers from each forwarded message
+	headerTemplates []HeaderTemplate
+
+	// metaHeaders lists upstream response headers copied into results' _meta
+	metaHeaders []string
+
+	// correlationHeader names the upstream request header carrying the correlation ID
+	correlationHeader string
+
+	writeMu sync.Mutex // Serializes writes to stdout
+
+	started time.Time // When the proxy started, for uptime reports
+
+	// lifetime bounds all upstream traffic and background work; cancelling
+	// it through stop aborts in-flight requests, streams and health checks
+	lifetime context.Context
+	stop     context.CancelCauseFunc
+
+	// healthFailed receives the first failed health recovery (--health-exit-on-failure)
+	healthFailed chan error
+
+	// maxConcurrent bounds how many client requests are forwarded at once
+	maxConcurrent int
+
+	// maxMessageSize bounds one message in either direction, see messageLimit
+	maxMessageSize int
+
+	// sseIdleTimeout aborts POST response streams silent for this long; zero disables
+	sseIdleTimeout time.Duration
+
+	// backendWait is how long Run waits for the upstream before reading the
+	// client, probing backendWaitURL if set or else the upstream's port;
+	// queueWhileDown reads and holds client messages meanwhile
+	backendWait    time.Duration
+	backendWaitURL string
+	queueWhileDown bool
+
+	// eagerInit is the handshake opened at startup that answers the client's initialize (--eager-init)
+	eagerInit *EagerInit
+
+	// listening marks a proxy serving one --listen connection; the shared
+	// upstream setup belongs to the listener's proxy
+	listening bool
+
+	// shadow mirrors client traffic to a second upstream and logs response differences
+	shadow *Shadow
+
+	// outage holds client messages while the upstream is unreachable (--outage-queue)
+	outage *OutageQueue
+
+	streams sync.WaitGroup // POST streams still being consumed in the background
+
+	// getStream keeps a standalone GET stream open for server-initiated messages
+	getStream       bool
+	getStreamMu     sync.Mutex
+	getStreamCancel context.CancelFunc
+
+	hooksMu       sync.Mutex
+	responseHooks map[string][]func(msg *JSONRPCMessage)
+}
+
+// JSONRPCMessage represents a JSON-RPC 2.0 message
+type JSONRPCMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+}
+
+// JSONRPCError represents a JSON-RPC error object
+type JSONRPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Error lets a JSON-RPC error be returned as a Go error
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// Run bridges client messages read from in to the upstream and writes the
+// responses and server-initiated messages to out. It returns nil at EOF on
+// in, or the cause of ctx's cancellation, which aborts in-flight requests,
+// response streams and health checks. A proxy runs only once.
+func (p *Proxy) Run(ctx context.Context, in io.Reader, out io.Writer) error {
+	p.stdout = &framedWriter{w: out, framing: p.framing}
+
+	// Cancelling ctx aborts everything still talking to the upstream
+	defer context.AfterFunc(ctx, func() { p.stop(context.Cause(ctx)) })()
+
+	// Messages over the limit are skipped and answered with an error
+	limit := p.messageLimit()
+	p.framing.maxSize = limit
+	p.framing.oversized = p.rejectOversized
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(newMessageBuffer(limit), limit+frameOverhead)
+	scanner.Split(p.framing.split)
+
+	// Requests are forwarded concurrently, at most maxConcurrent at a time
+	slots := make(chan struct{}, max(p.maxConcurrent, 1))
+	var handlers sync.WaitGroup
+
+	// Hold off on the client until the upstream is up (--wait-for-backend).
+	// With --queue-while-down input is read meanwhile and held in order.
+	ready := p.awaitBackend(p.baseContext())
+	var waiting <-chan struct{}
+	var queued []string
+	if p.queueWhileDown {
+		waiting = ready
+	} else {
+		select {
+		case <-ready:
+		case <-ctx.Done():
+		}
+	}
+
+	// Read input in the background so cancellation can interrupt the loop
+	lines := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(lines)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	dispatch := func(line string) {
+		// Answers to server requests go straight through, since a request
+		// holding a slot may be waiting for them. In sequential mode every
+		// other message waits for the slot, which keeps them in order.
+		if p.answersServerRequest(line) || (p.maxConcurrent > 1 && !isConcurrentRequest(line)) {
+			p.handleLine(line)
+			return
+		}
+
+		slots <- struct{}{}
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			defer func() { <-slots }()
+			p.handleLine(line)
+		}()
+	}
+	// Requests wait for the initialize handshake to finish
+	gate := newHandshakeGate(p, dispatch)
+	flush := func() {
+		if p.debug && len(queued) > 0 {
+			log.Printf("[INIT] Forwarding %d message(s) queued while the upstream was starting", len(queued))
+		}
+		for _, line := range queued {
+			gate.admit(line)
+		}
+		queued = nil
+	}
+
+	var stopped error
+	for stopped == nil {
+		var line string
+		select {
+		case <-ctx.Done():
+			stopped = context.Cause(ctx)
+			continue
+		case err := <-p.healthFailed:
+			log.Printf("[SHUTDOWN] Health recovery failed, shutting down: %v", err)
+			stopped = &healthFailedError{err: err}
+			continue
+		case <-waiting:
+			waiting = nil
+			flush()
+			continue
+		case next, ok := <-lines:
+			if !ok {
+				stopped = io.EOF
+				continue
+			}
+			line = next
+		}
+		if line == "" {
+			continue
+		}
+
+		if p.debug {
+			log.Printf("[STDIN] Received: %s", line)
+		}
+
+		if waiting != nil {
+			queued = p.queueMessage(queued, line)
+			continue
+		}
+		gate.admit(line)
+	}
+
+	// Input ended while messages were still queued for the upstream
+	if waiting != nil && stopped == io.EOF {
+		select {
+		case <-waiting:
+			flush()
+		case <-ctx.Done():
+		}
+	}
+
+	p.shutdown(&handlers)
+	// Background work such as health checks ends with Run
+	p.stop(stopped)
+
+	if stopped != io.EOF {
+		return stopped
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("stdin error: %w", err)
+	}
+
+	return nil
+}
+
+// streamDrainTimeout bounds how long shutdown waits for open POST streams
+const streamDrainTimeout = 5 * time.Second
+
+// drainStreams gives POST streams still open in the background a chance to
+// deliver their remaining messages before exit
+func (p *Proxy) drainStreams() {
+	done := make(chan struct{})
+	go func() {
+		p.streams.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(streamDrainTimeout):
+		if p.debug {
+			log.Printf("[SSE] Gave up waiting for open POST streams")
+		}
+	}
+}
+
+// isConcurrentRequest reports whether a client message may be handled
+// alongside others. Notifications and responses keep their order, and
+// initialize completes before anything that depends on its session.
+func isConcurrentRequest(line string) bool {
+	var msg JSONRPCMessage
+	if err := json.Unmarshal([]byte(line), &msg); err != nil {
+		return false
+	}
+	return msg.ID != nil && msg.Method != "" && msg.Method != "initialize"
+}
+
+// handleLine processes a single client message and writes any responses to stdout
+func (p *Proxy) handleLine(line string) {
+	// Parse JSON-RPC message
+	var msg JSONRPCMessage
+	if err := json.Unmarshal([]byte(line), &msg); err != nil {
+		log.Printf("[ERROR] Invalid JSON-RPC message: %v", err)
+		p.record(DirectionIn, []byte(line), "")
+		return
+	}
+
+	// Requests get a correlation ID tying together their logs, metrics and transcript entries
+	var correlationID string
+	var stats *requestStats
+	if msg.ID != nil && msg.Method != "" {
+		correlationID = newCorrelationID()
+		stats = p.trackRequest(&msg, correlationID)
+		if p.debug {
+			log.Printf("[STDIN] %s request %s has correlation ID %s", msg.Method, msg.ID, correlationID)
+		}
+	}
+	p.logMessage(DirectionIn, &msg, []byte(line), stats)
+
+	p.record(DirectionIn, []byte(line), correlationID)
+
+	// A bug while handling one message must not kill the bridge
+	defer p.recoverMessagePanic(&msg)
+
+	// Abort the upstream exchange of a cancelled request; the notification
+	// itself is still forwarded so the server stops working on it too
+	if msg.Method == "notifications/cancelled" {
+		var params struct {
+			RequestID json.RawMessage `json:"requestId"`
+		}
+		switch {
+		case json.Unmarshal(msg.Params, &params) != nil || params.RequestID == nil:
+		case p.requestCoalescer != nil && p.requestCoalescer.hasFollowers(params.RequestID):
+			// Identical requests merged into this one still want the result
+			if p.debug {
+				log.Printf("[CANCEL] Request %s is shared with identical requests, letting it finish", params.RequestID)
+			}
+		case p.cancelRequest(params.RequestID) && p.debug:
+			log.Printf("[CANCEL] Aborted in-flight request %s", params.RequestID)
+		}
+	}
+
+	// The client's answer to a server request goes to the session that asked
+	if msg.ID != nil && msg.Method == "" && !p.routeServerResponse(&msg) {
+		return
+	}
+
+	// Set up the upstream on the first message in lazy mode
+	if err := p.ensureConnected(); err != nil {
+		log.Printf("[ERROR] %v", err)
+		if msg.ID != nil && msg.Method != "" {
+			p.sendErrorResponse(msg.ID, -32603, fmt.Sprintf("Internal error: %v", err))
+		}
+		return
+	}
+
+	// Hold messages that hit a breakpoint until released via the control socket
+	if edited, forward := p.breakpoints.intercept(p.requestContext(stats), line, &msg); !forward {
+		// A request cancelled or cut short by shutdown while paused is
+		// settled like one cancelled upstream
+		if stats != nil && stats.ctx.Err() != nil {
+			p.finishRequest(msg.ID)
+			if p.baseContext().Err() != nil {
+				p.sendErrorResponse(msg.ID, -32603, "Internal error: proxy is shutting down")
+			}
+		}
+		return
+	} else if edited != line {
+		line = edited
+		msg = JSONRPCMessage{}
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			log.Printf("[ERROR] Invalid edited message: %v", err)
+			return
+		}
+	}
+
+	// Keep disabled client capabilities from reaching the server
+	if p.capabilityFilter != nil && msg.Method == "initialize" {
+		line = p.capabilityFilter.filterRequest(line, &msg)
+	}
+
+	// Remember the handshake so the session can be re-established later
+	if msg.Method == "initialize" {
+		p.sessionMu.Lock()
+		p.initParams = msg.Params
+		p.sessionMu.Unlock()
+		p.rememberClient(msg.Params)
+
+		// Pick up the session saved by an earlier run (--session-file) or
+		// opened at startup (--eager-init)
+		if p.resumeSession(&msg) || p.answerEagerInit(&msg) {
+			return
+		}
+	}
+	if msg.Method == "notifications/initialized" && p.initializedAlreadySent() {
+		return
+	}
+
+	// Answer from the recorded session in replay mode
+	if p.replay != nil {
+		p.serveReplay(&msg)
+		return
+	}
+
+	// Serve "always" fixtures without contacting the upstream
+	if rule := p.matchFixture(&msg, FixtureModeAlways); rule != nil {
+		p.serveFixture(rule, &msg)
+		return
+	}
+
+	// Reject tool calls whose arguments don't match the advertised inputSchema
+	if p.validator != nil && msg.Method == "tools/call" && msg.ID != nil {
+		if problem := p.validator.validate(&msg); problem != "" {
+			if p.debug {
+				log.Printf("[SCHEMA] Rejected %s: %s", paramsName(msg.Params), problem)
+			}
+			p.sendErrorResponse(msg.ID, -32602, "Invalid params: "+problem)
+			return
+		}
+	}
+
+	// Answer calls to the proxy's own status tool
+	if p.statusTool && p.serveStatusTool(&msg) {
+		return
+	}
+
+	// Answer reads of the proxy's own resources
+	if p.localResources != nil && p.serveLocalResource(&msg) {
+		return
+	}
+
+	// Refuse requests for capabilities disabled at the proxy
+	if p.capabilityFilter != nil && p.refuseDisabled(&msg) {
+		return
+	}
+
+	// Warn about calls the server never declared support for
+	p.checkCapability(&msg)
+
+	// Answer repeated read-only tool calls from the cache
+	if p.resultCache != nil && p.serveCached(&msg) {
+		return
+	}
+
+	// Answer repeated list requests until the server says the list changed
+	if p.listCache != nil && p.serveCachedList(&msg) {
+		return
+	}
+
+	// Share one upstream call between identical requests in flight together
+	if p.requestCoalescer != nil && p.joinInflight(&msg) {
+		return
+	}
+
+	// Hold or reject requests over the --rate limits
+	if p.rateLimiter != nil && p.throttle(&msg) {
+		return
+	}
+
+	// Route to the owning upstream(s) in aggregator mode
+	if p.aggregator != nil {
+		p.aggregator.handle(p, &msg)
+		return
+	}
+
+	// Answer resources/read from prefetched results, and prefetch links in tool results
+	if p.prefetcher != nil {
+		if msg.Method == "resources/read" && p.servePrefetched(&msg) {
+			return
+		}
+		if msg.Method == "tools/call" && msg.ID != nil {
+			p.onResponse(msg.ID, p.prefetchLinks)
+		}
+	}
+
+	// Mirror to the --shadow upstream for comparison
+	if p.shadow != nil {
+		p.shadow.mirror(p, line, &msg)
+	}
+
+	// Forward to HTTP endpoint
+	if err := p.forward(line, &msg); err != nil {
+		// Cancelled requests get no response, unless the proxy is stopping
+		if errors.Is(err, context.Canceled) {
+			p.finishRequest(msg.ID)
+			p.abandonShared(msg.ID)
+			if msg.ID != nil && msg.Method != "" && p.baseContext().Err() != nil {
+				p.sendErrorResponse(msg.ID, -32603, "Internal error: proxy is shutting down")
+				return
+			}
+			if p.debug {
+				log.Printf("[CANCEL] Request %s cancelled by the client", msg.ID)
+			}
+			return
+		}
+		if correlationID != "" {
+			log.Printf("[ERROR] Failed to forward message (cid=%s): %v", correlationID, err)
+		} else {
+			log.Printf("[ERROR] Failed to forward message: %v", err)
+		}
+		// Fall back to a fixture if one matches and the upstream couldn't be
+		// reached; its own rejections still go to the client
+		var open *circuitOpenError
+		if unreachable(err) || errors.As(err, &open) {
+			if rule := p.matchFixture(&msg, FixtureModeFallback); rule != nil {
+				p.serveFixture(rule, &msg)
+				return
+			}
+		}
+		// Send error response back to client; notifications and the
+		// client's responses to server requests are never answered
+		if msg.ID == nil || msg.Method == "" {
+			return
+		} else if errors.As(err, &open) {
+			p.sendErrorResponse(msg.ID, -32000, err.Error())
+		} else {
+			p.sendErrorResponse(msg.ID, -32603, fmt.Sprintf("Internal error: %v", err))
+		}
+	}
+}
+
+// ensureConnected runs deferred upstream setup once; a failed attempt is retried on the next message
+func (p *Proxy) ensureConnected() error {
+	p.connectMu.Lock()
+	defer p.connectMu.Unlock()
+
+	if p.connect == nil {
+		return nil
+	}
+	if err := p.connect(); err != nil {
+		return err
+	}
+	p.connect = nil
+
+	return nil
+}
+
+// forwardMessage sends a message to the HTTP endpoint and handles the response
+func (p *Proxy) forwardMessage(rawMessage string, msg *JSONRPCMessage) (err error) {
+	var lastErr error
+	maxRetries := 3
+	backoff := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}
+
+	var stats *requestStats
+	if msg.ID != nil && msg.Method != "" {
+		stats = p.trackedRequest(msg.ID)
+	}
+	correlationID := correlationOf(stats)
+	headers := p.requestHeaders(msg, correlationID)
+	ctx := p.requestContext(stats)
+
+	// Only requests expect a response; notifications and responses are acknowledged with 202
+	var requestID json.RawMessage
+	if msg.Method != "" {
+		requestID = msg.ID
+	}
+
+	if p.metrics != nil {
+		start := time.Now()
+		defer func() { p.metrics.observeRequest(msg.Method, time.Since(start), correlationID) }()
+	}
+	if p.breaker != nil && stats != nil {
+		if err := p.breaker.allow(); err != nil {
+			// A fallback --url takes over from an upstream the breaker gave up on
+			if !p.failOver(p.target(), err, msg.Method != "initialize") {
+				return err
+			}
+			if err := p.breaker.allow(); err != nil {
+				return err
+			}
+		}
+		defer func() {
+			switch {
+			case err == nil:
+				p.breaker.success()
+			case errors.Is(err, context.Canceled):
+				p.breaker.abandon()
+			case countsAsFailure(err):
+				p.breaker.failure(err)
+			default:
+				p.breaker.success()
+			}
+		}()
+	}
+	if p.tracer != nil && stats != nil {
+		span := p.tracer.start(msg, p.target())
+		headers.Set("traceparent", span.traceparent())
+		ctx = withSpan(ctx, span)
+		defer func() { p.tracer.finish(span, correlationID, stats.retries, err) }()
+	}
+
+	refusals := 0
+	failedURL := p.target()
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		if attempt > 0 {
+			if p.debug {
+				log.Printf("[RETRY] Attempt %d/%d after %v (cid=%s)", attempt+1, maxRetries, backoff[attempt-1], correlationID)
+			}
+			if p.metrics != nil {
+				p.metrics.observeRetry()
+			}
+			if stats != nil {
+				stats.retries++
+			}
+			p.retries.Add(1)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff[attempt-1]):
+			}
+		}
+
+		err := p.sendHTTPRequest(ctx, rawMessage, requestID, headers)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// The same response would be too large again, and a stalled
+		// server has already received the request
+		var tooLarge *messageTooLargeError
+		var idle *sseIdleError
+		if errors.As(err, &tooLarge) || errors.As(err, &idle) {
+			return err
+		}
+
+		lastErr = err
+		if p.debug {
+			log.Printf("[ERROR] Attempt %d failed: %v", attempt+1, err)
+		}
+		if connectionRefused(err) {
+			refusals++
+		}
+
+		// The upstream forgot the session, e.g. after a restart: open a new
+		// one and retry. A response can't follow, as the server request it
+		// answers died with the session.
+		var expired *sessionExpiredError
+		if errors.As(err, &expired) {
+			if msg.Method == "" {
+				return err
+			}
+			if msg.Method != "initialize" {
+				if err := p.reestablishSession(expired.session); err != nil {
+					return fmt.Errorf("%w; re-initializing failed: %v", expired, err)
+				}
+			}
+		}
+	}
+
+	// mcp-hub may have restarted on another port; follow it and try once more
+	if p.followHub && refusals == maxRetries {
+		if moved, err := p.rediscoverMcpHub(failedURL, msg.Method != "initialize"); err != nil {
+			log.Printf("[DISCOVERY] Rediscovering mcp-hub failed: %v", err)
+		} else if moved {
+			return p.sendHTTPRequest(ctx, rawMessage, requestID, headers)
+		}
+	}
+
+	// Try the next --url once the current one can't be reached
+	if unreachable(lastErr) && p.failOver(failedURL, lastErr, msg.Method != "initialize") {
+		return p.sendHTTPRequest(ctx, rawMessage, requestID, headers)
+	}
+
+	return fmt.Errorf("failed after %d attempts: %w", maxRetries, lastErr)
+}
+
+// sendHTTPRequest sends a single HTTP POST request with extra headers. id is
+// the request's JSON-RPC ID, or nil for notifications and responses;
+// cancelling ctx aborts the request and its response stream.
+func (p *Proxy) sendHTTPRequest(ctx context.Context, body string, id json.RawMessage, headers http.Header) error {
+	// Servers that haven't migrated take messages over HTTP+SSE
+	if p.legacy.inUse() {
+		_, err := p.legacyPost(ctx, body, id, headers, false)
+		return err
+	}
+
+	req, err := p.newPostRequest(body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+
+	// Send request
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+
+	p.captureSessionID(resp)
+	p.captureMetaHeaders(resp, id)
+	p.noteProtocol(resp.Proto)
+	if id != nil {
+		if stats := p.trackedRequest(id); stats != nil {
+			stats.status = resp.StatusCode
+		}
+	}
+	if span := spanFrom(ctx); span != nil {
+		span.setStatus(resp.StatusCode)
+	}
+	if resp.StatusCode < 400 {
+		p.legacy.streamableWorked()
+	}
+
+	// Notifications and responses are accepted with 202 and no body to parse
+	if id == nil && resp.StatusCode < 400 && (resp.StatusCode == http.StatusAccepted || resp.ContentLength == 0) {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return nil
+	}
+
+	// An SSE stream may outlive the response to this request; it closes the body itself
+	if resp.StatusCode < 400 {
+		sse, err := p.isSSEResponse(resp)
+		if err != nil {
+			resp.Body.Close()
+			return err
+		}
+		if sse {
+			return p.handleSSEResponse(ctx, resp.Body, id)
+		}
+	}
+	defer resp.Body.Close()
+
+	// Check for HTTP errors
+	if err := sessionExpired(req, resp); err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			warnClockSkew(resp)
+		}
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		err := &httpStatusError{code: resp.StatusCode, body: string(bodyBytes)}
+		if p.fallBackToLegacy(ctx, err) {
+			_, err := p.legacyPost(ctx, body, id, headers, false)
+			return err
+		}
+		return err
+	}
+
+	// Pass on whatever a server sent after a notification or response, but
+	// don't fail (and resend) it over a body the spec says isn't there
+	if id == nil {
+		if err := p.handleJSONResponse(resp.Body, resp.ContentLength); err != nil && p.debug {
+			log.Printf("[HTTP] Ignoring unexpected HTTP %d body after a notification or response: %v", resp.StatusCode, err)
+		}
+		return nil
+	}
+
+	return p.handleJSONResponse(resp.Body, resp.ContentLength)
+}
+
+// newPostRequest builds a POST to the upstream with protocol and session headers
+func (p *Proxy) newPostRequest(body string) (*http.Request, error) {
+	return p.newUpstreamRequest("POST", body, p.session())
+}
+
+// newUpstreamRequest builds a request to the upstream for an explicit session
+func (p *Proxy) newUpstreamRequest(method, body, sessionID string) (*http.Request, error) {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	target := p.target()
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set headers
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", p.acceptHeader())
+	p.setClientHeaders(req)
+
+	// Tell the server which protocol version the session negotiated
+	if version := p.negotiatedVersion(); version != "" {
+		req.Header.Set("MCP-Protocol-Version", version)
+	}
+
+	// Add session ID if we have one
+	if sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", sessionID)
+		if p.debug {
+			log.Printf("[HTTP] Using session ID: %s", sessionID)
+		}
+	}
+
+	if p.debug {
+		log.Printf("[HTTP] %s %s", method, target)
+	}
+
+	return req, nil
+}
+
+// baseContext returns the proxy's lifetime, or Background for a proxy created without one
+func (p *Proxy) baseContext() context.Context {
+	if p.lifetime == nil {
+		return context.Background()
+	}
+	return p.lifetime
+}
+
+// target returns the upstream URL, which changes when mcp-hub is rediscovered
+func (p *Proxy) target() string {
+	p.sessionMu.Lock()
+	defer p.sessionMu.Unlock()
+	return p.url
+}
+
+// session returns the current Mcp-Session-Id, or "" before initialization
+func (p *Proxy) session() string {
+	p.sessionMu.Lock()
+	defer p.sessionMu.Unlock()
+	return p.sessionID
+}
+
+// captureSessionID extracts the session ID from a response if none is established yet
+func (p *Proxy) captureSessionID(resp *http.Response) {
+	sessionID := resp.Header.Get("Mcp-Session-Id")
+	if sessionID == "" {
+		return
+	}
+
+	p.sessionMu.Lock()
+	defer p.sessionMu.Unlock()
+
+	if p.sessionID == "" {
+		p.sessionID = sessionID
+		if p.debug {
+			log.Printf("[SESSION] Established session ID: %s", sessionID)
+		}
+		// With --eager-init the stream waits for the client's handshake
+		if p.getStream && !p.eagerInit.holdsGetStream() {
+			p.startGetStream(sessionID)
+		}
+	}
+}
+
+// negotiatedVersion returns the protocol version negotiated by initialize
+func (p *Proxy) negotiatedVersion() string {
+	p.sessionMu.Lock()
+	defer p.sessionMu.Unlock()
+	return p.protocolVersion
+}
+
+// captureProtocolVersion remembers the protocol version from an initialize result
+func (p *Proxy) captureProtocolVersion(result json.RawMessage) {
+	var negotiated struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if json.Unmarshal(result, &negotiated) != nil || negotiated.ProtocolVersion == "" {
+		return
+	}
+
+	p.sessionMu.Lock()
+	p.protocolVersion = negotiated.ProtocolVersion
+	p.sessionMu.Unlock()
+	if p.debug {
+		log.Printf("[SESSION] Negotiated protocol version %s", negotiated.ProtocolVersion)
+	}
+}
+
+// handleJSONResponse handles a standard JSON response; size is the body's
+// Content-Length, or -1 if unknown
+func (p *Proxy) handleJSONResponse(body io.Reader, size int64) error {
+	limit := p.messageLimit()
+	if size > int64(limit) {
+		return &messageTooLargeError{limit: limit}
+	}
+
+	// Read into a buffer of the announced size so large bodies aren't regrown
+	var buf bytes.Buffer
+	if size > 0 {
+		buf.Grow(int(size) + bytes.MinRead)
+	}
+	if _, err := buf.ReadFrom(io.LimitReader(body, int64(limit)+1)); err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	data := buf.Bytes()
+	if len(data) > limit {
+		return &messageTooLargeError{limit: limit}
+	}
+
+	// Validate it's valid JSON
+	msg, err := p.decodeMessage(data)
+	if err != nil {
+		return fmt.Errorf("invalid JSON response: %w", err)
+	}
+
+	// Write to stdout
+	p.writeMessage(msg, data)
+	if p.debug {
+		log.Printf("[STDOUT] Sent JSON: %s", data)
+	}
+
+	return nil
+}
+
+// handleSSEResponse forwards a Server-Sent Events stream for a POST. It returns
+// once the response to request id has been delivered (or the stream ends);
+// the server may keep sending messages afterwards, so the rest of the stream
+// is consumed in the background.
+func (p *Proxy) handleSSEResponse(ctx context.Context, body io.ReadCloser, id json.RawMessage) error {
+	delivered := make(chan struct{})
+	finished := make(chan error, 1)
+	var once sync.Once
+
+	// The broker swaps stdout per client; keep writing to this request's client
+	out := p.stdout
+
+	p.streams.Add(1)
+	go func() {
+		defer p.streams.Done()
+		defer body.Close()
+		defer recoverPanic("POST stream")
+
+		isDelivered := func() bool {
+			select {
+			case <-delivered:
+				return true
+			default:
+				return false
+			}
+		}
+		onData := func(data []byte) {
+			msg, err := p.writeSSEData(out, data)
+			if err != nil {
+				log.Printf("[ERROR] Failed to write SSE data: %v", err)
+				return
+			}
+			if id != nil && msg.Method == "" && string(msg.ID) == string(id) {
+				once.Do(func() { close(delivered) })
+			}
+		}
+
+		stream, stop := p.watchStream(body)
+		var cursor sseCursor
+		err := p.readSSE(stream, &cursor, onData)
+		stop()
+
+		// A stream that dropped before the response can be resumed from its last event
+		for attempt := 1; id != nil && !isDelivered() && cursor.lastEventID != "" && ctx.Err() == nil && attempt <= sseResumeAttempts; attempt++ {
+			log.Printf("[SSE] Stream ended before the response, resuming after event %s (attempt %d/%d)", cursor.lastEventID, attempt, sseResumeAttempts)
+			err = p.resumeSSE(ctx, &cursor, onData)
+			if err != nil && !isDelivered() {
+				time.Sleep(cursor.reconnectDelay(time.Duration(attempt) * getStreamMinBackoff))
+			}
+		}
+		finished <- err
+	}()
+
+	select {
+	case err := <-finished:
+		return err
+	case <-delivered:
+		if p.debug {
+			log.Printf("[SSE] Response delivered, consuming the rest of the stream in the background")
+		}
+		return nil
+	}
+}
+
+// sseCursor carries what a Server-Sent Events stream told the client
+// across reconnects: the last event ID to resume after and the server's
+// requested reconnection delay
+type sseCursor struct {
+	lastEventID string
+	retry       time.Duration // Zero until the server sends a retry: field
+}
+
+// readSSE parses a Server-Sent Events stream and calls onData for the data
+// of each message event, which onData may keep. cursor, if not nil, is
+// updated with the stream's event IDs and retry interval.
+func (p *Proxy) readSSE(body io.Reader, cursor *sseCursor, onData func(data []byte)) error {
+	return p.readSSEEvents(body, cursor, func(eventType string, data []byte) {
+		// JSON-RPC messages arrive as "message" events, the default type;
+		// other event types aren't part of MCP
+		if eventType != "" && eventType != "message" {
+			if p.debug {
+				log.Printf("[SSE] Ignoring %q event: %s", eventType, data)
+			}
+			return
+		}
+		onData(data)
+	})
+}
+
+// readSSEEvents is readSSE for events of every type, which onEvent gets
+// along with their data
+func (p *Proxy) readSSEEvents(body io.Reader, cursor *sseCursor, onEvent func(eventType string, data []byte)) error {
+	limit := p.messageLimit()
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(newMessageBuffer(limit), limit+frameOverhead)
+	var data []byte
+	hasData := false
+	eventType := ""
+	eventID := ""
+	if cursor != nil {
+		eventID = cursor.lastEventID
+	}
+
+	dispatch := func() {
+		if cursor != nil {
+			cursor.lastEventID = eventID
+		}
+		if hasData {
+			onEvent(eventType, data)
+		}
+		data, hasData, eventType = nil, false, ""
+	}
+
+	for scanner.Scan() {
+		raw := scanner.Bytes()
+
+		// An empty line ends the event
+		if len(raw) == 0 {
+			dispatch()
+			continue
+		}
+		if raw[0] == ':' {
+			if p.debug {
+				log.Printf("[SSE] Comment: %s", raw)
+			}
+			continue
+		}
+
+		// "field: value", the space after the colon being optional
+		field, value, _ := bytes.Cut(raw, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+		switch string(field) {
+		case "data":
+			// Data is collected as bytes, never converted to strings;
+			// multiple lines join with newlines
+			if hasData {
+				data = append(data, '\n')
+			}
+			data = append(data, value...)
+			hasData = true
+			if len(data) > limit {
+				return &messageTooLargeError{limit: limit}
+			}
+		case "id":
+			// Remembered for resuming the stream with Last-Event-ID
+			if !bytes.Contains(value, []byte{0}) {
+				eventID = string(value)
+			}
+		case "event":
+			eventType = string(value)
+		case "retry":
+			if ms, err := strconv.ParseUint(string(value), 10, 31); err == nil && cursor != nil {
+				cursor.retry = time.Duration(ms) * time.Millisecond
+				if p.debug {
+					log.Printf("[SSE] Server asks to reconnect after %v", cursor.retry)
+				}
+			}
+		}
+	}
+
+	// Process any remaining data
+	if hasData {
+		dispatch()
+	}
+
+	return p.tooLarge(scanner.Err())
+}
+
+// writeSSEData writes SSE data to out and returns the parsed message
+func (p *Proxy) writeSSEData(out io.Writer, data []byte) (*JSONRPCMessage, error) {
+	// Validate it's valid JSON
+	msg, err := p.decodeMessage(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON in SSE data: %w", err)
+	}
+
+	// Write to stdout
+	p.observeServerMessage(msg)
+	p.writeMessageTo(out, msg, data)
+	if p.debug {
+		log.Printf("[STDOUT] Sent SSE data: %s", data)
+	}
+
+	return msg, nil
+}
+
+// largeMessageSize is the size from which upstream responses are only
+// validated, not decoded, unless the proxy looks into their result
+const largeMessageSize = 256 * 1024
+
+// messageEnvelope is a JSON-RPC message without its params and result
+type messageEnvelope struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+}
+
+// decodeMessage parses a message from the upstream. The result of a large
+// response nothing inspects is left undecoded, so a multi-megabyte payload
+// isn't held twice on its way to the client.
+func (p *Proxy) decodeMessage(data []byte) (*JSONRPCMessage, error) {
+	if len(data) >= largeMessageSize {
+		var envelope messageEnvelope
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return nil, err
+		}
+		if envelope.ID != nil && envelope.Method == "" && !p.inspectsResult(envelope.ID) {
+			return &JSONRPCMessage{
+				JSONRPC: envelope.JSONRPC,
+				ID:      envelope.ID,
+				Error:   envelope.Error,
+			}, nil
+		}
+	}
+
+	var msg JSONRPCMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// inspectsResult reports whether anything in writeMessageTo or a response
+// hook needs the decoded result of the response to request id
+func (p *Proxy) inspectsResult(id json.RawMessage) bool {
+	stats := p.trackedRequest(id)
+	if stats == nil || stats.headers != nil || p.annotate {
+		return true
+	}
+
+	p.hooksMu.Lock()
+	hooked := len(p.responseHooks[string(id)]) > 0
+	p.hooksMu.Unlock()
+	if hooked {
+		return true
+	}
+
+	switch stats.method {
+	case "initialize":
+		return true
+	case "tools/call":
+		return p.resolveLinks || p.usageStats != nil
+	case "tools/list":
+		return p.validator != nil || p.statusTool
+	case "resources/list":
+		return p.localResources != nil
+	}
+	return false
+}
+
+// sendErrorResponse sends a JSON-RPC error response to stdout
+func (p *Proxy) sendErrorResponse(id json.RawMessage, code int, message string) {
+	errResp := JSONRPCMessage{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error: &JSONRPCError{
+			Code:    code,
+			Message: message,
+		},
+	}
+
+	data, err := json.Marshal(errResp)
+	if err != nil {
+		log.Printf("[ERROR] Failed to marshal error response: %v", err)
+		return
+	}
+
+	p.writeMessage(&errResp, data)
+	if p.debug {
+		log.Printf("[STDOUT] Sent error: %s", data)
+	}
+}
+
+// sendLogMessage sends the client a notifications/message from the proxy itself
+func (p *Proxy) sendLogMessage(level, message string) {
+	params, _ := json.Marshal(map[string]string{
+		"level":  level,
+		"logger": "mcp-stdio-proxy",
+		"data":   message,
+	})
+	note := JSONRPCMessage{JSONRPC: "2.0", Method: "notifications/message", Params: params}
+	data, err := json.Marshal(note)
+	if err != nil {
+		return
+	}
+	p.writeMessage(&note, data)
+}
+
+// writeMessage writes a message to stdout and notifies any hook waiting for its response
+func (p *Proxy) writeMessage(msg *JSONRPCMessage, data []byte) {
+	p.writeMessageTo(p.stdout, msg, data)
+}
+
+// writeMessageTo is writeMessage for an explicit client writer
+func (p *Proxy) writeMessageTo(out io.Writer, msg *JSONRPCMessage, data []byte) {
+	var stats *requestStats
+	if msg.ID != nil && msg.Method == "" {
+		stats = p.finishRequest(msg.ID)
+	}
+	if p.resolveLinks && stats != nil && stats.method == "tools/call" {
+		data = p.resolveResourceLinks(msg, data, stats.tool)
+	}
+	if p.annotate && stats != nil {
+		data = p.annotateResponse(msg, data, stats)
+	}
+	if stats != nil && stats.headers != nil {
+		data = withResultMeta(msg, data, "upstreamHeaders", stats.headers)
+	}
+	if p.listCache != nil && msg.Method != "" && msg.ID == nil {
+		p.listCache.invalidate(msg.Method)
+	}
+	if p.validator != nil {
+		if stats != nil && stats.method == "tools/list" && msg.Result != nil {
+			p.validator.learn(msg.Result)
+		} else if msg.Method == "notifications/tools/list_changed" {
+			p.validator.forget()
+		}
+	}
+	if stats != nil && stats.method == "initialize" && msg.Result != nil {
+		p.captureProtocolVersion(msg.Result)
+		p.saveSession(msg.Result)
+	}
+	if p.usageStats != nil && stats != nil && stats.method == "tools/call" {
+		p.observeToolCall(stats.tool, time.Since(stats.start), msg)
+	}
+	if p.statusTool && stats != nil {
+		switch stats.method {
+		case "tools/list":
+			data = listStatusTool(msg, data)
+		case "initialize":
+			data = advertiseTools(msg, data)
+		}
+	}
+	if p.localResources != nil && stats != nil {
+		switch stats.method {
+		case "resources/list":
+			data = p.listLocalResources(msg, data)
+		case "initialize":
+			data = advertiseResources(msg, data)
+		}
+	}
+	if p.capabilityFilter != nil && stats != nil && stats.method == "initialize" {
+		data = p.capabilityFilter.filterResult(msg, data)
+	}
+
+	p.logMessage(DirectionOut, msg, data, stats)
+
+	// Hold change notifications so bursts of duplicates can be merged
+	if p.coalescer != nil && msg.ID == nil && coalescable(msg.Method) {
+		p.coalesceNotification(out, msg, data)
+		return
+	}
+
+	p.writeLine(out, data, correlationOf(stats))
+
+	if stats != nil && stats.method == "initialize" {
+		p.probeCapabilities(msg)
+	}
+
+	if p.metrics != nil && msg.Error != nil {
+		p.metrics.observeError(msg.Error.Code)
+	}
+
+	if msg.ID != nil && msg.Method == "" {
+		p.hooksMu.Lock()
+		hooks := p.responseHooks[string(msg.ID)]
+		delete(p.responseHooks, string(msg.ID))
+		p.hooksMu.Unlock()
+
+		for _, hook := range hooks {
+			hook(msg)
+		}
+	}
+}
+
+// writeLine writes one message line to a client and records it
+func (p *Proxy) writeLine(out io.Writer, data []byte, correlationID string) {
+	func() {
+		p.writeMu.Lock()
+		defer p.writeMu.Unlock()
+		writeFrame(out, data)
+	}()
+
+	p.record(DirectionOut, data, correlationID)
+}
+
+// record copies a message to the transcript and tee, if enabled
+func (p *Proxy) record(direction string, data []byte, correlationID string) {
+	if p.recorder != nil {
+		p.recorder.Record(direction, data, correlationID)
+	}
+	if p.tee != nil {
+		p.tee.Record(direction, data, correlationID)
+	}
+}
+
+// onResponse registers a hook called once when the response with the given ID is written
+func (p *Proxy) onResponse(id json.RawMessage, hook func(msg *JSONRPCMessage)) {
+	p.hooksMu.Lock()
+	defer p.hooksMu.Unlock()
+
+	if p.responseHooks == nil {
+		p.responseHooks = make(map[string][]func(msg *JSONRPCMessage))
+	}
+	p.responseHooks[string(id)] = append(p.responseHooks[string(id)], hook)
+}
+
+// McpHubInstance represents a discovered mcp-hub process
+type McpHubInstance struct {
+	Port        string
+	ConfigFiles []string
+	CommandLine string
+	PID         string
+	ConfigPath  string // Primary config path for display
+}
+
+// discoverMcpHubInstance attempts to find the mcp-hub instance with full details
+func discoverMcpHubInstance(debug bool) (*McpHubInstance, error) {
+	if debug {
+		// Print current working directory
+		cwd, err := os.Getwd()
+		if err != nil {
+			log.Printf("[DISCOVERY] Warning: Could not get current working directory: %v", err)
+		} else {
+			log.Printf("[DISCOVERY] Current working directory: %s", cwd)
+		}
+		log.Printf("[DISCOVERY] Attempting to discover mcp-hub port...")
+	}
+
+	// Strategy 1: Read running hubs from mcp-hub's state file
+	instances, err := findMcpHubInstancesFromState(debug)
+	if err != nil {
+		if debug {
+			log.Printf("[DISCOVERY] State file lookup failed: %v", err)
+		}
+		// Strategy 2: Try to find mcp-hub in process list with --port argument
+		instances, err = findAllMcpHubInstances(debug)
+	}
+	if err == nil && len(instances) > 0 {
+		if instances, err = mcpHubSelection.filter(instances); err != nil {
+			return nil, err
+		}
+		if debug {
+			log.Printf("[DISCOVERY] Found %d mcp-hub instance(s):", len(instances))
+			for i, inst := range instances {
+				log.Printf("[DISCOVERY] Instance %d:", i+1)
+				log.Printf("[DISCOVERY]   PID: %s", inst.PID)
+				log.Printf("[DISCOVERY]   Port: %s", inst.Port)
+				log.Printf("[DISCOVERY]   Config files: %v", inst.ConfigFiles)
+				log.Printf("[DISCOVERY]   Command: %s", inst.CommandLine)
+			}
+		}
+
+		// Select best instance based on project-local configs
+		cwd, err := os.Getwd()
+		if err != nil {
+			cwd = "" // Fall back to first instance if we can't get CWD
+		}
+		var selected *McpHubInstance
+		if mcpHubSelection.prompt && len(instances) > 1 {
+			if selected, err = promptForMcpHubInstance(rankMcpHubInstances(instances, cwd, debug)); err != nil {
+				return nil, err
+			}
+		} else {
+			selected = selectBestMcpHubInstance(instances, cwd, debug)
+		}
+
+		// Set primary config path for display
+		if len(selected.ConfigFiles) > 0 {
+			// Use the last (most specific) config file
+			selected.ConfigPath = selected.ConfigFiles[len(selected.ConfigFiles)-1]
+
+			// Replace $HOME with ~/ for shorter ps output
+			if homeDir, err := os.UserHomeDir(); err == nil && homeDir != "" {
+				selected.ConfigPath = strings.Replace(selected.ConfigPath, homeDir, "~", 1)
+			}
+		}
+
+		return selected, nil
+	}
+	if debug {
+		log.Printf("[DISCOVERY] Process list search failed: %v", err)
+	}
+
+	// Strategy 3: Try to find listening port using ss/netstat (fallback, no config info)
+	port, err := findPortInNetstat(debug)
+	if err == nil && mcpHubSelection.active() && !mcpHubSelection.matches(&McpHubInstance{Port: port}) {
+		err = fmt.Errorf("found mcp-hub on port %s, which doesn't match the --mcp-hub-pid/--mcp-hub-port/--mcp-hub-config selection", port)
+	}
+	if err == nil {
+		return &McpHubInstance{
+			Port:       port,
+			ConfigPath: "unknown",
+		}, nil
+	}
+	if debug {
+		log.Printf("[DISCOVERY] Network socket search failed: %v", err)
+	}
+
+	return nil, fmt.Errorf("could not discover mcp-hub port")
+}
+
+// processInfo is one entry of the system process list
+type processInfo struct {
+	PID         string
+	CommandLine string
+}
+
+// findAllMcpHubInstances searches for all mcp-hub processes and returns their details
+func findAllMcpHubInstances(debug bool) ([]McpHubInstance, error) {
+	processes, err := listProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []McpHubInstance
+	portRegex := regexp.MustCompile(`--port[= ](\d+)`)
+	configRegex := regexp.MustCompile(`--config[= ](?:"([^"]+)"|(\S+))`)
+
+	for _, process := range processes {
+		line := process.CommandLine
+		if !strings.Contains(line, "mcp-hub") {
+			continue
+		}
+		// Skip grep processes
+		if strings.Contains(line, "grep") {
+			continue
+		}
+
+		// Extract port from --port argument
+		portMatches := portRegex.FindStringSubmatch(line)
+		if len(portMatches) < 2 {
+			continue
+		}
+		port := portMatches[1]
+
+		// Extract all --config arguments, quoted (Windows paths with spaces) or not
+		var configFiles []string
+		configMatches := configRegex.FindAllStringSubmatch(line, -1)
+		for _, match := range configMatches {
+			configFiles = append(configFiles, match[1]+match[2])
+		}
+
+		instances = append(instances, McpHubInstance{
+			Port:        port,
+			ConfigFiles: configFiles,
+			CommandLine: line,
+			PID:         process.PID,
+		})
+	}
+
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no mcp-hub processes found in process list")
+	}
+
+	return instances, nil
+}
+
+// selectBestMcpHubInstance chooses the best mcp-hub instance based on project-local configs
+func selectBestMcpHubInstance(instances []McpHubInstance, cwd string, debug bool) *McpHubInstance {
+	if len(instances) == 0 {
+		return nil
+	}
+
+	// If only one instance, return it
+	if len(instances) == 1 {
+		if debug {
+			log.Printf("[DISCOVERY] Only one instance found, selecting port %s", instances[0].Port)
+		}
+		return &instances[0]
+	}
+
+	scored := rankMcpHubInstances(instances, cwd, debug)
+	if debug {
+		log.Printf("[DISCOVERY] Selected instance with port %s", scored[0].instance.Port)
+	}
+
+	return scored[0].instance
+}
+
+// scoredInstance is an mcp-hub instance with its selection priority
+type scoredInstance struct {
+	instance *McpHubInstance
+	score    int
+	reason   string
+}
+
+// rankMcpHubInstances scores instances by how closely their configs relate to cwd, best first
+func rankMcpHubInstances(instances []McpHubInstance, cwd string, debug bool) []scoredInstance {
+	var scored []scoredInstance
+
+	for i := range instances {
+		inst := &instances[i]
+		score, reason := scoreInstance(inst, cwd, debug)
+		scored = append(scored, scoredInstance{
+			instance: inst,
+			score:    score,
+			reason:   reason,
+		})
+	}
+
+	// Sort by score (highest first)
+	sort.Slice(scored, func(i, j int) bool {
+		return scored[i].score > scored[j].score
+	})
+
+	if debug {
+		log.Printf("[DISCOVERY] Instance scoring:")
+		for i, s := range scored {
+			log.Printf("[DISCOVERY]   Instance %d (port %s): score=%d - %s",
+				i+1, s.instance.Port, s.score, s.reason)
+		}
+	}
+
+	return scored
+}
+
+// scoreInstance calculates a priority score for an mcp-hub instance
+func scoreInstance(inst *McpHubInstance, cwd string, debug bool) (int, string) {
+	if cwd == "" {
+		return 0, "no CWD available, using default priority"
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = ""
+	}
+
+	var globalConfigPath string
+	if homeDir != "" {
+		globalConfigPath = filepath.Join(homeDir, ".mcp-hub")
+	}
+
+	maxScore := 0
+	bestReason := "global config only"
+
+	for _, configPath := range inst.ConfigFiles {
+		// Skip global configs
+		if globalConfigPath != "" && strings.HasPrefix(configPath, globalConfigPath) {
+			continue
+		}
+
+		// Get the directory of the config file
+		configDir := filepath.Dir(configPath)
+
+		// Calculate how closely related the config is to CWD
+		commonLength := commonPathLength(cwd, configDir)
+
+		// Award points: more common path components = higher score
+		score := commonLength * 100
+
+		// Bonus points if config is in a parent directory (typical project structure)
+		if strings.HasPrefix(cwd, configDir) {
+			score += 50
+		}
+
+		// Bonus points if config is in a child directory
+		if strings.HasPrefix(configDir, cwd) {
+			score += 25
+		}
+
+		if score > maxScore {
+			maxScore = score
+			bestReason = fmt.Sprintf("project-local config at %s (common path length: %d)", configPath, commonLength)
+		}
+	}
+
+	return maxScore, bestReason
+}
+
+// commonPathLength calculates the number of common path components between two paths
+func commonPathLength(path1, path2 string) int {
+	// Clean and split paths
+	p1 := filepath.Clean(path1)
+	p2 := filepath.Clean(path2)
+
+	parts1 := strings.Split(p1, string(filepath.Separator))
+	parts2 := strings.Split(p2, string(filepath.Separator))
+
+	// Count common prefix parts
+	common := 0
+	for i := 0; i < len(parts1) && i < len(parts2); i++ {
+		if parts1[i] == parts2[i] {
+			common++
+		} else {
+			break
+		}
+	}
+
+	return common
+}
@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate policies for requests over a --rate limit
+const (
+	RatePolicyQueue = "queue" // Hold the request until the limit allows it
+	RatePolicyError = "error" // Answer the request with a rate limit error
+)
+
+// rateLimitedCode is the JSON-RPC error code of requests rejected by --rate-policy error
+const rateLimitedCode = -32029
+
+// RateLimiter throttles requests forwarded upstream with token buckets: one
+// for all requests and one per limited method (--rate). A bucket holds up to
+// one period's worth of requests, so bursts up to the limit pass at once.
+type RateLimiter struct {
+	policy string
+
+	mu      sync.Mutex
+	all     *tokenBucket
+	methods map[string]*tokenBucket
+}
+
+// tokenBucket refills at rate tokens per second up to burst
+type tokenBucket struct {
+	spec   string // The limit as given, for errors and logs
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// rateLimitedError is returned for a request over its limit under the error policy
+type rateLimitedError struct {
+	method     string
+	limit      string
+	retryAfter time.Duration
+}
+
+// Error implements error
+func (e *rateLimitedError) Error() string {
+	return fmt.Sprintf("%s limited to %s, retry in %v", e.method, e.limit, e.retryAfter.Round(time.Millisecond))
+}
+
+// NewRateLimiter creates a rate limiter from --rate specs, each "N/period"
+// for all requests or "method=N/period" for one method, where period is s,
+// m, h or a duration such as 100ms
+func NewRateLimiter(specs []string, policy string) (*RateLimiter, error) {
+	switch policy {
+	case "":
+		policy = RatePolicyQueue
+	case RatePolicyQueue, RatePolicyError:
+	default:
+		return nil, fmt.Errorf("invalid rate policy %q (expected %s or %s)", policy, RatePolicyQueue, RatePolicyError)
+	}
+
+	r := &RateLimiter{policy: policy, methods: make(map[string]*tokenBucket)}
+	for _, spec := range specs {
+		method, limit := "", spec
+		if i := strings.LastIndex(spec, "="); i >= 0 {
+			method, limit = strings.TrimSpace(spec[:i]), spec[i+1:]
+			if method == "" {
+				return nil, fmt.Errorf("invalid rate %q: empty method", spec)
+			}
+		}
+		bucket, err := parseRate(strings.TrimSpace(limit))
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate %q: %w", spec, err)
+		}
+		if method == "" {
+			r.all = bucket
+		} else {
+			r.methods[method] = bucket
+		}
+	}
+	return r, nil
+}
+
+// parseRate parses "N/period" into a full bucket
+func parseRate(limit string) (*tokenBucket, error) {
+	count, period, ok := strings.Cut(limit, "/")
+	if !ok {
+		return nil, fmt.Errorf("expected N/s, N/m, N/h or N/<duration>")
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("count must be a positive integer")
+	}
+
+	var d time.Duration
+	switch period {
+	case "s":
+		d = time.Second
+	case "m":
+		d = time.Minute
+	case "h":
+		d = time.Hour
+	default:
+		if d, err = time.ParseDuration(period); err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid period %q", period)
+		}
+	}
+
+	return &tokenBucket{
+		spec:   limit,
+		rate:   float64(n) / d.Seconds(),
+		burst:  float64(n),
+		tokens: float64(n),
+		last:   time.Now(),
+	}, nil
+}
+
+// refill adds the tokens accumulated since the last call
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// wait returns how long until the bucket has a token
+func (b *tokenBucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// reserve takes a token for a request from every bucket that applies and
+// returns how long the request must wait before going upstream. Under the
+// error policy a request that would have to wait takes nothing and gets a
+// *rateLimitedError instead.
+func (r *RateLimiter) reserve(method string) (time.Duration, *rateLimitedError) {
+	var buckets []*tokenBucket
+	if r.all != nil {
+		buckets = append(buckets, r.all)
+	}
+	if bucket := r.methods[method]; bucket != nil {
+		buckets = append(buckets, bucket)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var delay time.Duration
+	var limit string
+	for _, b := range buckets {
+		b.refill(now)
+		if wait := b.wait(); wait > delay {
+			delay, limit = wait, b.spec
+		}
+	}
+	if delay > 0 && r.policy == RatePolicyError {
+		return 0, &rateLimitedError{method: method, limit: limit, retryAfter: delay}
+	}
+
+	// Queued requests take their token now, leaving the bucket in debt so
+	// later ones queue behind them
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return delay, nil
+}
+
+// throttle applies --rate to a request about to go upstream, holding it or
+// answering it with an error, and reports whether the request was handled
+// here. The handshake is never limited.
+func (p *Proxy) throttle(msg *JSONRPCMessage) bool {
+	if msg.ID == nil || msg.Method == "" || msg.Method == "initialize" {
+		return false
+	}
+
+	delay, limited := p.rateLimiter.reserve(msg.Method)
+	if limited != nil {
+		if p.debug {
+			log.Printf("[RATE] Rejected %s %s: %v", msg.Method, msg.ID, limited)
+		}
+		p.sendRateLimited(msg.ID, limited)
+		return true
+	}
+	if delay == 0 {
+		return false
+	}
+
+	if p.debug {
+		log.Printf("[RATE] Holding %s %s for %v", msg.Method, msg.ID, delay.Round(time.Millisecond))
+	}
+	p.sendProgress(msg, fmt.Sprintf("Rate limited, forwarding in %v", delay.Round(time.Millisecond)))
+
+	ctx := p.requestContext(p.trackedRequest(msg.ID))
+	select {
+	case <-time.After(delay):
+		return false
+	case <-ctx.Done():
+	}
+
+	// Cancelled while held: no response, unless the proxy is stopping
+	p.finishRequest(msg.ID)
+	p.abandonShared(msg.ID)
+	if p.baseContext().Err() != nil {
+		p.sendErrorResponse(msg.ID, -32603, "Internal error: proxy is shutting down")
+	} else if p.debug {
+		log.Printf("[CANCEL] Request %s cancelled by the client while rate limited", msg.ID)
+	}
+	return true
+}
+
+// sendRateLimited answers a request with a rate limit error whose data says
+// which limit applied and when to retry
+func (p *Proxy) sendRateLimited(id json.RawMessage, limited *rateLimitedError) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"method":       limited.method,
+		"limit":        limited.limit,
+		"retryAfterMs": limited.retryAfter.Milliseconds() + 1,
+	})
+	resp := JSONRPCMessage{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error: &JSONRPCError{
+			Code:    rateLimitedCode,
+			Message: "Rate limit exceeded: " + limited.Error(),
+			Data:    data,
+		},
+	}
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("[ERROR] Failed to marshal error response: %v", err)
+		return
+	}
+	p.writeMessage(&resp, raw)
+}
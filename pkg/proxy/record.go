@@ -0,0 +1,265 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recording directions
+const (
+	DirectionIn  = "in"  // Client -> proxy (stdin)
+	DirectionOut = "out" // Proxy -> client (stdout)
+)
+
+// RecordEntry is one line of a transcript
+type RecordEntry struct {
+	Time          time.Time       `json:"ts"`
+	Direction     string          `json:"dir"`
+	CorrelationID string          `json:"cid,omitempty"` // Set on requests and their responses
+	Message       json.RawMessage `json:"msg,omitempty"`
+	Raw           string          `json:"raw,omitempty"` // A line that isn't valid JSON, verbatim
+}
+
+// newRecordEntry builds a transcript entry for a message. Malformed client
+// input is kept verbatim in raw, since it's often what reproduces a bug.
+func newRecordEntry(direction string, data []byte, correlationID string) RecordEntry {
+	entry := RecordEntry{
+		Time:          time.Now().UTC(),
+		Direction:     direction,
+		CorrelationID: correlationID,
+	}
+	if json.Valid(data) {
+		entry.Message = json.RawMessage(data)
+	} else {
+		entry.Raw = string(data)
+	}
+	return entry
+}
+
+// RecorderOptions controls compression and rotation of transcripts
+type RecorderOptions struct {
+	Gzip    bool          // Compress the transcript with gzip
+	MaxSize int64         // Rotate after this many bytes on disk, compressed with Gzip (0 = never)
+	MaxAge  time.Duration // Rotate after the file has been open this long (0 = never)
+	Keep    int           // Number of rotated files to retain (0 = keep all)
+}
+
+// Recorder appends traffic to a JSONL transcript with optional gzip and rotation
+type Recorder struct {
+	mu      sync.Mutex
+	path    string
+	opts    RecorderOptions
+	file    *os.File
+	gz      *gzip.Writer
+	out     io.Writer
+	written int64 // Bytes in the active file on disk
+	opened  time.Time
+}
+
+// countingWriter counts the bytes written through it to the file, beneath
+// any compression
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+// Write implements io.Writer
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+// NewRecorder opens (or appends to) a transcript file
+func NewRecorder(path string, opts RecorderOptions) (*Recorder, error) {
+	r := &Recorder{path: path, opts: opts}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the active transcript file; callers must hold r.mu (or own r exclusively)
+func (r *Recorder) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open transcript: %w", err)
+	}
+
+	r.file = file
+	r.written = 0
+	r.opened = time.Now()
+
+	// Count existing content so appending doesn't postpone size rotation
+	if info, err := file.Stat(); err == nil {
+		r.written = info.Size()
+	}
+	r.out = countingWriter{w: file, n: &r.written}
+
+	// Concatenated gzip members are valid, so appending a new member is safe
+	if r.opts.Gzip {
+		r.gz = gzip.NewWriter(r.out)
+		r.out = r.gz
+	}
+
+	return nil
+}
+
+// closeFile flushes and closes the active file; callers must hold r.mu
+func (r *Recorder) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+	if r.gz != nil {
+		if err := r.gz.Close(); err != nil {
+			r.file.Close()
+			return err
+		}
+		r.gz = nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// Record appends one message to the transcript
+func (r *Recorder) Record(direction string, data []byte, correlationID string) {
+	line, err := json.Marshal(newRecordEntry(direction, data, correlationID))
+	if err != nil {
+		log.Printf("[RECORD] Failed to encode entry: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return
+	}
+
+	// The compressed size of an entry is only known once it is written, so
+	// a compressed transcript rotates after the entry crossing the limit
+	next := int64(len(line))
+	if r.gz != nil {
+		next = 0
+	}
+	if r.shouldRotate(next) {
+		if err := r.rotate(); err != nil {
+			log.Printf("[RECORD] Failed to rotate transcript: %v", err)
+			if r.file == nil {
+				return
+			}
+		}
+	}
+
+	if _, err := r.out.Write(line); err != nil {
+		log.Printf("[RECORD] Failed to write transcript: %v", err)
+		return
+	}
+	if r.gz != nil {
+		// Flush per entry so the transcript is readable while still recording
+		r.gz.Flush()
+	}
+}
+
+// shouldRotate reports whether the next write should go to a fresh file
+func (r *Recorder) shouldRotate(next int64) bool {
+	if r.written == 0 {
+		return false
+	}
+	if r.opts.MaxSize > 0 && r.written+next > r.opts.MaxSize {
+		return true
+	}
+	if r.opts.MaxAge > 0 && time.Since(r.opened) >= r.opts.MaxAge {
+		return true
+	}
+	return false
+}
+
+// rotate moves the active file aside, opens a new one and prunes old files
+func (r *Recorder) rotate() error {
+	if err := r.closeFile(); err != nil {
+		return err
+	}
+
+	prefix, suffix := rotationParts(r.path)
+	rotated := prefix + "-" + time.Now().Format(rotationLayout) + suffix
+	if err := os.Rename(r.path, rotated); err != nil {
+		// Keep recording into the same file rather than losing traffic
+		if openErr := r.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("failed to rename transcript: %w", err)
+	}
+
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	if r.opts.Keep > 0 {
+		old := rotatedFiles(prefix, suffix)
+		for len(old) > r.opts.Keep {
+			os.Remove(old[0])
+			old = old[1:]
+		}
+	}
+
+	return nil
+}
+
+// rotationLayout timestamps rotated transcripts; it sorts chronologically
+const rotationLayout = "20060102-150405.000"
+
+// rotatedFiles lists the transcripts rotated from prefix+suffix, oldest
+// first, leaving alone other files that merely share the prefix
+func rotatedFiles(prefix, suffix string) []string {
+	dir, base := filepath.Split(prefix)
+	entries, err := os.ReadDir(filepath.Clean(dir + "."))
+	if err != nil {
+		return nil
+	}
+	var rotated []string
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), base+"-")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if stamp, ok = strings.CutSuffix(stamp, suffix); !ok {
+			continue
+		}
+		if _, err := time.Parse(rotationLayout, stamp); err == nil {
+			rotated = append(rotated, dir+entry.Name())
+		}
+	}
+	sort.Strings(rotated)
+	return rotated
+}
+
+// rotationParts splits a path around which rotated timestamps are inserted,
+// e.g. "session.jsonl.gz" -> ("session", ".jsonl.gz")
+func rotationParts(path string) (string, string) {
+	suffix := ""
+	if strings.HasSuffix(path, ".gz") {
+		path = strings.TrimSuffix(path, ".gz")
+		suffix = ".gz"
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext), ext + suffix
+}
+
+// Close flushes and closes the transcript
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closeFile()
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecorderRotation(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		name := "plain"
+		if compressed {
+			name = "gzip"
+		}
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "session.jsonl")
+			if compressed {
+				path += ".gz"
+			}
+			// Files sharing the prefix that the recorder didn't rotate
+			unrelated := []string{"session-other.jsonl", "session-other.jsonl.gz", "session-2024.jsonl.gz"}
+			for _, file := range unrelated {
+				if err := os.WriteFile(filepath.Join(dir, file), nil, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			const maxSize = 2000
+			r, err := NewRecorder(path, RecorderOptions{Gzip: compressed, MaxSize: maxSize, Keep: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Random data keeps the entries from compressing away
+			noise := make([]byte, 100)
+			message := func() []byte {
+				rand.Read(noise)
+				return []byte(`{"jsonrpc":"2.0","method":"notifications/message","params":{"data":"` + hex.EncodeToString(noise) + `"}}`)
+			}
+			for i := 0; i < 50; i++ {
+				r.Record(DirectionOut, message(), "")
+				// Rotated names are timestamped to the millisecond
+				time.Sleep(2 * time.Millisecond)
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, file := range unrelated {
+				if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+					t.Errorf("pruning removed %s", file)
+				}
+			}
+			prefix, suffix := rotationParts(path)
+			rotated := rotatedFiles(prefix, suffix)
+			if len(rotated) != 1 {
+				t.Fatalf("kept %d rotated transcripts, want 1", len(rotated))
+			}
+
+			// Sizes are compared on disk; a compressed transcript may go
+			// over by the entry that crossed the limit
+			limit := int64(maxSize)
+			if compressed {
+				limit += int64(len(message())) + 100
+			}
+			info, err := os.Stat(rotated[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() > limit || info.Size() < maxSize/2 {
+				t.Errorf("rotated at %d bytes on disk, want about %d", info.Size(), maxSize)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Replay answers client requests from a recorded transcript instead of the
+// upstream. Requests are matched by method and a hash of their params;
+// identical requests get the recorded responses in order, the last repeating.
+type Replay struct {
+	mu        sync.Mutex
+	responses map[string][]JSONRPCMessage
+	served    map[string]int
+}
+
+// loadReplay reads a --record transcript and indexes the client's requests
+// with their responses
+func loadReplay(path string) (*Replay, error) {
+	entries, err := readTranscript(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Replay{
+		responses: make(map[string][]JSONRPCMessage),
+		served:    make(map[string]int),
+	}
+	for _, ex := range indexTranscript(entries) {
+		if ex.request == nil || ex.response == nil || ex.request.Direction != DirectionIn {
+			continue
+		}
+		key := replayKey(ex.msg.Method, ex.msg.Params)
+		r.responses[key] = append(r.responses[key], ex.reply)
+	}
+	if len(r.responses) == 0 {
+		return nil, fmt.Errorf("%s has no recorded requests with responses", path)
+	}
+
+	return r, nil
+}
+
+// replayKey identifies a request by method and params. Params are hashed in
+// canonical form without _meta, which carries per-run values like progress
+// tokens.
+func replayKey(method string, params json.RawMessage) string {
+	var value interface{}
+	if len(params) > 0 && json.Unmarshal(params, &value) == nil {
+		if object, ok := value.(map[string]interface{}); ok {
+			delete(object, "_meta")
+		}
+	}
+	canonical, _ := json.Marshal(value) // Maps marshal with sorted keys
+	sum := sha256.Sum256(canonical)
+	return method + " " + hex.EncodeToString(sum[:])
+}
+
+// lookup returns the next recorded response for a request, or false
+func (r *Replay) lookup(msg *JSONRPCMessage) (JSONRPCMessage, bool) {
+	key := replayKey(msg.Method, msg.Params)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	responses := r.responses[key]
+	if len(responses) == 0 {
+		return JSONRPCMessage{}, false
+	}
+	i := min(r.served[key], len(responses)-1)
+	r.served[key]++
+	return responses[i], true
+}
+
+// serveReplay answers a client message from the recording. Requests without
+// a recorded response get an error; notifications and responses are dropped.
+func (p *Proxy) serveReplay(msg *JSONRPCMessage) {
+	if msg.ID == nil || msg.Method == "" {
+		if p.debug {
+			log.Printf("[REPLAY] Dropped %s", describeMessage(msg))
+		}
+		return
+	}
+
+	recorded, ok := p.replay.lookup(msg)
+	if !ok {
+		log.Printf("[REPLAY] No recorded response for %s request %s", msg.Method, msg.ID)
+		p.sendErrorResponse(msg.ID, -32603, fmt.Sprintf("Internal error: no recorded response for %s", msg.Method))
+		return
+	}
+
+	resp := JSONRPCMessage{
+		JSONRPC: "2.0",
+		ID:      msg.ID,
+		Result:  recorded.Result,
+		Error:   recorded.Error,
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("[ERROR] Failed to marshal replayed response: %v", err)
+		return
+	}
+
+	p.writeMessage(&resp, data)
+	if p.debug {
+		log.Printf("[REPLAY] Served %s request %s from the recording", msg.Method, msg.ID)
+	}
+}
+
+// describeMessage names a message for logs
+func describeMessage(msg *JSONRPCMessage) string {
+	switch {
+	case msg.Method != "" && msg.ID != nil:
+		return fmt.Sprintf("%s request %s", msg.Method, msg.ID)
+	case msg.Method != "":
+		return msg.Method + " notification"
+	}
+	return fmt.Sprintf("response %s", msg.ID)
+}
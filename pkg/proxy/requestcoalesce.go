@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// coalescableMethods are the read-only requests merged by --coalesce-requests
+var coalescableMethods = map[string]bool{
+	"tools/list":               true,
+	"prompts/list":             true,
+	"resources/list":           true,
+	"resources/templates/list": true,
+	"resources/read":           true,
+}
+
+// RequestCoalescer merges identical read-only requests that are in flight at
+// the same time into one upstream call, and answers every request with its
+// result (--coalesce-requests)
+type RequestCoalescer struct {
+	mu       sync.Mutex
+	inflight map[string]*sharedRequest // Keyed by session, method and params
+	leaders  map[string]string         // Leader request ID -> key
+	debug    bool
+}
+
+// sharedRequest is an upstream call several client requests wait for
+type sharedRequest struct {
+	leader    json.RawMessage
+	followers []json.RawMessage
+}
+
+// NewRequestCoalescer creates an empty request coalescer
+func NewRequestCoalescer(debug bool) *RequestCoalescer {
+	return &RequestCoalescer{
+		inflight: make(map[string]*sharedRequest),
+		leaders:  make(map[string]string),
+		debug:    debug,
+	}
+}
+
+// key returns the coalescing key of a request, or "" if it can't be merged.
+// Params are re-marshalled so key order and whitespace don't matter.
+func (c *RequestCoalescer) key(msg *JSONRPCMessage, session string) string {
+	if msg.ID == nil || !coalescableMethods[msg.Method] {
+		return ""
+	}
+	var params interface{}
+	if len(msg.Params) > 0 {
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return ""
+		}
+	}
+	canonical, err := json.Marshal(params)
+	if err != nil {
+		return ""
+	}
+	return session + "\x00" + msg.Method + "\x00" + string(canonical)
+}
+
+// joinInflight attaches a request to an identical one already on its way
+// upstream and reports whether it did; the request is then answered along
+// with that one. Otherwise the request becomes the one others can join.
+func (p *Proxy) joinInflight(msg *JSONRPCMessage) bool {
+	c := p.requestCoalescer
+	key := c.key(msg, p.session())
+	if key == "" {
+		return false
+	}
+
+	c.mu.Lock()
+	if shared, ok := c.inflight[key]; ok {
+		shared.followers = append(shared.followers, msg.ID)
+		c.mu.Unlock()
+		if c.debug {
+			log.Printf("[COALESCE] %s %s joined in-flight request %s", msg.Method, msg.ID, shared.leader)
+		}
+		return true
+	}
+	leader := msg.ID
+	c.inflight[key] = &sharedRequest{leader: leader}
+	c.leaders[string(leader)] = key
+	c.mu.Unlock()
+
+	p.onResponse(leader, func(resp *JSONRPCMessage) {
+		for _, id := range c.release(leader) {
+			p.answerFollower(id, resp)
+		}
+	})
+	return false
+}
+
+// release ends a shared request and returns the requests that joined it
+func (c *RequestCoalescer) release(leader json.RawMessage) []json.RawMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key, ok := c.leaders[string(leader)]
+	if !ok {
+		return nil
+	}
+	delete(c.leaders, string(leader))
+	shared := c.inflight[key]
+	delete(c.inflight, key)
+	return shared.followers
+}
+
+// hasFollowers reports whether other requests wait for the given request
+func (c *RequestCoalescer) hasFollowers(leader json.RawMessage) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, ok := c.leaders[string(leader)]
+	return ok && len(c.inflight[key].followers) > 0
+}
+
+// answerFollower writes the shared response to a request that joined it
+func (p *Proxy) answerFollower(id json.RawMessage, shared *JSONRPCMessage) {
+	resp := JSONRPCMessage{JSONRPC: "2.0", ID: id, Result: shared.Result, Error: shared.Error}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("[ERROR] Failed to marshal coalesced response: %v", err)
+		return
+	}
+	p.writeMessage(&resp, data)
+}
+
+// abandonShared fails the requests that joined a request which ended
+// without a response, e.g. because the upstream call was cancelled
+func (p *Proxy) abandonShared(leader json.RawMessage) {
+	if p.requestCoalescer == nil {
+		return
+	}
+	for _, id := range p.requestCoalescer.release(leader) {
+		p.sendErrorResponse(id, -32603, fmt.Sprintf("Internal error: identical request %s was cancelled", leader))
+	}
+}
@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// resourceNotifyInterval limits how often subscribers are told a local resource changed
+const resourceNotifyInterval = time.Second
+
+// localResource is a resource the proxy serves itself, next to the upstream's
+type localResource struct {
+	URI         string                 `json:"uri"`
+	Name        string                 `json:"name"`
+	Title       string                 `json:"title,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	MimeType    string                 `json:"mimeType"`
+	render      func() ([]byte, error) // Returns the current contents
+}
+
+// LocalResources holds the proxy's own resources and their subscriptions
+type LocalResources struct {
+	mu         sync.Mutex
+	resources  []*localResource
+	subscribed map[string]bool
+	pending    map[string]bool // URIs with an updated notification scheduled
+}
+
+// addLocalResource serves a resource from the proxy itself
+func (p *Proxy) addLocalResource(resource *localResource) {
+	if p.localResources == nil {
+		p.localResources = &LocalResources{subscribed: make(map[string]bool), pending: make(map[string]bool)}
+	}
+	p.localResources.resources = append(p.localResources.resources, resource)
+}
+
+// find returns the local resource with the given URI
+func (r *LocalResources) find(uri string) *localResource {
+	for _, resource := range r.resources {
+		if resource.URI == uri {
+			return resource
+		}
+	}
+	return nil
+}
+
+// serveLocalResource answers reads of and subscriptions to local resources.
+// It returns true if the message was handled.
+func (p *Proxy) serveLocalResource(msg *JSONRPCMessage) bool {
+	if msg.ID == nil {
+		return false
+	}
+	switch msg.Method {
+	case "resources/read", "resources/subscribe", "resources/unsubscribe":
+	default:
+		return false
+	}
+
+	var params struct {
+		URI string `json:"uri"`
+	}
+	if json.Unmarshal(msg.Params, &params) != nil {
+		return false
+	}
+	r := p.localResources
+	resource := r.find(params.URI)
+	if resource == nil {
+		return false
+	}
+
+	var result interface{} = struct{}{}
+	switch msg.Method {
+	case "resources/read":
+		text, err := resource.render()
+		if err != nil {
+			p.sendErrorResponse(msg.ID, -32603, fmt.Sprintf("Internal error: %v", err))
+			return true
+		}
+		result = map[string]interface{}{
+			"contents": []map[string]string{{"uri": resource.URI, "mimeType": resource.MimeType, "text": string(text)}},
+		}
+	case "resources/subscribe", "resources/unsubscribe":
+		r.mu.Lock()
+		r.subscribed[resource.URI] = msg.Method == "resources/subscribe"
+		r.mu.Unlock()
+	}
+
+	encoded, _ := json.Marshal(result)
+	resp := JSONRPCMessage{JSONRPC: "2.0", ID: msg.ID, Result: encoded}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("[ERROR] Failed to marshal local resource response: %v", err)
+		return false
+	}
+	p.writeMessage(&resp, data)
+	if p.debug {
+		log.Printf("[RESOURCES] Served %s %s", msg.Method, resource.URI)
+	}
+	return true
+}
+
+// resourceUpdated tells a subscribed client that a local resource changed,
+// at most once per resourceNotifyInterval
+func (p *Proxy) resourceUpdated(uri string) {
+	r := p.localResources
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	if !r.subscribed[uri] || r.pending[uri] {
+		r.mu.Unlock()
+		return
+	}
+	r.pending[uri] = true
+	r.mu.Unlock()
+
+	time.AfterFunc(resourceNotifyInterval, func() {
+		defer recoverPanic("resource notification")
+		r.mu.Lock()
+		delete(r.pending, uri)
+		r.mu.Unlock()
+
+		params, _ := json.Marshal(map[string]string{"uri": uri})
+		note := JSONRPCMessage{JSONRPC: "2.0", Method: "notifications/resources/updated", Params: params}
+		data, _ := json.Marshal(note)
+		p.writeMessage(&note, data)
+	})
+}
+
+// listLocalResources appends the local resources to the last page of a
+// resources/list response
+func (p *Proxy) listLocalResources(msg *JSONRPCMessage, data []byte) []byte {
+	return rewriteResult(msg, data, func(result map[string]json.RawMessage) bool {
+		if _, paginated := result["nextCursor"]; paginated {
+			return false
+		}
+		var resources []json.RawMessage
+		if raw, ok := result["resources"]; ok && json.Unmarshal(raw, &resources) != nil {
+			return false
+		}
+		for _, resource := range p.localResources.resources {
+			entry, _ := json.Marshal(resource)
+			resources = append(resources, entry)
+		}
+		result["resources"], _ = json.Marshal(resources)
+		return true
+	})
+}
+
+// advertiseResources makes sure an initialize response declares resources,
+// so clients look for the local resources even if the upstream has none
+func advertiseResources(msg *JSONRPCMessage, data []byte) []byte {
+	return rewriteResult(msg, data, func(result map[string]json.RawMessage) bool {
+		var capabilities map[string]json.RawMessage
+		if raw, ok := result["capabilities"]; ok && json.Unmarshal(raw, &capabilities) != nil {
+			return false
+		}
+		if _, ok := capabilities["resources"]; ok {
+			return false
+		}
+		if capabilities == nil {
+			capabilities = make(map[string]json.RawMessage)
+		}
+		capabilities["resources"] = json.RawMessage(`{"subscribe":true}`)
+		result["capabilities"], _ = json.Marshal(capabilities)
+		return true
+	})
+}
+
+// rewriteResult applies edit to a response's result object, updating msg and
+// returning the re-encoded message when edit reports a change
+func rewriteResult(msg *JSONRPCMessage, data []byte, edit func(result map[string]json.RawMessage) bool) []byte {
+	var result map[string]json.RawMessage
+	if msg.Result == nil || json.Unmarshal(msg.Result, &result) != nil || result == nil {
+		return data
+	}
+	if !edit(result) {
+		return data
+	}
+
+	updated := *msg
+	updated.Result, _ = json.Marshal(result)
+	out, err := json.Marshal(updated)
+	if err != nil {
+		return data
+	}
+	*msg = updated
+	return out
+}
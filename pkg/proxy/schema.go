@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// SchemaValidator checks tools/call arguments against the inputSchema of
+// tools seen in tools/list responses
+type SchemaValidator struct {
+	mu      sync.Mutex
+	schemas map[string]map[string]interface{} // Tool name -> decoded inputSchema
+	debug   bool
+}
+
+// NewSchemaValidator creates a validator with no known tools
+func NewSchemaValidator(debug bool) *SchemaValidator {
+	return &SchemaValidator{schemas: make(map[string]map[string]interface{}), debug: debug}
+}
+
+// learn stores the input schemas from a tools/list result
+func (v *SchemaValidator) learn(result json.RawMessage) {
+	var list struct {
+		Tools []struct {
+			Name        string                 `json:"name"`
+			InputSchema map[string]interface{} `json:"inputSchema"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal(result, &list); err != nil {
+		return
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, tool := range list.Tools {
+		if tool.InputSchema != nil {
+			v.schemas[tool.Name] = tool.InputSchema
+		}
+	}
+	if v.debug {
+		log.Printf("[SCHEMA] Cached input schemas for %d tool(s)", len(list.Tools))
+	}
+}
+
+// forget drops all schemas after the server reports its tools changed
+func (v *SchemaValidator) forget() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.schemas = make(map[string]map[string]interface{})
+}
+
+// validate checks a tools/call request, returning a description of the first
+// violation or "" if the arguments conform (or the tool's schema is unknown)
+func (v *SchemaValidator) validate(msg *JSONRPCMessage) string {
+	var params struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return ""
+	}
+
+	v.mu.Lock()
+	schema, ok := v.schemas[params.Name]
+	v.mu.Unlock()
+	if !ok {
+		return ""
+	}
+
+	var arguments interface{} = map[string]interface{}{}
+	if len(params.Arguments) > 0 && string(params.Arguments) != "null" {
+		if err := json.Unmarshal(params.Arguments, &arguments); err != nil {
+			return "arguments: invalid JSON"
+		}
+	}
+
+	return validateSchema(schema, arguments, "arguments")
+}
+
+// validateSchema checks value against the commonly used subset of JSON Schema.
+// Unsupported keywords are ignored, so unusual schemas err on the side of forwarding.
+func validateSchema(schema map[string]interface{}, value interface{}, path string) string {
+	if types, ok := schema["type"]; ok && !matchesType(types, value) {
+		return fmt.Sprintf("%s: expected %s, got %s", path, describeTypes(types), jsonType(value))
+	}
+
+	if allowed, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range allowed {
+			if jsonEqual(candidate, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			encoded, _ := json.Marshal(allowed)
+			return fmt.Sprintf("%s: must be one of %s", path, encoded)
+		}
+	}
+	if constant, ok := schema["const"]; ok && !jsonEqual(constant, value) {
+		encoded, _ := json.Marshal(constant)
+		return fmt.Sprintf("%s: must be %s", path, encoded)
+	}
+
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		subschemas, ok := schema[keyword].([]interface{})
+		if !ok {
+			continue
+		}
+		matched := 0
+		var first string
+		for _, sub := range subschemas {
+			subschema, ok := sub.(map[string]interface{})
+			if !ok {
+				matched++
+				continue
+			}
+			if problem := validateSchema(subschema, value, path); problem == "" {
+				matched++
+			} else if first == "" {
+				first = problem
+			}
+		}
+		switch {
+		case keyword == "allOf" && matched < len(subschemas):
+			return first
+		case keyword == "anyOf" && matched == 0:
+			return fmt.Sprintf("%s: does not match any allowed schema (%s)", path, first)
+		case keyword == "oneOf" && matched != 1:
+			return fmt.Sprintf("%s: must match exactly one allowed schema, matched %d", path, matched)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return validateObject(schema, v, path)
+	case []interface{}:
+		if minItems, ok := schema["minItems"].(float64); ok && float64(len(v)) < minItems {
+			return fmt.Sprintf("%s: must have at least %g item(s)", path, minItems)
+		}
+		if maxItems, ok := schema["maxItems"].(float64); ok && float64(len(v)) > maxItems {
+			return fmt.Sprintf("%s: must have at most %g item(s)", path, maxItems)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				if problem := validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i)); problem != "" {
+					return problem
+				}
+			}
+		}
+	case string:
+		length := float64(utf8.RuneCountInString(v))
+		if minLength, ok := schema["minLength"].(float64); ok && length < minLength {
+			return fmt.Sprintf("%s: must be at least %g character(s)", path, minLength)
+		}
+		if maxLength, ok := schema["maxLength"].(float64); ok && length > maxLength {
+			return fmt.Sprintf("%s: must be at most %g character(s)", path, maxLength)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			// ECMA-262 patterns Go can't compile are skipped
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				return fmt.Sprintf("%s: must match pattern %q", path, pattern)
+			}
+		}
+	case float64:
+		if minimum, ok := schema["minimum"].(float64); ok && v < minimum {
+			return fmt.Sprintf("%s: must be >= %g", path, minimum)
+		}
+		if maximum, ok := schema["maximum"].(float64); ok && v > maximum {
+			return fmt.Sprintf("%s: must be <= %g", path, maximum)
+		}
+		if minimum, ok := schema["exclusiveMinimum"].(float64); ok && v <= minimum {
+			return fmt.Sprintf("%s: must be > %g", path, minimum)
+		}
+		if maximum, ok := schema["exclusiveMaximum"].(float64); ok && v >= maximum {
+			return fmt.Sprintf("%s: must be < %g", path, maximum)
+		}
+	}
+
+	return ""
+}
+
+// validateObject checks the object keywords of a schema
+func validateObject(schema map[string]interface{}, object map[string]interface{}, path string) string {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, present := object[key]; !present {
+					return fmt.Sprintf("%s: missing required property %q", path, key)
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if property, ok := properties[key].(map[string]interface{}); ok {
+			if problem := validateSchema(property, object[key], path+"."+key); problem != "" {
+				return problem
+			}
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				return fmt.Sprintf("%s: unexpected property %q", path, key)
+			}
+		case map[string]interface{}:
+			if problem := validateSchema(additional, object[key], path+"."+key); problem != "" {
+				return problem
+			}
+		}
+	}
+
+	return ""
+}
+
+// matchesType reports whether value has (one of) the schema's type(s)
+func matchesType(types interface{}, value interface{}) bool {
+	switch t := types.(type) {
+	case string:
+		actual := jsonType(value)
+		return actual == t || (t == "number" && actual == "integer")
+	case []interface{}:
+		for _, candidate := range t {
+			if matchesType(candidate, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// describeTypes renders a schema type keyword for error messages
+func describeTypes(types interface{}) string {
+	if list, ok := types.([]interface{}); ok {
+		names := make([]string, 0, len(list))
+		for _, t := range list {
+			names = append(names, fmt.Sprint(t))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(types)
+}
+
+// jsonType names the JSON Schema type of a decoded value
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+// jsonEqual compares two decoded JSON values
+func jsonEqual(a, b interface{}) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(encodedA) == string(encodedB)
+}
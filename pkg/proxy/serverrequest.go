@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// serverRequestTracker remembers requests the server sent the client, such
+// as sampling/createMessage or elicitation/create, until the client answers
+type serverRequestTracker struct {
+	mu       sync.Mutex
+	sessions map[string]string // Request ID to the session that sent it
+}
+
+// trackServerRequest records a server request forwarded to the client.
+// Requests left over from an earlier session can no longer be answered and
+// are forgotten.
+func (p *Proxy) trackServerRequest(id json.RawMessage) {
+	session := p.session()
+
+	p.serverRequests.mu.Lock()
+	defer p.serverRequests.mu.Unlock()
+	if p.serverRequests.sessions == nil {
+		p.serverRequests.sessions = make(map[string]string)
+	}
+	for pending, owner := range p.serverRequests.sessions {
+		if owner != session {
+			delete(p.serverRequests.sessions, pending)
+		}
+	}
+	p.serverRequests.sessions[string(id)] = session
+}
+
+// forgetServerRequest stops tracking a server request, e.g. one the server cancelled
+func (p *Proxy) forgetServerRequest(id json.RawMessage) {
+	p.serverRequests.mu.Lock()
+	defer p.serverRequests.mu.Unlock()
+	delete(p.serverRequests.sessions, string(id))
+}
+
+// answerServerRequest stops tracking the server request with the given ID
+// and returns the session it came from
+func (p *Proxy) answerServerRequest(id json.RawMessage) (session string, ok bool) {
+	p.serverRequests.mu.Lock()
+	defer p.serverRequests.mu.Unlock()
+	session, ok = p.serverRequests.sessions[string(id)]
+	delete(p.serverRequests.sessions, string(id))
+	return session, ok
+}
+
+// answersServerRequest reports whether a client message is the response to
+// a pending server request. Such answers are handled ahead of anything
+// waiting for a request slot, since a request may be blocked on them.
+func (p *Proxy) answersServerRequest(line string) bool {
+	p.serverRequests.mu.Lock()
+	pending := len(p.serverRequests.sessions)
+	p.serverRequests.mu.Unlock()
+	if pending == 0 {
+		return false
+	}
+
+	var envelope messageEnvelope
+	if err := json.Unmarshal([]byte(line), &envelope); err != nil {
+		return false
+	}
+	if envelope.ID == nil || envelope.Method != "" {
+		return false
+	}
+
+	p.serverRequests.mu.Lock()
+	defer p.serverRequests.mu.Unlock()
+	_, ok := p.serverRequests.sessions[string(envelope.ID)]
+	return ok
+}
+
+// observeServerMessage tracks requests and cancellations arriving from the
+// server so the client's answers can be routed back
+func (p *Proxy) observeServerMessage(msg *JSONRPCMessage) {
+	switch {
+	case msg.Method == "":
+		return
+	case msg.ID != nil:
+		p.trackServerRequest(msg.ID)
+		if p.debug {
+			log.Printf("[SSE] Server request %s (%s) forwarded to the client", msg.ID, msg.Method)
+		}
+	case msg.Method == "notifications/cancelled":
+		var params struct {
+			RequestID json.RawMessage `json:"requestId"`
+		}
+		if json.Unmarshal(msg.Params, &params) == nil && params.RequestID != nil {
+			p.forgetServerRequest(params.RequestID)
+		}
+	}
+}
+
+// routeServerResponse checks a client response before it is POSTed. It
+// reports false for an answer to a request from a session that has since
+// been replaced, which the new session knows nothing about.
+func (p *Proxy) routeServerResponse(msg *JSONRPCMessage) bool {
+	session, ok := p.answerServerRequest(msg.ID)
+	if !ok {
+		if p.debug {
+			log.Printf("[STDIN] Response %s doesn't match a pending server request, forwarding anyway", msg.ID)
+		}
+		return true
+	}
+	if current := p.session(); current != session {
+		log.Printf("[SESSION] Dropping response %s: the request came from session %s, now %s", msg.ID, session, current)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Service definition formats
+const (
+	ServiceSystemd = "systemd" // systemd user unit
+	ServiceLaunchd = "launchd" // launchd user agent
+)
+
+// serviceConfig describes the persistent proxy a service definition runs
+type serviceConfig struct {
+	name          string
+	executable    string
+	brokerSocket  string
+	controlSocket string
+	args          []string // Proxy options and URL passed through from the command line
+}
+
+// command returns the proxy's full argument list
+func (c *serviceConfig) command() []string {
+	command := []string{c.executable, "--serve", "--broker", c.brokerSocket, "--control-socket", c.controlSocket, "--health-check"}
+	return append(command, c.args...)
+}
+
+// runServiceCommand implements "mcp-stdio-proxy service": it prints (or
+// installs) a user service running a headless broker, so editors attach with
+// --broker instead of each starting their own upstream session
+func runServiceCommand(args []string) int {
+	fs := flag.NewFlagSet("service", flag.ContinueOnError)
+	defaultFormat := ServiceSystemd
+	if runtime.GOOS == "darwin" {
+		defaultFormat = ServiceLaunchd
+	}
+	format := fs.String("format", defaultFormat, "Service definition format: systemd or launchd")
+	install := fs.Bool("install", false, "Write the definition to the user service directory instead of printing it")
+	name := fs.String("name", "mcp-stdio-proxy", "Service name")
+	brokerSocket := fs.String("broker", defaultSocketPath("broker"), "Broker socket path clients attach to")
+	controlSocket := fs.String("control-socket", defaultSocketPath("control"), "Control socket path")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s service [OPTIONS] [--] [PROXY OPTIONS] <streamable-http-url>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Generate a systemd user unit or launchd agent running the proxy as a\n")
+		fmt.Fprintf(os.Stderr, "persistent broker with health checking and a control socket.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s service http://localhost:37373/mcp\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s service --install -- --timeout 300 http://localhost:37373/mcp\n", os.Args[0])
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to locate executable: %v\n", err)
+		return 1
+	}
+
+	config := &serviceConfig{
+		name:          *name,
+		executable:    executable,
+		brokerSocket:  *brokerSocket,
+		controlSocket: *controlSocket,
+		args:          fs.Args(),
+	}
+
+	var definition, path, next string
+	home, _ := os.UserHomeDir()
+	switch *format {
+	case ServiceSystemd:
+		definition = config.systemdUnit()
+		path = filepath.Join(home, ".config", "systemd", "user", config.name+".service")
+		next = fmt.Sprintf("systemctl --user daemon-reload && systemctl --user enable --now %s", config.name)
+	case ServiceLaunchd:
+		definition = config.launchdPlist(home)
+		path = filepath.Join(home, "Library", "LaunchAgents", config.name+".plist")
+		next = fmt.Sprintf("launchctl load -w %s", path)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown --format %q\n", *format)
+		return 2
+	}
+
+	if !*install {
+		fmt.Print(definition)
+		return 0
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(path, []byte(definition), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to write %s: %v\n", path, err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "Installed %s\n", path)
+	fmt.Fprintf(os.Stderr, "Start it with: %s\n", next)
+	fmt.Fprintf(os.Stderr, "Point clients at: %s --broker %s <url>\n", executable, config.brokerSocket)
+	return 0
+}
+
+// defaultSocketPath returns a per-user socket path for the service
+func defaultSocketPath(kind string) string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, fmt.Sprintf("mcp-stdio-proxy-%d-%s.sock", os.Getuid(), kind))
+}
+
+// systemdUnit renders a systemd user unit
+func (c *serviceConfig) systemdUnit() string {
+	quoted := make([]string, 0, len(c.command()))
+	for _, arg := range c.command() {
+		quoted = append(quoted, systemdQuote(arg))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[Unit]\n")
+	fmt.Fprintf(&b, "Description=MCP stdio proxy broker (%s)\n", c.name)
+	fmt.Fprintf(&b, "After=network-online.target\n\n")
+	fmt.Fprintf(&b, "[Service]\n")
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(quoted, " "))
+	fmt.Fprintf(&b, "Restart=on-failure\n")
+	fmt.Fprintf(&b, "RestartSec=5\n\n")
+	fmt.Fprintf(&b, "[Install]\n")
+	fmt.Fprintf(&b, "WantedBy=default.target\n")
+	return b.String()
+}
+
+// systemdQuote quotes an ExecStart argument when needed
+func systemdQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\$%;") {
+		return arg
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `$$`, `%`, `%%`)
+	return `"` + r.Replace(arg) + `"`
+}
+
+// launchdPlist renders a launchd user agent
+func (c *serviceConfig) launchdPlist(home string) string {
+	escape := func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(&b, "<!DOCTYPE plist PUBLIC \"-//Apple//DTD PLIST 1.0//EN\" \"http://www.apple.com/DTDs/PropertyList-1.0.dtd\">\n")
+	fmt.Fprintf(&b, "<plist version=\"1.0\">\n<dict>\n")
+	fmt.Fprintf(&b, "  <key>Label</key>\n  <string>%s</string>\n", escape(c.name))
+	fmt.Fprintf(&b, "  <key>ProgramArguments</key>\n  <array>\n")
+	for _, arg := range c.command() {
+		fmt.Fprintf(&b, "    <string>%s</string>\n", escape(arg))
+	}
+	fmt.Fprintf(&b, "  </array>\n")
+	fmt.Fprintf(&b, "  <key>RunAtLoad</key>\n  <true/>\n")
+	fmt.Fprintf(&b, "  <key>KeepAlive</key>\n  <dict>\n    <key>SuccessfulExit</key>\n    <false/>\n  </dict>\n")
+	fmt.Fprintf(&b, "  <key>StandardErrorPath</key>\n  <string>%s</string>\n", escape(filepath.Join(home, "Library", "Logs", c.name+".log")))
+	fmt.Fprintf(&b, "</dict>\n</plist>\n")
+	return b.String()
+}
@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// sessionValidateTimeout bounds the ping checking a saved session on startup
+const sessionValidateTimeout = 10 * time.Second
+
+// savedSession is the upstream session --session-file keeps across restarts,
+// with the initialize result a restarted proxy answers the client with
+type savedSession struct {
+	URL              string          `json:"url"`
+	SessionID        string          `json:"sessionId"`
+	ProtocolVersion  string          `json:"protocolVersion,omitempty"`
+	InitializeResult json.RawMessage `json:"initializeResult"`
+}
+
+// SessionFile persists the upstream session so a restarted proxy can resume
+// it instead of opening a new one (--session-file)
+type SessionFile struct {
+	path string
+
+	mu sync.Mutex
+	// saved is the session found on startup until the client's initialize
+	// consumes it
+	saved *savedSession
+	// resumed is set once a saved session was resumed, so the client's
+	// notifications/initialized isn't sent a second time
+	resumed bool
+}
+
+// LoadSessionFile reads a session saved by an earlier run. A missing file
+// simply means there's nothing to resume; a corrupt one is ignored.
+func LoadSessionFile(path string) (*SessionFile, error) {
+	f := &SessionFile{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session file: %w", err)
+	}
+
+	var saved savedSession
+	if err := json.Unmarshal(data, &saved); err != nil || saved.SessionID == "" || saved.InitializeResult == nil {
+		log.Printf("[SESSION] Warning: ignoring unusable session file %s", path)
+		return f, nil
+	}
+	f.saved = &saved
+	return f, nil
+}
+
+// save writes the session atomically, readable only by the user
+func (f *SessionFile) save(session *savedSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".session-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// remove deletes the saved session
+func (f *SessionFile) remove() {
+	if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("[SESSION] Failed to remove session file: %v", err)
+	}
+}
+
+// saveSession records the current session and the initialize result that
+// opened it
+func (p *Proxy) saveSession(result json.RawMessage) {
+	if p.sessionFile == nil || result == nil {
+		return
+	}
+	session := &savedSession{
+		URL:              p.target(),
+		SessionID:        p.session(),
+		ProtocolVersion:  p.negotiatedVersion(),
+		InitializeResult: result,
+	}
+	if session.SessionID == "" {
+		return
+	}
+	if err := p.sessionFile.save(session); err != nil {
+		log.Printf("[SESSION] Failed to save session to %s: %v", p.sessionFile.path, err)
+	} else if p.debug {
+		log.Printf("[SESSION] Saved session %s to %s", session.SessionID, p.sessionFile.path)
+	}
+}
+
+// forgetSavedSession deletes a saved session the upstream no longer knows
+func (p *Proxy) forgetSavedSession() {
+	if p.sessionFile != nil {
+		p.sessionFile.remove()
+	}
+}
+
+// resumeSession answers the client's initialize from the saved session if
+// the upstream still knows it, and reports whether it did. Otherwise the
+// handshake goes upstream as usual and opens a new session.
+func (p *Proxy) resumeSession(msg *JSONRPCMessage) bool {
+	if p.sessionFile == nil || p.aggregator != nil || p.replay != nil {
+		return false
+	}
+	p.sessionFile.mu.Lock()
+	saved := p.sessionFile.saved
+	p.sessionFile.saved = nil
+	p.sessionFile.mu.Unlock()
+	if saved == nil {
+		return false
+	}
+	if saved.URL != p.target() {
+		log.Printf("[SESSION] Saved session %s belongs to %s, not %s; initializing a new one", saved.SessionID, saved.URL, p.target())
+		return false
+	}
+
+	p.sessionMu.Lock()
+	p.sessionID = saved.SessionID
+	p.protocolVersion = saved.ProtocolVersion
+	p.sessionMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(p.baseContext(), sessionValidateTimeout)
+	defer cancel()
+	if _, err := p.callOnce(ctx, "ping", nil, nil); err != nil {
+		var expired *sessionExpiredError
+		if errors.As(err, &expired) {
+			log.Printf("[SESSION] Saved session %s has expired; initializing a new one", saved.SessionID)
+			p.forgetSavedSession()
+		} else {
+			log.Printf("[SESSION] Could not validate saved session %s, initializing a new one: %v", saved.SessionID, err)
+		}
+		p.sessionMu.Lock()
+		p.sessionID = ""
+		p.protocolVersion = ""
+		p.sessionMu.Unlock()
+		return false
+	}
+
+	log.Printf("[SESSION] Resumed session %s", saved.SessionID)
+	p.sessionFile.mu.Lock()
+	p.sessionFile.resumed = true
+	p.sessionFile.mu.Unlock()
+
+	response := JSONRPCMessage{JSONRPC: "2.0", ID: msg.ID, Result: saved.InitializeResult}
+	data, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("[ERROR] Failed to marshal saved initialize result: %v", err)
+		return false
+	}
+	p.writeMessage(&response, data)
+
+	if p.getStream {
+		p.startGetStream(saved.SessionID)
+	}
+	return true
+}
+
+// initializedAlreadySent reports whether the client's notifications/initialized
+// belongs to a resumed or eagerly opened session the server has seen it for
+func (p *Proxy) initializedAlreadySent() bool {
+	if p.eagerInit.takeInitialized() {
+		return true
+	}
+	if p.sessionFile == nil {
+		return false
+	}
+	p.sessionFile.mu.Lock()
+	defer p.sessionFile.mu.Unlock()
+	resumed := p.sessionFile.resumed
+	p.sessionFile.resumed = false
+	return resumed
+}
@@ -0,0 +1,297 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shadowBuffer is how many messages may wait for the shadow upstream before
+// new ones are dropped
+const shadowBuffer = 1024
+
+// shadowMaxDiffs caps the differences logged for one pair of responses
+const shadowMaxDiffs = 20
+
+// shadowPendingTTL is how long an exchange waits for its second response
+const shadowPendingTTL = 10 * time.Minute
+
+// Shadow mirrors client traffic to a second upstream and logs where its
+// responses differ from the primary's (--shadow). The client only ever sees
+// the primary; the shadow runs its own session and never slows the primary
+// down, dropping messages when it falls behind.
+type Shadow struct {
+	proxy *Proxy
+	queue chan shadowMessage
+
+	mu      sync.Mutex
+	pending map[string]*shadowExchange
+}
+
+// shadowMessage is a client message waiting to be mirrored
+type shadowMessage struct {
+	line string
+	msg  JSONRPCMessage
+}
+
+// shadowExchange pairs the primary's and the shadow's response to one request
+type shadowExchange struct {
+	method  string
+	start   time.Time
+	primary *JSONRPCMessage
+	shadow  *JSONRPCMessage
+	// Latencies of each side, set along with its response
+	primaryLatency time.Duration
+	shadowLatency  time.Duration
+}
+
+// NewShadow creates a shadow for the upstream at url, sending with client
+func NewShadow(url string, client *http.Client, debug bool) (*Shadow, error) {
+	resolved, err := upstreamURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("--shadow: %w", err)
+	}
+
+	s := &Shadow{
+		queue:   make(chan shadowMessage, shadowBuffer),
+		pending: make(map[string]*shadowExchange),
+	}
+	s.proxy = &Proxy{url: resolved, client: client, debug: debug}
+	s.proxy.stdout = shadowWriter{shadow: s}
+	return s, nil
+}
+
+// start mirrors queued messages until the primary's lifetime ends. The
+// handshake and notifications go out in order; requests run concurrently.
+func (s *Shadow) start(p *Proxy) {
+	s.proxy.lifetime = p.lifetime
+	go func() {
+		defer recoverPanic("shadow")
+		for {
+			select {
+			case <-p.baseContext().Done():
+				return
+			case m := <-s.queue:
+				if m.msg.ID != nil && m.msg.Method != "initialize" {
+					go s.forward(m)
+				} else {
+					s.forward(m)
+				}
+			}
+		}
+	}()
+}
+
+// mirror queues a client message for the shadow upstream and, for a
+// request, arranges for the primary's response to be compared with the
+// shadow's. Responses to server requests stay with the primary, whose
+// session they belong to.
+func (s *Shadow) mirror(p *Proxy, line string, msg *JSONRPCMessage) {
+	if msg.Method == "" {
+		return
+	}
+
+	if msg.ID != nil {
+		id := msg.ID
+		s.mu.Lock()
+		s.prune(time.Now())
+		s.pending[string(id)] = &shadowExchange{method: msg.Method, start: time.Now()}
+		s.mu.Unlock()
+		p.onResponse(id, func(resp *JSONRPCMessage) { s.record(id, resp, true) })
+	}
+
+	select {
+	case s.queue <- shadowMessage{line: line, msg: *msg}:
+	default:
+		log.Printf("[SHADOW] Shadow upstream is not keeping up, dropped %s", describeMessage(msg))
+		if msg.ID != nil {
+			s.mu.Lock()
+			delete(s.pending, string(msg.ID))
+			s.mu.Unlock()
+		}
+	}
+}
+
+// forward sends one message to the shadow upstream; a failed request is
+// compared as an internal error response
+func (s *Shadow) forward(m shadowMessage) {
+	err := s.proxy.forwardMessage(m.line, &m.msg)
+	if err == nil || m.msg.ID == nil {
+		if err != nil && s.proxy.debug {
+			log.Printf("[SHADOW] Failed to forward %s: %v", describeMessage(&m.msg), err)
+		}
+		return
+	}
+	s.record(m.msg.ID, &JSONRPCMessage{
+		JSONRPC: "2.0",
+		ID:      m.msg.ID,
+		Error:   &JSONRPCError{Code: -32603, Message: fmt.Sprintf("Internal error: %v", err)},
+	}, false)
+}
+
+// record stores one side's response and compares the pair once both are in
+func (s *Shadow) record(id json.RawMessage, resp *JSONRPCMessage, primary bool) {
+	s.mu.Lock()
+	exchange := s.pending[string(id)]
+	if exchange == nil {
+		s.mu.Unlock()
+		return
+	}
+	if primary {
+		exchange.primary = resp
+		exchange.primaryLatency = time.Since(exchange.start)
+	} else if exchange.shadow == nil {
+		exchange.shadow = resp
+		exchange.shadowLatency = time.Since(exchange.start)
+	}
+	complete := exchange.primary != nil && exchange.shadow != nil
+	if complete {
+		delete(s.pending, string(id))
+	}
+	s.mu.Unlock()
+
+	if complete {
+		s.compare(id, exchange)
+	}
+}
+
+// compare logs the structural differences between the two responses
+func (s *Shadow) compare(id json.RawMessage, exchange *shadowExchange) {
+	diffs := diffJSON(responseValue(exchange.primary), responseValue(exchange.shadow), "")
+	timing := fmt.Sprintf("primary %v, shadow %v", exchange.primaryLatency.Round(time.Millisecond), exchange.shadowLatency.Round(time.Millisecond))
+	if len(diffs) == 0 {
+		if s.proxy.debug {
+			log.Printf("[SHADOW] %s %s: responses match (%s)", exchange.method, id, timing)
+		}
+		return
+	}
+
+	total := len(diffs)
+	if total > shadowMaxDiffs {
+		diffs = append(diffs[:shadowMaxDiffs], fmt.Sprintf("... and %d more", total-shadowMaxDiffs))
+	}
+	log.Printf("[SHADOW] %s %s: %d difference(s) (%s): %s", exchange.method, id, total, timing, strings.Join(diffs, "; "))
+}
+
+// prune forgets exchanges one side never answered; s.mu must be held
+func (s *Shadow) prune(now time.Time) {
+	for id, exchange := range s.pending {
+		if now.Sub(exchange.start) > shadowPendingTTL {
+			delete(s.pending, id)
+		}
+	}
+}
+
+// shadowWriter receives what the shadow upstream sends; responses are
+// recorded for comparison and everything else is discarded
+type shadowWriter struct {
+	shadow *Shadow
+}
+
+// Write implements io.Writer
+func (w shadowWriter) Write(data []byte) (int, error) {
+	var msg JSONRPCMessage
+	if err := json.Unmarshal(bytes.TrimSpace(data), &msg); err == nil && msg.ID != nil && msg.Method == "" {
+		w.shadow.record(msg.ID, &msg, false)
+	}
+	return len(data), nil
+}
+
+// responseValue is the decoded part of a response worth comparing: its
+// error, or else its result
+func responseValue(msg *JSONRPCMessage) interface{} {
+	raw := msg.Result
+	key := "result"
+	if msg.Error != nil {
+		raw, _ = json.Marshal(msg.Error)
+		key = "error"
+	}
+
+	var value interface{}
+	if raw != nil {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		if err := dec.Decode(&value); err != nil {
+			value = string(raw)
+		}
+	}
+	return map[string]interface{}{key: value}
+}
+
+// diffJSON lists the differences between two decoded JSON values, one
+// "path: primary != shadow" line each
+func diffJSON(a, b interface{}, path string) []string {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(av)+len(bv))
+		for key := range av {
+			keys = append(keys, key)
+		}
+		for key := range bv {
+			if _, ok := av[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		var diffs []string
+		for _, key := range keys {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			aval, inA := av[key]
+			bval, inB := bv[key]
+			switch {
+			case !inB:
+				diffs = append(diffs, fmt.Sprintf("%s: only in primary", child))
+			case !inA:
+				diffs = append(diffs, fmt.Sprintf("%s: only in shadow", child))
+			default:
+				diffs = append(diffs, diffJSON(aval, bval, child)...)
+			}
+		}
+		return diffs
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		var diffs []string
+		if len(av) != len(bv) {
+			diffs = append(diffs, fmt.Sprintf("%s: %d items != %d items", path, len(av), len(bv)))
+		}
+		for i := 0; i < len(av) && i < len(bv); i++ {
+			diffs = append(diffs, diffJSON(av[i], bv[i], fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return diffs
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s: %s != %s", path, shortJSON(a), shortJSON(b))}
+}
+
+// shortJSON renders a value for a diff line, truncating long ones
+func shortJSON(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	if len(data) > 80 {
+		return string(data[:77]) + "..."
+	}
+	return string(data)
+}
@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout bounds how long shutdown waits for in-flight requests
+const shutdownTimeout = 10 * time.Second
+
+// signalError reports that the proxy stopped because of a signal
+type signalError struct {
+	signal os.Signal
+}
+
+// Error implements error
+func (e *signalError) Error() string {
+	return fmt.Sprintf("terminated by %v", e.signal)
+}
+
+// exitCode follows the shell convention of 128 plus the signal number
+func (e *signalError) exitCode() int {
+	if sig, ok := e.signal.(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+	return 1
+}
+
+// exitHealthFailed is the exit status once --health-exit-on-failure gives up
+// on the upstream; EX_TEMPFAIL tells a supervisor that a restart may help
+const exitHealthFailed = 75
+
+// healthFailedError reports that the proxy stopped because health recovery failed
+type healthFailedError struct {
+	err error
+}
+
+// Error implements error
+func (e *healthFailedError) Error() string {
+	return fmt.Sprintf("upstream health recovery failed: %v", e.err)
+}
+
+// healthFailure stops the proxy after a health checker gave up; only the
+// first failure counts
+func (p *Proxy) healthFailure(err error) {
+	select {
+	case p.healthFailed <- err:
+	default:
+	}
+}
+
+// shutdown waits for in-flight requests and open streams to finish, closes
+// the GET stream and terminates the upstream sessions
+func (p *Proxy) shutdown(handlers *sync.WaitGroup) {
+	done := make(chan struct{})
+	go func() {
+		handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		log.Printf("[SHUTDOWN] Gave up waiting for in-flight requests after %v", shutdownTimeout)
+	}
+
+	p.stopGetStream()
+	p.drainStreams()
+
+	if p.coalescer != nil {
+		p.coalescer.flush()
+	}
+
+	// A saved session is left open for the next run to resume
+	if p.sessionFile == nil {
+		p.terminateSessions()
+	} else if p.debug {
+		log.Printf("[SHUTDOWN] Keeping session %s for the next run", p.session())
+	}
+	if p.shadow != nil {
+		if err := p.shadow.proxy.terminateSession(); err != nil && p.debug {
+			log.Printf("[SHUTDOWN] Failed to terminate the shadow session: %v", err)
+		}
+	}
+	p.release()
+}
+
+// release flushes pending traces and stops the SSH tunnel and the spawned
+// or stdio server; it runs after the upstream sessions are terminated
+func (p *Proxy) release() {
+	if p.listening {
+		return
+	}
+	if p.tracer != nil {
+		p.tracer.shutdown()
+	}
+	if p.spawner != nil {
+		p.spawner.stop()
+	}
+	if p.stdio != nil {
+		p.stdio.stop()
+	}
+	if p.tunnel != nil {
+		p.tunnel.close()
+	}
+}
+
+// terminateSessions ends the upstream session, or every aggregated upstream's
+func (p *Proxy) terminateSessions() {
+	var wg sync.WaitGroup
+	for _, target := range p.upstreamProxies() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := target.terminateSession(); err != nil {
+				log.Printf("[SHUTDOWN] Failed to terminate session at %s: %v", target.target(), err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// upstreamProxies returns the proxy itself, or every aggregated upstream's proxy
+func (p *Proxy) upstreamProxies() []*Proxy {
+	if p.aggregator == nil {
+		return []*Proxy{p}
+	}
+	var proxies []*Proxy
+	for _, u := range p.aggregator.upstreams {
+		proxies = append(proxies, u.proxy)
+	}
+	return proxies
+}
+
+// terminateSession sends DELETE with the session ID so the server can free
+// the session right away instead of waiting for it to expire
+func (p *Proxy) terminateSession() error {
+	sessionID := p.session()
+	if sessionID == "" {
+		return nil
+	}
+
+	req, err := p.newUpstreamRequest("DELETE", "", sessionID)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	p.sessionMu.Lock()
+	if p.sessionID == sessionID {
+		p.sessionID = ""
+	}
+	p.sessionMu.Unlock()
+	p.forgetSavedSession()
+
+	// 405: the server doesn't let clients terminate sessions; 404: already gone
+	switch {
+	case resp.StatusCode == http.StatusMethodNotAllowed, resp.StatusCode == http.StatusNotFound:
+		if p.debug {
+			log.Printf("[SHUTDOWN] Server answered HTTP %d to session termination", resp.StatusCode)
+		}
+	case resp.StatusCode >= 400:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+	case p.debug:
+		log.Printf("[SHUTDOWN] Terminated session %s", sessionID)
+	}
+	return nil
+}
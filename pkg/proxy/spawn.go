@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	spawnStopGrace  = 5 * time.Second  // Time to exit after SIGTERM before SIGKILL
+	spawnMaxBackoff = 30 * time.Second // Longest wait between restarts
+	spawnStableRun  = time.Minute      // A server running this long resets the backoff
+)
+
+// Spawner runs the upstream HTTP server as a child process on a free local
+// port, restarting it if it crashes and stopping it when the proxy exits
+type Spawner struct {
+	command      string // Command line with {port} substituted
+	port         int
+	readyTimeout time.Duration
+	debug        bool
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	exited   chan struct{}
+	stopping bool
+}
+
+// NewSpawner allocates a free port for command, in which "{port}" is
+// replaced by the port number (also passed as $PORT)
+func NewSpawner(command string, readyTimeout time.Duration, debug bool) (*Spawner, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate a port: %w", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	return &Spawner{
+		command:      strings.ReplaceAll(command, "{port}", strconv.Itoa(port)),
+		port:         port,
+		readyTimeout: readyTimeout,
+		debug:        debug,
+	}, nil
+}
+
+// start launches the server and waits until it accepts connections
+func (s *Spawner) start() error {
+	exited, err := s.launch()
+	if err != nil {
+		return err
+	}
+	if err := s.waitReady(exited); err != nil {
+		s.stop()
+		return err
+	}
+	log.Printf("[SPAWN] Server ready on port %d", s.port)
+
+	go childSupervisor{tag: "SPAWN", status: s.status, launch: s.launch, ready: s.waitReady}.run(exited)
+	return nil
+}
+
+// launch starts the server process. Its stdout goes to stderr, since the
+// proxy's stdout carries the MCP stream.
+func (s *Spawner) launch() (chan struct{}, error) {
+	cmd := shellCommand(s.command)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PORT=%d", s.port))
+
+	if s.debug {
+		log.Printf("[SPAWN] Starting: %s", s.command)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %q: %w", s.command, err)
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	s.mu.Lock()
+	s.cmd = cmd
+	s.exited = exited
+	s.mu.Unlock()
+	return exited, nil
+}
+
+// waitReady polls the port until the server accepts a connection
+func (s *Spawner) waitReady(exited chan struct{}) error {
+	addr := fmt.Sprintf("127.0.0.1:%d", s.port)
+	deadline := time.Now().Add(s.readyTimeout)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+
+		select {
+		case <-exited:
+			return fmt.Errorf("spawned server exited before listening on port %d", s.port)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("spawned server did not listen on port %d within %v", s.port, s.readyTimeout)
+		}
+	}
+}
+
+// childSupervisor keeps a child process running, relaunching it with
+// backoff whenever it exits unexpectedly
+type childSupervisor struct {
+	tag string // Log tag, e.g. SPAWN
+	// status reports whether the child is being stopped on purpose and how
+	// the last one exited
+	status func() (stopping bool, state *os.ProcessState)
+	launch func() (chan struct{}, error)
+	// ready, if set, waits until a relaunched child can be used
+	ready func(exited chan struct{}) error
+}
+
+// run supervises the child launched with exited until it is stopped
+func (c childSupervisor) run(exited chan struct{}) {
+	defer recoverPanic(strings.ToLower(c.tag) + " supervisor")
+
+	backoff := time.Second
+	started := time.Now()
+	for {
+		<-exited
+
+		stopping, state := c.status()
+		if stopping {
+			return
+		}
+
+		if time.Since(started) >= spawnStableRun {
+			backoff = time.Second
+		}
+		log.Printf("[%s] Server exited (%v); restarting in %v", c.tag, state, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, spawnMaxBackoff)
+
+		if stopping, _ = c.status(); stopping {
+			return
+		}
+
+		var err error
+		started = time.Now()
+		if exited, err = c.launch(); err != nil {
+			log.Printf("[%s] %v", c.tag, err)
+			// Retry through the same backoff path
+			exited = make(chan struct{})
+			close(exited)
+			continue
+		}
+		if c.ready != nil {
+			if err := c.ready(exited); err != nil {
+				log.Printf("[%s] %v", c.tag, err)
+				continue
+			}
+		}
+		log.Printf("[%s] Server restarted", c.tag)
+	}
+}
+
+// status reports whether the server is being stopped and how it last exited
+func (s *Spawner) status() (bool, *os.ProcessState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopping, s.cmd.ProcessState
+}
+
+// stop terminates the server and everything it started
+func (s *Spawner) stop() {
+	s.mu.Lock()
+	s.stopping = true
+	cmd, exited := s.cmd, s.exited
+	s.mu.Unlock()
+
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+	select {
+	case <-exited:
+		return
+	default:
+	}
+
+	if s.debug {
+		log.Printf("[SPAWN] Stopping server (pid %d)", cmd.Process.Pid)
+	}
+	stopProcessTree(cmd, exited, spawnStopGrace)
+}
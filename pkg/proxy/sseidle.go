@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// sseIdleError reports a response stream aborted by --sse-idle-timeout
+type sseIdleError struct {
+	timeout time.Duration
+}
+
+// Error implements error
+func (e *sseIdleError) Error() string {
+	return fmt.Sprintf("SSE stream sent nothing for %v and was aborted (--sse-idle-timeout)", e.timeout)
+}
+
+// idleWatchdog closes a stream that delivers no bytes, not even comments or
+// keep-alives, for timeout, unblocking the reader stuck on it
+type idleWatchdog struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+// watchStream guards a POST response stream with --sse-idle-timeout; the
+// returned stop must be called once reading is done
+func (p *Proxy) watchStream(body io.ReadCloser) (io.Reader, func()) {
+	if p.sseIdleTimeout <= 0 {
+		return body, func() {}
+	}
+
+	w := &idleWatchdog{body: body, timeout: p.sseIdleTimeout}
+	w.timer = time.AfterFunc(w.timeout, func() {
+		log.Printf("[SSE] Response stream silent for %v, aborting it", w.timeout)
+		w.expired.Store(true)
+		body.Close()
+	})
+	return w, func() { w.timer.Stop() }
+}
+
+// Read implements io.Reader, restarting the idle timer on every chunk
+func (w *idleWatchdog) Read(b []byte) (int, error) {
+	n, err := w.body.Read(b)
+	if n > 0 {
+		w.timer.Reset(w.timeout)
+	}
+	if err != nil && w.expired.Load() {
+		return n, &sseIdleError{timeout: w.timeout}
+	}
+	return n, err
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// SSHTunnel reaches upstreams through an SSH connection, so servers that
+// listen only on a remote machine's loopback can be used without managing
+// "ssh -L" tunnels. Each upstream connection is an "ssh -W" stream over a
+// shared control master; a dropped master is re-established by the next
+// connection, so reconnects need no special handling.
+type SSHTunnel struct {
+	destination string // user@host as given to ssh
+	controlDir  string
+	debug       bool
+}
+
+// NewSSHTunnel prepares a tunnel through destination using the system ssh
+// client and its configuration (~/.ssh/config, agent, known_hosts)
+func NewSSHTunnel(destination string, debug bool) (*SSHTunnel, error) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		return nil, fmt.Errorf("--ssh requires the ssh client: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "mcp-stdio-proxy-ssh-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH control directory: %w", err)
+	}
+	return &SSHTunnel{destination: destination, controlDir: dir, debug: debug}, nil
+}
+
+// args returns the ssh options shared by all invocations. BatchMode makes
+// ssh fail instead of prompting, since stdin belongs to the MCP client.
+func (t *SSHTunnel) args(extra ...string) []string {
+	args := []string{
+		"-o", "BatchMode=yes",
+		"-o", "ControlMaster=auto",
+		"-o", "ControlPath=" + filepath.Join(t.controlDir, "%C"),
+		"-o", "ControlPersist=60",
+		"-o", "ServerAliveInterval=15",
+	}
+	return append(append(args, extra...), t.destination)
+}
+
+// dial opens a connection to addr as seen from the remote host
+func (t *SSHTunnel) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	// The remote side of the pipe pair is handed to ssh
+	localRead, remoteWrite, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	remoteRead, localWrite, err := os.Pipe()
+	if err != nil {
+		localRead.Close()
+		remoteWrite.Close()
+		return nil, err
+	}
+
+	cmd := exec.Command("ssh", t.args("-W", addr)...)
+	cmd.Stdin = remoteRead
+	cmd.Stdout = remoteWrite
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		for _, f := range []*os.File{localRead, remoteWrite, remoteRead, localWrite} {
+			f.Close()
+		}
+		return nil, fmt.Errorf("failed to start ssh: %w", err)
+	}
+	remoteRead.Close()
+	remoteWrite.Close()
+
+	if t.debug {
+		log.Printf("[SSH] Connecting to %s via %s", addr, t.destination)
+	}
+
+	conn := &sshConn{cmd: cmd, reader: localRead, writer: localWrite, addr: addr}
+	go func() {
+		cmd.Wait()
+		localRead.Close()
+	}()
+	return conn, nil
+}
+
+// close shuts down the control master and removes its socket directory
+func (t *SSHTunnel) close() {
+	exec.Command("ssh", t.args("-O", "exit")...).Run()
+	os.RemoveAll(t.controlDir)
+}
+
+// transport returns a copy of base that makes every connection through the tunnel
+func (t *SSHTunnel) transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	transport, ok := base.(*http.Transport)
+	if !ok {
+		return base
+	}
+	transport = transport.Clone()
+	transport.DialContext = t.dial
+	transport.Proxy = nil
+	return transport
+}
+
+// sshConn is a connection carried over an ssh process's stdin and stdout
+type sshConn struct {
+	cmd    *exec.Cmd
+	reader *os.File
+	writer *os.File
+	addr   string
+}
+
+func (c *sshConn) Read(b []byte) (int, error)  { return c.reader.Read(b) }
+func (c *sshConn) Write(b []byte) (int, error) { return c.writer.Write(b) }
+
+// Close ends the stream and its ssh process
+func (c *sshConn) Close() error {
+	c.writer.Close()
+	c.reader.Close()
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill()
+	}
+	return nil
+}
+
+func (c *sshConn) LocalAddr() net.Addr  { return sshAddr("local") }
+func (c *sshConn) RemoteAddr() net.Addr { return sshAddr(c.addr) }
+
+func (c *sshConn) SetDeadline(t time.Time) error {
+	c.reader.SetDeadline(t)
+	return c.writer.SetDeadline(t)
+}
+func (c *sshConn) SetReadDeadline(t time.Time) error  { return c.reader.SetReadDeadline(t) }
+func (c *sshConn) SetWriteDeadline(t time.Time) error { return c.writer.SetWriteDeadline(t) }
+
+// sshAddr names an endpoint of a tunnelled connection
+type sshAddr string
+
+func (a sshAddr) Network() string { return "ssh" }
+func (a sshAddr) String() string  { return string(a) }
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// statsURI is the resource the proxy serves its own usage statistics at
+const statsURI = "proxy://stats"
+
+// UsageStats collects per-tool call statistics for the stats resource
+type UsageStats struct {
+	mu      sync.Mutex
+	started time.Time
+	tools   map[string]*toolStats
+}
+
+// toolStats accumulates calls to one tool
+type toolStats struct {
+	calls   int
+	errors  int
+	total   time.Duration
+	maximum time.Duration
+}
+
+// toolStatsView is one tool's entry in the stats resource
+type toolStatsView struct {
+	Calls        int     `json:"calls"`
+	Errors       int     `json:"errors"`
+	ErrorRate    float64 `json:"errorRate"`
+	AvgLatencyMs float64 `json:"avgLatencyMs"`
+	MaxLatencyMs float64 `json:"maxLatencyMs"`
+}
+
+// NewUsageStats creates an empty statistics collector
+func NewUsageStats() *UsageStats {
+	return &UsageStats{started: time.Now(), tools: make(map[string]*toolStats)}
+}
+
+// observeToolCall records a completed tools/call and tells subscribers
+func (p *Proxy) observeToolCall(tool string, elapsed time.Duration, msg *JSONRPCMessage) {
+	s := p.usageStats
+
+	failed := msg.Error != nil
+	if msg.Result != nil {
+		var result struct {
+			IsError bool `json:"isError"`
+		}
+		json.Unmarshal(msg.Result, &result)
+		failed = failed || result.IsError
+	}
+
+	s.mu.Lock()
+	t, ok := s.tools[tool]
+	if !ok {
+		t = &toolStats{}
+		s.tools[tool] = t
+	}
+	t.calls++
+	if failed {
+		t.errors++
+	}
+	t.total += elapsed
+	if elapsed > t.maximum {
+		t.maximum = elapsed
+	}
+	s.mu.Unlock()
+
+	p.resourceUpdated(statsURI)
+}
+
+// render returns the stats resource contents
+func (s *UsageStats) render() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tools := make(map[string]toolStatsView, len(s.tools))
+	names := make([]string, 0, len(s.tools))
+	for name := range s.tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var calls, errors int
+	for _, name := range names {
+		t := s.tools[name]
+		calls += t.calls
+		errors += t.errors
+		tools[name] = toolStatsView{
+			Calls:        t.calls,
+			Errors:       t.errors,
+			ErrorRate:    float64(t.errors) / float64(t.calls),
+			AvgLatencyMs: float64(t.total.Microseconds()) / float64(t.calls) / 1000,
+			MaxLatencyMs: float64(t.maximum.Microseconds()) / 1000,
+		}
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"since":       s.started.UTC().Format(time.RFC3339),
+		"toolCalls":   calls,
+		"toolErrors":  errors,
+		"tools":       tools,
+		"generatedAt": time.Now().UTC().Format(time.RFC3339),
+	}, "", "  ")
+}
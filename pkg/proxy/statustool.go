@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// statusToolName is the synthetic tool reporting the proxy's own state
+const statusToolName = "proxy.status"
+
+// statusTool is the proxy.status entry appended to tools/list
+var statusTool = map[string]interface{}{
+	"name":        statusToolName,
+	"title":       "Proxy status",
+	"description": "Report the MCP proxy's connection state: upstream URL, session ID, health, retries and uptime. Use it to diagnose tools that fail or time out.",
+	"inputSchema": map[string]interface{}{"type": "object", "properties": map[string]interface{}{}},
+	"annotations": map[string]interface{}{"readOnlyHint": true, "openWorldHint": false},
+}
+
+// proxyStatus is the result of a proxy.status call
+type proxyStatus struct {
+	Version       string           `json:"version"`
+	UptimeSeconds int64            `json:"uptimeSeconds"`
+	Upstreams     []upstreamStatus `json:"upstreams"`
+	Health        string           `json:"health,omitempty"`
+	HealthDetails []upstreamHealth `json:"healthDetails,omitempty"`
+	Circuit       string           `json:"circuit,omitempty"`
+	Retries       int64            `json:"retries"`
+	InFlight      int              `json:"inFlight"`
+}
+
+// upstreamStatus is one upstream connection in a proxy.status result
+type upstreamStatus struct {
+	URL             string `json:"url"`
+	SessionID       string `json:"sessionId,omitempty"`
+	ProtocolVersion string `json:"protocolVersion,omitempty"`
+	HTTPProtocol    string `json:"httpProtocol,omitempty"`
+}
+
+// serveStatusTool answers calls to proxy.status. It returns true if the
+// message was handled.
+func (p *Proxy) serveStatusTool(msg *JSONRPCMessage) bool {
+	if msg.ID == nil || msg.Method != "tools/call" || paramsName(msg.Params) != statusToolName {
+		return false
+	}
+
+	status := proxyStatus{
+		Version:       version,
+		UptimeSeconds: int64(time.Since(p.started).Seconds()),
+		Retries:       p.retries.Load(),
+		InFlight:      p.inFlightRequests(),
+	}
+	for _, target := range p.upstreamProxies() {
+		status.Upstreams = append(status.Upstreams, upstreamStatus{
+			URL:             target.target(),
+			SessionID:       target.session(),
+			ProtocolVersion: target.negotiatedVersion(),
+			HTTPProtocol:    target.upstreamProtocol(),
+		})
+	}
+	if statuses := p.healthStatuses(); len(statuses) > 0 {
+		status.Health = overallHealth(statuses)
+		status.HealthDetails = statuses
+	}
+	if p.breaker != nil {
+		status.Circuit = p.breaker.status()
+	}
+
+	text, _ := json.MarshalIndent(status, "", "  ")
+	result, _ := json.Marshal(map[string]interface{}{
+		"content":           []map[string]string{{"type": "text", "text": string(text)}},
+		"structuredContent": status,
+	})
+	resp := JSONRPCMessage{JSONRPC: "2.0", ID: msg.ID, Result: result}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("[ERROR] Failed to marshal status tool response: %v", err)
+		return false
+	}
+	p.writeMessage(&resp, data)
+	if p.debug {
+		log.Printf("[STATUS] Served %s", statusToolName)
+	}
+	return true
+}
+
+// listStatusTool appends proxy.status to the last page of a tools/list response
+func listStatusTool(msg *JSONRPCMessage, data []byte) []byte {
+	return rewriteResult(msg, data, func(result map[string]json.RawMessage) bool {
+		if _, paginated := result["nextCursor"]; paginated {
+			return false
+		}
+		var tools []json.RawMessage
+		if raw, ok := result["tools"]; ok && json.Unmarshal(raw, &tools) != nil {
+			return false
+		}
+		entry, _ := json.Marshal(statusTool)
+		tools = append(tools, entry)
+		result["tools"], _ = json.Marshal(tools)
+		return true
+	})
+}
+
+// advertiseTools makes sure an initialize response declares tools, so
+// clients list proxy.status even if the upstream has no tools
+func advertiseTools(msg *JSONRPCMessage, data []byte) []byte {
+	return rewriteResult(msg, data, func(result map[string]json.RawMessage) bool {
+		var capabilities map[string]json.RawMessage
+		if raw, ok := result["capabilities"]; ok && json.Unmarshal(raw, &capabilities) != nil {
+			return false
+		}
+		if _, ok := capabilities["tools"]; ok {
+			return false
+		}
+		if capabilities == nil {
+			capabilities = make(map[string]json.RawMessage)
+		}
+		capabilities["tools"] = json.RawMessage(`{}`)
+		result["capabilities"], _ = json.Marshal(capabilities)
+		return true
+	})
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// parseFD interprets "fd:N" or a bare number as an inherited file descriptor
+func parseFD(spec string) (int, bool) {
+	fdSpec := strings.TrimPrefix(spec, "fd:")
+	fd, err := strconv.Atoi(fdSpec)
+	if err != nil || fd < 0 {
+		return 0, false
+	}
+	return fd, true
+}
+
+// openStream opens the client stream given to --in or --out: an inherited file
+// descriptor, or a path such as a FIFO. Opening a FIFO blocks until the other
+// end is opened, just as a supervisor would expect.
+func openStream(spec string, write bool) (*os.File, error) {
+	if fd, ok := parseFD(spec); ok {
+		file := os.NewFile(uintptr(fd), spec)
+		if _, err := file.Stat(); err != nil {
+			return nil, fmt.Errorf("file descriptor %d is not open: %w", fd, err)
+		}
+		return file, nil
+	}
+
+	if write {
+		file, err := os.OpenFile(spec, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open output %s: %w", spec, err)
+		}
+		return file, nil
+	}
+
+	file, err := os.Open(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input %s: %w", spec, err)
+	}
+	return file, nil
+}
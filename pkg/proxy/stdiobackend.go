@@ -0,0 +1,403 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stdioBacklog bounds the server messages held while no GET stream is open
+const stdioBacklog = 1000
+
+// errStdioNotRunning fails messages sent while the stdio server is down
+var errStdioNotRunning = errors.New("stdio server is not running")
+
+// StdioBackend runs a stdio MCP server as a child process and serves it to
+// the proxy as a Streamable HTTP upstream (--stdio-cmd). Being the HTTP
+// client's transport, it puts the child behind everything built on the
+// HTTP path: logging, recording, caching, filtering, retries and session
+// re-establishment. Responses answer their POST; everything else the
+// server sends goes out on the GET stream. The child is restarted when it
+// exits, with a new session ID, so the proxy re-initializes it.
+type StdioBackend struct {
+	command string
+	debug   bool
+
+	writeMu sync.Mutex // Serializes writes to the child's stdin
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	exited   chan struct{}
+	session  string        // Session ID of the running child, "" while it is down
+	up       chan struct{} // Closed once a child is running
+	started  int           // Children started so far, numbering the sessions
+	stopping bool
+	// pending holds the requests awaiting the child's response, by ID
+	pending map[string]chan []byte
+	// stream feeds the open GET stream; backlog holds messages until one opens
+	stream  chan []byte
+	backlog [][]byte
+}
+
+// NewStdioBackend prepares a backend running command through the shell
+func NewStdioBackend(command string, debug bool) (*StdioBackend, error) {
+	if strings.TrimSpace(command) == "" {
+		return nil, errors.New("empty --stdio-cmd")
+	}
+	return &StdioBackend{
+		command: command,
+		debug:   debug,
+		up:      make(chan struct{}),
+		pending: make(map[string]chan []byte),
+	}, nil
+}
+
+// stdioNameSanitizer keeps the characters usable in the backend's URL
+var stdioNameSanitizer = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// url names the backend in logs, metrics and proxy.status, e.g. stdio://npx
+func (s *StdioBackend) url() string {
+	name := stdioNameSanitizer.ReplaceAllString(filepath.Base(strings.Fields(s.command)[0]), "")
+	if name == "" {
+		name = "server"
+	}
+	return "stdio://" + name
+}
+
+// start launches the server and keeps it running until stop
+func (s *StdioBackend) start() error {
+	exited, err := s.launch()
+	if err != nil {
+		return err
+	}
+	go childSupervisor{tag: "STDIO", status: s.status, launch: s.launch}.run(exited)
+	return nil
+}
+
+// launch starts the server process with its stdin and stdout connected to
+// the proxy and its stderr going to the proxy's stderr
+func (s *StdioBackend) launch() (chan struct{}, error) {
+	cmd := shellCommand(s.command)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.debug {
+		log.Printf("[STDIO] Starting: %s", s.command)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %q: %w", s.command, err)
+	}
+
+	exited := make(chan struct{})
+	s.mu.Lock()
+	s.started++
+	s.cmd = cmd
+	s.stdin = stdin
+	s.exited = exited
+	s.session = fmt.Sprintf("stdio-%d-%d", cmd.Process.Pid, s.started)
+	close(s.up)
+	s.mu.Unlock()
+
+	go func() {
+		defer recoverPanic("stdio server output")
+		s.readOutput(stdout)
+		cmd.Wait()
+		s.down()
+		close(exited)
+	}()
+	return exited, nil
+}
+
+// readOutput dispatches each line the server writes to stdout
+func (s *StdioBackend) readOutput(stdout io.Reader) {
+	reader := bufio.NewReader(stdout)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			s.dispatch(line)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// dispatch hands a response to the request waiting for it and publishes
+// everything else on the GET stream
+func (s *StdioBackend) dispatch(line []byte) {
+	var envelope messageEnvelope
+	if err := json.Unmarshal(line, &envelope); err != nil {
+		log.Printf("[STDIO] Ignoring non-JSON output: %s", line)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if envelope.ID != nil && envelope.Method == "" {
+		if waiting, ok := s.pending[string(envelope.ID)]; ok {
+			delete(s.pending, string(envelope.ID))
+			waiting <- line
+			return
+		}
+	}
+
+	if s.stream != nil {
+		select {
+		case s.stream <- line:
+		default:
+			log.Printf("[STDIO] GET stream is not keeping up, dropped a server message")
+		}
+		return
+	}
+	if len(s.backlog) >= stdioBacklog {
+		log.Printf("[STDIO] No GET stream open, dropped the oldest held server message")
+		s.backlog = s.backlog[1:]
+	}
+	s.backlog = append(s.backlog, line)
+}
+
+// down forgets an exited child: its session, its pending requests and the
+// GET stream that carried its messages
+func (s *StdioBackend) down() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.session = ""
+	s.stdin = nil
+	s.up = make(chan struct{})
+	for id, waiting := range s.pending {
+		close(waiting)
+		delete(s.pending, id)
+	}
+	if s.stream != nil {
+		close(s.stream)
+		s.stream = nil
+	}
+	s.backlog = nil
+}
+
+// status reports whether the server is being stopped and how it last exited
+func (s *StdioBackend) status() (bool, *os.ProcessState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopping, s.cmd.ProcessState
+}
+
+// stop closes the server's stdin, which asks a stdio server to exit, and
+// terminates it if it is still running after the grace period
+func (s *StdioBackend) stop() {
+	s.mu.Lock()
+	s.stopping = true
+	cmd, stdin, exited := s.cmd, s.stdin, s.exited
+	s.mu.Unlock()
+
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+	if stdin != nil {
+		stdin.Close()
+	}
+	select {
+	case <-exited:
+		return
+	case <-time.After(spawnStopGrace):
+	}
+
+	if s.debug {
+		log.Printf("[STDIO] Stopping server (pid %d)", cmd.Process.Pid)
+	}
+	stopProcessTree(cmd, exited, spawnStopGrace)
+}
+
+// RoundTrip implements http.RoundTripper, answering the proxy's Streamable
+// HTTP requests from the child
+func (s *StdioBackend) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodPost:
+		return s.post(req)
+	case http.MethodGet:
+		return s.openStream(req)
+	case http.MethodDelete:
+		// The child's session ends when it exits; nothing to do until then
+		if !s.knows(req) {
+			return stdioResponse(req, http.StatusNotFound, "", nil), nil
+		}
+		return stdioResponse(req, http.StatusOK, "", nil), nil
+	default:
+		return stdioResponse(req, http.StatusNoContent, "", nil), nil
+	}
+}
+
+// knows reports whether a request's session belongs to the running child
+func (s *StdioBackend) knows(req *http.Request) bool {
+	session := req.Header.Get("Mcp-Session-Id")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return session == "" || session == s.session
+}
+
+// post writes a message to the child and, for a request, returns its response
+func (s *StdioBackend) post(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body = bytes.TrimSpace(body)
+	var envelope messageEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return stdioResponse(req, http.StatusBadRequest, "", nil), nil
+	}
+
+	// A restarted child doesn't know the old session
+	if envelope.Method != "initialize" && !s.knows(req) {
+		return stdioResponse(req, http.StatusNotFound, "", nil), nil
+	}
+
+	// Messages sent while the child restarts wait for it
+	var waiting chan []byte
+	isRequest := envelope.ID != nil && envelope.Method != ""
+	s.mu.Lock()
+	for s.stdin == nil && !s.stopping {
+		up := s.up
+		s.mu.Unlock()
+		select {
+		case <-up:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		s.mu.Lock()
+	}
+	stdin, session := s.stdin, s.session
+	if isRequest && stdin != nil {
+		waiting = make(chan []byte, 1)
+		s.pending[string(envelope.ID)] = waiting
+	}
+	s.mu.Unlock()
+	if stdin == nil {
+		return nil, errStdioNotRunning
+	}
+
+	s.writeMu.Lock()
+	_, err = stdin.Write(append(body, '\n'))
+	s.writeMu.Unlock()
+	if err != nil {
+		s.forget(envelope.ID)
+		return nil, fmt.Errorf("failed to write to the stdio server: %w", err)
+	}
+	if !isRequest {
+		return stdioResponse(req, http.StatusAccepted, "", nil), nil
+	}
+
+	select {
+	case response, ok := <-waiting:
+		if !ok {
+			return nil, errors.New("stdio server exited before responding")
+		}
+		resp := stdioResponse(req, http.StatusOK, "application/json", response)
+		if envelope.Method == "initialize" {
+			resp.Header.Set("Mcp-Session-Id", session)
+		}
+		return resp, nil
+	case <-req.Context().Done():
+		s.forget(envelope.ID)
+		return nil, req.Context().Err()
+	}
+}
+
+// forget stops waiting for a request's response
+func (s *StdioBackend) forget(id json.RawMessage) {
+	s.mu.Lock()
+	delete(s.pending, string(id))
+	s.mu.Unlock()
+}
+
+// openStream serves the GET stream: the child's notifications and requests,
+// starting with those held while no stream was open
+func (s *StdioBackend) openStream(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Mcp-Session-Id") == "" || !s.knows(req) {
+		return stdioResponse(req, http.StatusNotFound, "", nil), nil
+	}
+
+	stream := make(chan []byte, stdioBacklog)
+	s.mu.Lock()
+	if s.stream != nil {
+		close(s.stream)
+	}
+	s.stream = stream
+	for _, held := range s.backlog {
+		stream <- held
+	}
+	s.backlog = nil
+	s.mu.Unlock()
+
+	reader, writer := io.Pipe()
+	go func() {
+		defer writer.Close()
+		for {
+			select {
+			case msg, ok := <-stream:
+				if !ok {
+					return
+				}
+				if _, err := fmt.Fprintf(writer, "data: %s\n\n", msg); err != nil {
+					s.closeStream(stream)
+					return
+				}
+			case <-req.Context().Done():
+				s.closeStream(stream)
+				return
+			}
+		}
+	}()
+	resp := stdioResponse(req, http.StatusOK, "text/event-stream", nil)
+	resp.Body = reader
+	resp.ContentLength = -1
+	return resp, nil
+}
+
+// closeStream detaches a GET stream the proxy stopped reading
+func (s *StdioBackend) closeStream(stream chan []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stream == stream {
+		s.stream = nil
+	}
+}
+
+// stdioResponse builds the HTTP response to a request, with data as its body
+func stdioResponse(req *http.Request, status int, contentType string, data []byte) *http.Response {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "stdio",
+		ProtoMajor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}
+}
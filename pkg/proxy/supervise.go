@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+)
+
+// recoverPanic logs a recovered panic with its stack trace so a bug in one
+// goroutine can't take down the bridge. Use as `defer recoverPanic("where")`.
+func recoverPanic(where string) {
+	if r := recover(); r != nil {
+		log.Printf("[PANIC] %s: %v\n%s", where, r, debug.Stack())
+	}
+}
+
+// recoverMessagePanic recovers from a panic while handling a client message:
+// it logs the stack trace, answers the request with an error so the client
+// isn't left waiting, and re-establishes the upstream session in case the
+// panic left it in an inconsistent state.
+func (p *Proxy) recoverMessagePanic(msg *JSONRPCMessage) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	log.Printf("[PANIC] Handling %s: %v\n%s", msg.Method, r, debug.Stack())
+
+	if msg.ID != nil && msg.Method != "" {
+		p.sendErrorResponse(msg.ID, -32603, fmt.Sprintf("Internal proxy error: %v", r))
+	}
+
+	if p.session() == "" || msg.Method == "initialize" {
+		return
+	}
+	if err := p.reinitialize(); err != nil {
+		log.Printf("[PANIC] Failed to re-establish session: %v", err)
+	} else {
+		log.Printf("[PANIC] Re-established upstream session")
+	}
+}
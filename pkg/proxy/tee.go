@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// teeBuffer is how many entries may queue for a slow tee reader before entries are dropped
+const teeBuffer = 1024
+
+// Tee streams a live NDJSON copy of all traffic to a secondary sink (a FIFO,
+// file or inherited file descriptor). It never blocks the proxy: entries queue
+// while the sink opens and are dropped once the queue is full.
+type Tee struct {
+	// entries is never closed, so late Record calls can't panic; stop
+	// tells the writer to finish instead
+	entries chan []byte
+	stop    chan struct{}
+	done    chan struct{}
+	dropped atomic.Int64
+	closed  atomic.Bool
+	debug   bool
+}
+
+// teeCloseTimeout bounds how long Close waits for queued entries to drain
+const teeCloseTimeout = 2 * time.Second
+
+// NewTee starts streaming to target, either a path or "fd:N"
+func NewTee(target string, debug bool) (*Tee, error) {
+	t := &Tee{entries: make(chan []byte, teeBuffer), stop: make(chan struct{}), done: make(chan struct{}), debug: debug}
+
+	if strings.HasPrefix(target, "fd:") {
+		fd, ok := parseFD(target)
+		if !ok || fd < 3 {
+			return nil, fmt.Errorf("invalid tee file descriptor %q (expected fd:N with N >= 3)", target)
+		}
+		go t.run(func() (io.WriteCloser, error) {
+			return os.NewFile(uintptr(fd), target), nil
+		})
+		return t, nil
+	}
+
+	// Opening a FIFO blocks until a reader attaches, so open it in the background
+	go t.run(func() (io.WriteCloser, error) {
+		return os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	})
+	return t, nil
+}
+
+// Record queues one message; it is dropped if the sink is behind
+func (t *Tee) Record(direction string, data []byte, correlationID string) {
+	if t.closed.Load() {
+		return
+	}
+
+	line, err := json.Marshal(newRecordEntry(direction, data, correlationID))
+	if err != nil {
+		return
+	}
+
+	select {
+	case t.entries <- append(line, '\n'):
+	default:
+		if t.dropped.Add(1) == 1 || t.debug {
+			log.Printf("[TEE] Sink is not keeping up, dropping entries")
+		}
+	}
+}
+
+// Close stops accepting entries and waits briefly for queued ones to be written
+func (t *Tee) Close() {
+	if !t.closed.CompareAndSwap(false, true) {
+		return
+	}
+	close(t.stop)
+	select {
+	case <-t.done:
+	case <-time.After(teeCloseTimeout):
+	}
+}
+
+// run opens the sink and writes queued entries until a write fails
+func (t *Tee) run(open func() (io.WriteCloser, error)) {
+	defer close(t.done)
+	defer recoverPanic("tee")
+
+	sink, err := open()
+	if err != nil {
+		log.Printf("[TEE] Failed to open sink: %v", err)
+		return
+	}
+	defer sink.Close()
+
+	if t.debug {
+		log.Printf("[TEE] Sink opened")
+	}
+
+	for {
+		var line []byte
+		select {
+		case line = <-t.entries:
+		case <-t.stop:
+			// Write what was queued before Close
+			select {
+			case line = <-t.entries:
+			default:
+				return
+			}
+		}
+		if _, err := sink.Write(line); err != nil {
+			// The reader went away; stop rather than block or spin
+			log.Printf("[TEE] Sink closed: %v", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTeeRecordDuringClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tee.jsonl")
+	tee, err := NewTee(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tee.Record(DirectionIn, []byte(`{"jsonrpc":"2.0","id":1,"method":"ping"}`), "")
+
+	// Writers still running at exit must not panic once the tee closes
+	var writers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for j := 0; j < 1000; j++ {
+				tee.Record(DirectionOut, []byte(`{"jsonrpc":"2.0","method":"notifications/progress"}`), "")
+			}
+		}()
+	}
+	tee.Close()
+	tee.Close()
+	writers.Wait()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), `{"ts":`) || !strings.Contains(string(data), `"method":"ping"`) {
+		t.Errorf("the entry queued before Close was not written: %q", data)
+	}
+}
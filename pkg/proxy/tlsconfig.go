@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// newTLSTransport returns a transport trusting the PEM certificates in
+// caCert in addition to the system roots, or skipping certificate
+// verification entirely when insecure is set. It returns nil when neither
+// option is given, leaving the default transport in place.
+func newTLSTransport(caCert string, insecure bool) (*http.Transport, error) {
+	if caCert == "" && !insecure {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+	if caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", caCert)
+		}
+		config.RootCAs = pool
+	}
+	if insecure {
+		config.InsecureSkipVerify = true
+		log.Printf("[TLS] WARNING: --insecure-skip-verify disables TLS certificate verification; upstream traffic, including credentials, can be intercepted. Use --ca-cert instead where possible")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return transport, nil
+}
@@ -0,0 +1,324 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracing exports a span per forwarded JSON-RPC request to an OpenTelemetry
+// collector using OTLP/HTTP with JSON encoding, and propagates W3C trace
+// context to the upstream in the traceparent header.
+
+const (
+	traceBatchSize     = 256
+	traceFlushInterval = 5 * time.Second
+)
+
+// traceparentPattern matches a version 00 W3C traceparent header
+var traceparentPattern = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+// Tracer batches finished spans and exports them to an OTLP endpoint
+type Tracer struct {
+	endpoint    string // Full traces URL, e.g. http://localhost:4318/v1/traces
+	headers     map[string]string
+	serviceName string
+	client      *http.Client
+	debug       bool
+
+	mu      sync.Mutex
+	pending []*span
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// span is one forwarded request
+type span struct {
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]interface{}
+	err      string
+
+	mu     sync.Mutex
+	status int // Last upstream HTTP status
+}
+
+// NewTracer creates a tracer exporting to endpoint, an OTLP/HTTP base URL
+// (like OTEL_EXPORTER_OTLP_ENDPOINT) to which /v1/traces is appended unless
+// the URL already has a path
+func NewTracer(endpoint, serviceName string, debug bool) (*Tracer, error) {
+	endpoint = strings.TrimRight(endpoint, "/")
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: must be an http(s) URL", endpoint)
+	}
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+
+	t := &Tracer{
+		endpoint:    endpoint,
+		headers:     parseOTLPHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")),
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		debug:       debug,
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	go t.run()
+	return t, nil
+}
+
+// parseOTLPHeaders parses OTEL_EXPORTER_OTLP_HEADERS (key=value,key=value)
+func parseOTLPHeaders(spec string) map[string]string {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			continue
+		}
+		headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return headers
+}
+
+// start begins a span for a forwarded request. A valid traceparent in the
+// request's params._meta makes it the parent, joining the client's trace.
+func (t *Tracer) start(msg *JSONRPCMessage, backend string) *span {
+	s := &span{
+		traceID: randomHex(16),
+		spanID:  randomHex(8),
+		name:    msg.Method,
+		start:   time.Now(),
+		attrs: map[string]interface{}{
+			"rpc.system":             "jsonrpc",
+			"rpc.method":             msg.Method,
+			"rpc.jsonrpc.request_id": strings.Trim(string(msg.ID), `"`),
+			"server.address":         backend,
+		},
+	}
+	if tool := paramsName(msg.Params); tool != "" {
+		s.attrs["mcp.tool"] = tool
+	}
+
+	var params struct {
+		Meta struct {
+			Traceparent string `json:"traceparent"`
+		} `json:"_meta"`
+	}
+	if len(msg.Params) > 0 && json.Unmarshal(msg.Params, &params) == nil {
+		if m := traceparentPattern.FindStringSubmatch(params.Meta.Traceparent); m != nil {
+			s.traceID, s.parentID = m[1], m[2]
+		}
+	}
+	return s
+}
+
+// traceparent returns the W3C header value naming this span as the parent
+func (s *span) traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", s.traceID, s.spanID)
+}
+
+// setStatus records the upstream's HTTP status code
+func (s *span) setStatus(code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = code
+}
+
+// finish ends the span and queues it for export
+func (t *Tracer) finish(s *span, correlationID string, retries int, err error) {
+	s.end = time.Now()
+	s.attrs["mcp.retries"] = retries
+	if correlationID != "" {
+		s.attrs["mcp.correlation_id"] = correlationID
+	}
+	s.mu.Lock()
+	if s.status != 0 {
+		s.attrs["http.response.status_code"] = s.status
+	}
+	s.mu.Unlock()
+	if err != nil {
+		s.err = err.Error()
+	}
+
+	t.mu.Lock()
+	t.pending = append(t.pending, s)
+	full := len(t.pending) >= traceBatchSize
+	t.mu.Unlock()
+
+	if full {
+		select {
+		case t.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run exports batches periodically and when one fills up
+func (t *Tracer) run() {
+	defer close(t.stopped)
+	defer recoverPanic("trace exporter")
+
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.wake:
+		case <-t.done:
+			t.export()
+			return
+		}
+		t.export()
+	}
+}
+
+// shutdown exports the remaining spans
+func (t *Tracer) shutdown() {
+	select {
+	case <-t.done:
+	default:
+		close(t.done)
+	}
+	<-t.stopped
+}
+
+// export sends the pending spans; a failed export is logged and dropped
+func (t *Tracer) export() {
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(t.payload(spans))
+	if err != nil {
+		log.Printf("[TRACE] Failed to encode spans: %v", err)
+		return
+	}
+
+	req, err := http.NewRequest("POST", t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[TRACE] Failed to create export request: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		log.Printf("[TRACE] Failed to export %d spans: %v", len(spans), err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		log.Printf("[TRACE] Failed to export %d spans: HTTP %d: %s", len(spans), resp.StatusCode, string(bodyBytes))
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+
+	if t.debug {
+		log.Printf("[TRACE] Exported %d spans to %s", len(spans), t.endpoint)
+	}
+}
+
+// payload builds an OTLP ExportTraceServiceRequest in its JSON encoding
+func (t *Tracer) payload(spans []*span) map[string]interface{} {
+	encoded := make([]map[string]interface{}, 0, len(spans))
+	for _, s := range spans {
+		status := map[string]interface{}{"code": 1} // STATUS_CODE_OK
+		if s.err != "" {
+			status = map[string]interface{}{"code": 2, "message": s.err} // STATUS_CODE_ERROR
+		}
+		span := map[string]interface{}{
+			"traceId":           s.traceID,
+			"spanId":            s.spanID,
+			"name":              s.name,
+			"kind":              3, // SPAN_KIND_CLIENT
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+			"status":            status,
+		}
+		if s.parentID != "" {
+			span["parentSpanId"] = s.parentID
+		}
+		encoded = append(encoded, span)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{
+					"service.name":    t.serviceName,
+					"service.version": version,
+				}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "mcp-stdio-proxy", "version": version},
+				"spans": encoded,
+			}},
+		}},
+	}
+}
+
+// otlpAttributes encodes attributes as OTLP KeyValues, in key order
+func otlpAttributes(attrs map[string]interface{}) []interface{} {
+	encoded := make([]interface{}, 0, len(attrs))
+	for _, key := range sortedKeys(attrs) {
+		var value map[string]interface{}
+		switch v := attrs[key].(type) {
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		encoded = append(encoded, map[string]interface{}{"key": key, "value": value})
+	}
+	return encoded
+}
+
+// randomHex returns n random bytes in hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// spanKey carries the current request's span through the request context
+type spanKey struct{}
+
+// withSpan returns ctx carrying s
+func withSpan(ctx context.Context, s *span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// spanFrom returns the span carried by ctx, or nil
+func spanFrom(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
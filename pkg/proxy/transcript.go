@@ -0,0 +1,349 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// exchange is a request paired with its response, or a lone notification
+type exchange struct {
+	request  *RecordEntry
+	response *RecordEntry
+	msg      JSONRPCMessage // Parsed request (or notification)
+	reply    JSONRPCMessage // Parsed response
+}
+
+// transcriptQuery selects exchanges from a transcript
+type transcriptQuery struct {
+	method        string
+	id            string
+	tool          string
+	correlationID string
+	since         time.Time
+	until         time.Time
+	errorsOnly    bool
+}
+
+// runTranscriptCommand implements "mcp-stdio-proxy transcript"
+func runTranscriptCommand(args []string) int {
+	if len(args) == 0 || args[0] != "query" {
+		fmt.Fprintf(os.Stderr, "Usage: %s transcript query [OPTIONS] FILE...\n", os.Args[0])
+		return 2
+	}
+
+	fs := flag.NewFlagSet("transcript query", flag.ContinueOnError)
+	method := fs.String("method", "", "Only exchanges with this JSON-RPC method")
+	id := fs.String("id", "", "Only the exchange with this JSON-RPC request ID")
+	tool := fs.String("tool", "", "Only tools/call exchanges for this tool")
+	correlationID := fs.String("cid", "", "Only the exchange with this correlation ID")
+	since := fs.String("since", "", "Only exchanges at or after this time (RFC 3339, or a duration ago such as 15m)")
+	until := fs.String("until", "", "Only exchanges before this time (RFC 3339, or a duration ago)")
+	errorsOnly := fs.Bool("errors", false, "Only exchanges whose response is an error or has isError set")
+	brief := fs.Bool("brief", false, "Print one summary line per exchange instead of the messages")
+	raw := fs.Bool("raw", false, "Print matching entries as transcript JSONL, for piping into other tools")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s transcript query [OPTIONS] FILE...\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Pair requests with their responses in a --record transcript (plain or\n")
+		fmt.Fprintf(os.Stderr, "gzipped) and print the exchanges matching the filters.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s transcript query session.jsonl --method tools/call --errors\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s transcript query session.jsonl.gz --id 42\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s transcript query session.jsonl --since 15m --brief\n", os.Args[0])
+	}
+
+	// Allow options after the file names, as in "query file.jsonl --method x"
+	var files []string
+	rest := args[1:]
+	for {
+		if err := fs.Parse(rest); err != nil {
+			return 2
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		files = append(files, fs.Arg(0))
+		rest = fs.Args()[1:]
+	}
+	if len(files) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	query := transcriptQuery{method: *method, id: *id, tool: *tool, correlationID: *correlationID, errorsOnly: *errorsOnly}
+	var err error
+	if query.since, err = parseQueryTime(*since); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --since: %v\n", err)
+		return 2
+	}
+	if query.until, err = parseQueryTime(*until); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --until: %v\n", err)
+		return 2
+	}
+
+	var entries []*RecordEntry
+	for _, file := range files {
+		fileEntries, err := readTranscript(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		entries = append(entries, fileEntries...)
+	}
+
+	exchanges := indexTranscript(entries)
+	matched := 0
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for _, ex := range exchanges {
+		if !query.matches(ex) {
+			continue
+		}
+		matched++
+		switch {
+		case *raw:
+			for _, entry := range []*RecordEntry{ex.request, ex.response} {
+				if entry != nil {
+					line, _ := json.Marshal(entry)
+					fmt.Fprintf(out, "%s\n", line)
+				}
+			}
+		case *brief:
+			fmt.Fprintf(out, "%s\n", ex.summary())
+		default:
+			ex.print(out)
+		}
+	}
+
+	if !*raw {
+		fmt.Fprintf(os.Stderr, "%d of %d exchange(s) matched\n", matched, len(exchanges))
+	}
+	return 0
+}
+
+// parseQueryTime parses an RFC 3339 time or a duration before now; empty means unset
+func parseQueryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a duration", value)
+	}
+	return time.Now().Add(-d), nil
+}
+
+// readTranscript loads all entries of a transcript, transparently gunzipping it
+func readTranscript(path string) ([]*RecordEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transcript: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var source io.Reader = reader
+	if magic, _ := reader.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		defer gz.Close()
+		source = gz
+	}
+
+	var entries []*RecordEntry
+	scanner := bufio.NewScanner(source)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry RecordEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid entry: %w", path, line, err)
+		}
+		entries = append(entries, &entry)
+	}
+	// A transcript still being written may end in a truncated gzip member
+	if err := scanner.Err(); err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return entries, nil
+}
+
+// indexTranscript pairs requests with responses, by correlation ID when
+// recorded and by JSON-RPC ID otherwise, ordered by request time
+func indexTranscript(entries []*RecordEntry) []*exchange {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+
+	var exchanges []*exchange
+	pending := make(map[string]*exchange)
+	pendingKey := func(direction string, entry *RecordEntry, id json.RawMessage) string {
+		if entry.CorrelationID != "" {
+			return "cid:" + entry.CorrelationID
+		}
+		return direction + ":" + string(id)
+	}
+
+	for _, entry := range entries {
+		var msg JSONRPCMessage
+		if err := json.Unmarshal(entry.Message, &msg); err != nil {
+			continue
+		}
+
+		// Responses travel opposite to their request
+		if msg.Method == "" && msg.ID != nil {
+			requestDirection := DirectionIn
+			if entry.Direction == DirectionIn {
+				requestDirection = DirectionOut
+			}
+			key := pendingKey(requestDirection, entry, msg.ID)
+			if ex, ok := pending[key]; ok {
+				ex.response = entry
+				ex.reply = msg
+				delete(pending, key)
+				continue
+			}
+			exchanges = append(exchanges, &exchange{response: entry, reply: msg})
+			continue
+		}
+
+		ex := &exchange{request: entry, msg: msg}
+		exchanges = append(exchanges, ex)
+		if msg.ID != nil {
+			pending[pendingKey(entry.Direction, entry, msg.ID)] = ex
+		}
+	}
+
+	return exchanges
+}
+
+// matches reports whether an exchange passes the query's filters
+func (q *transcriptQuery) matches(ex *exchange) bool {
+	if q.method != "" && ex.msg.Method != q.method {
+		return false
+	}
+	if q.tool != "" && (ex.msg.Method != "tools/call" || paramsName(ex.msg.Params) != q.tool) {
+		return false
+	}
+	if q.id != "" && strings.Trim(string(ex.id()), `"`) != strings.Trim(q.id, `"`) {
+		return false
+	}
+	if q.correlationID != "" && ex.entry().CorrelationID != q.correlationID {
+		return false
+	}
+	if !q.since.IsZero() && ex.entry().Time.Before(q.since) {
+		return false
+	}
+	if !q.until.IsZero() && !ex.entry().Time.Before(q.until) {
+		return false
+	}
+	if q.errorsOnly && ex.failure() == "" {
+		return false
+	}
+	return true
+}
+
+// entry returns the first recorded entry of the exchange
+func (ex *exchange) entry() *RecordEntry {
+	if ex.request != nil {
+		return ex.request
+	}
+	return ex.response
+}
+
+// id returns the exchange's JSON-RPC ID
+func (ex *exchange) id() json.RawMessage {
+	if ex.msg.ID != nil {
+		return ex.msg.ID
+	}
+	return ex.reply.ID
+}
+
+// failure describes an error response, or returns "" for success
+func (ex *exchange) failure() string {
+	if ex.reply.Error != nil {
+		return ex.reply.Error.Error()
+	}
+	if ex.reply.Result != nil {
+		var result struct {
+			IsError bool `json:"isError"`
+		}
+		if json.Unmarshal(ex.reply.Result, &result) == nil && result.IsError {
+			return "tool error (isError)"
+		}
+	}
+	return ""
+}
+
+// summary renders the exchange as one line
+func (ex *exchange) summary() string {
+	entry := ex.entry()
+	parts := []string{entry.Time.Format("2006-01-02T15:04:05.000Z07:00"), entry.Direction}
+
+	method := ex.msg.Method
+	if method == "" {
+		method = "(response)"
+	}
+	if name := paramsName(ex.msg.Params); name != "" {
+		method += " " + name
+	}
+	parts = append(parts, method)
+
+	if id := ex.id(); id != nil {
+		parts = append(parts, "id="+string(id))
+	}
+	if entry.CorrelationID != "" {
+		parts = append(parts, "cid="+entry.CorrelationID)
+	}
+
+	switch {
+	case ex.request != nil && ex.response != nil:
+		parts = append(parts, fmt.Sprintf("%dms", ex.response.Time.Sub(ex.request.Time).Milliseconds()))
+		if failure := ex.failure(); failure != "" {
+			parts = append(parts, "error: "+failure)
+		} else {
+			parts = append(parts, "ok")
+		}
+	case ex.msg.ID != nil:
+		parts = append(parts, "no response")
+	}
+
+	return strings.Join(parts, "  ")
+}
+
+// print renders the summary followed by the indented messages
+func (ex *exchange) print(w io.Writer) {
+	fmt.Fprintf(w, "%s\n", ex.summary())
+	for _, entry := range []*RecordEntry{ex.request, ex.response} {
+		if entry == nil {
+			continue
+		}
+		arrow := "->"
+		if entry.Direction == DirectionOut {
+			arrow = "<-"
+		}
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, entry.Message, "   ", "  "); err != nil {
+			pretty.Write(entry.Message)
+		}
+		fmt.Fprintf(w, "%s %s\n", arrow, pretty.String())
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// HTTP/2 modes for upstream connections
+const (
+	HTTP2Auto = "auto" // HTTP/2 over TLS when the server offers it, HTTP/1.1 otherwise
+	HTTP2Off  = "off"  // HTTP/1.1 only
+	HTTP2H2C  = "h2c"  // HTTP/2 only, in cleartext with prior knowledge for http:// upstreams
+)
+
+// TransportOptions tunes the upstream HTTP transport; zero fields keep Go's defaults
+type TransportOptions struct {
+	ConnectTimeout        time.Duration // Establishing the TCP connection
+	TLSHandshakeTimeout   time.Duration // Completing the TLS handshake
+	ResponseHeaderTimeout time.Duration // From sending a request until the response headers arrive
+	IdleConnTimeout       time.Duration // How long an unused keep-alive connection stays open
+	MaxIdleConnsPerHost   int           // Keep-alive connections kept open per host
+	HTTP2                 string        // HTTP/2 mode, HTTP2Auto if empty
+}
+
+// validate checks the HTTP/2 mode
+func (o TransportOptions) validate() error {
+	switch o.HTTP2 {
+	case "", HTTP2Auto, HTTP2Off, HTTP2H2C:
+		return nil
+	}
+	return fmt.Errorf("invalid HTTP/2 mode %q (expected %s, %s or %s)", o.HTTP2, HTTP2Auto, HTTP2Off, HTTP2H2C)
+}
+
+// apply returns base with the options set. Only *http.Transport can be
+// tuned; other round trippers, and base when no option is set, are
+// returned as they are.
+func (o TransportOptions) apply(base http.RoundTripper) http.RoundTripper {
+	if o == (TransportOptions{}) || o == (TransportOptions{HTTP2: HTTP2Auto}) {
+		return base
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	transport, ok := base.(*http.Transport)
+	if !ok {
+		return base
+	}
+	transport = transport.Clone()
+
+	if o.ConnectTimeout > 0 {
+		dialer := &net.Dialer{Timeout: o.ConnectTimeout, KeepAlive: 30 * time.Second}
+		transport.DialContext = dialer.DialContext
+	}
+	if o.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = o.TLSHandshakeTimeout
+	}
+	if o.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = o.ResponseHeaderTimeout
+	}
+	if o.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = o.IdleConnTimeout
+	}
+	if o.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+		transport.MaxIdleConns = max(transport.MaxIdleConns, o.MaxIdleConnsPerHost)
+	}
+
+	// Custom dialers and TLS settings would otherwise turn off HTTP/2
+	transport.ForceAttemptHTTP2 = true
+	switch o.HTTP2 {
+	case HTTP2Off:
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP1(true)
+		// A TLS config cloned from a transport already in use still offers h2
+		if transport.TLSClientConfig != nil {
+			transport.TLSClientConfig = transport.TLSClientConfig.Clone()
+			transport.TLSClientConfig.NextProtos = slices.DeleteFunc(transport.TLSClientConfig.NextProtos, func(proto string) bool { return proto == "h2" })
+		}
+	case HTTP2H2C:
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return transport
+}
+
+// noteProtocol records the HTTP version the upstream answered with, logging
+// it whenever it changes so --debug shows whether HTTP/2 is in use
+func (p *Proxy) noteProtocol(proto string) {
+	if previous := p.httpProtocol.Swap(proto); previous != proto && p.debug {
+		log.Printf("[HTTP] Upstream %s answers over %s", p.target(), proto)
+	}
+}
+
+// upstreamProtocol returns the HTTP version of the last upstream response, or ""
+func (p *Proxy) upstreamProtocol() string {
+	proto, _ := p.httpProtocol.Load().(string)
+	return proto
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// unixScheme prefixes upstream URLs reached through a Unix domain socket:
+// http+unix:///run/mcp/hub.sock:/mcp is the path /mcp on /run/mcp/hub.sock
+const unixScheme = "http+unix://"
+
+// unixSockets maps the placeholder hosts that http+unix:// URLs are rewritten
+// to onto their socket paths
+var unixSockets = struct {
+	sync.Mutex
+	hosts map[string]string
+}{hosts: make(map[string]string)}
+
+// isUnixURL reports whether raw is an http+unix:// URL
+func isUnixURL(raw string) bool {
+	return strings.HasPrefix(raw, unixScheme)
+}
+
+// resolveUnixURL rewrites an http+unix:// URL to a plain http:// URL whose
+// host the transport from withUnixSockets dials as the socket
+func resolveUnixURL(raw string) (string, error) {
+	socket, path, _ := strings.Cut(strings.TrimPrefix(raw, unixScheme), ":")
+	if socket == "" {
+		return "", fmt.Errorf("invalid URL %q: expected http+unix:///path/to.sock:/path", raw)
+	}
+	if path == "" {
+		path = "/"
+	} else if !strings.HasPrefix(path, "/") {
+		return "", fmt.Errorf("invalid URL %q: the path after the socket must start with /", raw)
+	}
+
+	unixSockets.Lock()
+	defer unixSockets.Unlock()
+	host := ""
+	for h, s := range unixSockets.hosts {
+		if s == socket {
+			host = h
+		}
+	}
+	if host == "" {
+		host = fmt.Sprintf("unix-socket-%d", len(unixSockets.hosts)+1)
+		unixSockets.hosts[host] = socket
+	}
+	return "http://" + host + path, nil
+}
+
+// unixSocketName names an aggregated upstream after its socket file
+func unixSocketName(raw string) string {
+	socket, _, _ := strings.Cut(strings.TrimPrefix(raw, unixScheme), ":")
+	base := filepath.Base(socket)
+	return hostUpstreamName(strings.TrimSuffix(base, filepath.Ext(base)))
+}
+
+// withUnixSockets returns a transport that connects to the placeholder hosts
+// of http+unix:// URLs through their sockets, and to everything else as base
+// (or the default transport) would
+func withUnixSockets(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	transport, ok := base.(*http.Transport)
+	if !ok {
+		return base
+	}
+	transport = transport.Clone()
+
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, _ := net.SplitHostPort(addr)
+		unixSockets.Lock()
+		socket, ok := unixSockets.hosts[host]
+		unixSockets.Unlock()
+		if ok {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		}
+		return dial(ctx, network, addr)
+	}
+	return transport
+}
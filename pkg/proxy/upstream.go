@@ -0,0 +1,289 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// internalIDCounter numbers requests the proxy sends on its own behalf
+var internalIDCounter atomic.Int64
+
+// call sends a proxy-originated request over the current session and returns
+// the matching response. Nothing is written to stdout; unrelated messages on
+// the response stream are discarded.
+func (p *Proxy) call(method string, params interface{}) (*JSONRPCMessage, error) {
+	return p.callContext(p.baseContext(), method, params)
+}
+
+// callContext is call with a context bounding the whole exchange. A request
+// the upstream rejects for an expired session is retried on a new one.
+func (p *Proxy) callContext(ctx context.Context, method string, params interface{}) (*JSONRPCMessage, error) {
+	return p.callObserved(ctx, method, params, nil)
+}
+
+// callObserver follows a proxy-originated request on behalf of a client:
+// sent learns the internal ID of each attempt, and message receives the
+// other messages on its response stream, such as progress notifications
+type callObserver struct {
+	sent    func(id json.RawMessage)
+	message func(msg *JSONRPCMessage)
+}
+
+// callObserved is callContext reporting the exchange to observer, if not nil
+func (p *Proxy) callObserved(ctx context.Context, method string, params interface{}, observer *callObserver) (*JSONRPCMessage, error) {
+	result, err := p.callOnce(ctx, method, params, observer)
+	var expired *sessionExpiredError
+	if errors.As(err, &expired) && method != "initialize" {
+		if err := p.reestablishSession(expired.session); err != nil {
+			return nil, fmt.Errorf("%w; re-initializing failed: %v", expired, err)
+		}
+		return p.callOnce(ctx, method, params, observer)
+	}
+	return result, err
+}
+
+// callOnce sends one proxy-originated request and reads its response
+func (p *Proxy) callOnce(ctx context.Context, method string, params interface{}, observer *callObserver) (*JSONRPCMessage, error) {
+	id := json.RawMessage(fmt.Sprintf(`"mcp-stdio-proxy-%d"`, internalIDCounter.Add(1)))
+	if observer != nil && observer.sent != nil {
+		observer.sent(id)
+	}
+
+	req := JSONRPCMessage{JSONRPC: "2.0", ID: id, Method: method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal params: %w", err)
+		}
+		req.Params = raw
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	if p.debug {
+		log.Printf("[UPSTREAM] Internal request: %s", body)
+	}
+
+	var result *JSONRPCMessage
+	if p.legacy.inUse() {
+		result, err = p.legacyPost(ctx, string(body), id, nil, true)
+	} else {
+		result, err = p.callStreamable(ctx, string(body), id, observer)
+		if p.fallBackToLegacy(ctx, err) {
+			result, err = p.legacyPost(ctx, string(body), id, nil, true)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if result == nil {
+		return nil, fmt.Errorf("no response to %s", method)
+	}
+	if result.Error != nil {
+		return result, fmt.Errorf("%s failed: %s (code %d)", method, result.Error.Message, result.Error.Code)
+	}
+	if method == "initialize" {
+		p.captureProtocolVersion(result.Result)
+	}
+
+	return result, nil
+}
+
+// callStreamable POSTs a proxy-originated request over Streamable HTTP and
+// returns its response, or nil if none came
+func (p *Proxy) callStreamable(ctx context.Context, body string, id json.RawMessage, observer *callObserver) (*JSONRPCMessage, error) {
+	httpReq, err := p.newPostRequest(body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq = httpReq.WithContext(ctx)
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	p.captureSessionID(resp)
+
+	if err := sessionExpired(httpReq, resp); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, &httpStatusError{code: resp.StatusCode, body: string(bodyBytes)}
+	}
+	p.legacy.streamableWorked()
+
+	var result *JSONRPCMessage
+	match := func(data []byte) {
+		var msg JSONRPCMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return
+		}
+		if msg.Method == "" && string(msg.ID) == string(id) {
+			result = &msg
+		} else if observer != nil && observer.message != nil {
+			observer.message(&msg)
+		} else if p.debug {
+			log.Printf("[UPSTREAM] Discarded unrelated message: %s", data)
+		}
+	}
+
+	sse, err := p.isSSEResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	if sse {
+		if err := p.readSSE(resp.Body, nil, match); err != nil {
+			return nil, fmt.Errorf("failed to read SSE response: %w", err)
+		}
+	} else {
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response body: %w", err)
+		}
+		match(data)
+	}
+	return result, nil
+}
+
+// ping sends an MCP ping over the current session, bounded by ctx and timeout
+func (p *Proxy) ping(ctx context.Context, timeout time.Duration) error {
+	if p.session() == "" && !p.legacy.inUse() {
+		return errNoSession
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_, err := p.callContext(ctx, "ping", nil)
+	return err
+}
+
+// errNoSession is returned by ping before the client has initialized a session
+var errNoSession = errors.New("no session established yet")
+
+// notify sends a proxy-originated notification over the current session
+func (p *Proxy) notify(method string, params interface{}) error {
+	msg := JSONRPCMessage{JSONRPC: "2.0", Method: method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("failed to marshal params: %w", err)
+		}
+		msg.Params = raw
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	if p.legacy.inUse() {
+		_, err := p.legacyPost(p.baseContext(), string(body), nil, nil, false)
+		return err
+	}
+
+	req, err := p.newPostRequest(string(body))
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req.WithContext(p.baseContext()))
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// reinitialize discards the current session and replays the client's
+// initialize handshake to obtain a new one
+func (p *Proxy) reinitialize() error {
+	p.sessionMu.Lock()
+	params := p.initParams
+	p.sessionID = ""
+	p.protocolVersion = ""
+	p.sessionMu.Unlock()
+
+	if params == nil {
+		return errors.New("no initialize request to replay")
+	}
+
+	result, err := p.call("initialize", params)
+	if err != nil {
+		return fmt.Errorf("initialize failed: %w", err)
+	}
+	if err := p.notify("notifications/initialized", nil); err != nil {
+		return fmt.Errorf("initialized notification failed: %w", err)
+	}
+	p.saveSession(result.Result)
+
+	if p.debug {
+		log.Printf("[SESSION] Re-initialized, new session ID: %s", p.session())
+	}
+	return nil
+}
+
+// sessionExpiredError means the upstream no longer knows the session a
+// request was sent with, typically because it restarted
+type sessionExpiredError struct {
+	session string
+}
+
+// Error implements error
+func (e *sessionExpiredError) Error() string {
+	return fmt.Sprintf("session %s not found (HTTP 404)", e.session)
+}
+
+// httpStatusError is an error status from the upstream
+type httpStatusError struct {
+	code int
+	body string
+}
+
+// Error implements error
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.code, e.body)
+}
+
+// sessionExpired returns a sessionExpiredError for a 404 to a request that
+// carried a session ID, and nil otherwise
+func sessionExpired(req *http.Request, resp *http.Response) error {
+	session := req.Header.Get("Mcp-Session-Id")
+	if resp.StatusCode != http.StatusNotFound || session == "" {
+		return nil
+	}
+	return &sessionExpiredError{session: session}
+}
+
+// reestablishSession replaces an expired session by replaying the client's
+// initialize handshake, unless a concurrent request already replaced it
+func (p *Proxy) reestablishSession(expired string) error {
+	p.reinitMu.Lock()
+	defer p.reinitMu.Unlock()
+
+	if current := p.session(); current != "" && current != expired {
+		return nil
+	}
+	log.Printf("[SESSION] Upstream no longer knows session %s; re-initializing", expired)
+	p.forgetSavedSession()
+	return p.reinitialize()
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testUpstream is a minimal Streamable HTTP MCP server. tools/call echoes
+// its arguments after delay, or with {"wait":true} holds the request until
+// the proxy abandons it; messages sent on notify go out on the GET stream.
+type testUpstream struct {
+	*httptest.Server
+	delay    time.Duration
+	received chan JSONRPCMessage // Every message POSTed to the server
+
+	streamOpen chan struct{}
+	openOnce   sync.Once
+	notify     chan string
+	responses  chan JSONRPCMessage // Client answers to server requests
+}
+
+func newTestUpstream(t *testing.T, delay time.Duration) *testUpstream {
+	u := &testUpstream{
+		delay:      delay,
+		streamOpen: make(chan struct{}),
+		notify:     make(chan string, 10),
+		responses:  make(chan JSONRPCMessage, 10),
+		received:   make(chan JSONRPCMessage, 100),
+	}
+	u.Server = httptest.NewServer(http.HandlerFunc(u.serve))
+	t.Cleanup(u.Close)
+	return u
+}
+
+func (u *testUpstream) serve(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		u.serveStream(w, r)
+		return
+	case http.MethodDelete:
+		return
+	}
+
+	var msg JSONRPCMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	select {
+	case u.received <- msg:
+	default:
+	}
+	if msg.ID == nil || msg.Method == "" {
+		if msg.ID != nil {
+			u.responses <- msg
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	var result interface{}
+	switch msg.Method {
+	case "initialize":
+		w.Header().Set("Mcp-Session-Id", "test-session")
+		result = map[string]interface{}{
+			"protocolVersion": latestProtocolVersion,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+			"serverInfo":      map[string]string{"name": "test", "version": "1"},
+		}
+	case "tools/call":
+		time.Sleep(u.delay)
+		var params struct {
+			Arguments json.RawMessage `json:"arguments"`
+			Meta      struct {
+				ProgressToken json.RawMessage `json:"progressToken"`
+			} `json:"_meta"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		var options struct {
+			Wait bool `json:"wait"`
+		}
+		if json.Unmarshal(params.Arguments, &options); options.Wait {
+			<-r.Context().Done()
+			return
+		}
+		result = map[string]interface{}{"arguments": params.Arguments}
+		if params.Meta.ProgressToken != nil {
+			u.serveWithProgress(w, &msg, params.Meta.ProgressToken, result)
+			return
+		}
+	default:
+		result = map[string]interface{}{}
+	}
+
+	raw, _ := json.Marshal(result)
+	data, _ := json.Marshal(JSONRPCMessage{JSONRPC: "2.0", ID: msg.ID, Result: raw})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// serveWithProgress answers on an SSE stream, reporting progress first
+func (u *testUpstream) serveWithProgress(w http.ResponseWriter, msg *JSONRPCMessage, token json.RawMessage, result interface{}) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	fmt.Fprintf(w, "data: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\",\"params\":{\"progressToken\":%s,\"progress\":1}}\n\n", token)
+	raw, _ := json.Marshal(result)
+	data, _ := json.Marshal(JSONRPCMessage{JSONRPC: "2.0", ID: msg.ID, Result: raw})
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+func (u *testUpstream) serveStream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	u.openOnce.Do(func() { close(u.streamOpen) })
+
+	for {
+		select {
+		case msg := <-u.notify:
+			fmt.Fprintf(w, "data: %s\n\n", msg)
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// expectMessage waits for the upstream to receive a message with method
+func (u *testUpstream) expectMessage(t *testing.T, method string) JSONRPCMessage {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-u.received:
+			if msg.Method == method {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("the upstream did not receive %s", method)
+		}
+	}
+}